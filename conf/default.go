@@ -1,5 +1,9 @@
 package conf
 
+import (
+	"crypto/rsa"
+)
+
 // DefaultConfig 是默认配置，它实现多个子模块的配置接口
 type DefaultConfig struct {
 	// AppID 微信应用（公众号/小程序...） app id
@@ -8,11 +12,18 @@ type DefaultConfig struct {
 	MchID string
 	// MchKey 微信支付密钥
 	MchKey string
+	// MchCertSerialNo 商户 API 证书序列号（APIv3）
+	MchCertSerialNo string
+	// MchPrivateKey 商户 API 证书私钥（APIv3）
+	MchPrivateKey *rsa.PrivateKey
+	// MchAPIv3Key APIv3 密钥
+	MchAPIv3Key string
 }
 
 var (
 	_ MchConfig         = (*DefaultConfig)(nil)
 	_ MchConfigSelector = (*DefaultConfig)(nil)
+	_ MchV3Config       = (*DefaultConfig)(nil)
 )
 
 // WechatAppID 返回微信应用（公众号/小程序...） app id
@@ -30,6 +41,21 @@ func (config *DefaultConfig) WechatMchKey() string {
 	return config.MchKey
 }
 
+// WechatMchCertSerialNo 返回商户 API 证书序列号
+func (config *DefaultConfig) WechatMchCertSerialNo() string {
+	return config.MchCertSerialNo
+}
+
+// WechatMchPrivateKey 返回商户 API 证书私钥
+func (config *DefaultConfig) WechatMchPrivateKey() *rsa.PrivateKey {
+	return config.MchPrivateKey
+}
+
+// WechatMchAPIv3Key 返回 APIv3 密钥
+func (config *DefaultConfig) WechatMchAPIv3Key() string {
+	return config.MchAPIv3Key
+}
+
 // SelectMch 实现 MchConfigSelector 接口
 func (config *DefaultConfig) SelectMch(appID, mchID string) (MchConfig, error) {
 	if appID == "" || mchID == "" {
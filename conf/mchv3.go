@@ -0,0 +1,20 @@
+package conf
+
+import (
+	"crypto/rsa"
+)
+
+// MchV3Config 包含微信支付 APIv3 接口所必须的配置信息
+type MchV3Config interface {
+	// WechatMchID 返回微信支付商户号（服务商模式下为服务商商户号）
+	WechatMchID() string
+
+	// WechatMchCertSerialNo 返回商户 API 证书的序列号
+	WechatMchCertSerialNo() string
+
+	// WechatMchPrivateKey 返回商户 API 证书的私钥（apiclient_key.pem），用于请求签名以及解密敏感信息
+	WechatMchPrivateKey() *rsa.PrivateKey
+
+	// WechatMchAPIv3Key 返回 APIv3 密钥，用于解密平台证书和回调通知
+	WechatMchAPIv3Key() string
+}
@@ -1,6 +1,6 @@
 module github.com/huangjunwen/wx-driver
 
-go 1.12
+go 1.13
 
 require (
 	github.com/mitchellh/go-homedir v1.1.0
@@ -0,0 +1,240 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+var (
+	// ErrCircuitOpen 表示熔断器处于打开状态，请求没有发出
+	ErrCircuitOpen = errors.New("Circuit breaker is open")
+)
+
+var (
+	// DefaultCircuitBreakerThreshold 为熔断器默认的连续失败次数阈值
+	DefaultCircuitBreakerThreshold = 5
+	// DefaultCircuitBreakerOpenTimeout 为熔断器打开后默认的持续时长，之后进入半开状态
+	DefaultCircuitBreakerOpenTimeout = 30 * time.Second
+	// DefaultCircuitBreakerProbes 为半开状态下默认需要连续成功的探测请求数
+	DefaultCircuitBreakerProbes = 1
+)
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	// CircuitClosed 关闭：正常发出请求
+	CircuitClosed CircuitState = iota
+	// CircuitOpen 打开：不发出请求，直接返回 ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen 半开：只允许有限的探测请求，成功后关闭，失败后重新打开
+	CircuitHalfOpen
+)
+
+// String 返回状态名称
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return ""
+	}
+}
+
+// CircuitBreaker 为熔断器：按商户号、接入点和接口路径分别统计，连续失败（网络错误、http 5xx、无法解码的响应以及
+// SYSTEMERROR）达到阈值后打开，打开期间的请求直接返回 ErrCircuitOpen 而不必等待超时；一段时间后进入半开状态，
+// 允许少量探测请求，探测成功后关闭，失败则重新打开。
+//
+// 与 Failover 一起使用时，熔断器打开的接入点会被跳过，所有接入点均打开时才返回 ErrCircuitOpen。
+//
+// CircuitBreaker 需要在多次调用间共享，一般放在 DefaultOptions 中：
+//
+//   mch.DefaultOptions = mch.MustOptions(
+//   	mch.UseCircuitBreaker(mch.NewCircuitBreaker(0, 0, 0)),
+//   )
+type CircuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+	probes      int
+
+	mu       sync.Mutex
+	circuits map[circuitKey]*circuit
+}
+
+type circuitKey struct {
+	mchID   string
+	urlBase string
+	path    string
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int       // 关闭状态下的连续失败次数
+	openedAt time.Time // 打开的时间
+	inflight int       // 半开状态下进行中的探测请求数
+	passed   int       // 半开状态下已成功的探测请求数
+}
+
+// circuitResult 为一次请求对熔断器而言的结果
+type circuitResult int
+
+const (
+	circuitSuccess circuitResult = iota
+	circuitFailure
+	circuitIgnore // 不计入，如调用方取消
+)
+
+// NewCircuitBreaker 创建一个 CircuitBreaker，threshold 为打开熔断器的连续失败次数，openTimeout 为打开后进入半开状态前的时长，
+// probes 为半开状态下需要连续成功的探测请求数；<= 0 时分别使用 DefaultCircuitBreakerXXX
+func NewCircuitBreaker(threshold int, openTimeout time.Duration, probes int) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = DefaultCircuitBreakerThreshold
+	}
+	if openTimeout <= 0 {
+		openTimeout = DefaultCircuitBreakerOpenTimeout
+	}
+	if probes <= 0 {
+		probes = DefaultCircuitBreakerProbes
+	}
+	return &CircuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		probes:      probes,
+		circuits:    map[circuitKey]*circuit{},
+	}
+}
+
+// State 返回商户 mchID 在接入点 urlBase 上接口 path 的熔断器状态
+func (breaker *CircuitBreaker) State(mchID, urlBase, path string) CircuitState {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	c := breaker.circuits[circuitKey{mchID: mchID, urlBase: urlBase, path: path}]
+	if c == nil {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && !utils.Now().Before(c.openedAt.Add(breaker.openTimeout)) {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// allow 判断是否可以发出请求，probe 表示该请求为半开状态下的探测请求；breaker 为 nil 时总是允许
+func (breaker *CircuitBreaker) allow(key circuitKey) (probe bool, ok bool) {
+	if breaker == nil {
+		return false, true
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	c := breaker.circuits[key]
+	if c == nil {
+		return false, true
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		if utils.Now().Before(c.openedAt.Add(breaker.openTimeout)) {
+			return false, false
+		}
+		c.state = CircuitHalfOpen
+		c.inflight = 0
+		c.passed = 0
+		fallthrough
+	case CircuitHalfOpen:
+		if c.inflight+c.passed >= breaker.probes {
+			return false, false
+		}
+		c.inflight++
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// done 报告 allow 允许的请求的结果
+func (breaker *CircuitBreaker) done(key circuitKey, probe bool, result circuitResult) {
+	if breaker == nil {
+		return
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	c := breaker.circuits[key]
+	if c == nil {
+		if result != circuitFailure {
+			return
+		}
+		c = &circuit{}
+		breaker.circuits[key] = c
+	}
+
+	if probe {
+		// 探测请求的结果只在半开状态下有意义
+		if c.state != CircuitHalfOpen {
+			return
+		}
+		c.inflight--
+		switch result {
+		case circuitSuccess:
+			c.passed++
+			if c.passed >= breaker.probes {
+				c.state = CircuitClosed
+				c.failures = 0
+			}
+		case circuitFailure:
+			c.state = CircuitOpen
+			c.openedAt = utils.Now()
+		}
+		return
+	}
+
+	// 非探测请求的结果只在关闭状态下计入
+	if c.state != CircuitClosed {
+		return
+	}
+	switch result {
+	case circuitSuccess:
+		c.failures = 0
+	case circuitFailure:
+		c.failures++
+		if c.failures >= breaker.threshold {
+			c.state = CircuitOpen
+			c.openedAt = utils.Now()
+		}
+	}
+}
+
+// circuitResultOf 返回请求结果对熔断器而言是成功还是失败：网络错误、http 5xx、无法解码的响应以及 SYSTEMERROR 为失败，
+// 其它（包括一般的业务错误）说明微信支付工作正常，为成功
+func circuitResultOf(err error) circuitResult {
+	if err == nil {
+		return circuitSuccess
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return circuitIgnore
+	}
+	var httpErr *MchHTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.StatusCode >= 500 {
+			return circuitFailure
+		}
+		return circuitSuccess
+	}
+	if ErrCodeOf(err) == ErrCodeSYSTEMERROR {
+		return circuitFailure
+	}
+	switch ClassifyError(err) {
+	case ErrorClassNetwork, ErrorClassDecode:
+		return circuitFailure
+	default:
+		return circuitSuccess
+	}
+}
@@ -0,0 +1,184 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFuncClient 将函数转为 HTTPClient
+type testFuncClient func(req *http.Request) (*http.Response, error)
+
+func (fn testFuncClient) Do(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestCircuitBreakerState(t *testing.T) {
+	assert := assert.New(t)
+
+	now := utils.Now
+	current := time.Unix(1490840662, 0)
+	utils.Now = func() time.Time {
+		return current
+	}
+	defer func() {
+		utils.Now = now
+	}()
+
+	breaker := NewCircuitBreaker(2, time.Minute, 2)
+	key := circuitKey{mchID: "1", urlBase: URLBaseDefault, path: "/pay/orderquery"}
+	state := func() CircuitState {
+		return breaker.State(key.mchID, key.urlBase, key.path)
+	}
+	call := func(result circuitResult) bool {
+		probe, ok := breaker.allow(key)
+		if ok {
+			breaker.done(key, probe, result)
+		}
+		return ok
+	}
+
+	// 连续失败才打开
+	assert.Equal(CircuitClosed, state())
+	assert.True(call(circuitFailure))
+	assert.True(call(circuitSuccess))
+	assert.True(call(circuitFailure))
+	assert.True(call(circuitIgnore))
+	assert.Equal(CircuitClosed, state())
+	assert.True(call(circuitFailure))
+	assert.Equal(CircuitOpen, state())
+	assert.False(call(circuitSuccess))
+
+	// 其它商户/接入点/接口不受影响
+	_, ok := breaker.allow(circuitKey{mchID: "2", urlBase: URLBaseDefault, path: "/pay/orderquery"})
+	assert.True(ok)
+	_, ok = breaker.allow(circuitKey{mchID: "1", urlBase: URLBaseDefault2, path: "/pay/orderquery"})
+	assert.True(ok)
+	_, ok = breaker.allow(circuitKey{mchID: "1", urlBase: URLBaseDefault, path: "/pay/refundquery"})
+	assert.True(ok)
+
+	// 半开状态下只允许有限的探测请求，探测失败则重新打开
+	current = current.Add(time.Minute)
+	assert.Equal(CircuitHalfOpen, state())
+	probe1, ok := breaker.allow(key)
+	assert.True(probe1 && ok)
+	probe2, ok := breaker.allow(key)
+	assert.True(probe2 && ok)
+	_, ok = breaker.allow(key)
+	assert.False(ok)
+	breaker.done(key, probe1, circuitSuccess)
+	breaker.done(key, probe2, circuitFailure)
+	assert.Equal(CircuitOpen, state())
+	assert.False(call(circuitSuccess))
+
+	// 探测请求被取消时归还名额
+	current = current.Add(time.Minute)
+	probe1, ok = breaker.allow(key)
+	assert.True(probe1 && ok)
+	breaker.done(key, probe1, circuitIgnore)
+
+	// 探测全部成功后关闭
+	assert.True(call(circuitSuccess))
+	assert.Equal(CircuitHalfOpen, state())
+	assert.True(call(circuitSuccess))
+	assert.Equal(CircuitClosed, state())
+
+	// 打开前发出的请求的结果不影响半开状态
+	assert.True(call(circuitFailure))
+	probe, ok := breaker.allow(key)
+	assert.True(ok)
+	assert.False(probe)
+	assert.True(call(circuitFailure))
+	assert.Equal(CircuitOpen, state())
+	current = current.Add(time.Minute)
+	assert.True(call(circuitSuccess))
+	breaker.done(key, probe, circuitFailure)
+	assert.Equal(CircuitHalfOpen, state())
+}
+
+func TestCircuitResultOf(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		Err    error
+		Expect circuitResult
+	}{
+		{nil, circuitSuccess},
+		{context.Canceled, circuitIgnore},
+		{&MchHTTPError{StatusCode: 502}, circuitFailure},
+		{&MchHTTPError{StatusCode: 404}, circuitSuccess},
+		{&MchBusinessError{ErrCode: ErrCodeSYSTEMERROR}, circuitFailure},
+		{&MchBusinessError{ErrCode: ErrCodeORDERNOTEXIST}, circuitSuccess},
+		{&MchCommunicationError{}, circuitSuccess},
+		{&timeoutError{}, circuitFailure},
+	} {
+		assert.Equal(testCase.Expect, circuitResultOf(testCase.Err), "%#v", testCase.Err)
+	}
+}
+
+func TestCircuitBreakerCall(t *testing.T) {
+	assert := assert.New(t)
+
+	breaker := NewCircuitBreaker(2, time.Hour, 1)
+	recorder := &testEventRecorder{}
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testBusinessErrorRespond(ErrCodeSYSTEMERROR),
+		testNetworkErrorRespond,
+		testSignedRespond(MchXML{"result_code": "SUCCESS"}),
+	}}
+	options := MustOptions(
+		UseClient(client),
+		UseObserver(recorder),
+		UseCircuitBreaker(breaker),
+		UseRetryPolicy(&RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	)
+
+	// SYSTEMERROR 和网络错误各一次后打开，之后不发出请求也不再重试
+	_, err := PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, options)
+	assert.Equal(ErrCircuitOpen, err)
+	assert.Len(client.reqs, 2)
+	assert.Equal(CircuitOpen, breaker.State(config.WechatMchID(), URLBaseDefault, "/pay/orderquery"))
+	assert.Len(recorder.events, 3)
+	assert.Equal(ErrorClassCircuitOpen, recorder.events[2].ErrorClass)
+
+	// 其它接口不受影响
+	_, err = PostMchXML(context.Background(), config, "/pay/refundquery", MchXML{}, options)
+	assert.NoError(err)
+	assert.Len(client.reqs, 3)
+}
+
+func TestCircuitBreakerFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	// 默认接入点返回 502（不会触发故障切换），备用域名正常
+	hosts := []string{}
+	client := testFuncClient(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		if req.URL.Host == "api.mch.weixin.qq.com" {
+			return testRespond(502, MchXML{})()
+		}
+		return testSignedRespond(MchXML{"result_code": "SUCCESS"})()
+	})
+	breaker := NewCircuitBreaker(1, time.Hour, 1)
+	options := MustOptions(
+		UseClient(client),
+		UseFailover(MustFailover(0, URLBaseDefault, URLBaseDefault2)),
+		UseCircuitBreaker(breaker),
+	)
+
+	_, err := PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, options)
+	var httpErr *MchHTTPError
+	assert.True(errors.As(err, &httpErr))
+	assert.Equal(CircuitOpen, breaker.State(config.WechatMchID(), URLBaseDefault, "/pay/orderquery"))
+
+	// 熔断器打开的接入点被跳过
+	hosts = nil
+	_, err = PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, options)
+	assert.NoError(err)
+	assert.Equal([]string{"api2.mch.weixin.qq.com"}, hosts)
+}
@@ -0,0 +1,377 @@
+package mch
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MissingFieldError 表示请求缺少必填字段，或响应缺少必返回字段
+//
+// NOTE: 该类型为可比较的值类型，因此形如 ErrUnifiedOrderMissingOutTradeNo 的错误变量可以直接使用 == 比较
+type MissingFieldError struct {
+	// Type 请求/响应类型名，如 UnifiedOrderRequest
+	Type string
+	// Field 字段名，如 out_trade_no
+	Field string
+	// InResponse 为 true 时表示响应缺少字段，否则为请求缺少字段
+	InResponse bool
+}
+
+// Error 满足 error 接口
+func (err MissingFieldError) Error() string {
+	if err.InResponse {
+		return fmt.Sprintf("No %s is returned from %s", err.Field, err.Type)
+	}
+	return fmt.Sprintf("Missing %s in %s", err.Field, err.Type)
+}
+
+func missingRequestField(typeName, fieldName string) error {
+	return MissingFieldError{Type: typeName, Field: fieldName}
+}
+
+func missingResponseField(typeName, fieldName string) error {
+	return MissingFieldError{Type: typeName, Field: fieldName, InResponse: true}
+}
+
+// MarshalMchXML 将结构体（或结构体指针）v 按字段的 `mch` tag 编码为 MchXML，tag 的形式为：
+//
+//   `mch:"fieldName[,option]..."`
+//
+// 其中 fieldName 为 xml 字段名，可包含 $n 表示列表元素的下标（如 refund_id_$n）；没有 tag 或 tag 为 "-" 的字段会被忽略。
+// 支持的字段类型有：string、整数、time.Time、Money、TradeType/TradeState/RefundStatus/SignType/Currency
+// 以及元素为结构体的切片；零值字段不会被编码。支持的 option 有：
+//
+//   required 必填/必返回：编码时字段不能为零值，解码时 xml 中必须有该字段，否则返回 MissingFieldError
+//   currency=xxx 用于 Money，xxx 为对应的币种字段名（如 fee_type）；没有该选项时只编解码金额
+//   layout=xxx 用于 time.Time，xxx 为时间格式，默认为 20060102150405
+//   count=xxx 用于切片，xxx 为元素个数字段名（如 refund_count），元素中 $n 从 0 开始编号
+//
+// 例如：
+//
+//   type RefundInfo struct {
+//   	RefundID  string `mch:"refund_id_$n,required"`
+//   	RefundFee Money  `mch:"refund_fee_$n,required,currency=fee_type"`
+//   }
+//
+//   type RefundQueryResponse struct {
+//   	OutTradeNo string       `mch:"out_trade_no,required"`
+//   	Refunds    []RefundInfo `mch:",count=refund_count"`
+//   }
+//
+// 每种类型的 tag 只会解析一次并缓存
+//
+// NOTE: 最终用户一般不需要使用该函数
+func MarshalMchXML(v interface{}) (MchXML, error) {
+	rv, codec, err := structCodecOf(v)
+	if err != nil {
+		return nil, err
+	}
+	x := MchXML{}
+	if err := codec.encode(x, rv, codec.name, -1); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// UnmarshalMchXML 将 MchXML 按字段的 `mch` tag 解码到结构体指针 v 中，tag 的形式见 MarshalMchXML
+//
+// NOTE: 最终用户一般不需要使用该函数
+func UnmarshalMchXML(x MchXML, v interface{}) error {
+	return unmarshalMchXML(x, v, 0)
+}
+
+// unmarshalMchXML 同 UnmarshalMchXML，但切片元素中的 $n 从 base 开始编号
+func unmarshalMchXML(x MchXML, v interface{}, base int) error {
+	rv, codec, err := structCodecOf(v)
+	if err != nil {
+		return err
+	}
+	if !rv.CanSet() {
+		return fmt.Errorf("Expect a struct pointer to unmarshal MchXML but got %T", v)
+	}
+	return codec.decode(x, rv, codec.name, -1, base)
+}
+
+var (
+	typeTime         = reflect.TypeOf(time.Time{})
+	typeMoney        = reflect.TypeOf(Money{})
+	typeTradeType    = reflect.TypeOf(TradeType{})
+	typeTradeState   = reflect.TypeOf(TradeState{})
+	typeRefundStatus = reflect.TypeOf(RefundStatus{})
+	typeSignType     = reflect.TypeOf(SignType{})
+	typeCurrency     = reflect.TypeOf(Currency{})
+
+	// reflect.Type -> *structCodec
+	structCodecs sync.Map
+)
+
+type structCodec struct {
+	name   string
+	fields []*fieldCodec
+}
+
+type fieldCodec struct {
+	index    int
+	name     string
+	required bool
+	currency string
+	layout   string
+	count    string
+	typ      reflect.Type
+	elem     *structCodec
+}
+
+func structCodecOf(v interface{}) (reflect.Value, *structCodec, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("Expect a struct or struct pointer but got %T", v)
+	}
+	codec, err := codecOfType(rv.Type())
+	return rv, codec, err
+}
+
+func codecOfType(t reflect.Type) (*structCodec, error) {
+	if codec, ok := structCodecs.Load(t); ok {
+		return codec.(*structCodec), nil
+	}
+
+	codec := &structCodec{name: t.Name()}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("mch")
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		fc := &fieldCodec{
+			index:  i,
+			name:   parts[0],
+			layout: timeCompactLayout,
+			typ:    sf.Type,
+		}
+		for _, opt := range parts[1:] {
+			switch {
+			case opt == "required":
+				fc.required = true
+			case strings.HasPrefix(opt, "currency="):
+				fc.currency = strings.TrimPrefix(opt, "currency=")
+			case strings.HasPrefix(opt, "layout="):
+				fc.layout = strings.TrimPrefix(opt, "layout=")
+			case strings.HasPrefix(opt, "count="):
+				fc.count = strings.TrimPrefix(opt, "count=")
+			default:
+				return nil, fmt.Errorf("Unknown mch tag option %+q in %s.%s", opt, t.Name(), sf.Name)
+			}
+		}
+
+		switch {
+		case sf.Type.Kind() == reflect.Slice:
+			if fc.count == "" || sf.Type.Elem().Kind() != reflect.Struct {
+				return nil, fmt.Errorf("Slice field %s.%s should be a slice of struct with count option", t.Name(), sf.Name)
+			}
+			elem, err := codecOfType(sf.Type.Elem())
+			if err != nil {
+				return nil, err
+			}
+			fc.elem = elem
+
+		case fc.name == "":
+			return nil, fmt.Errorf("Missing field name in mch tag of %s.%s", t.Name(), sf.Name)
+
+		case sf.Type == typeTime, sf.Type == typeMoney, sf.Type == typeTradeType, sf.Type == typeTradeState,
+			sf.Type == typeRefundStatus, sf.Type == typeSignType, sf.Type == typeCurrency:
+
+		default:
+			switch sf.Type.Kind() {
+			case reflect.String,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return nil, fmt.Errorf("Unsupported type %s of field %s.%s", sf.Type, t.Name(), sf.Name)
+			}
+		}
+
+		codec.fields = append(codec.fields, fc)
+	}
+
+	actual, _ := structCodecs.LoadOrStore(t, codec)
+	return actual.(*structCodec), nil
+}
+
+// fieldName 返回第 n 个元素的字段名，n < 0 表示不是列表元素
+func (fc *fieldCodec) fieldName(n int) string {
+	if n < 0 {
+		return fc.name
+	}
+	return strings.Replace(fc.name, "$n", strconv.Itoa(n), -1)
+}
+
+func (codec *structCodec) encode(x MchXML, rv reflect.Value, typeName string, n int) error {
+	for _, fc := range codec.fields {
+		fv := rv.Field(fc.index)
+		fieldName := fc.fieldName(n)
+
+		if fc.elem != nil {
+			for i := 0; i < fv.Len(); i++ {
+				if err := fc.elem.encode(x, fv.Index(i), typeName, i); err != nil {
+					return err
+				}
+			}
+			if fv.Len() != 0 {
+				x.fillUint64(uint64(fv.Len()), fc.count)
+			}
+			continue
+		}
+
+		if isZeroField(fv) {
+			if fc.required {
+				return missingRequestField(typeName, fieldName)
+			}
+			continue
+		}
+
+		switch fc.typ {
+		case typeTime:
+			x.fillTime(fv.Interface().(time.Time), fieldName, fc.layout)
+		case typeMoney:
+			m := fv.Interface().(Money)
+			if fc.currency != "" {
+				x.fillMoney(m, fieldName, fc.currency)
+			} else {
+				x.fillUint64(m.Amount, fieldName)
+			}
+		case typeTradeType, typeTradeState, typeRefundStatus, typeSignType, typeCurrency:
+			x.fillStringer(fv.Interface().(fmt.Stringer), fieldName)
+		default:
+			switch fv.Kind() {
+			case reflect.String:
+				x.fillString(fv.String(), fieldName)
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				x.fillInt64(fv.Int(), fieldName)
+			default:
+				x.fillUint64(fv.Uint(), fieldName)
+			}
+		}
+	}
+	return nil
+}
+
+func (codec *structCodec) decode(x MchXML, rv reflect.Value, typeName string, n int, base int) (err error) {
+	for _, fc := range codec.fields {
+		fv := rv.Field(fc.index)
+		fieldName := fc.fieldName(n)
+
+		if fc.elem != nil {
+			var count uint64
+			x.extractUint64(&count, fc.count, &err)
+			if err != nil {
+				return err
+			}
+			// 每个元素至少对应一个 _$n 字段，超出字段总数的个数必然有误，避免按其分配过大的切片
+			if count > uint64(len(x)) {
+				return fmt.Errorf("Field <%s> value %d exceeds the number of fields in %s", fc.count, count, typeName)
+			}
+			fv.Set(reflect.MakeSlice(fc.typ, int(count), int(count)))
+			for i := 0; i < int(count); i++ {
+				if err := fc.elem.decode(x, fv.Index(i), typeName, base+i, base); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if fc.required && x[fieldName] == "" {
+			return missingResponseField(typeName, fieldName)
+		}
+
+		switch fc.typ {
+		case typeTime:
+			x.extractTime(fv.Addr().Interface().(*time.Time), fieldName, fc.layout, &err)
+		case typeMoney:
+			m := fv.Addr().Interface().(*Money)
+			if fc.currency != "" {
+				x.extractMoney(m, fieldName, fc.currency, &err)
+			} else {
+				x.extractUint64(&m.Amount, fieldName, &err)
+				m.Currency = CurrencyCNY
+			}
+		case typeTradeType:
+			x.extractTradeType(fv.Addr().Interface().(*TradeType), fieldName, &err)
+		case typeTradeState:
+			x.extractTradeState(fv.Addr().Interface().(*TradeState), fieldName, &err)
+		case typeRefundStatus:
+			x.extractRefundStatus(fv.Addr().Interface().(*RefundStatus), fieldName, &err)
+		case typeSignType:
+			x.extractSignType(fv.Addr().Interface().(*SignType), fieldName, &err)
+		case typeCurrency:
+			x.extractCurrency(fv.Addr().Interface().(*Currency), fieldName, &err)
+		default:
+			switch fv.Kind() {
+			case reflect.String:
+				var s string
+				x.extractString(&s, fieldName, &err)
+				fv.SetString(s)
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				var i int64
+				x.extractInt64(&i, fieldName, &err)
+				if err == nil && fv.OverflowInt(i) {
+					err = fmt.Errorf("Field <%s> value %d overflows %s", fieldName, i, fc.typ)
+				}
+				fv.SetInt(i)
+			default:
+				var u uint64
+				x.extractUint64(&u, fieldName, &err)
+				if err == nil && fv.OverflowUint(u) {
+					err = fmt.Errorf("Field <%s> value %d overflows %s", fieldName, u, fc.typ)
+				}
+				fv.SetUint(u)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingRequiredFields 返回结构体（或结构体指针）v 中带有 required 选项但为零值的字段名，不检查切片中的元素
+func missingRequiredFields(v interface{}) []string {
+	rv, codec, err := structCodecOf(v)
+	if err != nil {
+		return nil
+	}
+	fieldNames := []string{}
+	for _, fc := range codec.fields {
+		if fc.elem == nil && fc.required && isZeroField(rv.Field(fc.index)) {
+			fieldNames = append(fieldNames, fc.fieldName(-1))
+		}
+	}
+	return fieldNames
+}
+
+func isZeroField(fv reflect.Value) bool {
+	switch fv.Type() {
+	case typeTime:
+		return fv.Interface().(time.Time).IsZero()
+	case typeMoney:
+		return fv.Interface().(Money).IsZero()
+	case typeTradeType, typeTradeState, typeRefundStatus, typeSignType, typeCurrency:
+		return fv.Interface().(fmt.Stringer).String() == ""
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String() == ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fv.Int() == 0
+	default:
+		return fv.Uint() == 0
+	}
+}
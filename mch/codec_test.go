@@ -0,0 +1,189 @@
+package mch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalMchXML(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &UnifiedOrderRequest{
+		OutTradeNo: "20150806125346",
+		TotalFee:   Money{Amount: 888, Currency: CurrencyUSD},
+		Body:       "腾讯充值中心-QQ会员充值",
+		NotifyUrl:  "https://example.com/wxpay/notify",
+		TradeType:  TradeTypeNATIVE,
+		TimeStart:  time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC),
+	}
+	x, err := MarshalMchXML(req)
+	assert.NoError(err)
+	assert.Equal(MchXML{
+		"out_trade_no": "20150806125346",
+		"total_fee":    "888",
+		"fee_type":     "USD",
+		"body":         "腾讯充值中心-QQ会员充值",
+		"notify_url":   "https://example.com/wxpay/notify",
+		"trade_type":   "NATIVE",
+		"time_start":   "20190101100000",
+	}, x)
+
+	// 必填字段，错误与包中的错误变量一致
+	req.Body = ""
+	_, err = MarshalMchXML(req)
+	assert.Equal(ErrUnifiedOrderMissingBody, err)
+	assert.Equal("Missing body in UnifiedOrderRequest", err.Error())
+	var e MissingFieldError
+	assert.True(errors.As(err, &e))
+	assert.Equal("body", e.Field)
+	assert.False(e.InResponse)
+
+	// 切片
+	type item struct {
+		ID  string `mch:"id_$n,required"`
+		Fee Money  `mch:"fee_$n"`
+	}
+	type list struct {
+		Items []item `mch:",count=count"`
+	}
+	x, err = MarshalMchXML(list{Items: []item{{ID: "a", Fee: CNY(1)}, {ID: "b"}}})
+	assert.NoError(err)
+	assert.Equal(MchXML{"count": "2", "id_0": "a", "fee_0": "1", "id_1": "b"}, x)
+	_, err = MarshalMchXML(list{Items: []item{{ID: "a"}, {}}})
+	assert.Equal(MissingFieldError{Type: "list", Field: "id_1"}, err)
+}
+
+func TestUnmarshalMchXML(t *testing.T) {
+	assert := assert.New(t)
+
+	x := MchXML{
+		"out_trade_no":            "1217752501201407033233368018",
+		"transaction_id":          "1008450740201411110005820873",
+		"total_fee":               "100",
+		"fee_type":                "HKD",
+		"cash_fee":                "0",
+		"refund_count":            "2",
+		"refund_id_3":             "r3",
+		"out_refund_no_3":         "o3",
+		"refund_fee_3":            "40",
+		"refund_status_3":         "SUCCESS",
+		"refund_success_time_3":   "2016-07-25 15:26:26",
+		"refund_id_4":             "r4",
+		"out_refund_no_4":         "o4",
+		"refund_fee_4":            "60",
+		"refund_status_4":         "PROCESSING",
+		"refund_channel_4":        "ORIGINAL",
+		"total_refund_count":      "5",
+		"unknown_field_is_ignore": "1",
+	}
+
+	resp := RefundQueryResponse{}
+	assert.NoError(unmarshalMchXML(x, &resp, 3))
+	assert.Equal("1217752501201407033233368018", resp.OutTradeNo)
+	assert.Equal(Money{Amount: 100, Currency: CurrencyHKD}, resp.TotalFee)
+	assert.Equal(CNY(0), resp.CashFee)
+	assert.Equal(uint64(2), resp.RefundCount)
+	assert.Equal(uint64(5), resp.TotalRefundCount)
+	assert.Equal([]RefundInfo{
+		{
+			RefundID:          "r3",
+			OutRefundNo:       "o3",
+			RefundFee:         Money{Amount: 40, Currency: CurrencyHKD},
+			RefundStatus:      RefundStatusSUCCESS,
+			RefundSuccessTime: time.Date(2016, 7, 25, 15, 26, 26, 0, cstTimeZone),
+		},
+		{
+			RefundID:      "r4",
+			OutRefundNo:   "o4",
+			RefundFee:     Money{Amount: 60, Currency: CurrencyHKD},
+			RefundStatus:  RefundStatusPROCESSING,
+			RefundChannel: "ORIGINAL",
+		},
+	}, resp.Refunds)
+
+	// 下标从 0 开始时找不到 refund_id_0
+	err := UnmarshalMchXML(x, &RefundQueryResponse{})
+	assert.Equal(MissingFieldError{Type: "RefundQueryResponse", Field: "refund_id_0", InResponse: true}, err)
+	assert.Equal("No refund_id_0 is returned from RefundQueryResponse", err.Error())
+
+	// 必返回字段
+	delete(x, "transaction_id")
+	assert.Equal(ErrRefundQueryNoTransactionID, unmarshalMchXML(x, &RefundQueryResponse{}, 3))
+
+	// 格式错误
+	x["transaction_id"] = "1008450740201411110005820873"
+	x["refund_status_4"] = "XXX"
+	assert.Error(unmarshalMchXML(x, &RefundQueryResponse{}, 3))
+
+	// 个数超出字段总数时直接报错而不分配切片
+	x["refund_status_4"] = "PROCESSING"
+	for _, count := range []string{"1000000000", "18446744073709551615"} {
+		x["refund_count"] = count
+		assert.EqualError(unmarshalMchXML(x, &RefundQueryResponse{}, 3),
+			"Field <refund_count> value "+count+" exceeds the number of fields in RefundQueryResponse")
+	}
+
+	// 需要结构体指针
+	assert.Error(UnmarshalMchXML(x, RefundQueryResponse{}))
+}
+
+func TestMchXMLCodecBadTag(t *testing.T) {
+	assert := assert.New(t)
+
+	{
+		type T struct {
+			A string `mch:"a,unknown"`
+		}
+		_, err := MarshalMchXML(T{})
+		assert.Error(err)
+	}
+	{
+		type T struct {
+			A float64 `mch:"a"`
+		}
+		_, err := MarshalMchXML(T{})
+		assert.Error(err)
+	}
+	{
+		type T struct {
+			A []string `mch:",count=a_count"`
+		}
+		_, err := MarshalMchXML(T{})
+		assert.Error(err)
+	}
+	{
+		type T struct {
+			A string `mch:",required"`
+		}
+		_, err := MarshalMchXML(T{})
+		assert.Error(err)
+	}
+	{
+		type T struct {
+			A uint8  `mch:"a"`
+			B int    `mch:"b"`
+			C string `mch:"-"`
+			D string
+		}
+		x, err := MarshalMchXML(&T{A: 1, B: -2, C: "c", D: "d"})
+		assert.NoError(err)
+		assert.Equal(MchXML{"a": "1", "b": "-2"}, x)
+
+		t := T{}
+		assert.Error(UnmarshalMchXML(MchXML{"a": "256"}, &t))
+		assert.NoError(UnmarshalMchXML(x, &t))
+		assert.Equal(T{A: 1, B: -2}, t)
+	}
+}
+
+func TestMchXMLKeepFirst(t *testing.T) {
+	assert := assert.New(t)
+
+	x := MchXML{"out_refund_no": "o", "transaction_id": "t", "out_trade_no": "n"}
+	assert.True(x.keepFirst("refund_id", "out_refund_no", "transaction_id", "out_trade_no"))
+	assert.Equal(MchXML{"out_refund_no": "o"}, x)
+	assert.False(MchXML{}.keepFirst("transaction_id", "out_trade_no"))
+}
@@ -3,6 +3,8 @@ package mch
 const (
 	// URLBaseDefault 默认接入点
 	URLBaseDefault = "https://api.mch.weixin.qq.com"
+	// URLBaseDefault2 默认接入点的备用域名，默认接入点出错时可切换到此
+	URLBaseDefault2 = "https://api2.mch.weixin.qq.com"
 	// URLBaseHK 建议东南亚接入点
 	URLBaseHK = "https://apihk.mch.weixin.qq.com"
 	// URLBaseUS 建议其它地区接入点
@@ -59,3 +61,20 @@ var (
 	SignTypeMD5        = SignType{"MD5"}
 	SignTypeHMACSHA256 = SignType{"HMAC-SHA256"}
 )
+
+var (
+	// CurrencyInvalid 表示无效币种，在金额中表示默认币种即人民币
+	CurrencyInvalid = Currency{""}
+	// CurrencyCNY 表示人民币，为微信支付的默认币种
+	CurrencyCNY = Currency{"CNY"}
+	// CurrencyHKD 表示港元
+	CurrencyHKD = Currency{"HKD"}
+	// CurrencyUSD 表示美元
+	CurrencyUSD = Currency{"USD"}
+	// CurrencyEUR 表示欧元
+	CurrencyEUR = Currency{"EUR"}
+	// CurrencyGBP 表示英镑
+	CurrencyGBP = Currency{"GBP"}
+	// CurrencyJPY 表示日元
+	CurrencyJPY = Currency{"JPY"}
+)
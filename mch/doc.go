@@ -15,6 +15,23 @@
 //   resp 响应
 //   err 错误，只有当通讯成功且业务结果成功时，返回空 err
 //
+// 本包尚未封装的接口可使用 Endpoint 描述（路径、是否需要证书、公共字段名、响应是否签名等），
+// 然后以带 `mch` tag 的请求/响应结构体调用 CallEndpoint
+//
+// ##### 错误 ########################################################
+//
+// 调用接口返回的错误主要有以下几类，可使用 errors.As 区分：
+//
+//   *MchCommunicationError 通讯失败（return_code 不为 SUCCESS）
+//   *MchSignError 响应签名不一致
+//   *MchBusinessError 业务失败（result_code 不为 SUCCESS），ErrCode 为 ErrCodeXXX 常量之一
+//
+// 一般不需要直接比较 ErrCode，可使用 IsRetryable/IsOrderNotExist/IsAlreadyPaid 等函数判断
+//
+// 调用接口前会检查请求，缺少必填字段或字段长度/格式不满足要求时返回列出全部问题的 *ValidationError，
+// 可用 errors.Is 判断其中是否有某个问题（如 errors.Is(err, ErrUnifiedOrderMissingOutTradeNo)）；
+// 响应缺少必返回字段时返回 MissingFieldError
+//
 // ##### https ########################################################
 //
 // 有些接口（例如退款接口）需要客户端证书方可方可调用，最简便的方法是在 DefaultOptions
@@ -35,6 +52,39 @@
 //  	}),
 //  )
 //
+// ##### 重试 ########################################################
+//
+// 默认每个接口只调用一次，可使用 UseRetryPolicy 选项开启重试（网络错误、http 5xx 以及 SYSTEMERROR 等可重试的业务错误）；
+// 重试时业务参数不变，因此依赖 out_trade_no/out_refund_no 等保证幂等，非幂等的接口应使用 DeclareNonIdempotent 声明
+// （付款码支付、撤销订单及企业付款/现金红包等付款接口已内置声明，不会重试）
+//
+// ##### 限流 ########################################################
+//
+// 可使用 UseRateLimiter 选项按商户号和接口路径限制调用速率（默认限制见 DefaultRateLimits），以免批量任务触发微信支付的
+// 频率限制（FREQUENCY_LIMITED）而影响在线支付；没有令牌时等待或直接返回 *RateLimitError
+//
+// ##### 熔断 ########################################################
+//
+// 可使用 UseCircuitBreaker 选项在微信支付故障时快速失败：按商户号、接入点和接口路径统计，连续失败（网络错误、http 5xx、
+// SYSTEMERROR 等）达到阈值后直接返回 ErrCircuitOpen 而不必等待超时，一段时间后以少量探测请求恢复；与 Failover
+// 一起使用时会跳过熔断器打开的接入点
+//
+// ##### 回调去重 ########################################################
+//
+// 微信支付在收到 SUCCESS 前会重复回调，可使用 UseNotifyDeduper 选项让 OrderNotify/RefundNotify 对同一事件只执行一次 handler，
+// 重复的回调直接返回 SUCCESS；内置 MemoryNotifyDeduper（内存 LRU）和 FileNotifyDeduper（追加写入的文件）两种实现。
+// handler 成功后记录失败不会让回调失败，而是以 EventKindNotifyDedupe 事件报告给 Observer
+//
+// ##### 监控 ########################################################
+//
+// 可使用 UseObserver 选项接收每次接口调用（包括重试）以及回调处理的事件（Event），包括耗时、状态码、
+// return_code/result_code/err_code 以及错误分类（ErrorClass）；内置的 MetricsCollector 将其统计为
+// Prometheus 文本格式的计数和耗时直方图，可直接作为 http.Handler 暴露；回调处理的事件不使用请求路径，
+// 而使用固定的标签（默认为 "notify"，可使用 UseNotifyLabel 为每个回调分别设置）
+//
+// 可使用 UseTracer 选项接入链路追踪（见 utils.Tracer）：调用接口、处理回调以及回调中再次查询时都会开始一个 span，
+// 并带有 out_trade_no/transaction_id 等属性
+//
 // ##### 签名 ########################################################
 //
 // 目前签名不允许小写，因为安全规范中要求大写，mch 模块中就不做额外的 ToUpper 操作了
@@ -65,4 +115,8 @@
 //
 //   - 退款金额 0.6 美元 （退 60%）
 //   - 现金退款金额 2.1 人民币（== 3.5 人民币 * 0.6)
+//
+// 接口中的金额与其币种合并为 Money 类型，可使用 ExpectedCashRefundFee 按上述比例计算预期的现金退款金额，
+// 使用 ConvertByRate 按汇率换算金额；申请退款时会检查退款币种与标价币种是否一致，若提供了已退款金额
+// （RefundRequest.RefundedFee）还会检查退款金额不超过剩余可退金额
 package mch
@@ -0,0 +1,238 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/conf"
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+var (
+	ErrEndpointBadPath         = errors.New("Endpoint path should start with '/'")
+	ErrEndpointNoRequest       = errors.New("Missing request in CallEndpoint")
+	ErrClientCertRequired      = errors.New("Endpoint requires a http client with client certificate")
+	ErrEndpointSignTypeOmitted = errors.New("Endpoint which omits sign_type only supports MD5")
+)
+
+// Endpoint 描述一个 mch xml 接口，用于 CallEndpoint 调用本包尚未封装的接口；零值字段即 PostMchXML 的默认行为：
+//
+//   - 请求中带有公共字段 appid/mch_id/nonce_str/sign_type/sign
+//   - 使用 options 中的签名类型
+//   - 响应带有签名，且 appid/mch_id（若有返回）须与请求一致
+//   - 接口是否幂等由 DeclareNonIdempotent 决定
+//
+// 例如企业付款到零钱接口：
+//
+//   var transfers = &mch.Endpoint{
+//   	Path:              "/mmpaymkttransfers/promotion/transfers",
+//   	RequireClientCert: true,
+//   	AppIDField:        "mch_appid",
+//   	MchIDField:        "mchid",
+//   	OmitSignType:      true,
+//   	ResponseUnsigned:  true,
+//   	NonIdempotent:     true,
+//   }
+type Endpoint struct {
+	// Path 接口路径，如 "/pay/unifiedorder"
+	Path string
+
+	// RequireClientCert 接口需要客户端证书（如退款接口）；若使用的 client 为 *http.Client 且没有配置客户端证书，
+	// 则不发出请求直接返回 ErrClientCertRequired
+	RequireClientCert bool
+
+	// SignTypes 接口支持的签名类型，为空表示都支持；options 中的签名类型不被支持时使用 SignTypes[0]
+	SignTypes []SignType
+
+	// AppIDField 公共字段 appid 的字段名，为空时为 "appid"（如企业付款接口为 "mch_appid"）
+	AppIDField string
+
+	// MchIDField 公共字段 mch_id 的字段名，为空时为 "mch_id"（如企业付款接口为 "mchid"）
+	MchIDField string
+
+	// OmitSignType 请求中不带 sign_type 字段，此时微信支付按 MD5 验证签名
+	OmitSignType bool
+
+	// ResponseUnsigned 响应没有签名，不验证响应签名
+	ResponseUnsigned bool
+
+	// NonIdempotent 接口不是幂等的，与 DeclareNonIdempotent 效果相同：不会重试，超时也不会切换接入点
+	NonIdempotent bool
+}
+
+func (ep *Endpoint) appIDField() string {
+	if ep.AppIDField != "" {
+		return ep.AppIDField
+	}
+	return "appid"
+}
+
+func (ep *Endpoint) mchIDField() string {
+	if ep.MchIDField != "" {
+		return ep.MchIDField
+	}
+	return "mch_id"
+}
+
+func (ep *Endpoint) idempotent() bool {
+	return !ep.NonIdempotent && isIdempotent(ep.Path)
+}
+
+// signType 返回实际使用的签名类型
+func (ep *Endpoint) signType(options *Options) (SignType, error) {
+	signType := options.SignType()
+	if ep.OmitSignType {
+		for _, st := range ep.SignTypes {
+			if st != SignTypeMD5 {
+				return SignTypeInvalid, ErrEndpointSignTypeOmitted
+			}
+		}
+		return SignTypeMD5, nil
+	}
+	if len(ep.SignTypes) == 0 {
+		return signType, nil
+	}
+	for _, st := range ep.SignTypes {
+		if st == signType {
+			return signType, nil
+		}
+	}
+	return ep.SignTypes[0], nil
+}
+
+// check 检查接口描述以及 client 是否满足接口要求
+func (ep *Endpoint) check(options *Options) error {
+	if !strings.HasPrefix(ep.Path, "/") {
+		return ErrEndpointBadPath
+	}
+	if ep.RequireClientCert && !hasClientCert(options.Client()) {
+		return ErrClientCertRequired
+	}
+	return nil
+}
+
+// hasClientCert 检查 client 是否配置了客户端证书；只能检查 *http.Client（及其 *http.Transport），
+// 其它类型的 client 无法得知，总是返回 true
+func hasClientCert(client utils.HTTPClient) bool {
+	c, ok := client.(*http.Client)
+	if !ok {
+		return true
+	}
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return true
+	}
+	tlsConfig := t.TLSClientConfig
+	return tlsConfig != nil && (len(tlsConfig.Certificates) != 0 || tlsConfig.GetClientCertificate != nil)
+}
+
+// CallEndpoint 调用 ep 描述的接口，用于调用本包尚未封装的接口：
+//
+//   - req 为带 `mch` tag 的结构体或其指针（见 MarshalMchXML），若其实现了 Validate() error 则会在调用前检查
+//   - resp 为带 `mch` tag 的结构体指针（见 UnmarshalMchXML），为 nil 时不解码
+//   - 返回响应的原始数据
+//
+// 例如：
+//
+//   type TransfersRequest struct {
+//   	PartnerTradeNo string    `mch:"partner_trade_no,required"`
+//   	OpenID         string    `mch:"openid,required"`
+//   	CheckName      string    `mch:"check_name,required"`
+//   	Amount         mch.Money `mch:"amount,required"`
+//   	Desc           string    `mch:"desc,required"`
+//   }
+//
+//   type TransfersResponse struct {
+//   	PaymentNo   string    `mch:"payment_no,required"`
+//   	PaymentTime time.Time `mch:"payment_time,layout=2006-01-02 15:04:05"`
+//   }
+//
+//   resp := &TransfersResponse{}
+//   _, err := mch.CallEndpoint(ctx, config, transfers, &TransfersRequest{...}, resp)
+func CallEndpoint(ctx context.Context, config conf.MchConfig, ep *Endpoint, req interface{}, resp interface{}, opts ...Option) (MchXML, error) {
+	options, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil {
+		return nil, ErrEndpointNoRequest
+	}
+
+	// 检查字段长度和格式，在编码前检查以便一次列出全部问题
+	if v, ok := req.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// req -> reqXML
+	reqXML, err := MarshalMchXML(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// reqXML -> respXML
+	respXML, err := PostEndpointXML(ctx, config, ep, reqXML, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// respXML -> resp
+	if resp != nil {
+		if err := UnmarshalMchXML(respXML, resp); err != nil {
+			return respXML, err
+		}
+	}
+	return respXML, nil
+}
+
+// PostEndpointXML 同 PostMchXML，但按 ep 的描述调用接口
+//
+// NOTE: 最终用户一般不需要使用该函数
+func PostEndpointXML(ctx context.Context, config conf.MchConfig, ep *Endpoint, reqXML MchXML, options *Options) (respXML MchXML, err error) {
+	ctx, span := startCallSpan(ctx, ep, reqXML, options)
+	attempt := 0
+	defer func() {
+		endCallSpan(span, attempt, respXML, err)
+	}()
+
+	if err := ep.check(options); err != nil {
+		return nil, err
+	}
+	signType, err := ep.signType(options)
+	if err != nil {
+		return nil, err
+	}
+
+	idempotent := ep.idempotent()
+	retryPolicy := options.RetryPolicy()
+	if !idempotent {
+		retryPolicy = nil
+	}
+
+	observer := options.Observer()
+	for attempt = 1; ; attempt++ {
+		event := &Event{
+			Kind:    EventKindCall,
+			Path:    ep.Path,
+			MchID:   config.WechatMchID(),
+			Attempt: attempt,
+		}
+		start := time.Now()
+		respXML, retryable, err := postMchXML(ctx, config, ep, signType, reqXML, options, idempotent, event)
+		observe(observer, event, start, err)
+		if err == nil {
+			return respXML, nil
+		}
+		if !retryable || !retryPolicy.wait(ctx, attempt) {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package mch
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTransfersRequest struct {
+	PartnerTradeNo string `mch:"partner_trade_no,required"`
+	OpenID         string `mch:"openid,required"`
+	Amount         Money  `mch:"amount,required"`
+	Desc           string `mch:"desc"`
+}
+
+func (req *testTransfersRequest) Validate() error {
+	v := &validator{request: "testTransfersRequest"}
+	v.maxLen("desc", req.Desc, 8)
+	return v.err()
+}
+
+type testTransfersResponse struct {
+	PartnerTradeNo string    `mch:"partner_trade_no,required"`
+	PaymentNo      string    `mch:"payment_no,required"`
+	PaymentTime    time.Time `mch:"payment_time,layout=2006-01-02 15:04:05"`
+}
+
+func TestCallEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	transfers := &Endpoint{
+		Path:             "/mmpaymkttransfers/promotion/transfers",
+		AppIDField:       "mch_appid",
+		MchIDField:       "mchid",
+		OmitSignType:     true,
+		ResponseUnsigned: true,
+		NonIdempotent:    true,
+	}
+	req := &testTransfersRequest{
+		PartnerTradeNo: "10000098201411111234567890",
+		OpenID:         "oxTWIuGaIt6gTKsQRLau2M0yL16E",
+		Amount:         CNY(100),
+		Desc:           "desc",
+	}
+
+	// 成功，响应没有签名
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testRespond(200, MchXML{
+			"return_code":      "SUCCESS",
+			"result_code":      "SUCCESS",
+			"mch_appid":        config.WechatAppID(),
+			"mchid":            config.WechatMchID(),
+			"partner_trade_no": "10000098201411111234567890",
+			"payment_no":       "1000018301201505190181489473",
+			"payment_time":     "2015-05-19 15:26:59",
+		}),
+	}}
+	resp := &testTransfersResponse{}
+	respXML, err := CallEndpoint(context.Background(), config, transfers, req, resp, UseClient(client), UseSignType(SignTypeHMACSHA256))
+	assert.NoError(err)
+	assert.Equal("1000018301201505190181489473", respXML["payment_no"])
+	assert.Equal("1000018301201505190181489473", resp.PaymentNo)
+	assert.Equal(time.Date(2015, 5, 19, 15, 26, 59, 0, cstTimeZone), resp.PaymentTime)
+
+	// 请求使用了描述中的公共字段名，且没有 sign_type，按 MD5 签名
+	reqXML := client.reqs[0]
+	assert.Equal(config.WechatAppID(), reqXML["mch_appid"])
+	assert.Equal(config.WechatMchID(), reqXML["mchid"])
+	assert.Equal("100", reqXML["amount"])
+	for _, fieldName := range []string{"appid", "mch_id", "sign_type"} {
+		_, ok := reqXML[fieldName]
+		assert.False(ok, fieldName)
+	}
+	assert.Equal(SignMchXML(reqXML, SignTypeMD5, config.WechatMchKey()), reqXML["sign"])
+
+	// 响应中的公共字段不一致
+	client = &testSeqClient{resps: []func() (*http.Response, error){
+		testRespond(200, MchXML{
+			"return_code": "SUCCESS",
+			"result_code": "SUCCESS",
+			"mchid":       "10000101",
+		}),
+	}}
+	_, err = CallEndpoint(context.Background(), config, transfers, req, nil, UseClient(client))
+	assert.Error(err)
+
+	// 缺少必填字段/检查字段不通过时不发出请求
+	client = &testSeqClient{}
+	_, err = CallEndpoint(context.Background(), config, transfers, &testTransfersRequest{}, nil, UseClient(client))
+	assert.Equal(MissingFieldError{Type: "testTransfersRequest", Field: "partner_trade_no"}, err)
+	_, err = CallEndpoint(context.Background(), config, transfers, &testTransfersRequest{
+		PartnerTradeNo: "1",
+		OpenID:         "1",
+		Amount:         CNY(1),
+		Desc:           "description",
+	}, nil, UseClient(client))
+	var e *ValidationError
+	assert.True(errors.As(err, &e))
+	_, err = CallEndpoint(context.Background(), config, transfers, nil, nil, UseClient(client))
+	assert.Equal(ErrEndpointNoRequest, err)
+	_, err = CallEndpoint(context.Background(), config, &Endpoint{Path: "pay/x"}, req, nil, UseClient(client))
+	assert.Equal(ErrEndpointBadPath, err)
+	_, err = CallEndpoint(context.Background(), config, &Endpoint{
+		Path:         "/pay/x",
+		OmitSignType: true,
+		SignTypes:    []SignType{SignTypeHMACSHA256},
+	}, req, nil, UseClient(client))
+	assert.Equal(ErrEndpointSignTypeOmitted, err)
+	assert.Len(client.reqs, 0)
+}
+
+func TestEndpointSignType(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		Endpoint *Endpoint
+		Options  *Options
+		Expect   SignType
+	}{
+		{&Endpoint{}, nil, SignTypeMD5},
+		{&Endpoint{}, MustOptions(UseSignType(SignTypeHMACSHA256)), SignTypeHMACSHA256},
+		{&Endpoint{SignTypes: []SignType{SignTypeHMACSHA256}}, nil, SignTypeHMACSHA256},
+		{&Endpoint{SignTypes: []SignType{SignTypeMD5, SignTypeHMACSHA256}}, MustOptions(UseSignType(SignTypeHMACSHA256)), SignTypeHMACSHA256},
+		{&Endpoint{OmitSignType: true}, MustOptions(UseSignType(SignTypeHMACSHA256)), SignTypeMD5},
+	} {
+		signType, err := testCase.Endpoint.signType(testCase.Options)
+		assert.NoError(err)
+		assert.Equal(testCase.Expect, signType)
+	}
+
+	// 响应按实际使用的签名类型验证
+	x := MchXML{
+		"return_code": "SUCCESS",
+		"result_code": "SUCCESS",
+		"appid":       config.WechatAppID(),
+		"mch_id":      config.WechatMchID(),
+	}
+	x["sign"] = SignMchXML(x, SignTypeHMACSHA256, config.WechatMchKey())
+	client := &testSeqClient{resps: []func() (*http.Response, error){testRespond(200, x)}}
+	_, err := PostEndpointXML(context.Background(), config, &Endpoint{
+		Path:      "/pay/x",
+		SignTypes: []SignType{SignTypeHMACSHA256},
+	}, MchXML{}, MustOptions(UseClient(client)))
+	assert.NoError(err)
+	assert.Equal("HMAC-SHA256", client.reqs[0]["sign_type"])
+}
+
+func TestEndpointRequireClientCert(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(hasClientCert(&testSeqClient{}))
+	assert.False(hasClientCert(&http.Client{}))
+	assert.False(hasClientCert(&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{}}}))
+	assert.True(hasClientCert(&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		Certificates: []tls.Certificate{{}},
+	}}}))
+
+	// 退款接口需要证书，不会发出请求
+	_, err := Refund(context.Background(), config, &RefundRequest{
+		OutRefundNo: "1217752501201407033233368018",
+		OutTradeNo:  "1217752501201407033233368018",
+		TotalFee:    CNY(100),
+		RefundFee:   CNY(100),
+	}, UseClient(&http.Client{}))
+	assert.Equal(ErrClientCertRequired, err)
+}
@@ -0,0 +1,153 @@
+package mch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 微信支付接口文档中的错误代码（err_code）
+const (
+	// ----- 公共 -----
+	ErrCodeSYSTEMERROR           = "SYSTEMERROR"           // 系统错误/超时，原参数重试
+	ErrCodeINVALID_REQUEST       = "INVALID_REQUEST"       // 参数错误
+	ErrCodePARAM_ERROR           = "PARAM_ERROR"           // 参数错误
+	ErrCodeNOAUTH                = "NOAUTH"                // 商户无此接口权限
+	ErrCodeAPPID_NOT_EXIST       = "APPID_NOT_EXIST"       // APPID 不存在
+	ErrCodeMCHID_NOT_EXIST       = "MCHID_NOT_EXIST"       // MCHID 不存在
+	ErrCodeAPPID_MCHID_NOT_MATCH = "APPID_MCHID_NOT_MATCH" // appid 和 mch_id 不匹配
+	ErrCodeLACK_PARAMS           = "LACK_PARAMS"           // 缺少参数
+	ErrCodeSIGNERROR             = "SIGNERROR"             // 签名错误
+	ErrCodeXML_FORMAT_ERROR      = "XML_FORMAT_ERROR"      // XML 格式错误
+	ErrCodeREQUIRE_POST_METHOD   = "REQUIRE_POST_METHOD"   // 请使用 post 方法
+	ErrCodePOST_DATA_EMPTY       = "POST_DATA_EMPTY"       // post 数据为空
+	ErrCodeNOT_UTF8              = "NOT_UTF8"              // 编码格式错误
+	ErrCodeFREQUENCY_LIMITED     = "FREQUENCY_LIMITED"     // 频率限制，稍后重试
+
+	// ----- 下单/查单/关单 -----
+	ErrCodeNOTENOUGH         = "NOTENOUGH"         // 余额不足
+	ErrCodeORDERPAID         = "ORDERPAID"         // 商户订单已支付
+	ErrCodeORDERCLOSED       = "ORDERCLOSED"       // 订单已关闭
+	ErrCodeORDERNOTEXIST     = "ORDERNOTEXIST"     // 此交易订单号不存在
+	ErrCodeOUT_TRADE_NO_USED = "OUT_TRADE_NO_USED" // 商户订单号重复
+	ErrCodeUSERPAYING        = "USERPAYING"        // 用户支付中，需要输入密码
+
+	// ----- 退款/查询退款 -----
+	ErrCodeBIZERR_NEED_RETRY     = "BIZERR_NEED_RETRY"     // 退款业务流程错误，需要商户触发重试来解决
+	ErrCodeTRADE_OVERDUE         = "TRADE_OVERDUE"         // 订单已经超过退款期限
+	ErrCodeERROR                 = "ERROR"                 // 业务错误
+	ErrCodeUSER_ACCOUNT_ABNORMAL = "USER_ACCOUNT_ABNORMAL" // 退款请求失败，用户帐号注销
+	ErrCodeINVALID_REQ_TOO_MUCH  = "INVALID_REQ_TOO_MUCH"  // 无效请求过多
+	ErrCodeINVALID_TRANSACTIONID = "INVALID_TRANSACTIONID" // 无效 transaction_id
+	ErrCodeREFUNDNOTEXIST        = "REFUNDNOTEXIST"        // 退款订单查询失败
+	ErrCodeREFUND_FEE_MISMATCH   = "REFUND_FEE_MISMATCH"   // 订单金额或退款金额与之前请求不一致
+	ErrCodeORDER_NOT_READY       = "ORDER_NOT_READY"       // 订单处理中，暂时无法退款
+	ErrCodeCERT_ERROR            = "CERT_ERROR"            // 证书校验错误
+)
+
+// MchHTTPError 是 http 错误，即响应的状态码不为 2xx
+type MchHTTPError struct {
+	// StatusCode 状态码
+	StatusCode int
+	// Status 状态，如 "502 Bad Gateway"
+	Status string
+}
+
+// Error 满足 error 接口
+func (err *MchHTTPError) Error() string {
+	return fmt.Sprintf("MchHTTPError(status=%s)", err.Status)
+}
+
+// MchCommunicationError 是微信支付通讯错误，即返回的 return_code 不为 SUCCESS（此时响应没有签名）
+type MchCommunicationError struct {
+	// ReturnCode 返回状态码 SUCCESS/FAIL
+	ReturnCode string
+	// ReturnMsg 返回信息，如非空，为错误原因
+	ReturnMsg string
+}
+
+// Error 满足 error 接口
+func (err *MchCommunicationError) Error() string {
+	return fmt.Sprintf(
+		"MchCommunicationError(return_code=%s return_msg=%s)",
+		err.ReturnCode,
+		err.ReturnMsg,
+	)
+}
+
+// MchSignError 是签名错误，即响应中的签名与计算出来的签名不一致
+type MchSignError struct {
+	// Expect 计算出来的签名
+	Expect string
+	// Got 响应中的签名
+	Got string
+}
+
+// Error 满足 error 接口
+func (err *MchSignError) Error() string {
+	return fmt.Sprintf("Response <sign> expect %+q but got %+q", err.Expect, err.Got)
+}
+
+// MchBusinessError 是微信支付业务错误
+type MchBusinessError struct {
+	// ResultCode 业务结果 SUCCESS/FAIL
+	ResultCode string
+	// ErrCode 错误代码, 当 ResultCode 为FAIL时返回错误代码
+	ErrCode string
+	// ErrCodeDes 错误代码描述
+	ErrCodeDes string
+}
+
+// Error 满足 error 接口
+func (err *MchBusinessError) Error() string {
+	return fmt.Sprintf(
+		"MchBusinessError(result_code=%s err_code=%s err_code_des=%s)",
+		err.ResultCode,
+		err.ErrCode,
+		err.ErrCodeDes,
+	)
+}
+
+// IsRetryable 当业务错误可使用原参数重试时返回 true
+func (err *MchBusinessError) IsRetryable() bool {
+	switch err.ErrCode {
+	case ErrCodeSYSTEMERROR, ErrCodeBIZERR_NEED_RETRY, ErrCodeFREQUENCY_LIMITED:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrCodeOf 返回 err（或其包装的错误）中 MchBusinessError 的错误代码，若不是业务错误则返回空
+func ErrCodeOf(err error) string {
+	var e *MchBusinessError
+	if errors.As(err, &e) {
+		return e.ErrCode
+	}
+	return ""
+}
+
+// IsRetryable 当 err 为可使用原参数重试的业务错误（SYSTEMERROR/BIZERR_NEED_RETRY/FREQUENCY_LIMITED）时返回 true
+func IsRetryable(err error) bool {
+	var e *MchBusinessError
+	return errors.As(err, &e) && e.IsRetryable()
+}
+
+// IsOrderNotExist 当 err 为订单不存在的业务错误时返回 true
+func IsOrderNotExist(err error) bool {
+	return ErrCodeOf(err) == ErrCodeORDERNOTEXIST
+}
+
+// IsAlreadyPaid 当 err 为订单已支付的业务错误时返回 true
+func IsAlreadyPaid(err error) bool {
+	return ErrCodeOf(err) == ErrCodeORDERPAID
+}
+
+// IsOrderClosed 当 err 为订单已关闭的业务错误时返回 true
+func IsOrderClosed(err error) bool {
+	return ErrCodeOf(err) == ErrCodeORDERCLOSED
+}
+
+// IsRefundNotExist 当 err 为退款单不存在的业务错误时返回 true
+func IsRefundNotExist(err error) bool {
+	return ErrCodeOf(err) == ErrCodeREFUNDNOTEXIST
+}
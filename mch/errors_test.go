@@ -0,0 +1,79 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostMchXMLErrorTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	post := func(data string) error {
+		_, err := PostMchXML(context.Background(), config, "/", MchXML{}, MustOptions(
+			UseClient(TestClient([]byte(data))),
+		))
+		return err
+	}
+
+	{
+		err := post("<xml><return_code>FAIL</return_code><return_msg>签名失败</return_msg></xml>")
+		var e *MchCommunicationError
+		assert.True(errors.As(err, &e))
+		assert.Equal("FAIL", e.ReturnCode)
+		assert.Equal("签名失败", e.ReturnMsg)
+	}
+
+	{
+		err := post("<xml><return_code>SUCCESS</return_code><sign>2C2B2A1D626E750FCFD0ED661E80E3AB</sign></xml>")
+		var e *MchSignError
+		assert.True(errors.As(err, &e))
+		assert.Equal("2C2B2A1D626E750FCFD0ED661E80E3AA", e.Expect)
+		assert.Equal("2C2B2A1D626E750FCFD0ED661E80E3AB", e.Got)
+	}
+
+	{
+		err := post(`<xml>
+			<appid><![CDATA[wxd930ea5d5a258f4f]]></appid>
+			<mch_id><![CDATA[10000100]]></mch_id>
+			<result_code><![CDATA[FAIL]]></result_code>
+			<return_code><![CDATA[SUCCESS]]></return_code>
+			<sign>FE153FF7F03BE9A3A2C7188464320B1D</sign>
+			</xml>`)
+		var e *MchBusinessError
+		assert.True(errors.As(err, &e))
+		assert.Equal("FAIL", e.ResultCode)
+	}
+}
+
+func TestMchBusinessErrorPredicates(t *testing.T) {
+	assert := assert.New(t)
+
+	wrap := func(errCode string) error {
+		return fmt.Errorf("wrapped: %w", &MchBusinessError{ResultCode: "FAIL", ErrCode: errCode})
+	}
+
+	for _, testCase := range []struct {
+		Err                 error
+		ExpectRetryable     bool
+		ExpectOrderNotExist bool
+		ExpectAlreadyPaid   bool
+	}{
+		{nil, false, false, false},
+		{errors.New("other"), false, false, false},
+		{&MchCommunicationError{ReturnCode: "FAIL"}, false, false, false},
+		{wrap(ErrCodeSYSTEMERROR), true, false, false},
+		{wrap(ErrCodeBIZERR_NEED_RETRY), true, false, false},
+		{wrap(ErrCodeFREQUENCY_LIMITED), true, false, false},
+		{wrap(ErrCodeORDERNOTEXIST), false, true, false},
+		{wrap(ErrCodeORDERPAID), false, false, true},
+		{wrap(ErrCodeNOTENOUGH), false, false, false},
+	} {
+		assert.Equal(testCase.ExpectRetryable, IsRetryable(testCase.Err), "IsRetryable(%v)", testCase.Err)
+		assert.Equal(testCase.ExpectOrderNotExist, IsOrderNotExist(testCase.Err), "IsOrderNotExist(%v)", testCase.Err)
+		assert.Equal(testCase.ExpectAlreadyPaid, IsAlreadyPaid(testCase.Err), "IsAlreadyPaid(%v)", testCase.Err)
+	}
+}
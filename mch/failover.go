@@ -0,0 +1,127 @@
+package mch
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+var (
+	// DefaultFailoverCooldown 为接入点失败后被降级的默认时长
+	DefaultFailoverCooldown = 30 * time.Second
+)
+
+// Failover 在多个接入点之间进行故障切换：按顺序使用接入点，当遇到连接错误或超时时，将该接入点降级一段时间（cooldown）
+// 并立即切换到下一个接入点重新发送请求；降级期间的接入点排在健康接入点之后，冷却结束后恢复原有顺序。
+//
+// Failover 需要在多次调用间共享才能记录接入点的健康状况，一般放在 DefaultOptions 或长期使用的 Options 中：
+//
+//   mch.DefaultOptions = mch.MustOptions(
+//   	mch.UseFailover(mch.MustFailover(0, mch.URLBaseDefault, mch.URLBaseDefault2)),
+//   )
+//
+// NOTE: 对于超时的情况，请求可能已被微信支付处理，因此只有幂等的接口（见 DeclareNonIdempotent）才会在超时时切换
+type Failover struct {
+	urlBases []string
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	downUntil map[string]time.Time
+}
+
+// NewFailover 创建一个 Failover，urlBases 为按优先级排列的接入点，cooldown 为接入点失败后被降级的时长，
+// 为 0 时使用 DefaultFailoverCooldown
+func NewFailover(cooldown time.Duration, urlBases ...string) (*Failover, error) {
+	if len(urlBases) == 0 {
+		return nil, errors.New("Empty url base list for Failover")
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultFailoverCooldown
+	}
+
+	failover := &Failover{
+		cooldown:  cooldown,
+		downUntil: map[string]time.Time{},
+	}
+	for _, urlBase := range urlBases {
+		u, err := normalizeURLBase(urlBase)
+		if err != nil {
+			return nil, err
+		}
+		failover.urlBases = append(failover.urlBases, u)
+	}
+	return failover, nil
+}
+
+// MustFailover 是 must 版 NewFailover
+func MustFailover(cooldown time.Duration, urlBases ...string) *Failover {
+	failover, err := NewFailover(cooldown, urlBases...)
+	if err != nil {
+		panic(err)
+	}
+	return failover
+}
+
+// Candidates 返回本次调用时尝试接入点的顺序：健康的接入点按优先级在前，降级中的接入点按恢复时间先后在后
+func (failover *Failover) Candidates() []string {
+	now := utils.Now()
+
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+
+	healthy := make([]string, 0, len(failover.urlBases))
+	down := []string{}
+	for _, urlBase := range failover.urlBases {
+		if until, ok := failover.downUntil[urlBase]; ok && now.Before(until) {
+			down = append(down, urlBase)
+		} else {
+			healthy = append(healthy, urlBase)
+		}
+	}
+	sort.SliceStable(down, func(i, j int) bool {
+		return failover.downUntil[down[i]].Before(failover.downUntil[down[j]])
+	})
+	return append(healthy, down...)
+}
+
+// IsHealthy 当 urlBase 未处于降级状态时返回 true
+func (failover *Failover) IsHealthy(urlBase string) bool {
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	until, ok := failover.downUntil[urlBase]
+	return !ok || !utils.Now().Before(until)
+}
+
+func (failover *Failover) markDown(urlBase string) {
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	failover.downUntil[urlBase] = utils.Now().Add(failover.cooldown)
+}
+
+func (failover *Failover) markUp(urlBase string) {
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	delete(failover.downUntil, urlBase)
+}
+
+// shouldFailover 判断 client.Do 返回的错误是否应该切换接入点：连接错误（DNS 失败、连接被拒绝等）总是切换，
+// 超时则只有幂等接口才切换
+func shouldFailover(err error, idempotent bool) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return idempotent
+	}
+	return false
+}
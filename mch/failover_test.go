@@ -0,0 +1,112 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// testHostClient 对 down 中的 host 返回连接错误，否则返回成功响应
+type testHostClient struct {
+	down  map[string]bool
+	hosts []string
+}
+
+func (c *testHostClient) Do(req *http.Request) (*http.Response, error) {
+	c.hosts = append(c.hosts, req.URL.Host)
+	if c.down[req.URL.Host] {
+		return nil, &url.Error{
+			Op:  "Post",
+			URL: req.URL.String(),
+			Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		}
+	}
+	return testSignedRespond(MchXML{"result_code": "SUCCESS"})()
+}
+
+func TestFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	now := utils.Now
+	current := time.Unix(1490840662, 0)
+	utils.Now = func() time.Time {
+		return current
+	}
+	defer func() {
+		utils.Now = now
+	}()
+
+	failover := MustFailover(time.Minute, URLBaseDefault, URLBaseDefault2+"/some/path", URLBaseHK)
+	client := &testHostClient{down: map[string]bool{"api.mch.weixin.qq.com": true}}
+
+	post := func() (string, error) {
+		var urlBase string
+		_, err := PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, MustOptions(
+			UseClient(client),
+			UseFailover(failover),
+			ReportURLBase(&urlBase),
+		))
+		return urlBase, err
+	}
+
+	// 默认接入点连接失败，切换到备用域名
+	urlBase, err := post()
+	assert.NoError(err)
+	assert.Equal(URLBaseDefault2, urlBase)
+	assert.Equal([]string{"api.mch.weixin.qq.com", "api2.mch.weixin.qq.com"}, client.hosts)
+	assert.False(failover.IsHealthy(URLBaseDefault))
+	assert.Equal([]string{URLBaseDefault2, URLBaseHK, URLBaseDefault}, failover.Candidates())
+
+	// 冷却期内直接使用备用域名
+	client.hosts = nil
+	urlBase, err = post()
+	assert.NoError(err)
+	assert.Equal(URLBaseDefault2, urlBase)
+	assert.Equal([]string{"api2.mch.weixin.qq.com"}, client.hosts)
+
+	// 冷却结束，默认接入点恢复后重新使用
+	current = current.Add(time.Minute)
+	client.down = map[string]bool{}
+	client.hosts = nil
+	urlBase, err = post()
+	assert.NoError(err)
+	assert.Equal(URLBaseDefault, urlBase)
+	assert.True(failover.IsHealthy(URLBaseDefault))
+
+	// 全部失败
+	client.down = map[string]bool{
+		"api.mch.weixin.qq.com":   true,
+		"api2.mch.weixin.qq.com":  true,
+		"apihk.mch.weixin.qq.com": true,
+	}
+	client.hosts = nil
+	_, err = post()
+	assert.Error(err)
+	assert.Len(client.hosts, 3)
+}
+
+func TestShouldFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	timeoutErr := &url.Error{Op: "Post", Err: &net.DNSError{IsTimeout: true}}
+	assert.True(shouldFailover(timeoutErr, true))
+
+	readTimeoutErr := &url.Error{Op: "Post", Err: &net.OpError{Op: "read", Err: &timeoutError{}}}
+	assert.True(shouldFailover(readTimeoutErr, true))
+	assert.False(shouldFailover(readTimeoutErr, false))
+
+	assert.False(shouldFailover(errors.New("other"), true))
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
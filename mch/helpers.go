@@ -9,37 +9,16 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"hash"
 	"net/http"
-	"sort"
+	"time"
 
 	"github.com/huangjunwen/wx-driver/conf"
 	"github.com/huangjunwen/wx-driver/utils"
 )
 
-// MchBusinessError 是微信支付业务错误
-type MchBusinessError struct {
-	// ResultCode 业务结果 SUCCESS/FAIL
-	ResultCode string
-	// ErrCode 错误代码, 当 ResultCode 为FAIL时返回错误代码
-	ErrCode string
-	// ErrCodeDes 错误代码描述
-	ErrCodeDes string
-}
-
-// Error 满足 error 接口
-func (err *MchBusinessError) Error() string {
-	return fmt.Sprintf(
-		"MchBusinessError(result_code=%s err_code=%s err_code_des=%s)",
-		err.ResultCode,
-		err.ErrCode,
-		err.ErrCodeDes,
-	)
-}
-
 // SignMchXML 对 MchXML 进行签名，签名算法见微信支付《安全规范》，signType 为空时默认使用 MD5，
 // x 中 sign 字段和空值字段皆不参与签名；返回的签名字符串为大写
 //
@@ -54,15 +33,8 @@ func SignMchXML(x MchXML, signType SignType, mchKey string) string {
 		h = md5.New()
 	}
 
-	// 排序字段名
-	fieldNames := make([]string, 0, len(x))
-	for fieldName, _ := range x {
-		fieldNames = append(fieldNames, fieldName)
-	}
-	sort.Strings(fieldNames)
-
-	// 签名
-	for _, fieldName := range fieldNames {
+	// 按字段名排序后签名
+	for _, fieldName := range x.sortedFieldNames() {
 		// sign 不参与签名
 		if fieldName == "sign" {
 			continue
@@ -135,12 +107,7 @@ func DecryptMchXML(mchKey string, cipherText string) (MchXML, error) {
 	plainBytes = plainBytes[:l-p]
 
 	// xml 解码
-	x := MchXML{}
-	if err := xml.Unmarshal(plainBytes, &x); err != nil {
-		return nil, err
-	}
-
-	return x, nil
+	return DecodeMchXML(plainBytes)
 }
 
 // PostMchXML 调用 mch xml 接口，大致过程如下：
@@ -148,93 +115,202 @@ func DecryptMchXML(mchKey string, cipherText string) (MchXML, error) {
 //   - 添加公共字段 appid/mch_id/mch_id/nonce_str/sign_type
 //   - 签名并添加 sign
 //   - 调用 api，等待结果或错误
+//   - 检查 http 状态码
 //   - 检查 return_code/return_msg
 //   - 验证签名
 //   - 验证 appid/mch_id
 //   - 检查 result_code
 //
+// 若 options 中设置了 RetryPolicy 且接口是幂等的，则失败时会按策略重试
+//
+// 若需要调用公共字段、签名等与上述不同的接口，见 Endpoint 和 PostEndpointXML
+//
 // NOTE: 最终用户一般不需要使用该函数
 func PostMchXML(ctx context.Context, config conf.MchConfig, path string, reqXML MchXML, options *Options) (MchXML, error) {
-	client := options.Client()
-	urlBase := options.URLBase()
-	signType := options.SignType()
+	return PostEndpointXML(ctx, config, &Endpoint{Path: path}, reqXML, options)
+}
+
+// postMchXML 调用一次 mch xml 接口，返回的 retryable 表示该错误是否可以重试；过程中的状态码等信息会记录到 event 中
+func postMchXML(ctx context.Context, config conf.MchConfig, ep *Endpoint, signType SignType, reqXML MchXML, options *Options, idempotent bool, event *Event) (respXML MchXML, retryable bool, err error) {
+	// 限流
+	if err := options.RateLimiter().wait(ctx, config.WechatMchID(), ep.Path); err != nil {
+		return nil, false, err
+	}
 
-	// 添加公共字段
-	reqXML["appid"] = config.WechatAppID()
-	reqXML["mch_id"] = config.WechatMchID()
-	reqXML["sign_type"] = signType.String()
+	// 添加公共字段，每次调用都重新生成 nonce_str
+	reqXML[ep.appIDField()] = config.WechatAppID()
+	reqXML[ep.mchIDField()] = config.WechatMchID()
+	if !ep.OmitSignType {
+		reqXML["sign_type"] = signType.String()
+	}
 	reqXML["nonce_str"] = utils.NonceStr(16) // 32 位以内
 
 	// 签名
 	reqXML["sign"] = SignMchXML(reqXML, signType, config.WechatMchKey())
 
 	// 编码
-	reqBody, err := xml.Marshal(reqXML)
-	if err != nil {
-		return nil, err
-	}
-
-	// 构造请求
-	req, err := http.NewRequest("POST", urlBase+path, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, err
-	}
-	req = req.WithContext(ctx)
+	reqBody := EncodeMchXML(reqXML)
 
 	// 调用!
-	resp, err := client.Do(req)
+	resp, report, err := doMchRequest(ctx, config.WechatMchID(), ep.Path, reqBody, options, idempotent)
 	if err != nil {
-		return nil, err
+		// 熔断器打开，不需要重试
+		if err == ErrCircuitOpen {
+			return nil, false, err
+		}
+		// ctx 结束导致的错误不需要重试
+		if ctx.Err() != nil {
+			event.ErrorClass = ErrorClassContext
+			return nil, false, err
+		}
+		event.ErrorClass = ErrorClassNetwork
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	defer func() {
+		report(circuitResultOf(err))
+	}()
+	event.StatusCode = resp.StatusCode
+
+	// 检查 http 状态码
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, resp.StatusCode >= 500, &MchHTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
 	}
 
 	// 解码
-	respXML := MchXML{}
-	if err := xml.NewDecoder(resp.Body).Decode(&respXML); err != nil {
-		return nil, err
+	respXML, err = ReadMchXML(resp.Body)
+	if err != nil {
+		event.ErrorClass = ErrorClassDecode
+		return nil, false, err
 	}
+	event.ReturnCode = respXML["return_code"]
+	event.ResultCode = respXML["result_code"]
+	event.ErrCode = respXML["err_code"]
 
 	// 检查通讯标识 return_code，若失败是没有签名的
 	if respXML["return_code"] != "SUCCESS" {
-		return nil, fmt.Errorf("Response return_code=%+q return_msg=%+q", respXML["return_code"], respXML["return_msg"])
+		return nil, false, &MchCommunicationError{
+			ReturnCode: respXML["return_code"],
+			ReturnMsg:  respXML["return_msg"],
+		}
 	}
 
 	// 验证签名
-	sign := SignMchXML(respXML, signType, config.WechatMchKey())
-	suppliedSign := respXML["sign"]
-	if suppliedSign == "" || suppliedSign != sign {
-		return nil, fmt.Errorf("Response <sign> expect %+q but got %+q", sign, suppliedSign)
+	if !ep.ResponseUnsigned {
+		sign := SignMchXML(respXML, signType, config.WechatMchKey())
+		suppliedSign := respXML["sign"]
+		if suppliedSign == "" || suppliedSign != sign {
+			return nil, false, &MchSignError{
+				Expect: sign,
+				Got:    suppliedSign,
+			}
+		}
 	}
 
 	// 验证 appID 和 mchID
-	appID := respXML["appid"]
-	mchID := respXML["mch_id"]
+	appID := respXML[ep.appIDField()]
+	mchID := respXML[ep.mchIDField()]
 	if appID != "" && appID != config.WechatAppID() {
-		return nil, fmt.Errorf("Response <appid> expect %+q but got %+q", config.WechatAppID(), appID)
+		return nil, false, fmt.Errorf("Response <%s> expect %+q but got %+q", ep.appIDField(), config.WechatAppID(), appID)
 	}
 	if mchID != "" && mchID != config.WechatMchID() {
-		return nil, fmt.Errorf("Response <mch_id> expect %+q but got %+q", config.WechatMchID(), mchID)
+		return nil, false, fmt.Errorf("Response <%s> expect %+q but got %+q", ep.mchIDField(), config.WechatMchID(), mchID)
 	}
 
 	// 检查业务标识 result_code
 	if respXML["result_code"] != "SUCCESS" {
-		return nil, &MchBusinessError{
+		err := &MchBusinessError{
 			ResultCode: respXML["result_code"],
 			ErrCode:    respXML["err_code"],
 			ErrCodeDes: respXML["err_code_des"],
 		}
+		return nil, err.IsRetryable(), err
 	}
 
 	// 全部通过
-	return respXML, nil
+	return respXML, false, nil
 
 }
 
+// doMchRequest 发送请求，若设置了 Failover 则遇到连接错误/超时时依次切换接入点，若设置了 CircuitBreaker 则跳过熔断器打开的接入点；
+// 收到响应后须调用返回的 report 向熔断器报告结果
+func doMchRequest(ctx context.Context, mchID, path string, reqBody []byte, options *Options, idempotent bool) (resp *http.Response, report func(circuitResult), err error) {
+	client := options.Client()
+	failover := options.Failover()
+	breaker := options.CircuitBreaker()
+
+	urlBases := []string{options.URLBase()}
+	if failover != nil {
+		urlBases = failover.Candidates()
+	}
+
+	for _, urlBase := range urlBases {
+		// 检查熔断器
+		key := circuitKey{mchID: mchID, urlBase: urlBase, path: path}
+		probe, ok := breaker.allow(key)
+		if !ok {
+			err = ErrCircuitOpen
+			continue
+		}
+
+		// 构造请求
+		var req *http.Request
+		req, err = http.NewRequest("POST", urlBase+path, bytes.NewReader(reqBody))
+		if err != nil {
+			breaker.done(key, probe, circuitIgnore)
+			return nil, nil, err
+		}
+		req = req.WithContext(ctx)
+
+		resp, err = client.Do(req)
+		if err == nil {
+			if failover != nil {
+				failover.markUp(urlBase)
+			}
+			if options != nil && options.urlBaseReport != nil {
+				*options.urlBaseReport = urlBase
+			}
+			return resp, func(result circuitResult) {
+				breaker.done(key, probe, result)
+			}, nil
+		}
+
+		// ctx 结束导致的错误不计入熔断器
+		if ctx.Err() != nil {
+			breaker.done(key, probe, circuitIgnore)
+			break
+		}
+		breaker.done(key, probe, circuitFailure)
+
+		if failover == nil || !shouldFailover(err, idempotent) {
+			break
+		}
+		failover.markDown(urlBase)
+	}
+	return nil, nil, err
+}
+
 // HandleMchXML 处理 mch xml 回调，若 handler 返回非 nil error，则该 http.Handler 返回 FAIL return_code 给微信
 //
 // NOTE: 最终用户一般不需要使用该函数
 func HandleMchXML(handler func(context.Context, MchXML) error, options *Options) http.Handler {
 
+	observer := options.Observer()
+	tracer := options.Tracer()
+	label := options.NotifyLabel()
 	return options.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "mch.notify "+label)
+		span.SetAttribute("path", label)
+		event := &Event{
+			Kind:       EventKindNotify,
+			Path:       label,
+			Attempt:    1,
+			StatusCode: http.StatusOK,
+		}
+		start := time.Now()
 
 		writeResponse := func(success bool, msg string) {
 			respXML := MchXML{}
@@ -246,24 +322,41 @@ func HandleMchXML(handler func(context.Context, MchXML) error, options *Options)
 			if msg != "" {
 				respXML["return_msg"] = msg
 			}
-			xml.NewEncoder(w).Encode(respXML)
+			WriteMchXML(w, respXML)
 		}
 
 		// 解码
-		reqXML := MchXML{}
-		if err := xml.NewDecoder(r.Body).Decode(&reqXML); err != nil {
+		reqXML, err := ReadMchXML(r.Body)
+		if err != nil {
+			event.ErrorClass = ErrorClassDecode
+			observe(observer, event, start, err)
+			endSpan(span, err)
 			writeResponse(false, "Invalid xml")
 			return
 		}
+		event.MchID = reqXML["mch_id"]
+		event.ReturnCode = reqXML["return_code"]
+		event.ResultCode = reqXML["result_code"]
+		event.ErrCode = reqXML["err_code"]
+		setSpanAttributes(span, reqXML)
 
 		// 检查通讯标识 return code，若失败了还回调 ??!
 		if reqXML["return_code"] != "SUCCESS" {
+			err := &MchCommunicationError{
+				ReturnCode: reqXML["return_code"],
+				ReturnMsg:  reqXML["return_msg"],
+			}
+			observe(observer, event, start, err)
+			endSpan(span, err)
 			writeResponse(false, "Failed return_code")
 			return
 		}
 
 		// 执行 handler
-		if err := handler(r.Context(), reqXML); err != nil {
+		err = handler(ctx, reqXML)
+		observe(observer, event, start, err)
+		endSpan(span, err)
+		if err != nil {
 			writeResponse(false, err.Error())
 			return
 		}
@@ -0,0 +1,220 @@
+package mch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultLatencyBuckets 为 MetricsCollector 默认的耗时直方图分桶（秒）
+	DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// MetricsCollector 是内置的 Observer，统计调用接口和处理回调的次数、重试次数以及耗时直方图，
+// 并以 Prometheus 文本格式输出（本身是一个 http.Handler），不依赖 Prometheus 的客户端库：
+//
+//   wx_mch_events_total{kind,path,mch_id,error_class,err_code} 事件数
+//   wx_mch_retries_total{kind,path,mch_id} 重试数（Attempt > 1 的调用）
+//   wx_mch_latency_seconds{kind,path} 耗时直方图
+type MetricsCollector struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	events    map[metricsEventKey]uint64
+	retries   map[metricsRetryKey]uint64
+	latencies map[metricsLatencyKey]*metricsHistogram
+}
+
+type metricsEventKey struct {
+	kind       EventKind
+	path       string
+	mchID      string
+	errorClass ErrorClass
+	errCode    string
+}
+
+type metricsRetryKey struct {
+	kind  EventKind
+	path  string
+	mchID string
+}
+
+type metricsLatencyKey struct {
+	kind EventKind
+	path string
+}
+
+type metricsHistogram struct {
+	counts []uint64 // 与 buckets 对应，非累计
+	sum    float64
+	count  uint64
+}
+
+var (
+	_ Observer     = (*MetricsCollector)(nil)
+	_ http.Handler = (*MetricsCollector)(nil)
+)
+
+// NewMetricsCollector 创建 MetricsCollector，buckets 为耗时直方图的分桶上限（秒），为空时使用 DefaultLatencyBuckets
+func NewMetricsCollector(buckets ...float64) *MetricsCollector {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &MetricsCollector{
+		buckets:   buckets,
+		events:    map[metricsEventKey]uint64{},
+		retries:   map[metricsRetryKey]uint64{},
+		latencies: map[metricsLatencyKey]*metricsHistogram{},
+	}
+}
+
+// Observe 实现 Observer 接口
+func (c *MetricsCollector) Observe(event *Event) {
+	seconds := event.Latency.Seconds()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events[metricsEventKey{
+		kind:       event.Kind,
+		path:       event.Path,
+		mchID:      event.MchID,
+		errorClass: event.ErrorClass,
+		errCode:    event.ErrCode,
+	}]++
+
+	if event.Attempt > 1 {
+		c.retries[metricsRetryKey{
+			kind:  event.Kind,
+			path:  event.Path,
+			mchID: event.MchID,
+		}]++
+	}
+
+	latencyKey := metricsLatencyKey{kind: event.Kind, path: event.Path}
+	h := c.latencies[latencyKey]
+	if h == nil {
+		h = &metricsHistogram{counts: make([]uint64, len(c.buckets))}
+		c.latencies[latencyKey] = h
+	}
+	for i, upper := range c.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP 实现 http.Handler 接口，输出 Prometheus 文本格式
+func (c *MetricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WritePrometheus(w)
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出统计结果，输出按标签排序
+func (c *MetricsCollector) WritePrometheus(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	// 事件数
+	fmt.Fprintln(bw, "# HELP wx_mch_events_total Number of mch calls and notifications.")
+	fmt.Fprintln(bw, "# TYPE wx_mch_events_total counter")
+	lines := make([]string, 0, len(c.events))
+	for key, n := range c.events {
+		lines = append(lines, fmt.Sprintf("wx_mch_events_total%s %d", formatLabels(
+			"kind", string(key.kind),
+			"path", key.path,
+			"mch_id", key.mchID,
+			"error_class", string(key.errorClass),
+			"err_code", key.errCode,
+		), n))
+	}
+	writeSortedLines(bw, lines)
+
+	// 重试数
+	fmt.Fprintln(bw, "# HELP wx_mch_retries_total Number of retried mch calls.")
+	fmt.Fprintln(bw, "# TYPE wx_mch_retries_total counter")
+	lines = lines[:0]
+	for key, n := range c.retries {
+		lines = append(lines, fmt.Sprintf("wx_mch_retries_total%s %d", formatLabels(
+			"kind", string(key.kind),
+			"path", key.path,
+			"mch_id", key.mchID,
+		), n))
+	}
+	writeSortedLines(bw, lines)
+
+	// 耗时
+	fmt.Fprintln(bw, "# HELP wx_mch_latency_seconds Latency of mch calls and notifications.")
+	fmt.Fprintln(bw, "# TYPE wx_mch_latency_seconds histogram")
+	keys := make([]metricsLatencyKey, 0, len(c.latencies))
+	for key := range c.latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].path < keys[j].path
+	})
+	for _, key := range keys {
+		h := c.latencies[key]
+		cumulative := uint64(0)
+		for i, upper := range c.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(bw, "wx_mch_latency_seconds_bucket%s %d\n", formatLabels(
+				"kind", string(key.kind),
+				"path", key.path,
+				"le", strconv.FormatFloat(upper, 'g', -1, 64),
+			), cumulative)
+		}
+		labels := formatLabels("kind", string(key.kind), "path", key.path)
+		fmt.Fprintf(bw, "wx_mch_latency_seconds_bucket%s %d\n", formatLabels(
+			"kind", string(key.kind),
+			"path", key.path,
+			"le", "+Inf",
+		), h.count)
+		fmt.Fprintf(bw, "wx_mch_latency_seconds_sum%s %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(bw, "wx_mch_latency_seconds_count%s %d\n", labels, h.count)
+	}
+
+	return bw.Flush()
+}
+
+func writeSortedLines(w io.Writer, lines []string) {
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels 将 name1, value1, name2, value2... 格式化为 {name1="value1",name2="value2"}
+func formatLabels(nameValues ...string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(nameValues[i])
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueReplacer.Replace(nameValues[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
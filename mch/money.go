@@ -0,0 +1,127 @@
+package mch
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrMoneyCurrencyMismatch = errors.New("Currency mismatch between money")
+	ErrMoneyNegative         = errors.New("Money can not be negative")
+	ErrMoneyOverflow         = errors.New("Money overflow")
+	ErrMoneyZeroTotal        = errors.New("Total money can not be zero")
+)
+
+// Money 表示金额，接口中的各类金额字段（total_fee/cash_fee/refund_fee/cash_refund_fee）与其对应的
+// 币种字段（fee_type/cash_fee_type/refund_fee_type/cash_refund_fee_type）合并为一个 Money
+type Money struct {
+	// Amount 金额，单位为该币种的最小单位，如人民币为分
+	Amount uint64
+
+	// Currency 币种，为空时表示默认币种即人民币
+	Currency Currency
+}
+
+// CNY 返回以分为单位的人民币金额
+func CNY(amount uint64) Money {
+	return Money{Amount: amount, Currency: CurrencyCNY}
+}
+
+// currency 返回币种，为空时返回人民币
+func (m Money) currency() Currency {
+	if !m.Currency.IsValid() {
+		return CurrencyCNY
+	}
+	return m.Currency
+}
+
+// String 实现 Stringer 接口，形如 "100 CNY"
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Amount, m.currency())
+}
+
+// IsZero 当金额为 0 时返回 true
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// SameCurrency 当两个金额的币种相同时返回 true，空币种视为人民币
+func (m Money) SameCurrency(other Money) bool {
+	return m.currency() == other.currency()
+}
+
+// Equal 当两个金额的数额与币种都相同时返回 true，空币种视为人民币
+func (m Money) Equal(other Money) bool {
+	return m.Amount == other.Amount && m.SameCurrency(other)
+}
+
+// Add 返回 m + other，币种必须一致
+func (m Money) Add(other Money) (Money, error) {
+	if !m.SameCurrency(other) {
+		return Money{}, ErrMoneyCurrencyMismatch
+	}
+	sum := m.Amount + other.Amount
+	if sum < m.Amount {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: sum, Currency: m.currency()}, nil
+}
+
+// Sub 返回 m - other，币种必须一致且结果不能为负
+func (m Money) Sub(other Money) (Money, error) {
+	if !m.SameCurrency(other) {
+		return Money{}, ErrMoneyCurrencyMismatch
+	}
+	if other.Amount > m.Amount {
+		return Money{}, ErrMoneyNegative
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.currency()}, nil
+}
+
+// ExpectedCashRefundFee 计算预期的现金退款金额，根据（见包文档的金额说明）：
+//
+//   refund_fee/total_fee == cash_refund_fee/cash_fee
+//
+// 即 cash_refund_fee = cash_fee * refund_fee / total_fee（四舍五入），返回的币种与 cashFee 一致；
+// refundFee 与 totalFee 的币种必须一致
+//
+// NOTE: 由于取整的原因，结果与微信支付计算的结果可能相差 1 个最小单位
+func ExpectedCashRefundFee(totalFee, cashFee, refundFee Money) (Money, error) {
+	if !totalFee.SameCurrency(refundFee) {
+		return Money{}, ErrMoneyCurrencyMismatch
+	}
+	if totalFee.IsZero() {
+		return Money{}, ErrMoneyZeroTotal
+	}
+	if refundFee.Amount > totalFee.Amount {
+		return Money{}, fmt.Errorf("Refund fee %s exceeds total fee %s", refundFee, totalFee)
+	}
+	amount := mulDivRound(cashFee.Amount, refundFee.Amount, totalFee.Amount)
+	return Money{Amount: amount, Currency: cashFee.currency()}, nil
+}
+
+// ConvertByRate 按汇率 rate（接口中 rate 字段的值，即标价币种与支付币种的兑换比例乘以 10^8）将标价币种的金额
+// 换算为支付币种 to 的金额（四舍五入），例如用于估算外币订单的现金支付金额
+//
+// NOTE: 假设两个币种最小单位的换算关系与其主单位一致（例如美元和人民币都以 1/100 为最小单位）
+func ConvertByRate(m Money, rate uint64, to Currency) Money {
+	if !to.IsValid() {
+		to = CurrencyCNY
+	}
+	return Money{
+		Amount:   mulDivRound(m.Amount, rate, 100000000),
+		Currency: to,
+	}
+}
+
+// mulDivRound 返回 a * b / c 四舍五入的结果
+func mulDivRound(a, b, c uint64) uint64 {
+	n := new(big.Int).Mul(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+	d := new(big.Int).SetUint64(c)
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Lsh(r, 1).Cmp(d) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q.Uint64()
+}
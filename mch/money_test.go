@@ -0,0 +1,125 @@
+package mch
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoney(t *testing.T) {
+	assert := assert.New(t)
+
+	usd := func(amount uint64) Money {
+		return Money{Amount: amount, Currency: CurrencyUSD}
+	}
+
+	// 空币种视为人民币
+	assert.True(Money{Amount: 1}.Equal(CNY(1)))
+	assert.Equal("1 CNY", Money{Amount: 1}.String())
+	assert.False(CNY(1).SameCurrency(usd(1)))
+
+	sum, err := Money{Amount: 1}.Add(CNY(2))
+	assert.NoError(err)
+	assert.Equal(CNY(3), sum)
+	_, err = CNY(1).Add(usd(2))
+	assert.Equal(ErrMoneyCurrencyMismatch, err)
+	_, err = CNY(1).Add(CNY(^uint64(0)))
+	assert.Equal(ErrMoneyOverflow, err)
+
+	diff, err := usd(3).Sub(usd(1))
+	assert.NoError(err)
+	assert.Equal(usd(2), diff)
+	_, err = usd(1).Sub(usd(3))
+	assert.Equal(ErrMoneyNegative, err)
+	_, err = usd(3).Sub(CNY(1))
+	assert.Equal(ErrMoneyCurrencyMismatch, err)
+
+	// 包文档中的例子：标价 1 美元，现金支付 3.5 人民币，退款 0.6 美元，则现金退款 2.1 人民币
+	cashRefundFee, err := ExpectedCashRefundFee(usd(100), CNY(350), usd(60))
+	assert.NoError(err)
+	assert.Equal(CNY(210), cashRefundFee)
+	// 四舍五入
+	cashRefundFee, err = ExpectedCashRefundFee(CNY(3), CNY(2), CNY(1))
+	assert.NoError(err)
+	assert.Equal(CNY(1), cashRefundFee)
+	_, err = ExpectedCashRefundFee(usd(100), CNY(350), CNY(60))
+	assert.Equal(ErrMoneyCurrencyMismatch, err)
+	_, err = ExpectedCashRefundFee(usd(100), CNY(350), usd(101))
+	assert.Error(err)
+	_, err = ExpectedCashRefundFee(usd(0), CNY(350), usd(0))
+	assert.Equal(ErrMoneyZeroTotal, err)
+
+	// 汇率 7 即 700000000
+	assert.Equal(CNY(350), ConvertByRate(usd(50), 700000000, CurrencyCNY))
+	assert.Equal(CNY(7), ConvertByRate(usd(1), 654321000, CurrencyInvalid))
+}
+
+func TestRefundMoneyCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		TotalFee    Money
+		RefundFee   Money
+		RefundedFee Money
+		ExpectErr   error
+	}{
+		{CNY(100), Money{Amount: 100, Currency: CurrencyUSD}, Money{}, ErrRefundFeeTypeMismatch},
+		{CNY(100), CNY(101), Money{}, ErrRefundExceedsRefundable},
+		{CNY(100), CNY(50), CNY(51), ErrRefundExceedsRefundable},
+		{Money{Amount: 100, Currency: CurrencyUSD}, Money{Amount: 101, Currency: CurrencyUSD}, Money{}, ErrRefundExceedsRefundable},
+	} {
+		_, err := Refund(context.Background(), config, &RefundRequest{
+			OutRefundNo: "R1",
+			OutTradeNo:  "1217752501201407033233368018",
+			TotalFee:    testCase.TotalFee,
+			RefundFee:   testCase.RefundFee,
+			RefundedFee: testCase.RefundedFee,
+		}, UseClient(TestClient(nil)))
+		assert.Equal(testCase.ExpectErr, err)
+	}
+
+	// 通过检查后才调用接口
+	client := &testSeqClient{resps: []func() (*http.Response, error){testBusinessErrorRespond(ErrCodeNOTENOUGH)}}
+	_, err := Refund(context.Background(), config, &RefundRequest{
+		OutRefundNo: "R1",
+		OutTradeNo:  "1217752501201407033233368018",
+		TotalFee:    CNY(100),
+		RefundFee:   CNY(50),
+		RefundedFee: CNY(50),
+	}, UseClient(client))
+	assert.Equal(ErrCodeNOTENOUGH, ErrCodeOf(err))
+	assert.Len(client.reqs, 1)
+	assert.Equal("50", client.reqs[0]["refund_fee"])
+	assert.Equal("CNY", client.reqs[0]["refund_fee_type"])
+}
+
+func TestRefundForeignCurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	// 外币退款，不提供已退款金额
+	client := &testSeqClient{resps: []func() (*http.Response, error){testSignedRespond(MchXML{
+		"result_code":     "SUCCESS",
+		"transaction_id":  "1008450740201411110005820873",
+		"out_trade_no":    "1217752501201407033233368018",
+		"out_refund_no":   "R1",
+		"refund_id":       "2008450740201411110000174436",
+		"refund_fee":      "60",
+		"total_fee":       "100",
+		"fee_type":        "USD",
+		"cash_fee":        "700",
+		"cash_fee_type":   "CNY",
+		"cash_refund_fee": "420",
+	})}}
+	_, err := Refund(context.Background(), config, &RefundRequest{
+		OutRefundNo: "R1",
+		OutTradeNo:  "1217752501201407033233368018",
+		TotalFee:    Money{Amount: 100, Currency: CurrencyUSD},
+		RefundFee:   Money{Amount: 60, Currency: CurrencyUSD},
+	}, UseClient(client))
+	assert.NoError(err)
+	assert.Len(client.reqs, 1)
+	assert.Equal("60", client.reqs[0]["refund_fee"])
+	assert.Equal("USD", client.reqs[0]["refund_fee_type"])
+}
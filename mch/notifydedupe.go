@@ -0,0 +1,258 @@
+package mch
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/conf"
+)
+
+var (
+	// DefaultNotifyDeduperCapacity 为 MemoryNotifyDeduper 默认记录的事件数
+	DefaultNotifyDeduperCapacity = 10000
+)
+
+var (
+	ErrNotifyDeduperBadKey = errors.New("Notify deduper key should not be empty or contain line breaks")
+)
+
+// NotifyDeduper 用于回调去重：微信支付在收到 SUCCESS 前会多次重复回调，设置后（见 UseNotifyDeduper）OrderNotify/RefundNotify
+// 对同一事件只在首次成功处理时执行 handler，重复的回调直接返回 SUCCESS。事件的 key 为：
+//
+//   - 支付结果通知："order:" + transaction_id + ":" + trade_state
+//   - 退款结果通知："refund:" + refund_id + ":" + refund_status
+//
+// 只有 handler 成功后才会调用 Mark，因此 handler 失败时微信支付重新回调仍会再次执行 handler；
+// 而 handler 成功后 Mark 失败时仍返回 SUCCESS（否则微信支付重新回调会再次执行已成功的 handler），
+// 该错误以 EventKindNotifyDedupe 事件报告给 Observer
+//
+// NOTE: 同一事件的回调若并发到达，仍可能都执行 handler，handler 本身仍需保证幂等
+type NotifyDeduper interface {
+	// Seen 返回 key 对应的事件是否已被成功处理过
+	Seen(ctx context.Context, key string) (bool, error)
+
+	// Mark 记录 key 对应的事件已被成功处理
+	Mark(ctx context.Context, key string) error
+}
+
+// orderNotifyKey 返回支付结果通知的去重 key
+func orderNotifyKey(resp *OrderQueryResponse) string {
+	if resp.TransactionID == "" {
+		return ""
+	}
+	return "order:" + resp.TransactionID + ":" + resp.TradeState.String()
+}
+
+// refundNotifyKey 返回退款结果通知的去重 key
+func refundNotifyKey(refundID string, resp *RefundQueryResponse) string {
+	for _, refund := range resp.Refunds {
+		if refund.RefundID == refundID {
+			return "refund:" + refundID + ":" + refund.RefundStatus.String()
+		}
+	}
+	return ""
+}
+
+// dedupeNotify 若 key 对应的事件没有被成功处理过则执行 handle，成功后记录；deduper 为 nil 或 key 为空时总是执行 handle。
+// handle 成功后记录失败不返回错误，而是报告给 Observer
+func dedupeNotify(ctx context.Context, config conf.MchConfig, key string, options *Options, handle func() error) error {
+	deduper := options.NotifyDeduper()
+	if deduper == nil || key == "" {
+		return handle()
+	}
+	seen, err := deduper.Seen(ctx, key)
+	if err != nil {
+		return err
+	}
+	if seen {
+		return nil
+	}
+	if err := handle(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := deduper.Mark(ctx, key); err != nil {
+		observe(options.Observer(), &Event{
+			Kind:    EventKindNotifyDedupe,
+			Path:    options.NotifyLabel(),
+			MchID:   config.WechatMchID(),
+			Attempt: 1,
+		}, start, err)
+	}
+	return nil
+}
+
+// MemoryNotifyDeduper 为内存中的 NotifyDeduper，最多记录 capacity 个最近处理的事件（LRU），进程重启后丢失
+type MemoryNotifyDeduper struct {
+	capacity int
+
+	mu    sync.Mutex
+	order *list.List // 最近使用的在前，元素为 key
+	keys  map[string]*list.Element
+}
+
+var (
+	_ NotifyDeduper = (*MemoryNotifyDeduper)(nil)
+	_ NotifyDeduper = (*FileNotifyDeduper)(nil)
+)
+
+// NewMemoryNotifyDeduper 创建一个 MemoryNotifyDeduper，capacity <= 0 时使用 DefaultNotifyDeduperCapacity
+func NewMemoryNotifyDeduper(capacity int) *MemoryNotifyDeduper {
+	if capacity <= 0 {
+		capacity = DefaultNotifyDeduperCapacity
+	}
+	return &MemoryNotifyDeduper{
+		capacity: capacity,
+		order:    list.New(),
+		keys:     map[string]*list.Element{},
+	}
+}
+
+// Seen 实现 NotifyDeduper 接口
+func (deduper *MemoryNotifyDeduper) Seen(ctx context.Context, key string) (bool, error) {
+	deduper.mu.Lock()
+	defer deduper.mu.Unlock()
+	elem, ok := deduper.keys[key]
+	if ok {
+		deduper.order.MoveToFront(elem)
+	}
+	return ok, nil
+}
+
+// Mark 实现 NotifyDeduper 接口
+func (deduper *MemoryNotifyDeduper) Mark(ctx context.Context, key string) error {
+	deduper.mu.Lock()
+	defer deduper.mu.Unlock()
+	if elem, ok := deduper.keys[key]; ok {
+		deduper.order.MoveToFront(elem)
+		return nil
+	}
+	deduper.keys[key] = deduper.order.PushFront(key)
+	for deduper.order.Len() > deduper.capacity {
+		oldest := deduper.order.Back()
+		deduper.order.Remove(oldest)
+		delete(deduper.keys, oldest.Value.(string))
+	}
+	return nil
+}
+
+// FileNotifyDeduper 为基于文件的 NotifyDeduper：每行一个 key，只追加写入并在每次记录后 fsync，
+// 打开时载入全部 key，因此进程重启后仍然有效；文件会持续增长，可在停止服务时清理
+type FileNotifyDeduper struct {
+	mu   sync.Mutex
+	f    dedupeFile
+	keys map[string]struct{}
+	// err 为写入失败且无法回滚时的错误，之后的 Mark 均返回该错误以免在损坏的行后继续写入
+	err error
+}
+
+// dedupeFile 为 FileNotifyDeduper 所需的文件操作，即 *os.File 的子集
+type dedupeFile interface {
+	io.WriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenFileNotifyDeduper 打开（或创建）path 对应的文件作为 FileNotifyDeduper；
+// 若上次写入时进程中断导致最后一行不完整，该行会被丢弃
+func OpenFileNotifyDeduper(path string) (*FileNotifyDeduper, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]struct{}{}
+	r := bufio.NewReader(f)
+	size := int64(0)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		size += int64(len(line))
+		if key := string(bytes.TrimSuffix(line, []byte{'\n'})); key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+
+	// 丢弃不完整的最后一行，并定位到文件末尾
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &FileNotifyDeduper{
+		f:    f,
+		keys: keys,
+	}, nil
+}
+
+// Seen 实现 NotifyDeduper 接口
+func (deduper *FileNotifyDeduper) Seen(ctx context.Context, key string) (bool, error) {
+	deduper.mu.Lock()
+	defer deduper.mu.Unlock()
+	_, ok := deduper.keys[key]
+	return ok, nil
+}
+
+// Mark 实现 NotifyDeduper 接口
+func (deduper *FileNotifyDeduper) Mark(ctx context.Context, key string) error {
+	if key == "" || strings.ContainsAny(key, "\r\n") {
+		return ErrNotifyDeduperBadKey
+	}
+
+	deduper.mu.Lock()
+	defer deduper.mu.Unlock()
+	if _, ok := deduper.keys[key]; ok {
+		return nil
+	}
+	if deduper.err != nil {
+		return deduper.err
+	}
+
+	offset, err := deduper.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = deduper.f.Write([]byte(key + "\n"))
+	if err == nil {
+		err = deduper.f.Sync()
+	}
+	if err != nil {
+		// 可能只写入了一部分，回滚至写入前的位置，否则下一个 key 会接在这部分之后，重启后两者都会丢失
+		if _, rollbackErr := deduper.f.Seek(offset, io.SeekStart); rollbackErr != nil {
+			deduper.err = fmt.Errorf("Notify deduper file is corrupted: %s", rollbackErr)
+		} else if rollbackErr := deduper.f.Truncate(offset); rollbackErr != nil {
+			deduper.err = fmt.Errorf("Notify deduper file is corrupted: %s", rollbackErr)
+		}
+		return err
+	}
+	deduper.keys[key] = struct{}{}
+	return nil
+}
+
+// Close 关闭文件
+func (deduper *FileNotifyDeduper) Close() error {
+	deduper.mu.Lock()
+	defer deduper.mu.Unlock()
+	return deduper.f.Close()
+}
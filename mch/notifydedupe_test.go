@@ -0,0 +1,325 @@
+package mch
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryNotifyDeduper(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	deduper := NewMemoryNotifyDeduper(2)
+	seen := func(key string) bool {
+		ok, err := deduper.Seen(ctx, key)
+		assert.NoError(err)
+		return ok
+	}
+
+	assert.False(seen("a"))
+	assert.NoError(deduper.Mark(ctx, "a"))
+	assert.NoError(deduper.Mark(ctx, "b"))
+	assert.True(seen("a"))
+	assert.True(seen("b"))
+
+	// 淘汰最久未使用的 a
+	assert.True(seen("b"))
+	assert.NoError(deduper.Mark(ctx, "c"))
+	assert.False(seen("a"))
+	assert.True(seen("b"))
+	assert.True(seen("c"))
+}
+
+func TestFileNotifyDeduper(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "notifydedupe")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify.log")
+
+	deduper, err := OpenFileNotifyDeduper(path)
+	assert.NoError(err)
+	assert.NoError(deduper.Mark(ctx, "a"))
+	assert.NoError(deduper.Mark(ctx, "b"))
+	assert.NoError(deduper.Mark(ctx, "a"))
+	assert.Equal(ErrNotifyDeduperBadKey, deduper.Mark(ctx, "c\nd"))
+	assert.Equal(ErrNotifyDeduperBadKey, deduper.Mark(ctx, ""))
+	assert.NoError(deduper.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("a\nb\n", string(data))
+
+	// 模拟写入中断：不完整的最后一行被丢弃
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(err)
+	f.WriteString("partial")
+	f.Close()
+
+	// 重新打开后仍然有效
+	deduper, err = OpenFileNotifyDeduper(path)
+	assert.NoError(err)
+	defer deduper.Close()
+	for key, expect := range map[string]bool{"a": true, "b": true, "partial": false, "c": false} {
+		ok, err := deduper.Seen(ctx, key)
+		assert.NoError(err)
+		assert.Equal(expect, ok, key)
+	}
+	assert.NoError(deduper.Mark(ctx, "c"))
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("a\nb\nc\n", string(data))
+}
+
+// testFailingFile 在 failWrite 为 true 时只写入一半内容并返回错误
+type testFailingFile struct {
+	*os.File
+	failWrite bool
+	failSync  bool
+}
+
+func (f *testFailingFile) Write(p []byte) (int, error) {
+	if f.failWrite {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errors.New("no space left on device")
+	}
+	return f.File.Write(p)
+}
+
+func (f *testFailingFile) Sync() error {
+	if f.failSync {
+		return errors.New("sync failed")
+	}
+	return f.File.Sync()
+}
+
+func TestFileNotifyDeduperWriteError(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "notifydedupe")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify.log")
+
+	deduper, err := OpenFileNotifyDeduper(path)
+	assert.NoError(err)
+	f := &testFailingFile{File: deduper.f.(*os.File)}
+	deduper.f = f
+
+	assert.NoError(deduper.Mark(ctx, "a"))
+	// 写入一部分后失败
+	f.failWrite = true
+	assert.Error(deduper.Mark(ctx, "bbbbbbbb"))
+	f.failWrite = false
+	// fsync 失败
+	f.failSync = true
+	assert.Error(deduper.Mark(ctx, "dddd"))
+	f.failSync = false
+	assert.NoError(deduper.Mark(ctx, "c"))
+	for key, expect := range map[string]bool{"a": true, "bbbbbbbb": false, "dddd": false, "c": true} {
+		ok, _ := deduper.Seen(ctx, key)
+		assert.Equal(expect, ok, key)
+	}
+	assert.NoError(deduper.Close())
+
+	// 失败的写入已被回滚，不会与下一个 key 连在一起
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("a\nc\n", string(data))
+
+	deduper, err = OpenFileNotifyDeduper(path)
+	assert.NoError(err)
+	defer deduper.Close()
+	for key, expect := range map[string]bool{"a": true, "c": true, "bbbb": false, "bbbbc": false} {
+		ok, _ := deduper.Seen(ctx, key)
+		assert.Equal(expect, ok, key)
+	}
+}
+
+// testSignedNotify 返回带签名的回调请求 body
+func testSignedNotify(x MchXML) string {
+	x["return_code"] = "SUCCESS"
+	x["appid"] = config.WechatAppID()
+	x["mch_id"] = config.WechatMchID()
+	x["sign"] = SignMchXML(x, SignTypeMD5, config.WechatMchKey())
+	return string(EncodeMchXML(x))
+}
+
+// testEncryptMchXML 为 DecryptMchXML 的逆操作
+func testEncryptMchXML(mchKey string, x MchXML) string {
+	keyMD5 := md5.Sum([]byte(mchKey))
+	cipher, _ := aes.NewCipher([]byte(hex.EncodeToString(keyMD5[:])))
+	bs := cipher.BlockSize()
+
+	plainBytes := EncodeMchXML(x)
+	p := bs - len(plainBytes)%bs
+	plainBytes = append(plainBytes, bytes.Repeat([]byte{byte(p)}, p)...)
+
+	cipherBytes := make([]byte, len(plainBytes))
+	for i := 0; i < len(plainBytes); i += bs {
+		cipher.Encrypt(cipherBytes[i:i+bs], plainBytes[i:i+bs])
+	}
+	return base64.StdEncoding.EncodeToString(cipherBytes)
+}
+
+func TestOrderNotifyDedupe(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testOrderQueryRespond(TradeStateSUCCESS),
+	}}
+	calls := 0
+	var handlerErr error
+	handler := OrderNotify(func(ctx context.Context, resp *OrderQueryResponse) error {
+		calls++
+		return handlerErr
+	}, config, MustOptions(UseClient(client), UseNotifyDeduper(NewMemoryNotifyDeduper(0))))
+
+	body := testSignedNotify(MchXML{
+		"result_code":    "SUCCESS",
+		"out_trade_no":   "1217752501201407033233368018",
+		"transaction_id": "1008450740201411110005820873",
+	})
+	notify := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(body)))
+		x, err := DecodeMchXML(w.Body.Bytes())
+		assert.NoError(err)
+		return x["return_code"]
+	}
+
+	// handler 失败时不记录，重复回调会再次执行
+	handlerErr = errors.New("handler error")
+	assert.Equal("FAIL", notify())
+	assert.Equal(1, calls)
+
+	// 成功后重复回调直接返回 SUCCESS
+	handlerErr = nil
+	assert.Equal("SUCCESS", notify())
+	assert.Equal(2, calls)
+	assert.Equal("SUCCESS", notify())
+	assert.Equal("SUCCESS", notify())
+	assert.Equal(2, calls)
+
+	// 仍然会再次查询
+	assert.Len(client.reqs, 4)
+}
+
+func TestRefundNotifyDedupe(t *testing.T) {
+	assert := assert.New(t)
+
+	refundQueryRespond := func(refundStatus RefundStatus) func() (*http.Response, error) {
+		return testSignedRespond(MchXML{
+			"result_code":     "SUCCESS",
+			"out_trade_no":    "1217752501201407033233368018",
+			"transaction_id":  "1008450740201411110005820873",
+			"total_fee":       "100",
+			"cash_fee":        "100",
+			"refund_count":    "1",
+			"refund_id_0":     "2008450740201411110000174436",
+			"out_refund_no_0": "1217752501201407033233368018",
+			"refund_fee_0":    "100",
+			"refund_status_0": refundStatus.String(),
+		})
+	}
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		refundQueryRespond(RefundStatusPROCESSING),
+		refundQueryRespond(RefundStatusSUCCESS),
+		refundQueryRespond(RefundStatusSUCCESS),
+	}}
+	statuses := []RefundStatus{}
+	handler := RefundNotify(func(ctx context.Context, resp *RefundQueryResponse) error {
+		statuses = append(statuses, resp.Refunds[0].RefundStatus)
+		return nil
+	}, config, MustOptions(UseClient(client), UseNotifyDeduper(NewMemoryNotifyDeduper(0))))
+
+	x := MchXML{
+		"return_code": "SUCCESS",
+		"appid":       config.WechatAppID(),
+		"mch_id":      config.WechatMchID(),
+		"req_info": testEncryptMchXML(config.WechatMchKey(), MchXML{
+			"refund_id":     "2008450740201411110000174436",
+			"out_refund_no": "1217752501201407033233368018",
+		}),
+	}
+	body := string(EncodeMchXML(x))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(body)))
+		assert.Contains(w.Body.String(), "SUCCESS")
+	}
+
+	// 退款状态变化视为不同的事件
+	assert.Equal([]RefundStatus{RefundStatusPROCESSING, RefundStatusSUCCESS}, statuses)
+}
+
+// testMarkFailDeduper 的 Mark 总是失败
+type testMarkFailDeduper struct {
+	*MemoryNotifyDeduper
+}
+
+func (deduper testMarkFailDeduper) Mark(ctx context.Context, key string) error {
+	return errors.New("disk full")
+}
+
+func TestNotifyDedupeMarkError(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testOrderQueryRespond(TradeStateSUCCESS),
+	}}
+	recorder := &testEventRecorder{}
+	calls := 0
+	handler := OrderNotify(func(ctx context.Context, resp *OrderQueryResponse) error {
+		calls++
+		return nil
+	}, config, MustOptions(
+		UseClient(client),
+		UseObserver(recorder),
+		UseNotifyDeduper(testMarkFailDeduper{NewMemoryNotifyDeduper(0)}),
+	))
+
+	body := testSignedNotify(MchXML{
+		"result_code":    "SUCCESS",
+		"out_trade_no":   "1217752501201407033233368018",
+		"transaction_id": "1008450740201411110005820873",
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(body)))
+
+	// handler 已成功，记录失败不影响回调结果
+	x, err := DecodeMchXML(w.Body.Bytes())
+	assert.NoError(err)
+	assert.Equal("SUCCESS", x["return_code"])
+	assert.Equal(1, calls)
+
+	// 记录失败以事件报告
+	kinds := []EventKind{}
+	for _, event := range recorder.events {
+		kinds = append(kinds, event.Kind)
+	}
+	assert.Equal([]EventKind{EventKindCall, EventKindNotifyDedupe, EventKindNotify}, kinds)
+	event := recorder.events[1]
+	assert.Equal(DefaultNotifyLabel, event.Path)
+	assert.Equal(config.WechatMchID(), event.MchID)
+	assert.Equal(ErrorClassOther, event.ErrorClass)
+	assert.EqualError(event.Err, "disk full")
+	assert.Nil(recorder.events[2].Err)
+}
@@ -0,0 +1,136 @@
+package mch
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"time"
+)
+
+// EventKind 事件类型
+type EventKind string
+
+const (
+	// EventKindCall 调用接口（PostMchXML），每次尝试（包括重试）都产生一个事件
+	EventKindCall EventKind = "call"
+	// EventKindNotify 处理回调（HandleMchXML）
+	EventKindNotify EventKind = "notify"
+	// EventKindNotifyDedupe 回调处理成功后记录去重 key 失败（NotifyDeduper.Mark），不影响回调的结果
+	EventKindNotifyDedupe EventKind = "notify_dedupe"
+)
+
+var (
+	// DefaultNotifyLabel 为回调处理默认的标签，见 UseNotifyLabel
+	DefaultNotifyLabel = "notify"
+)
+
+// ErrorClass 错误分类，用于监控统计
+type ErrorClass string
+
+const (
+	ErrorClassNone          ErrorClass = ""              // 没有错误
+	ErrorClassNetwork       ErrorClass = "network"       // 网络错误，如连接失败、超时
+	ErrorClassContext       ErrorClass = "context"       // ctx 取消或超时
+	ErrorClassHTTP          ErrorClass = "http"          // http 状态码不为 2xx，见 MchHTTPError
+	ErrorClassDecode        ErrorClass = "decode"        // xml 解码错误
+	ErrorClassCommunication ErrorClass = "communication" // return_code 不为 SUCCESS，见 MchCommunicationError
+	ErrorClassSign          ErrorClass = "sign"          // 签名错误，见 MchSignError
+	ErrorClassBusiness      ErrorClass = "business"      // result_code 不为 SUCCESS，见 MchBusinessError
+	ErrorClassValidation    ErrorClass = "validation"    // 请求/响应字段错误，见 MissingFieldError/ValidationError
+	ErrorClassRateLimited   ErrorClass = "rate_limited"  // 被限流，见 RateLimitError
+	ErrorClassCircuitOpen   ErrorClass = "circuit_open"  // 熔断器打开，见 ErrCircuitOpen
+	ErrorClassOther         ErrorClass = "other"         // 其它错误
+)
+
+// Event 为一次接口调用或回调处理的结构化事件
+type Event struct {
+	// Kind 事件类型
+	Kind EventKind
+	// Path 接口路径（调用）或回调标签（回调，见 UseNotifyLabel）
+	Path string
+	// MchID 商户号
+	MchID string
+	// Attempt 第几次尝试，从 1 开始；回调总是 1
+	Attempt int
+	// Latency 耗时（包括限流等待的时间）
+	Latency time.Duration
+	// StatusCode http 状态码；没有收到响应时为 0
+	StatusCode int
+	// ReturnCode/ResultCode/ErrCode 响应（调用）或请求（回调）中对应的字段
+	ReturnCode string
+	ResultCode string
+	ErrCode    string
+	// ErrorClass 错误分类，没有错误时为空
+	ErrorClass ErrorClass
+	// Err 错误
+	Err error
+}
+
+// Observer 接收接口调用和回调处理的事件，可用于日志、监控等；须能被并发调用，且不应阻塞
+type Observer interface {
+	Observe(event *Event)
+}
+
+// ObserverFunc 将函数转为 Observer
+type ObserverFunc func(event *Event)
+
+// Observe 实现 Observer 接口
+func (fn ObserverFunc) Observe(event *Event) {
+	fn(event)
+}
+
+func noopObserve(event *Event) {}
+
+// ClassifyError 返回错误的分类
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassNone
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassContext
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return ErrorClassCircuitOpen
+	}
+
+	var httpErr *MchHTTPError
+	var communicationErr *MchCommunicationError
+	var signErr *MchSignError
+	var businessErr *MchBusinessError
+	var missingFieldErr MissingFieldError
+	var validationErr *ValidationError
+	var rateLimitErr *RateLimitError
+	var syntaxErr *xml.SyntaxError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &httpErr):
+		return ErrorClassHTTP
+	case errors.As(err, &communicationErr):
+		return ErrorClassCommunication
+	case errors.As(err, &signErr):
+		return ErrorClassSign
+	case errors.As(err, &businessErr):
+		return ErrorClassBusiness
+	case errors.As(err, &missingFieldErr), errors.As(err, &validationErr):
+		return ErrorClassValidation
+	case errors.As(err, &rateLimitErr):
+		return ErrorClassRateLimited
+	case errors.As(err, &syntaxErr):
+		return ErrorClassDecode
+	case errors.As(err, &netErr):
+		return ErrorClassNetwork
+	default:
+		return ErrorClassOther
+	}
+}
+
+// observe 补全事件中的错误信息并发送给 observer
+func observe(observer Observer, event *Event, start time.Time, err error) {
+	event.Latency = time.Since(start)
+	event.Err = err
+	if err != nil && event.ErrorClass == ErrorClassNone {
+		event.ErrorClass = ClassifyError(err)
+	}
+	observer.Observe(event)
+}
@@ -0,0 +1,193 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEventRecorder 记录收到的事件
+type testEventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *testEventRecorder) Observe(event *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, *event)
+}
+
+func TestObserveCall(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := &testEventRecorder{}
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testNetworkErrorRespond,
+		testRespond(502, MchXML{}),
+		testBusinessErrorRespond(ErrCodeSYSTEMERROR),
+		testSignedRespond(MchXML{"result_code": "SUCCESS"}),
+	}}
+	_, err := PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, MustOptions(
+		UseClient(client),
+		UseObserver(recorder),
+		UseRetryPolicy(&RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	))
+	assert.NoError(err)
+
+	assert.Len(recorder.events, 4)
+	for i, testCase := range []struct {
+		StatusCode int
+		ResultCode string
+		ErrCode    string
+		ErrorClass ErrorClass
+	}{
+		{0, "", "", ErrorClassNetwork},
+		{502, "", "", ErrorClassHTTP},
+		{200, "FAIL", ErrCodeSYSTEMERROR, ErrorClassBusiness},
+		{200, "SUCCESS", "", ErrorClassNone},
+	} {
+		event := recorder.events[i]
+		assert.Equal(EventKindCall, event.Kind)
+		assert.Equal("/pay/orderquery", event.Path)
+		assert.Equal(config.WechatMchID(), event.MchID)
+		assert.Equal(i+1, event.Attempt)
+		assert.Equal(testCase.StatusCode, event.StatusCode, i)
+		assert.Equal(testCase.ResultCode, event.ResultCode, i)
+		assert.Equal(testCase.ErrCode, event.ErrCode, i)
+		assert.Equal(testCase.ErrorClass, event.ErrorClass, i)
+		assert.Equal(testCase.ErrorClass != ErrorClassNone, event.Err != nil, i)
+		assert.True(event.Latency > 0, i)
+	}
+
+	// ctx 取消
+	recorder = &testEventRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = PostMchXML(ctx, config, "/pay/orderquery", MchXML{}, MustOptions(
+		UseClient(&testSeqClient{resps: []func() (*http.Response, error){testNetworkErrorRespond}}),
+		UseObserver(recorder),
+	))
+	assert.Error(err)
+	assert.Len(recorder.events, 1)
+	assert.Equal(ErrorClassContext, recorder.events[0].ErrorClass)
+}
+
+func TestObserveNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := &testEventRecorder{}
+	options := MustOptions(UseObserver(recorder))
+	handlerErr := errors.New("handler error")
+	handler := HandleMchXML(func(ctx context.Context, x MchXML) error {
+		if x["out_trade_no"] == "fail" {
+			return handlerErr
+		}
+		return nil
+	}, options)
+
+	for i, body := range []string{
+		"<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><mch_id>10000100</mch_id><out_trade_no>1</out_trade_no></xml>",
+		"<xml><return_code>SUCCESS</return_code><result_code>SUCCESS</result_code><mch_id>10000100</mch_id><out_trade_no>fail</out_trade_no></xml>",
+		"<xml><return_code>FAIL</return_code><return_msg>x</return_msg></xml>",
+		"<xml></notxml>",
+	} {
+		// 请求路径不作为标签
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/notify/%d?x=%d", i, i), strings.NewReader(body)))
+	}
+
+	assert.Len(recorder.events, 4)
+	for i, testCase := range []struct {
+		MchID      string
+		ErrorClass ErrorClass
+	}{
+		{"10000100", ErrorClassNone},
+		{"10000100", ErrorClassOther},
+		{"", ErrorClassCommunication},
+		{"", ErrorClassDecode},
+	} {
+		event := recorder.events[i]
+		assert.Equal(EventKindNotify, event.Kind)
+		assert.Equal(DefaultNotifyLabel, event.Path)
+		assert.Equal(1, event.Attempt)
+		assert.Equal(testCase.MchID, event.MchID, i)
+		assert.Equal(testCase.ErrorClass, event.ErrorClass, i)
+	}
+	assert.Equal(handlerErr, recorder.events[1].Err)
+
+	// 自定义标签
+	recorder = &testEventRecorder{}
+	handler = HandleMchXML(func(ctx context.Context, x MchXML) error {
+		return nil
+	}, MustOptions(UseObserver(recorder), UseNotifyLabel("order_notify")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/notify/10000100", strings.NewReader("<xml></notxml>")))
+	assert.Len(recorder.events, 1)
+	assert.Equal("order_notify", recorder.events[0].Path)
+}
+
+func TestClassifyError(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		Err    error
+		Expect ErrorClass
+	}{
+		{nil, ErrorClassNone},
+		{context.Canceled, ErrorClassContext},
+		{context.DeadlineExceeded, ErrorClassContext},
+		{&MchHTTPError{StatusCode: 502}, ErrorClassHTTP},
+		{&MchCommunicationError{}, ErrorClassCommunication},
+		{&MchBusinessError{}, ErrorClassBusiness},
+		{missingRequestField("X", "a"), ErrorClassValidation},
+		{&ValidationError{}, ErrorClassValidation},
+		{&RateLimitError{}, ErrorClassRateLimited},
+		{ErrCircuitOpen, ErrorClassCircuitOpen},
+		{&timeoutError{}, ErrorClassNetwork},
+		{errors.New("x"), ErrorClassOther},
+	} {
+		assert.Equal(testCase.Expect, ClassifyError(testCase.Err), "%#v", testCase.Err)
+	}
+}
+
+func TestMetricsCollector(t *testing.T) {
+	assert := assert.New(t)
+
+	collector := NewMetricsCollector(0.1, 1)
+	collector.Observe(&Event{Kind: EventKindCall, Path: "/pay/orderquery", MchID: "1", Attempt: 1, Latency: 50 * time.Millisecond, ErrorClass: ErrorClassBusiness, ErrCode: ErrCodeSYSTEMERROR})
+	collector.Observe(&Event{Kind: EventKindCall, Path: "/pay/orderquery", MchID: "1", Attempt: 2, Latency: 500 * time.Millisecond})
+	collector.Observe(&Event{Kind: EventKindNotify, Path: "/notify\"", MchID: "1", Attempt: 1, Latency: 2 * time.Second})
+
+	w := httptest.NewRecorder()
+	collector.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(`# HELP wx_mch_events_total Number of mch calls and notifications.
+# TYPE wx_mch_events_total counter
+wx_mch_events_total{kind="call",path="/pay/orderquery",mch_id="1",error_class="",err_code=""} 1
+wx_mch_events_total{kind="call",path="/pay/orderquery",mch_id="1",error_class="business",err_code="SYSTEMERROR"} 1
+wx_mch_events_total{kind="notify",path="/notify\"",mch_id="1",error_class="",err_code=""} 1
+# HELP wx_mch_retries_total Number of retried mch calls.
+# TYPE wx_mch_retries_total counter
+wx_mch_retries_total{kind="call",path="/pay/orderquery",mch_id="1"} 1
+# HELP wx_mch_latency_seconds Latency of mch calls and notifications.
+# TYPE wx_mch_latency_seconds histogram
+wx_mch_latency_seconds_bucket{kind="call",path="/pay/orderquery",le="0.1"} 1
+wx_mch_latency_seconds_bucket{kind="call",path="/pay/orderquery",le="1"} 2
+wx_mch_latency_seconds_bucket{kind="call",path="/pay/orderquery",le="+Inf"} 2
+wx_mch_latency_seconds_sum{kind="call",path="/pay/orderquery"} 0.55
+wx_mch_latency_seconds_count{kind="call",path="/pay/orderquery"} 2
+wx_mch_latency_seconds_bucket{kind="notify",path="/notify\"",le="0.1"} 0
+wx_mch_latency_seconds_bucket{kind="notify",path="/notify\"",le="1"} 0
+wx_mch_latency_seconds_bucket{kind="notify",path="/notify\"",le="+Inf"} 1
+wx_mch_latency_seconds_sum{kind="notify",path="/notify\""} 2
+wx_mch_latency_seconds_count{kind="notify",path="/notify\""} 1
+`, w.Body.String())
+}
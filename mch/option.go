@@ -28,6 +28,33 @@ type Options struct {
 
 	// 签名类型
 	signType SignType
+
+	// 重试策略
+	retryPolicy *RetryPolicy
+
+	// 故障切换
+	failover *Failover
+
+	// 事件观察者
+	observer Observer
+
+	// 链路追踪
+	tracer utils.Tracer
+
+	// 限流
+	rateLimiter *RateLimiter
+
+	// 熔断器
+	circuitBreaker *CircuitBreaker
+
+	// 回调去重
+	notifyDeduper NotifyDeduper
+
+	// 回调处理在事件/span 中的名称
+	notifyLabel string
+
+	// 用于报告实际提供服务的接入点
+	urlBaseReport *string
 }
 
 // Option 代表调用微信支付接口时的单个选项
@@ -127,6 +154,111 @@ func (options *Options) SignType() SignType {
 	return SignTypeMD5
 }
 
+// RetryPolicy 返回重试策略，依次：options.retryPolicy > DefaultOptions.retryPolicy > nil（不重试）
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) RetryPolicy() *RetryPolicy {
+	if options != nil && options.retryPolicy != nil {
+		return options.retryPolicy
+	}
+	if DefaultOptions != nil && DefaultOptions.retryPolicy != nil {
+		return DefaultOptions.retryPolicy
+	}
+	return nil
+}
+
+// Failover 返回故障切换设置，依次：options.failover > DefaultOptions.failover > nil（只使用 URLBase）
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) Failover() *Failover {
+	if options != nil && options.failover != nil {
+		return options.failover
+	}
+	if DefaultOptions != nil && DefaultOptions.failover != nil {
+		return DefaultOptions.failover
+	}
+	return nil
+}
+
+// Observer 返回事件观察者，依次：options.observer > DefaultOptions.observer > 不做任何事的 Observer
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) Observer() Observer {
+	if options != nil && options.observer != nil {
+		return options.observer
+	}
+	if DefaultOptions != nil && DefaultOptions.observer != nil {
+		return DefaultOptions.observer
+	}
+	return ObserverFunc(noopObserve)
+}
+
+// Tracer 返回链路追踪，依次：options.tracer > DefaultOptions.tracer > utils.NoopTracer
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) Tracer() utils.Tracer {
+	if options != nil && options.tracer != nil {
+		return options.tracer
+	}
+	if DefaultOptions != nil && DefaultOptions.tracer != nil {
+		return DefaultOptions.tracer
+	}
+	return utils.NoopTracer
+}
+
+// RateLimiter 返回限流设置，依次：options.rateLimiter > DefaultOptions.rateLimiter > nil（不限流）
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) RateLimiter() *RateLimiter {
+	if options != nil && options.rateLimiter != nil {
+		return options.rateLimiter
+	}
+	if DefaultOptions != nil && DefaultOptions.rateLimiter != nil {
+		return DefaultOptions.rateLimiter
+	}
+	return nil
+}
+
+// CircuitBreaker 返回熔断器设置，依次：options.circuitBreaker > DefaultOptions.circuitBreaker > nil（不熔断）
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) CircuitBreaker() *CircuitBreaker {
+	if options != nil && options.circuitBreaker != nil {
+		return options.circuitBreaker
+	}
+	if DefaultOptions != nil && DefaultOptions.circuitBreaker != nil {
+		return DefaultOptions.circuitBreaker
+	}
+	return nil
+}
+
+// NotifyDeduper 返回回调去重设置，依次：options.notifyDeduper > DefaultOptions.notifyDeduper > nil（不去重）
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) NotifyDeduper() NotifyDeduper {
+	if options != nil && options.notifyDeduper != nil {
+		return options.notifyDeduper
+	}
+	if DefaultOptions != nil && DefaultOptions.notifyDeduper != nil {
+		return DefaultOptions.notifyDeduper
+	}
+	return nil
+}
+
+// NotifyLabel 返回回调处理在事件（Event.Path）和 span 名称中使用的标签，依次：
+// options.notifyLabel > DefaultOptions.notifyLabel > DefaultNotifyLabel
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) NotifyLabel() string {
+	if options != nil && options.notifyLabel != "" {
+		return options.notifyLabel
+	}
+	if DefaultOptions != nil && DefaultOptions.notifyLabel != "" {
+		return DefaultOptions.notifyLabel
+	}
+	return DefaultNotifyLabel
+}
+
 // UseClient 设置 HTTPClient
 func UseClient(client utils.HTTPClient) Option {
 	return func(options *Options) error {
@@ -146,19 +278,27 @@ func UseMiddleware(middleware func(http.Handler) http.Handler) Option {
 // UseURLBase 设置 API 地址前缀，默认为 "https://api.mch.weixin.qq.com"
 func UseURLBase(urlBase string) Option {
 	return func(options *Options) error {
-		u, err := url.Parse(urlBase)
+		u, err := normalizeURLBase(urlBase)
 		if err != nil {
 			return err
 		}
-		// 只取 scheme 和 host 部分
-		options.urlBase = (&url.URL{
-			Scheme: u.Scheme,
-			Host:   u.Host,
-		}).String()
+		options.urlBase = u
 		return nil
 	}
 }
 
+func normalizeURLBase(urlBase string) (string, error) {
+	u, err := url.Parse(urlBase)
+	if err != nil {
+		return "", err
+	}
+	// 只取 scheme 和 host 部分
+	return (&url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+	}).String(), nil
+}
+
 // UseSignType 设置签名类型
 func UseSignType(signType SignType) Option {
 	return func(options *Options) error {
@@ -166,3 +306,91 @@ func UseSignType(signType SignType) Option {
 		return nil
 	}
 }
+
+// UseRetryPolicy 设置重试策略，例如：
+//
+//   mch.UseRetryPolicy(&mch.RetryPolicy{
+//   	MaxAttempts: 3,
+//   	BaseDelay:   100 * time.Millisecond,
+//   	MaxDelay:    time.Second,
+//   })
+func UseRetryPolicy(retryPolicy *RetryPolicy) Option {
+	return func(options *Options) error {
+		options.retryPolicy = retryPolicy
+		return nil
+	}
+}
+
+// UseFailover 设置故障切换，设置后 URLBase 将被忽略，改为按 Failover 的接入点列表调用
+func UseFailover(failover *Failover) Option {
+	return func(options *Options) error {
+		options.failover = failover
+		return nil
+	}
+}
+
+// UseObserver 设置事件观察者，每次调用接口（包括重试）以及处理回调时都会收到一个 Event，例如使用内置的 MetricsCollector：
+//
+//   collector := mch.NewMetricsCollector()
+//   mch.DefaultOptions = mch.MustOptions(mch.UseObserver(collector), ...)
+//   http.Handle("/metrics", collector)
+func UseObserver(observer Observer) Option {
+	return func(options *Options) error {
+		options.observer = observer
+		return nil
+	}
+}
+
+// UseTracer 设置链路追踪，调用接口、处理回调以及回调中再次查询时都会开始一个 span（见 utils.Tracer）
+func UseTracer(tracer utils.Tracer) Option {
+	return func(options *Options) error {
+		options.tracer = tracer
+		return nil
+	}
+}
+
+// UseRateLimiter 设置限流，见 RateLimiter
+func UseRateLimiter(rateLimiter *RateLimiter) Option {
+	return func(options *Options) error {
+		options.rateLimiter = rateLimiter
+		return nil
+	}
+}
+
+// UseCircuitBreaker 设置熔断器，见 CircuitBreaker
+func UseCircuitBreaker(circuitBreaker *CircuitBreaker) Option {
+	return func(options *Options) error {
+		options.circuitBreaker = circuitBreaker
+		return nil
+	}
+}
+
+// UseNotifyDeduper 设置回调去重，用于 OrderNotify/RefundNotify，见 NotifyDeduper
+func UseNotifyDeduper(notifyDeduper NotifyDeduper) Option {
+	return func(options *Options) error {
+		options.notifyDeduper = notifyDeduper
+		return nil
+	}
+}
+
+// UseNotifyLabel 设置回调处理在事件（Event.Path）和 span 名称中使用的固定标签，例如 "order_notify"；
+// 不使用请求路径是因为路径中可能带有商户号等参数，作为指标的标签会导致基数无限增长
+func UseNotifyLabel(label string) Option {
+	return func(options *Options) error {
+		options.notifyLabel = label
+		return nil
+	}
+}
+
+// ReportURLBase 在收到响应后将实际提供服务的接入点写入 *dst，例如：
+//
+//   var urlBase string
+//   resp, err := mch.OrderQuery(ctx, config, req, mch.ReportURLBase(&urlBase))
+//
+// NOTE: 该选项仅对单次调用有意义，不应放在 DefaultOptions 或者多次调用间共享的 Options 中
+func ReportURLBase(dst *string) Option {
+	return func(options *Options) error {
+		options.urlBaseReport = dst
+		return nil
+	}
+}
@@ -11,23 +11,23 @@ import (
 
 var (
 	ErrOrderQueryMissingID       = errors.New("Missing transaction_id/out_trade_no in OrderQueryRequest")
-	ErrOrderQueryNoOutTradeNo    = errors.New("No out_trade_no is returned from OrderQueryResponse")
-	ErrOrderQueryNoTradeState    = errors.New("No trade_state is returned from OrderQueryResponse")
-	ErrOrderQueryNoTransactionID = errors.New("No transaction_id is returned from OrderQueryResponse")
-	ErrOrderQueryNoOpenID        = errors.New("No openid is returned from OrderQueryResponse")
-	ErrOrderQueryNoTradeType     = errors.New("No trade_type is returned from OrderQueryResponse")
-	ErrOrderQueryNoBankType      = errors.New("No bank_type is returned from OrderQueryResponse")
-	ErrOrderQueryNoTimeEnd       = errors.New("No time_end is returned from OrderQueryResponse")
-	ErrOrderQueryNoTotalFee      = errors.New("No total_fee is returned from OrderQueryResponse")
-	ErrOrderQueryNoCashFee       = errors.New("No cash_fee is returned from OrderQueryResponse")
+	ErrOrderQueryNoOutTradeNo    = missingResponseField("OrderQueryResponse", "out_trade_no")
+	ErrOrderQueryNoTradeState    = missingResponseField("OrderQueryResponse", "trade_state")
+	ErrOrderQueryNoTransactionID = missingResponseField("OrderQueryResponse", "transaction_id")
+	ErrOrderQueryNoOpenID        = missingResponseField("OrderQueryResponse", "openid")
+	ErrOrderQueryNoTradeType     = missingResponseField("OrderQueryResponse", "trade_type")
+	ErrOrderQueryNoBankType      = missingResponseField("OrderQueryResponse", "bank_type")
+	ErrOrderQueryNoTimeEnd       = missingResponseField("OrderQueryResponse", "time_end")
+	ErrOrderQueryNoTotalFee      = missingResponseField("OrderQueryResponse", "total_fee")
+	ErrOrderQueryNoCashFee       = missingResponseField("OrderQueryResponse", "cash_fee")
 )
 
 // OrderQueryRequest 为查询订单接口请求
 type OrderQueryRequest struct {
 	// ----- 必填字段 -----
 	// 以下二选一
-	TransactionID string // transaction_id String(32) 微信支付订单号 建议优先使用
-	OutTradeNo    string // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
+	TransactionID string `mch:"transaction_id"` // transaction_id String(32) 微信支付订单号 建议优先使用
+	OutTradeNo    string `mch:"out_trade_no"`   // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
 }
 
 // OrderQueryResponse 为查询订单接口响应
@@ -36,40 +36,43 @@ type OrderQueryResponse struct {
 	MchXML MchXML
 
 	// ----- 必返回字段 -----
-	OutTradeNo string     // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
-	TradeState TradeState // trade_state String(32) 交易状态
+	OutTradeNo string     `mch:"out_trade_no,required"` // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
+	TradeState TradeState `mch:"trade_state,required"`  // trade_state String(32) 交易状态
 
 	// ----- 支付完成后(SUCCESS, REFUND) 必返回字段 -----
-	TransactionID string    // transaction_id String(32) 微信支付订单号
-	OpenID        string    // openid String(128) 用户标识
-	TradeType     TradeType // trade_type String(16) 交易类型
-	BankType      string    // bank_type String(16) 付款银行
-	TimeEnd       time.Time // time_end String(14) 订单支付时间
-	TotalFee      uint64    // total_fee Int 标价金额
-	CashFee       uint64    // cash_fee Int 现金支付金额
+	TransactionID string    `mch:"transaction_id"`                  // transaction_id String(32) 微信支付订单号
+	OpenID        string    `mch:"openid"`                          // openid String(128) 用户标识
+	TradeType     TradeType `mch:"trade_type"`                      // trade_type String(16) 交易类型
+	BankType      string    `mch:"bank_type"`                       // bank_type String(16) 付款银行
+	TimeEnd       time.Time `mch:"time_end"`                        // time_end String(14) 订单支付时间
+	TotalFee      Money     `mch:"total_fee,currency=fee_type"`     // total_fee Int 标价金额 / fee_type String(16) 标价币种
+	CashFee       Money     `mch:"cash_fee,currency=cash_fee_type"` // cash_fee Int 现金支付金额 / cash_fee_type String(16) 现金支付币种
 
 	// ----- 支付完成后可能返回的字段 -----
-	FeeType     string // fee_type String(16) 标价币种
-	CashFeeType string // cash_fee_type String(16) 现金支付币种
-	Rate        uint64 // rate String(16) 汇率 标价币种与支付币种兑换比例乘以10^8
+	Rate uint64 `mch:"rate"` // rate String(16) 汇率 标价币种与支付币种兑换比例乘以10^8
 	// TODO: 优惠金额字段
 	// TODO: 这里涉及不同 Version 时返回的字段不一样，应当用统一的结构存储
 
 	// ----- 其它字段 -----
-	DeviceInfo     string // device_info String(32) 设备号
-	TradeStateDesc string // trade_state_desc String(256) 交易状态描述
-	IsSubscribe    string // is_subscribe String(1) Y/N 是否关注公众账号 仅在公众账号类型支付有效
-	Attach         string // attach String(127) 附加数据
+	DeviceInfo     string `mch:"device_info"`      // device_info String(32) 设备号
+	TradeStateDesc string `mch:"trade_state_desc"` // trade_state_desc String(256) 交易状态描述
+	IsSubscribe    string `mch:"is_subscribe"`     // is_subscribe String(1) Y/N 是否关注公众账号 仅在公众账号类型支付有效
+	Attach         string `mch:"attach"`           // attach String(127) 附加数据
 }
 
 func orderQuery(ctx context.Context, config conf.MchConfig, req *OrderQueryRequest, options *Options) (*OrderQueryResponse, error) {
+	// 检查必填字段以及字段长度和格式，一次列出全部问题
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
 	// req -> reqXML
-	reqXML := MchXML{}
-	if req.TransactionID != "" {
-		reqXML.fillString(req.TransactionID, "transaction_id")
-	} else if req.OutTradeNo != "" {
-		reqXML.fillString(req.OutTradeNo, "out_trade_no")
-	} else {
+	reqXML, err := MarshalMchXML(req)
+	if err != nil {
+		return nil, err
+	}
+	// 二选一，优先使用 transaction_id
+	if !reqXML.keepFirst("transaction_id", "out_trade_no") {
 		return nil, ErrOrderQueryMissingID
 	}
 
@@ -83,34 +86,11 @@ func orderQuery(ctx context.Context, config conf.MchConfig, req *OrderQueryReque
 	resp := OrderQueryResponse{
 		MchXML: respXML,
 	}
-	respXML.extractString(&resp.OutTradeNo, "out_trade_no", &err)
-	respXML.extractTradeState(&resp.TradeState, "trade_state", &err)
-	respXML.extractString(&resp.TransactionID, "transaction_id", &err)
-	respXML.extractString(&resp.OpenID, "openid", &err)
-	respXML.extractTradeType(&resp.TradeType, "trade_type", &err)
-	respXML.extractString(&resp.BankType, "bank_type", &err)
-	respXML.extractTimeCompact(&resp.TimeEnd, "time_end", &err)
-	respXML.extractUint64(&resp.TotalFee, "total_fee", &err)
-	respXML.extractString(&resp.FeeType, "fee_type", &err)
-	respXML.extractUint64(&resp.CashFee, "cash_fee", &err)
-	respXML.extractString(&resp.CashFeeType, "cash_fee_type", &err)
-	respXML.extractUint64(&resp.Rate, "rate", &err)
-	respXML.extractString(&resp.DeviceInfo, "device_info", &err)
-	respXML.extractString(&resp.TradeStateDesc, "trade_state_desc", &err)
-	respXML.extractString(&resp.IsSubscribe, "is_subscribe", &err)
-	respXML.extractString(&resp.Attach, "attach", &err)
-	if err != nil {
+	if err := UnmarshalMchXML(respXML, &resp); err != nil {
 		return nil, err
 	}
 
 	// 检查返回字段
-	if resp.OutTradeNo == "" {
-		return nil, ErrOrderQueryNoOutTradeNo
-	}
-	if !resp.TradeState.IsValid() {
-		return nil, ErrOrderQueryNoTradeState
-	}
-
 	switch resp.TradeState {
 	case TradeStateSUCCESS, TradeStateREFUND:
 		if resp.TransactionID == "" {
@@ -128,12 +108,12 @@ func orderQuery(ctx context.Context, config conf.MchConfig, req *OrderQueryReque
 		if resp.TimeEnd.IsZero() {
 			return nil, ErrOrderQueryNoTimeEnd
 		}
-		if resp.TotalFee == 0 {
+		if resp.TotalFee.IsZero() {
 			return nil, ErrOrderQueryNoTotalFee
 		}
 		// CashFee 说不定可能为 0 （完全用优惠金支付），如果为 0，则需要额外检查 xml 是否
 		// 有该项返回，若有返回则则不报错
-		if resp.CashFee == 0 {
+		if resp.CashFee.IsZero() {
 			if respXML["cash_fee"] == "" {
 				return nil, ErrOrderQueryNoCashFee
 			}
@@ -155,6 +135,8 @@ func OrderQuery(ctx context.Context, config conf.MchConfig, req *OrderQueryReque
 // OrderNotify 创建一个处理支付结果通知的 http.Handler; 传入 handler 的参数包括上下文和查询订单接口返回的 Response；handler
 // 处理过后若成功应该返回 nil，若失败则应该返回一个非 nil error 对象，该 error 的 String() 将会返回给外部
 //
+// 若设置了 NotifyDeduper（见 UseNotifyDeduper），同一事件的重复回调不会再次执行 handler
+//
 // NOTE：请使用与在统一下单一样的签名类型，否则签名会可能不通过
 func OrderNotify(handler func(context.Context, *OrderQueryResponse) error, selector conf.MchConfigSelector, options *Options) http.Handler {
 
@@ -167,14 +149,19 @@ func OrderNotify(handler func(context.Context, *OrderQueryResponse) error, selec
 		// 1. 回调所带的参数虽然与查询接口返回的几乎一致，但依据文档显示回调里好像没有包含 trade_state，
 		//    再次发起查询能与主动查询保持一致
 		// 2. 回调虽然带有签名，但万一 key 泄漏则任何人都可以伪造；主动发起查询则能多一层防护
-		resp, err := orderQuery(ctx, config, &OrderQueryRequest{
+		queryCtx, span := options.Tracer().Start(ctx, "mch.OrderNotify query")
+		setSpanAttributes(span, x)
+		resp, err := orderQuery(queryCtx, config, &OrderQueryRequest{
 			TransactionID: x["transaction_id"],
 			OutTradeNo:    x["out_trade_no"],
 		}, options)
+		endSpan(span, err)
 		if err != nil {
 			return err
 		}
-		return handler(ctx, resp)
+		return dedupeNotify(ctx, config, orderNotifyKey(resp), options, func() error {
+			return handler(ctx, resp)
+		})
 	}, selector, options)
 
 }
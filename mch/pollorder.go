@@ -0,0 +1,163 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/conf"
+)
+
+var (
+	ErrPollOrderMissingID = errors.New("Missing transaction_id/out_trade_no in PollOrderRequest")
+)
+
+var (
+	// DefaultPollOrderIntervals 为轮询订单的默认查询间隔，用完后重复最后一个
+	DefaultPollOrderIntervals = []time.Duration{
+		2 * time.Second,
+		2 * time.Second,
+		3 * time.Second,
+		5 * time.Second,
+		10 * time.Second,
+	}
+
+	// DefaultPollOrderCloseTimeout 为轮询超时后关单的默认超时时间
+	DefaultPollOrderCloseTimeout = 10 * time.Second
+)
+
+// PollOrderRequest 为轮询订单请求
+type PollOrderRequest struct {
+	// ----- 必填字段 -----
+	// 以下二选一
+	TransactionID string // transaction_id String(32) 微信支付订单号 建议优先使用
+	OutTradeNo    string // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
+
+	// ----- 选填字段 -----
+	// Intervals 为每次查询间的等待时间，用完后重复最后一个，为空时使用 DefaultPollOrderIntervals
+	Intervals []time.Duration
+
+	// OnStateChange 在每次观察到交易状态变化时（包括首次查询）被调用
+	OnStateChange func(*OrderQueryResponse)
+
+	// CloseOnTimeout 为 true 时，若 ctx 结束时订单仍未进入终态，则调用关闭订单接口
+	CloseOnTimeout bool
+
+	// CloseTimeout 为关单（以及关单后查询）的超时时间，为 0 时使用 DefaultPollOrderCloseTimeout；
+	// 关单使用的 context 不受 ctx 的取消影响，但保留 ctx 中的值
+	CloseTimeout time.Duration
+}
+
+// PollOrder 轮询查询订单接口直至交易状态为终态（见 TradeState.IsTerminal）或 ctx 结束，例如用于 NATIVE 支付展示二维码后等待支付结果；
+// 查询时遇到网络错误或可重试的错误时会继续轮询，遇到其它业务错误则立即返回。
+//
+// 进入终态时返回最后一次查询的结果以及 nil error；ctx 结束时：
+//
+//   - 若没有设置 CloseOnTimeout，返回最后一次成功查询的结果（可能为 nil）以及 ctx.Err()
+//   - 若设置了 CloseOnTimeout，则关闭订单并再次查询，返回该次查询结果（正常情况下为 CLOSED）以及 nil error；
+//     若关单时订单恰好已支付（ORDERPAID），同样再次查询并返回支付成功的结果
+func PollOrder(ctx context.Context, config conf.MchConfig, req *PollOrderRequest, opts ...Option) (*OrderQueryResponse, error) {
+	options, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if req.TransactionID == "" && req.OutTradeNo == "" {
+		return nil, ErrPollOrderMissingID
+	}
+
+	intervals := req.Intervals
+	if len(intervals) == 0 {
+		intervals = DefaultPollOrderIntervals
+	}
+
+	var last *OrderQueryResponse
+	observe := func(resp *OrderQueryResponse) {
+		if req.OnStateChange != nil && (last == nil || last.TradeState != resp.TradeState) {
+			req.OnStateChange(resp)
+		}
+		last = resp
+	}
+	queryReq := &OrderQueryRequest{
+		TransactionID: req.TransactionID,
+		OutTradeNo:    req.OutTradeNo,
+	}
+
+	for i := 0; ; i++ {
+		resp, err := orderQuery(ctx, config, queryReq, options)
+		switch {
+		case err == nil:
+			observe(resp)
+			if resp.TradeState.IsTerminal() {
+				return resp, nil
+			}
+
+		case ctx.Err() != nil:
+			// ctx 结束，下面处理
+
+		default:
+			// 非可重试的业务错误直接返回，其它错误（网络错误等）继续轮询
+			var e *MchBusinessError
+			if errors.As(err, &e) && !e.IsRetryable() {
+				return last, err
+			}
+		}
+
+		interval := intervals[len(intervals)-1]
+		if i < len(intervals) {
+			interval = intervals[i]
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+		}
+
+		// ----- ctx 结束 -----
+		if !req.CloseOnTimeout {
+			return last, ctx.Err()
+		}
+
+		outTradeNo := req.OutTradeNo
+		if outTradeNo == "" && last != nil {
+			outTradeNo = last.OutTradeNo
+		}
+		if outTradeNo == "" {
+			return last, ctx.Err()
+		}
+
+		closeTimeout := req.CloseTimeout
+		if closeTimeout <= 0 {
+			closeTimeout = DefaultPollOrderCloseTimeout
+		}
+		// ctx 已结束，但关单请求仍需保留其中的值（如 tracer span、日志字段）
+		closeCtx, cancel := context.WithTimeout(valuesOnlyContext{ctx}, closeTimeout)
+		defer cancel()
+
+		// 关单成功、订单已关闭或关单时订单恰好已支付，都再查询一次以获得最终状态
+		err = closeOrder(closeCtx, config, &CloseOrderRequest{OutTradeNo: outTradeNo}, options)
+		if err != nil && !IsAlreadyPaid(err) && !IsOrderClosed(err) {
+			return last, err
+		}
+		resp, err = orderQuery(closeCtx, config, queryReq, options)
+		if err != nil {
+			return last, err
+		}
+		observe(resp)
+		return resp, nil
+	}
+}
+
+// valuesOnlyContext 只保留 parent 中的值，不继承其 deadline 和取消
+type valuesOnlyContext struct {
+	parent context.Context
+}
+
+func (valuesOnlyContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (valuesOnlyContext) Done() <-chan struct{} { return nil }
+
+func (valuesOnlyContext) Err() error { return nil }
+
+func (c valuesOnlyContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
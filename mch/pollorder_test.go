@@ -0,0 +1,179 @@
+package mch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPathClient 按请求路径依次返回预设的响应
+type testPathClient struct {
+	mu    sync.Mutex
+	resps map[string][]func() (*http.Response, error)
+	paths []string
+	// values 为每个请求的 context 中 testCtxKey 对应的值
+	values []interface{}
+}
+
+type testCtxKey struct{}
+
+func (c *testPathClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := req.URL.Path
+	c.paths = append(c.paths, path)
+	c.values = append(c.values, req.Context().Value(testCtxKey{}))
+	resps := c.resps[path]
+	resp := resps[0]
+	if len(resps) > 1 {
+		c.resps[path] = resps[1:]
+	}
+	return resp()
+}
+
+func testOrderQueryRespond(tradeState TradeState) func() (*http.Response, error) {
+	x := MchXML{
+		"result_code":  "SUCCESS",
+		"out_trade_no": "1217752501201407033233368018",
+		"trade_state":  tradeState.String(),
+	}
+	if tradeState == TradeStateSUCCESS {
+		x["transaction_id"] = "1008450740201411110005820873"
+		x["openid"] = "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"
+		x["trade_type"] = "NATIVE"
+		x["bank_type"] = "CMC"
+		x["time_end"] = "20141030133525"
+		x["total_fee"] = "1"
+		x["cash_fee"] = "1"
+	}
+	return testSignedRespond(x)
+}
+
+func TestPollOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		Timeout      time.Duration
+		Resps        map[string][]func() (*http.Response, error)
+		ExpectErr    bool
+		ExpectState  TradeState
+		ExpectStates []TradeState
+	}{
+		{
+			time.Second,
+			map[string][]func() (*http.Response, error){
+				"/pay/orderquery": {
+					testOrderQueryRespond(TradeStateNOTPAY),
+					testOrderQueryRespond(TradeStateUSERPAYING),
+					testOrderQueryRespond(TradeStateUSERPAYING),
+					testBusinessErrorRespond(ErrCodeSYSTEMERROR),
+					testOrderQueryRespond(TradeStateSUCCESS),
+				},
+			},
+			false,
+			TradeStateSUCCESS,
+			[]TradeState{TradeStateNOTPAY, TradeStateUSERPAYING, TradeStateSUCCESS},
+		}, // 支付成功
+		{
+			time.Second,
+			map[string][]func() (*http.Response, error){
+				"/pay/orderquery": {testBusinessErrorRespond(ErrCodeORDERNOTEXIST)},
+			},
+			true,
+			TradeStateInvalid,
+			nil,
+		}, // 不可重试的业务错误
+		{
+			20 * time.Millisecond,
+			map[string][]func() (*http.Response, error){
+				"/pay/orderquery": {testOrderQueryRespond(TradeStateNOTPAY)},
+			},
+			true,
+			TradeStateNOTPAY,
+			[]TradeState{TradeStateNOTPAY},
+		}, // 超时
+	} {
+		client := &testPathClient{resps: testCase.Resps}
+		states := []TradeState{}
+		ctx, cancel := context.WithTimeout(context.Background(), testCase.Timeout)
+		resp, err := PollOrder(ctx, config, &PollOrderRequest{
+			OutTradeNo: "1217752501201407033233368018",
+			Intervals:  []time.Duration{time.Millisecond},
+			OnStateChange: func(resp *OrderQueryResponse) {
+				states = append(states, resp.TradeState)
+			},
+		}, UseClient(client))
+		cancel()
+
+		if testCase.ExpectErr {
+			assert.Error(err)
+		} else {
+			assert.NoError(err)
+		}
+		if testCase.ExpectState.IsValid() {
+			assert.Equal(testCase.ExpectState, resp.TradeState)
+		}
+		if testCase.ExpectStates != nil {
+			assert.Equal(testCase.ExpectStates, states)
+		}
+	}
+}
+
+func TestPollOrderCloseOnTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		CloseResp    func() (*http.Response, error)
+		FinalState   TradeState
+		ExpectStates []TradeState
+	}{
+		{
+			testSignedRespond(MchXML{"result_code": "SUCCESS"}),
+			TradeStateCLOSED,
+			[]TradeState{TradeStateNOTPAY, TradeStateCLOSED},
+		}, // 超时关单
+		{
+			testBusinessErrorRespond(ErrCodeORDERPAID),
+			TradeStateSUCCESS,
+			[]TradeState{TradeStateNOTPAY, TradeStateSUCCESS},
+		}, // 关单时订单恰好已支付
+	} {
+		// 关单前查询总是返回 NOTPAY，关单后查询返回最终状态
+		client := &testPathClient{resps: map[string][]func() (*http.Response, error){
+			"/pay/orderquery": {testOrderQueryRespond(TradeStateNOTPAY)},
+		}}
+		closeResp := testCase.CloseResp
+		finalResp := testOrderQueryRespond(testCase.FinalState)
+		client.resps["/pay/closeorder"] = []func() (*http.Response, error){
+			func() (*http.Response, error) {
+				client.resps["/pay/orderquery"] = []func() (*http.Response, error){finalResp}
+				return closeResp()
+			},
+		}
+
+		states := []TradeState{}
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), testCtxKey{}, "span"), 20*time.Millisecond)
+		resp, err := PollOrder(ctx, config, &PollOrderRequest{
+			OutTradeNo: "1217752501201407033233368018",
+			Intervals:  []time.Duration{time.Millisecond},
+			OnStateChange: func(resp *OrderQueryResponse) {
+				states = append(states, resp.TradeState)
+			},
+			CloseOnTimeout: true,
+		}, UseClient(client))
+		cancel()
+
+		assert.NoError(err)
+		assert.Equal(testCase.FinalState, resp.TradeState)
+		assert.Equal(testCase.ExpectStates, states)
+		assert.Equal("/pay/orderquery", client.paths[len(client.paths)-1])
+		assert.Equal("/pay/closeorder", client.paths[len(client.paths)-2])
+		// 关单及之后的查询仍保留调用方 ctx 中的值
+		assert.Equal("span", client.values[len(client.values)-1])
+		assert.Equal("span", client.values[len(client.values)-2])
+	}
+}
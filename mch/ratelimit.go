@@ -0,0 +1,179 @@
+package mch
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+var (
+	// DefaultRateLimits 为各接口默认的速率限制，未列出的接口不限制；微信支付对部分接口有频率限制（超过时返回
+	// FREQUENCY_LIMITED），以下为偏保守的取值，应按商户实际情况调整：
+	//
+	//   /pay/orderquery       查询订单 50 次/秒
+	//   /pay/closeorder       关闭订单 50 次/秒
+	//   /secapi/pay/refund    申请退款 100 次/秒（文档为 150 次/秒）
+	//   /pay/refundquery      查询退款 20 次/秒
+	//   /pay/downloadbill     下载交易账单 1 次/秒
+	//   /pay/downloadfundflow 下载资金账单 1 次/秒
+	//
+	// 统一下单等直接影响用户支付的接口默认不限制
+	DefaultRateLimits = map[string]RateLimit{
+		"/pay/orderquery":       {Rate: 50, Burst: 50},
+		"/pay/closeorder":       {Rate: 50, Burst: 50},
+		"/secapi/pay/refund":    {Rate: 100, Burst: 100},
+		"/pay/refundquery":      {Rate: 20, Burst: 20},
+		"/pay/downloadbill":     {Rate: 1, Burst: 1},
+		"/pay/downloadfundflow": {Rate: 1, Burst: 1},
+	}
+)
+
+// RateLimit 为单个接口的速率限制（令牌桶）
+type RateLimit struct {
+	// Rate 每秒平均请求数，<= 0 表示不限制
+	Rate float64
+
+	// Burst 允许的突发请求数（桶容量），<= 0 时为 1
+	Burst int
+}
+
+// RateLimitError 表示请求因限流而没有发出
+type RateLimitError struct {
+	// MchID 商户号
+	MchID string
+	// Path 接口路径
+	Path string
+	// Wait 需要等待的时长
+	Wait time.Duration
+}
+
+// Error 满足 error 接口
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("RateLimitError(mch_id=%s, path=%s, wait=%s)", err.MchID, err.Path, err.Wait)
+}
+
+// RateLimiter 按商户号和接口路径限制调用速率（令牌桶），用于避免批量任务（如对账、批量查单）耗尽微信支付的频率限制
+// 而影响在线支付；每次尝试（包括重试）都消耗一个令牌。没有令牌时：
+//
+//   - 阻塞模式：等待至有令牌，若等待后会超过 ctx 的 deadline 则直接返回 *RateLimitError
+//   - 快速失败模式：直接返回 *RateLimitError
+//
+// RateLimiter 需要在多次调用间共享，一般放在 DefaultOptions 中；批量任务也可使用另一个限制更严、快速失败的 RateLimiter：
+//
+//   mch.DefaultOptions = mch.MustOptions(
+//   	mch.UseRateLimiter(mch.NewRateLimiter(nil, false)),
+//   )
+type RateLimiter struct {
+	limits   map[string]RateLimit
+	failFast bool
+
+	mu      sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+}
+
+type rateLimitKey struct {
+	mchID string
+	path  string
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64 // 可以为负数，表示已被预留的令牌
+	last   time.Time
+}
+
+// NewRateLimiter 创建一个 RateLimiter，limits 为接口路径到速率限制的映射，为 nil 时使用 DefaultRateLimits；
+// failFast 为 true 时没有令牌则直接返回 *RateLimitError，否则等待
+func NewRateLimiter(limits map[string]RateLimit, failFast bool) *RateLimiter {
+	if limits == nil {
+		limits = DefaultRateLimits
+	}
+	copied := make(map[string]RateLimit, len(limits))
+	for path, limit := range limits {
+		if limit.Burst <= 0 {
+			limit.Burst = 1
+		}
+		copied[path] = limit
+	}
+	return &RateLimiter{
+		limits:   copied,
+		failFast: failFast,
+		buckets:  map[rateLimitKey]*tokenBucket{},
+	}
+}
+
+// reserve 取一个令牌，返回需要等待的时长；快速失败模式或等待后会超过 deadline 时不预留令牌并返回 ok == false
+func (limiter *RateLimiter) reserve(mchID, path string, deadline time.Time) (wait time.Duration, ok bool) {
+	limit, found := limiter.limits[path]
+	if !found || limit.Rate <= 0 {
+		return 0, true
+	}
+	now := utils.Now()
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	key := rateLimitKey{mchID: mchID, path: path}
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		limiter.buckets[key] = bucket
+	}
+
+	// 补充令牌
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed.Seconds()*limit.Rate)
+		bucket.last = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+
+	wait = time.Duration(math.Ceil((1 - bucket.tokens) / limit.Rate * float64(time.Second)))
+	if limiter.failFast || (!deadline.IsZero() && now.Add(wait).After(deadline)) {
+		return wait, false
+	}
+	bucket.tokens--
+	return wait, true
+}
+
+// cancel 归还 reserve 预留的令牌
+func (limiter *RateLimiter) cancel(mchID, path string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if bucket := limiter.buckets[rateLimitKey{mchID: mchID, path: path}]; bucket != nil {
+		bucket.tokens = math.Min(float64(bucket.limit.Burst), bucket.tokens+1)
+	}
+}
+
+// wait 等待至可以发出请求；limiter 为 nil 时不限制
+func (limiter *RateLimiter) wait(ctx context.Context, mchID, path string) error {
+	if limiter == nil {
+		return nil
+	}
+
+	deadline, _ := ctx.Deadline()
+	wait, ok := limiter.reserve(mchID, path, deadline)
+	if !ok {
+		return &RateLimitError{MchID: mchID, Path: path, Wait: wait}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		limiter.cancel(mchID, path)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
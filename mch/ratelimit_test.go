@@ -0,0 +1,127 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	assert := assert.New(t)
+
+	current := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := utils.Now
+	utils.Now = func() time.Time {
+		return current
+	}
+	defer func() {
+		utils.Now = now
+	}()
+
+	limiter := NewRateLimiter(map[string]RateLimit{
+		"/pay/orderquery": {Rate: 2, Burst: 2},
+		"/pay/unlimited":  {},
+	}, true)
+
+	// 桶满时可突发
+	for i := 0; i < 2; i++ {
+		wait, ok := limiter.reserve("1", "/pay/orderquery", time.Time{})
+		assert.True(ok)
+		assert.Equal(time.Duration(0), wait)
+	}
+
+	// 快速失败，返回需要等待的时长
+	wait, ok := limiter.reserve("1", "/pay/orderquery", time.Time{})
+	assert.False(ok)
+	assert.Equal(500*time.Millisecond, wait)
+
+	// 不同商户/没有限制的接口互不影响
+	_, ok = limiter.reserve("2", "/pay/orderquery", time.Time{})
+	assert.True(ok)
+	for i := 0; i < 10; i++ {
+		_, ok = limiter.reserve("1", "/pay/unlimited", time.Time{})
+		assert.True(ok)
+		_, ok = limiter.reserve("1", "/pay/unifiedorder", time.Time{})
+		assert.True(ok)
+	}
+
+	// 按时间补充令牌，但不超过桶容量
+	current = current.Add(500 * time.Millisecond)
+	_, ok = limiter.reserve("1", "/pay/orderquery", time.Time{})
+	assert.True(ok)
+	_, ok = limiter.reserve("1", "/pay/orderquery", time.Time{})
+	assert.False(ok)
+	current = current.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		_, ok = limiter.reserve("1", "/pay/orderquery", time.Time{})
+		assert.True(ok)
+	}
+	_, ok = limiter.reserve("1", "/pay/orderquery", time.Time{})
+	assert.False(ok)
+
+	// 阻塞模式预留令牌，后续请求等待更久；等待后超过 deadline 时不预留
+	limiter = NewRateLimiter(map[string]RateLimit{"/pay/orderquery": {Rate: 2}}, false)
+	wait, ok = limiter.reserve("1", "/pay/orderquery", time.Time{})
+	assert.True(ok)
+	assert.Equal(time.Duration(0), wait)
+	wait, ok = limiter.reserve("1", "/pay/orderquery", time.Time{})
+	assert.True(ok)
+	assert.Equal(500*time.Millisecond, wait)
+	wait, ok = limiter.reserve("1", "/pay/orderquery", current.Add(time.Second))
+	assert.True(ok)
+	assert.Equal(time.Second, wait)
+	_, ok = limiter.reserve("1", "/pay/orderquery", current.Add(time.Second))
+	assert.False(ok)
+	limiter.cancel("1", "/pay/orderquery")
+	wait, ok = limiter.reserve("1", "/pay/orderquery", current.Add(time.Second))
+	assert.True(ok)
+	assert.Equal(time.Second, wait)
+}
+
+func TestRateLimiterCall(t *testing.T) {
+	assert := assert.New(t)
+
+	respond := testSignedRespond(MchXML{"result_code": "SUCCESS"})
+	client := &testSeqClient{resps: []func() (*http.Response, error){respond}}
+
+	// 快速失败：不发出请求
+	recorder := &testEventRecorder{}
+	options := MustOptions(
+		UseClient(client),
+		UseObserver(recorder),
+		UseRateLimiter(NewRateLimiter(map[string]RateLimit{"/pay/orderquery": {Rate: 1}}, true)),
+	)
+	_, err := PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, options)
+	assert.NoError(err)
+	_, err = PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, options)
+	var rateLimitErr *RateLimitError
+	assert.True(errors.As(err, &rateLimitErr))
+	assert.Equal(config.WechatMchID(), rateLimitErr.MchID)
+	assert.Equal("/pay/orderquery", rateLimitErr.Path)
+	assert.Len(client.reqs, 1)
+	assert.Len(recorder.events, 2)
+	assert.Equal(ErrorClassRateLimited, recorder.events[1].ErrorClass)
+
+	// 阻塞：等待令牌
+	options = MustOptions(
+		UseClient(client),
+		UseRateLimiter(NewRateLimiter(map[string]RateLimit{"/pay/orderquery": {Rate: 20}}, false)),
+	)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = PostMchXML(context.Background(), config, "/pay/orderquery", MchXML{}, options)
+		assert.NoError(err)
+	}
+	assert.True(time.Since(start) >= 90*time.Millisecond)
+
+	// 阻塞：等待后会超过 deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = PostMchXML(ctx, config, "/pay/orderquery", MchXML{}, options)
+	assert.True(errors.As(err, &rateLimitErr))
+}
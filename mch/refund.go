@@ -3,42 +3,54 @@ package mch
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/huangjunwen/wx-driver/conf"
 )
 
 var (
-	ErrRefundMissingOutRefundNo = errors.New("Missing out_refund_no in RefundRequest")
+	ErrRefundMissingOutRefundNo = missingRequestField("RefundRequest", "out_refund_no")
 	ErrRefundMissingID          = errors.New("Missing transaction_id/out_trade_no in RefundRequest")
-	ErrRefundMissingTotalFee    = errors.New("Missing total_fee in RefundRequest")
-	ErrRefundMissingRefundFee   = errors.New("Missing refund_fee in RefundRequest")
-	ErrRefundNoTransactionID    = errors.New("No transaction_id is returned from RefundResponse")
-	ErrRefundNoOutTradeNo       = errors.New("No out_trade_no is returned from RefundResponse")
-	ErrRefundNoRefundID         = errors.New("No refund_id is returned from RefundResponse")
-	ErrRefundNoOutRefundNo      = errors.New("No out_refund_no is returned from RefundResponse")
-	ErrRefundNoTotalFee         = errors.New("No total_fee is returned from RefundResponse")
-	ErrRefundNoCashFee          = errors.New("No cash_fee is returned from RefundResponse")
-	ErrRefundNoRefundFee        = errors.New("No refund_fee is returned from RefundResponse")
-	ErrRefundNoCashRefundFee    = errors.New("No cash_refund_fee is returned from RefundResponse")
+	ErrRefundMissingTotalFee    = missingRequestField("RefundRequest", "total_fee")
+	ErrRefundMissingRefundFee   = missingRequestField("RefundRequest", "refund_fee")
+	ErrRefundFeeTypeMismatch    = errors.New("Mismatch refund_fee_type and fee_type in RefundRequest")
+	ErrRefundExceedsRefundable  = errors.New("refund_fee exceeds the remaining refundable fee in RefundRequest")
+	ErrRefundNoTransactionID    = missingResponseField("RefundResponse", "transaction_id")
+	ErrRefundNoOutTradeNo       = missingResponseField("RefundResponse", "out_trade_no")
+	ErrRefundNoRefundID         = missingResponseField("RefundResponse", "refund_id")
+	ErrRefundNoOutRefundNo      = missingResponseField("RefundResponse", "out_refund_no")
+	ErrRefundNoTotalFee         = missingResponseField("RefundResponse", "total_fee")
+	ErrRefundNoCashFee          = missingResponseField("RefundResponse", "cash_fee")
+	ErrRefundNoRefundFee        = missingResponseField("RefundResponse", "refund_fee")
+	ErrRefundNoCashRefundFee    = missingResponseField("RefundResponse", "cash_refund_fee")
+)
+
+var (
+	refundEndpoint = &Endpoint{
+		Path:              "/secapi/pay/refund",
+		RequireClientCert: true,
+	}
 )
 
 // RefundRequest 为申请退款接口请求
 type RefundRequest struct {
 	// ----- 必填字段 -----
-	OutRefundNo string // out_refund_no String(64) 商户退款单号，商户系统内部唯一
+	OutRefundNo string `mch:"out_refund_no,required"` // out_refund_no String(64) 商户退款单号，商户系统内部唯一
 
 	// 以下二选一
-	TransactionID string // transaction_id String(32) 微信订单号
-	OutTradeNo    string // out_trade_no String(32) 商户订单号，商户系统内部唯一
+	TransactionID string `mch:"transaction_id"` // transaction_id String(32) 微信订单号
+	OutTradeNo    string `mch:"out_trade_no"`   // out_trade_no String(32) 商户订单号，商户系统内部唯一
 
 	// 金额字段
-	TotalFee  uint64 // total_fee Int 标价金额 单位为分
-	RefundFee uint64 // refund_fee Int 退款金额 (NOTE: 此退款金额的币种跟标价金额是一致的)
+	TotalFee  Money `mch:"total_fee,required"`                           // total_fee Int 标价金额 单位为分 (NOTE: 币种为订单的标价币种，不会发送给微信，仅用于检查)
+	RefundFee Money `mch:"refund_fee,required,currency=refund_fee_type"` // refund_fee Int 退款金额 / refund_fee_type String(8) 退款币种，必须与标价币种一致
 
 	// ----- 选填字段 -----
-	RefundFeeType string // refund_fee_type String(8) 退款币种，必须与标价金额一致
-	RefundDesc    string // refund_desc String(80) 退款原因
-	NotifyUrl     string // notify_url String(256) 异步接收微信支付退款结果通知的回调地址
+	RefundDesc string `mch:"refund_desc"` // refund_desc String(80) 退款原因
+	NotifyUrl  string `mch:"notify_url"`  // notify_url String(256) 异步接收微信支付退款结果通知的回调地址
+
+	// ----- 检查用字段，不会发送给微信 -----
+	RefundedFee Money // 该订单之前已退款的金额（可使用 RefundQueryAll 获得），用于检查本次退款是否超出剩余可退金额
 }
 
 // RefundRequest 为申请退款接口响应
@@ -47,73 +59,61 @@ type RefundResponse struct {
 	MchXML MchXML
 
 	// ----- 必返回字段 -----
-	TransactionID string // transaction_id String(32) 微信订单号
-	OutTradeNo    string // out_trade_no String(32) 商户订单号
-	RefundID      string // refund_id String(32) 微信退款单号
-	OutRefundNo   string // out_refund_no String(64) 商户退款单号
-	TotalFee      uint64 // total_fee Int 标价金额
-	CashFee       uint64 // cash_fee Int 现金支付金额
-	RefundFee     uint64 // refund_fee Int 退款金额
-	CashRefundFee uint64 // cash_refund_fee Int 现金退款金额
+	TransactionID string `mch:"transaction_id,required"`                                // transaction_id String(32) 微信订单号
+	OutTradeNo    string `mch:"out_trade_no,required"`                                  // out_trade_no String(32) 商户订单号
+	RefundID      string `mch:"refund_id,required"`                                     // refund_id String(32) 微信退款单号
+	OutRefundNo   string `mch:"out_refund_no,required"`                                 // out_refund_no String(64) 商户退款单号
+	TotalFee      Money  `mch:"total_fee,required,currency=fee_type"`                   // total_fee Int 标价金额 / fee_type String(16) 标价币种
+	CashFee       Money  `mch:"cash_fee,required,currency=cash_fee_type"`               // cash_fee Int 现金支付金额 / cash_fee_type String(16) 现金支付币种
+	RefundFee     Money  `mch:"refund_fee,required,currency=refund_fee_type"`           // refund_fee Int 退款金额 / refund_fee_type String(8) 退款币种
+	CashRefundFee Money  `mch:"cash_refund_fee,required,currency=cash_refund_fee_type"` // cash_refund_fee Int 现金退款金额 / cash_refund_fee_type String(8) 现金退款金额币种
 
 	// ----- 选传字段 -----
-	FeeType           string // fee_type String(16) 标价币种
-	CashFeeType       string // cash_fee_type String(16) 现金支付币种
-	Rate              uint64 // rate String(16) 汇率 标价币种与支付币种兑换比例乘以10^8
-	RefundFeeType     string // refund_fee_type String(8) 退款币种
-	CashRefundFeeType string // cash_refund_fee_type String(8) 现金退款金额币种
+	Rate uint64 `mch:"rate"` // rate String(16) 汇率 标价币种与支付币种兑换比例乘以10^8
 
 	// TODO: 优惠金额字段
 }
 
-// Refund 申请退款接口，该接口需要客户端证书的 client
+// Refund 申请退款接口，该接口需要客户端证书的 client，若 client 没有配置证书则返回 ErrClientCertRequired
 func Refund(ctx context.Context, config conf.MchConfig, req *RefundRequest, opts ...Option) (*RefundResponse, error) {
 	options, err := NewOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	// req -> reqXML
-	reqXML := MchXML{}
-
-	if req.OutRefundNo != "" {
-		reqXML.fillString(req.OutRefundNo, "out_refund_no")
-	} else {
-		return nil, ErrRefundMissingOutRefundNo
-	}
-
-	if req.TransactionID != "" {
-		reqXML.fillString(req.TransactionID, "transaction_id")
-	} else if req.OutTradeNo != "" {
-		reqXML.fillString(req.OutTradeNo, "out_trade_no")
-	} else {
-		return nil, ErrRefundMissingID
+	// 检查必填字段以及字段长度和格式，一次列出全部问题
+	if err := req.Validate(); err != nil {
+		return nil, err
 	}
 
-	if req.TotalFee != 0 {
-		reqXML.fillUint64(req.TotalFee, "total_fee")
-	} else {
-		return nil, ErrRefundMissingTotalFee
+	// req -> reqXML
+	reqXML, err := MarshalMchXML(req)
+	if err != nil {
+		return nil, err
 	}
-
-	if req.RefundFee != 0 {
-		reqXML.fillUint64(req.RefundFee, "refund_fee")
-	} else {
-		return nil, ErrRefundMissingRefundFee
+	// 二选一，优先使用 transaction_id
+	if !reqXML.keepFirst("transaction_id", "out_trade_no") {
+		return nil, ErrRefundMissingID
 	}
 
-	if req.RefundFeeType != "" {
-		reqXML.fillString(req.RefundFeeType, "refund_fee_type")
+	// 检查退款币种与标价币种一致，且退款金额不超过剩余可退金额
+	if !req.RefundFee.SameCurrency(req.TotalFee) {
+		return nil, ErrRefundFeeTypeMismatch
 	}
-	if req.RefundDesc != "" {
-		reqXML.fillString(req.RefundDesc, "refund_desc")
+	// 未提供已退款金额时其币种为空（视为人民币），不能与外币的标价金额相减
+	refundableFee := req.TotalFee
+	if !req.RefundedFee.IsZero() {
+		refundableFee, err = req.TotalFee.Sub(req.RefundedFee)
+		if err != nil {
+			return nil, fmt.Errorf("Bad refunded fee in RefundRequest: %s", err)
+		}
 	}
-	if req.NotifyUrl != "" {
-		reqXML.fillString(req.NotifyUrl, "notify_url")
+	if req.RefundFee.Amount > refundableFee.Amount {
+		return nil, ErrRefundExceedsRefundable
 	}
 
 	// reqXML -> respXML
-	respXML, err := PostMchXML(ctx, config, "/secapi/pay/refund", reqXML, options)
+	respXML, err := PostEndpointXML(ctx, config, refundEndpoint, reqXML, options)
 	if err != nil {
 		return nil, err
 	}
@@ -122,55 +122,10 @@ func Refund(ctx context.Context, config conf.MchConfig, req *RefundRequest, opts
 	resp := RefundResponse{
 		MchXML: respXML,
 	}
-	respXML.extractString(&resp.TransactionID, "transaction_id", &err)
-	respXML.extractString(&resp.OutTradeNo, "out_trade_no", &err)
-	respXML.extractString(&resp.RefundID, "refund_id", &err)
-	respXML.extractString(&resp.OutRefundNo, "out_refund_no", &err)
-	respXML.extractUint64(&resp.TotalFee, "total_fee", &err)
-	respXML.extractUint64(&resp.CashFee, "cash_fee", &err)
-	respXML.extractUint64(&resp.RefundFee, "refund_fee", &err)
-	respXML.extractUint64(&resp.CashRefundFee, "cash_refund_fee", &err)
-	respXML.extractString(&resp.FeeType, "fee_type", &err)
-	respXML.extractString(&resp.CashFeeType, "cash_fee_type", &err)
-	respXML.extractUint64(&resp.Rate, "rate", &err)
-	respXML.extractString(&resp.RefundFeeType, "refund_fee_type", &err)
-	respXML.extractString(&resp.CashRefundFeeType, "cash_refund_fee_type", &err)
-	if err != nil {
+	if err := UnmarshalMchXML(respXML, &resp); err != nil {
 		return nil, err
 	}
 
-	// 检查返回字段
-	if resp.TransactionID == "" {
-		return nil, ErrRefundNoTransactionID
-	}
-	if resp.OutTradeNo == "" {
-		return nil, ErrRefundNoOutTradeNo
-	}
-	if resp.RefundID == "" {
-		return nil, ErrRefundNoRefundID
-	}
-	if resp.OutRefundNo == "" {
-		return nil, ErrRefundNoOutRefundNo
-	}
-	if resp.TotalFee == 0 {
-		return nil, ErrRefundNoTotalFee
-	}
-	if resp.RefundFee == 0 {
-		return nil, ErrRefundNoRefundFee
-	}
-	// CashFee/CashRefundFee 说不定可能为 0 （完全用优惠金支付），如果为 0，则需要额外检查 xml 是否
-	// 有该项返回，若有返回则则不报错
-	if resp.CashFee == 0 {
-		if respXML["cash_fee"] == "" {
-			return nil, ErrRefundNoCashFee
-		}
-	}
-	if resp.CashRefundFee == 0 {
-		if respXML["cash_refund_fee"] == "" {
-			return nil, ErrRefundNoCashRefundFee
-		}
-	}
-
 	return &resp, nil
 
 }
@@ -3,7 +3,6 @@ package mch
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"time"
 
@@ -12,24 +11,30 @@ import (
 
 var (
 	ErrRefundQueryMissingID       = errors.New("Missing refund_id/out_refund_no/transaction_id/out_trade_no in RefundQueryRequest")
-	ErrRefundQueryNoOutTradeNo    = errors.New("No out_trade_no is returned from RefundQueryResponse")
-	ErrRefundQueryNoTransactionID = errors.New("No transaction_id is returned from RefundQueryResponse")
-	ErrRefundQueryNoTotalFee      = errors.New("No total_fee is returned from RefundQueryResponse")
-	ErrRefundQueryNoCashFee       = errors.New("No cash_fee is returned from RefundQueryResponse")
-	ErrRefundQueryNoRefundCount   = errors.New("No refund_count is returned from RefundQueryResponse")
+	ErrRefundQueryNoOutTradeNo    = missingResponseField("RefundQueryResponse", "out_trade_no")
+	ErrRefundQueryNoTransactionID = missingResponseField("RefundQueryResponse", "transaction_id")
+	ErrRefundQueryNoTotalFee      = missingResponseField("RefundQueryResponse", "total_fee")
+	ErrRefundQueryNoCashFee       = missingResponseField("RefundQueryResponse", "cash_fee")
+	ErrRefundQueryNoRefundCount   = missingResponseField("RefundQueryResponse", "refund_count")
+	ErrRefundQueryAllMissingID    = errors.New("Missing transaction_id/out_trade_no in RefundQueryRequest for RefundQueryAll")
+)
+
+const (
+	// refundQueryPageSize 为查询退款接口每次最多返回的退款单数
+	refundQueryPageSize = 10
 )
 
 // RefundQueryRequest 为查询退款接口请求
 type RefundQueryRequest struct {
 	// ----- 必填字段 -----
 	// 以下四选一，优先级为 refund_id > out_refund_no > transaction_id > out_trade_no
-	RefundID      string // refund_id String(32) 微信退款单号
-	OutRefundNo   string // out_refund_no String(64) 商户退款单号
-	TransactionID string // transaction_id String(32) 微信订单号
-	OutTradeNo    string // out_trade_no String(32) 商户订单号
+	RefundID      string `mch:"refund_id"`      // refund_id String(32) 微信退款单号
+	OutRefundNo   string `mch:"out_refund_no"`  // out_refund_no String(64) 商户退款单号
+	TransactionID string `mch:"transaction_id"` // transaction_id String(32) 微信订单号
+	OutTradeNo    string `mch:"out_trade_no"`   // out_trade_no String(32) 商户订单号
 
 	// ----- 选填字段 -----
-	Offset uint // offset Int 偏移量
+	Offset uint `mch:"offset"` // offset Int 偏移量
 }
 
 // RefundQueryRequest 为查询退款接口响应
@@ -38,52 +43,47 @@ type RefundQueryResponse struct {
 	MchXML MchXML
 
 	// ----- 必返回字段 -----
-	OutTradeNo    string       // out_trade_no String(32) 商户订单号
-	TransactionID string       // transaction_id String(32) 微信订单号
-	TotalFee      uint64       // total_fee Int 标价金额
-	CashFee       uint64       // cash_fee Int 现金支付金额
-	RefundCount   uint64       // refund_count 当前返回退款单数
-	Refunds       []RefundInfo // 退款单信息
+	OutTradeNo    string       `mch:"out_trade_no,required"`                    // out_trade_no String(32) 商户订单号
+	TransactionID string       `mch:"transaction_id,required"`                  // transaction_id String(32) 微信订单号
+	TotalFee      Money        `mch:"total_fee,required,currency=fee_type"`     // total_fee Int 标价金额 / fee_type String(16) 标价币种
+	CashFee       Money        `mch:"cash_fee,required,currency=cash_fee_type"` // cash_fee Int 现金支付金额 / cash_fee_type String(16) 现金支付币种
+	RefundCount   uint64       `mch:"refund_count,required"`                    // refund_count 当前返回退款单数
+	Refunds       []RefundInfo `mch:",count=refund_count"`                      // 退款单信息
 
 	// ----- 其它字段 -----
-	TotalRefundCount uint64 // total_refund_count Int 订单总退款次数
-	FeeType          string // fee_type String(16) 标价币种
-	CashFeeType      string // cash_fee_type String(16) 现金支付币种
-	Rate             uint64 // rate String(16) 汇率 标价币种与支付币种兑换比例乘以10^8
+	TotalRefundCount uint64 `mch:"total_refund_count"` // total_refund_count Int 订单总退款次数
+	Rate             uint64 `mch:"rate"`               // rate String(16) 汇率 标价币种与支付币种兑换比例乘以10^8
 }
 
 // RefundInfo 为查询退款接口响应中的单笔退款单信息
 type RefundInfo struct {
 	// ----- 必返回字段 -----
-	RefundID     string       // refund_id_$n String(32) 微信退款单号
-	OutRefundNo  string       // out_refund_no_$n String(64) 商户退款单号
-	RefundFee    uint64       // refund_fee_$n Int 退款金额
-	RefundStatus RefundStatus // refund_status_$n String(16) 退款状态
+	RefundID     string       `mch:"refund_id_$n,required"`                    // refund_id_$n String(32) 微信退款单号
+	OutRefundNo  string       `mch:"out_refund_no_$n,required"`                // out_refund_no_$n String(64) 商户退款单号
+	RefundFee    Money        `mch:"refund_fee_$n,required,currency=fee_type"` // refund_fee_$n Int 退款金额 币种为标价币种
+	RefundStatus RefundStatus `mch:"refund_status_$n,required"`                // refund_status_$n String(16) 退款状态
 
 	// ----- 其它字段 -----
-	RefundChannel     string    // refund_channel_$n String(16) 退款渠道
-	RefundAccount     string    // refund_account_$n String(30) 退款资金来源
-	RefundRecvAccout  string    // refund_recv_accout_$n String(64) 退款入账账户
-	RefundSuccessTime time.Time // refund_success_time_$n String(20) 退款成功时间 (2016-07-25 15:26:26)
+	RefundChannel     string    `mch:"refund_channel_$n"`                                 // refund_channel_$n String(16) 退款渠道
+	RefundAccount     string    `mch:"refund_account_$n"`                                 // refund_account_$n String(30) 退款资金来源
+	RefundRecvAccout  string    `mch:"refund_recv_accout_$n"`                             // refund_recv_accout_$n String(64) 退款入账账户
+	RefundSuccessTime time.Time `mch:"refund_success_time_$n,layout=2006-01-02 15:04:05"` // refund_success_time_$n String(20) 退款成功时间 (2016-07-25 15:26:26)
 }
 
 func refundQuery(ctx context.Context, config conf.MchConfig, req *RefundQueryRequest, options *Options) (*RefundQueryResponse, error) {
-	// req -> reqXML
-	reqXML := MchXML{}
-	if req.RefundID != "" {
-		reqXML.fillString(req.RefundID, "refund_id")
-	} else if req.OutRefundNo != "" {
-		reqXML.fillString(req.OutRefundNo, "out_refund_no")
-	} else if req.TransactionID != "" {
-		reqXML.fillString(req.TransactionID, "transaction_id")
-	} else if req.OutTradeNo != "" {
-		reqXML.fillString(req.OutTradeNo, "out_trade_no")
-	} else {
-		return nil, ErrRefundQueryMissingID
+	// 检查必填字段以及字段长度和格式，一次列出全部问题
+	if err := req.Validate(); err != nil {
+		return nil, err
 	}
 
-	if req.Offset != 0 {
-		reqXML.fillUint64(uint64(req.Offset), "offset")
+	// req -> reqXML
+	reqXML, err := MarshalMchXML(req)
+	if err != nil {
+		return nil, err
+	}
+	// 四选一，按优先级保留
+	if !reqXML.keepFirst("refund_id", "out_refund_no", "transaction_id", "out_trade_no") {
+		return nil, ErrRefundQueryMissingID
 	}
 
 	// reqXML -> respXML
@@ -96,68 +96,17 @@ func refundQuery(ctx context.Context, config conf.MchConfig, req *RefundQueryReq
 	resp := RefundQueryResponse{
 		MchXML: respXML,
 	}
-	respXML.extractString(&resp.OutTradeNo, "out_trade_no", &err)
-	respXML.extractString(&resp.TransactionID, "transaction_id", &err)
-	respXML.extractUint64(&resp.TotalFee, "total_fee", &err)
-	respXML.extractUint64(&resp.CashFee, "cash_fee", &err)
-	respXML.extractUint64(&resp.RefundCount, "refund_count", &err)
-	respXML.extractUint64(&resp.TotalRefundCount, "total_refund_count", &err)
-	respXML.extractString(&resp.FeeType, "fee_type", &err)
-	respXML.extractString(&resp.CashFeeType, "cash_fee_type", &err)
-	respXML.extractUint64(&resp.Rate, "rate", &err)
-	if err != nil {
+	// NOTE: 退款单下标从 offset 开始编号
+	if err := unmarshalMchXML(respXML, &resp, int(req.Offset)); err != nil {
 		return nil, err
 	}
 
-	if resp.OutTradeNo == "" {
-		return nil, ErrRefundQueryNoOutTradeNo
-	}
-	if resp.TransactionID == "" {
-		return nil, ErrRefundQueryNoTransactionID
-	}
-	if resp.TotalFee == 0 {
-		return nil, ErrRefundQueryNoTotalFee
-	}
-	if resp.CashFee == 0 {
-		return nil, ErrRefundQueryNoCashFee
-	}
 	if resp.RefundCount == 0 {
 		// NOTE: 据实际测试，若支付订单没有退款单不会返回一个 0 的 refund_count 而是返回错误，
 		// 所以这里直接检查为 0 就好
 		return nil, ErrRefundQueryNoRefundCount
 	}
 
-	resp.Refunds = make([]RefundInfo, resp.RefundCount)
-	for i := 0; i < int(resp.RefundCount); i++ {
-		ri := &resp.Refunds[i]
-		idx := i + int(req.Offset)
-		respXML.extractString(&ri.RefundID, fmt.Sprintf("refund_id_%d", idx), &err)
-		respXML.extractString(&ri.OutRefundNo, fmt.Sprintf("out_refund_no_%d", idx), &err)
-		respXML.extractUint64(&ri.RefundFee, fmt.Sprintf("refund_fee_%d", idx), &err)
-		respXML.extractRefundStatus(&ri.RefundStatus, fmt.Sprintf("refund_status_%d", idx), &err)
-		respXML.extractString(&ri.RefundChannel, fmt.Sprintf("refund_channel_%d", idx), &err)
-		respXML.extractString(&ri.RefundAccount, fmt.Sprintf("refund_account_%d", idx), &err)
-		respXML.extractString(&ri.RefundRecvAccout, fmt.Sprintf("refund_recv_accout_%d", idx), &err)
-		respXML.extractTime(&ri.RefundSuccessTime, fmt.Sprintf("refund_success_time_%d", idx), "2006-01-02 15:04:05", &err)
-		if err != nil {
-			return nil, err
-		}
-
-		if ri.RefundID == "" {
-			return nil, fmt.Errorf("No refund_id_%d is returned from RefundQueryResponse", idx)
-		}
-		if ri.OutRefundNo == "" {
-			return nil, fmt.Errorf("No out_refund_no_%d is returned from RefundQueryResponse", idx)
-		}
-		if ri.RefundFee == 0 {
-			// NOTE: refund_fee 总是非 0
-			return nil, fmt.Errorf("No refund_fee_%d is returned from RefundQueryResponse", idx)
-		}
-		if !ri.RefundStatus.IsValid() {
-			return nil, fmt.Errorf("No refund_status_%d is returned from RefundQueryResponse", idx)
-		}
-	}
-
 	return &resp, nil
 }
 
@@ -170,8 +119,125 @@ func RefundQuery(ctx context.Context, config conf.MchConfig, req *RefundQueryReq
 	return refundQuery(ctx, config, req, options)
 }
 
+// RefundQueryAllResponse 为查询订单全部退款的结果
+type RefundQueryAllResponse struct {
+	// ----- 原始数据 -----
+	Pages []*RefundQueryResponse // 每次调用查询退款接口的响应
+
+	// ----- 订单信息 -----
+	OutTradeNo    string // out_trade_no String(32) 商户订单号
+	TransactionID string // transaction_id String(32) 微信订单号
+	TotalFee      Money  // total_fee Int 标价金额 / fee_type String(16) 标价币种
+	CashFee       Money  // cash_fee Int 现金支付金额 / cash_fee_type String(16) 现金支付币种
+
+	// ----- 退款信息 -----
+	Refunds          []RefundInfo // 订单的全部退款单，按 refund_id 去重
+	RefundFee        Money        // 退款总金额，即除退款关闭（REFUNDCLOSE）以外的退款单的 refund_fee 之和
+	SuccessRefundFee Money        // 已成功退款的总金额，即退款成功（SUCCESS）的退款单的 refund_fee 之和
+}
+
+// RefundableFee 返回剩余可退款金额，即标价金额减去退款总金额，可用于 RefundRequest.RefundedFee 的检查：
+//
+//   all, err := mch.RefundQueryAll(ctx, config, &mch.RefundQueryRequest{OutTradeNo: outTradeNo})
+//   ...
+//   resp, err := mch.Refund(ctx, config, &mch.RefundRequest{
+//   	...
+//   	TotalFee:    all.TotalFee,
+//   	RefundFee:   refundFee,
+//   	RefundedFee: all.RefundFee,
+//   })
+func (resp *RefundQueryAllResponse) RefundableFee() Money {
+	refundable, err := resp.TotalFee.Sub(resp.RefundFee)
+	if err != nil {
+		return Money{Currency: resp.TotalFee.Currency}
+	}
+	return refundable
+}
+
+// RefundQueryAll 查询订单的全部退款单：查询退款接口每次最多返回 10 笔退款单，该函数会使用 offset 自动翻页，
+// 按 refund_id 去重后汇总退款金额；req 中必须提供 TransactionID 或 OutTradeNo，RefundID/OutRefundNo/Offset 会被忽略
+func RefundQueryAll(ctx context.Context, config conf.MchConfig, req *RefundQueryRequest, opts ...Option) (*RefundQueryAllResponse, error) {
+	options, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return refundQueryAll(ctx, config, req, options)
+}
+
+func refundQueryAll(ctx context.Context, config conf.MchConfig, req *RefundQueryRequest, options *Options) (*RefundQueryAllResponse, error) {
+	if req.TransactionID == "" && req.OutTradeNo == "" {
+		return nil, ErrRefundQueryAllMissingID
+	}
+
+	result := &RefundQueryAllResponse{}
+	seen := map[string]bool{}
+	offset := uint(0)
+	for {
+		resp, err := refundQuery(ctx, config, &RefundQueryRequest{
+			TransactionID: req.TransactionID,
+			OutTradeNo:    req.OutTradeNo,
+			Offset:        offset,
+		}, options)
+		if err != nil {
+			// 翻页超出范围
+			if offset != 0 && IsRefundNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+
+		if len(result.Pages) == 0 {
+			result.OutTradeNo = resp.OutTradeNo
+			result.TransactionID = resp.TransactionID
+			result.TotalFee = resp.TotalFee
+			result.CashFee = resp.CashFee
+			result.RefundFee = Money{Currency: resp.TotalFee.Currency}
+			result.SuccessRefundFee = Money{Currency: resp.TotalFee.Currency}
+		}
+		result.Pages = append(result.Pages, resp)
+
+		added := 0
+		for _, ri := range resp.Refunds {
+			if seen[ri.RefundID] {
+				continue
+			}
+			seen[ri.RefundID] = true
+			added++
+
+			result.Refunds = append(result.Refunds, ri)
+			if ri.RefundStatus != RefundStatusREFUNDCLOSE {
+				if result.RefundFee, err = result.RefundFee.Add(ri.RefundFee); err != nil {
+					return nil, err
+				}
+			}
+			if ri.RefundStatus == RefundStatusSUCCESS {
+				if result.SuccessRefundFee, err = result.SuccessRefundFee.Add(ri.RefundFee); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// total_refund_count 只在部分情况下返回，没有返回时以是否满页判断是否还有下一页；
+		// 另外若本页没有新的退款单也结束，避免死循环
+		offset += uint(resp.RefundCount)
+		if resp.TotalRefundCount != 0 {
+			if uint64(offset) >= resp.TotalRefundCount {
+				break
+			}
+		} else if resp.RefundCount < refundQueryPageSize {
+			break
+		}
+		if added == 0 {
+			break
+		}
+	}
+
+	return result, nil
+}
+
 // RefundNotify 创建一个处理退款结果通知的 http.Handler; 传入 handler 的参数包括上下文和查询退款接口返回的 Response；handler
-// 处理过后若成功应该返回 nil，若失败则应该返回一个非 nil error 对象，该 error 的 String() 将会返回给外部
+// 处理过后若成功应该返回 nil，若失败则应该返回一个非 nil error 对象，该 error 的 String() 将会返回给外部；
+// 若设置了 NotifyDeduper（见 UseNotifyDeduper），同一事件的重复回调不会再次执行 handler
 func RefundNotify(handler func(context.Context, *RefundQueryResponse) error, selector conf.MchConfigSelector, options *Options) http.Handler {
 
 	return HandleMchXML(func(ctx context.Context, x MchXML) error {
@@ -189,13 +255,18 @@ func RefundNotify(handler func(context.Context, *RefundQueryResponse) error, sel
 		}
 
 		// 这里再次发起查询，原因与 OrderNotify 一样
-		resp, err := refundQuery(ctx, config, &RefundQueryRequest{
+		queryCtx, span := options.Tracer().Start(ctx, "mch.RefundNotify query")
+		setSpanAttributes(span, x1)
+		resp, err := refundQuery(queryCtx, config, &RefundQueryRequest{
 			RefundID: x1["refund_id"],
 		}, options)
+		endSpan(span, err)
 		if err != nil {
 			return err
 		}
-		return handler(ctx, resp)
+		return dedupeNotify(ctx, config, refundNotifyKey(x1["refund_id"], resp), options, func() error {
+			return handler(ctx, resp)
+		})
 
 	}, options)
 
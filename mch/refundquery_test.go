@@ -0,0 +1,126 @@
+package mch
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRefundPagesClient 模拟一笔有多笔退款的订单，按请求中的 offset 分页返回
+type testRefundPagesClient struct {
+	refunds         []RefundInfo
+	withTotalCount  bool
+	offsets         []int
+	duplicateOffset int
+}
+
+func (c *testRefundPagesClient) Do(req *http.Request) (*http.Response, error) {
+	reqXML := MchXML{}
+	if err := xml.NewDecoder(req.Body).Decode(&reqXML); err != nil {
+		return nil, err
+	}
+	offset, _ := strconv.Atoi(reqXML["offset"])
+	c.offsets = append(c.offsets, offset)
+	if offset >= len(c.refunds) {
+		return testBusinessErrorRespond(ErrCodeREFUNDNOTEXIST)()
+	}
+
+	x := MchXML{
+		"result_code":    "SUCCESS",
+		"out_trade_no":   "1217752501201407033233368018",
+		"transaction_id": "1008450740201411110005820873",
+		"total_fee":      "10000",
+		"cash_fee":       "10000",
+	}
+	if c.withTotalCount {
+		x["total_refund_count"] = strconv.Itoa(len(c.refunds))
+	}
+	n := 0
+	for i := offset; i < len(c.refunds) && n < refundQueryPageSize; i++ {
+		ri := c.refunds[i]
+		// 模拟翻页时返回了上一页已返回的退款单
+		if c.duplicateOffset != 0 && offset == c.duplicateOffset && i == offset {
+			ri = c.refunds[i-1]
+		}
+		x[fmt.Sprintf("refund_id_%d", i)] = ri.RefundID
+		x[fmt.Sprintf("out_refund_no_%d", i)] = ri.OutRefundNo
+		x[fmt.Sprintf("refund_fee_%d", i)] = strconv.FormatUint(ri.RefundFee.Amount, 10)
+		x[fmt.Sprintf("refund_status_%d", i)] = ri.RefundStatus.String()
+		n++
+	}
+	x["refund_count"] = strconv.Itoa(n)
+	return testSignedRespond(x)()
+}
+
+func TestRefundQueryAll(t *testing.T) {
+	assert := assert.New(t)
+
+	refunds := []RefundInfo{}
+	for i := 0; i < 23; i++ {
+		status := RefundStatusSUCCESS
+		switch i % 3 {
+		case 1:
+			status = RefundStatusPROCESSING
+		case 2:
+			status = RefundStatusREFUNDCLOSE
+		}
+		refunds = append(refunds, RefundInfo{
+			RefundID:     fmt.Sprintf("5000000%02d", i),
+			OutRefundNo:  fmt.Sprintf("R%02d", i),
+			RefundFee:    CNY(uint64(i + 1)),
+			RefundStatus: status,
+		})
+	}
+
+	var expectRefundFee, expectSuccessRefundFee uint64
+	for _, ri := range refunds {
+		if ri.RefundStatus != RefundStatusREFUNDCLOSE {
+			expectRefundFee += ri.RefundFee.Amount
+		}
+		if ri.RefundStatus == RefundStatusSUCCESS {
+			expectSuccessRefundFee += ri.RefundFee.Amount
+		}
+	}
+
+	for _, testCase := range []struct {
+		Client        *testRefundPagesClient
+		ExpectOffsets []int
+		ExpectCount   int
+	}{
+		{&testRefundPagesClient{refunds: refunds, withTotalCount: true}, []int{0, 10, 20}, 23},
+		{&testRefundPagesClient{refunds: refunds}, []int{0, 10, 20}, 23},
+		{&testRefundPagesClient{refunds: refunds[:20]}, []int{0, 10, 20}, 20},
+		{&testRefundPagesClient{refunds: refunds[:5], withTotalCount: true}, []int{0}, 5},
+	} {
+		resp, err := RefundQueryAll(context.Background(), config, &RefundQueryRequest{
+			OutTradeNo: "1217752501201407033233368018",
+		}, UseClient(testCase.Client))
+		assert.NoError(err)
+		assert.Equal(testCase.ExpectOffsets, testCase.Client.offsets)
+		assert.Len(resp.Refunds, testCase.ExpectCount)
+		assert.Equal(CNY(10000), resp.TotalFee)
+		if testCase.ExpectCount == len(refunds) {
+			assert.Equal(refunds, resp.Refunds)
+			assert.Equal(CNY(expectRefundFee), resp.RefundFee)
+			assert.Equal(CNY(expectSuccessRefundFee), resp.SuccessRefundFee)
+			assert.Equal(CNY(10000-expectRefundFee), resp.RefundableFee())
+		}
+	}
+
+	// 翻页返回重复的退款单时去重
+	client := &testRefundPagesClient{refunds: refunds, withTotalCount: true, duplicateOffset: 10}
+	resp, err := RefundQueryAll(context.Background(), config, &RefundQueryRequest{
+		TransactionID: "1008450740201411110005820873",
+	}, UseClient(client))
+	assert.NoError(err)
+	assert.Len(resp.Refunds, 22)
+
+	// 必须提供订单号
+	_, err = RefundQueryAll(context.Background(), config, &RefundQueryRequest{RefundID: "500000000"})
+	assert.Equal(ErrRefundQueryAllMissingID, err)
+}
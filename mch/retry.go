@@ -0,0 +1,102 @@
+package mch
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+// RetryPolicy 为调用微信支付接口失败时的重试策略：网络错误、http 5xx 以及可重试的业务错误（见 IsRetryable）
+// 会按指数退避（带随机抖动）重试；每次重试使用相同的业务参数（例如 out_trade_no/out_refund_no）以保证幂等，
+// 但会重新生成 nonce_str 和签名
+//
+// NOTE: 使用 DeclareNonIdempotent 声明的接口永远不会重试
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试次数（包括首次调用），<= 1 时不重试
+	MaxAttempts int
+
+	// BaseDelay 首次重试前的等待时间，之后每次翻倍
+	BaseDelay time.Duration
+
+	// MaxDelay 每次等待时间的上限，为 0 时不限制（但翻倍不会超出 time.Duration 的范围）
+	MaxDelay time.Duration
+}
+
+var (
+	nonIdempotentPathsMu sync.RWMutex
+	nonIdempotentPaths   = map[string]bool{}
+)
+
+// DeclareNonIdempotent 声明 path（如 "/pay/micropay"）对应的接口不是幂等的，即重复调用可能产生不同的结果，
+// PostMchXML 不会对这类接口进行重试；一般在接口所在文件的 init 中调用
+func DeclareNonIdempotent(path string) {
+	nonIdempotentPathsMu.Lock()
+	defer nonIdempotentPathsMu.Unlock()
+	nonIdempotentPaths[path] = true
+}
+
+func init() {
+	// 付款码支付、撤销订单以及企业付款/现金红包等付款接口：请求超时后可能已被处理，
+	// 应先查询结果再决定是否重新提交，不能直接重试
+	for _, path := range []string{
+		"/pay/micropay",
+		"/secapi/pay/reverse",
+		"/mmpaymkttransfers/promotion/transfers",
+		"/mmpaysptrans/pay_bank",
+		"/mmpaymkttransfers/sendredpack",
+		"/mmpaymkttransfers/sendgroupredpack",
+	} {
+		DeclareNonIdempotent(path)
+	}
+}
+
+func isIdempotent(path string) bool {
+	nonIdempotentPathsMu.RLock()
+	defer nonIdempotentPathsMu.RUnlock()
+	return !nonIdempotentPaths[path]
+}
+
+// delay 返回第 attempt 次（从 1 开始）尝试失败后的等待时间，在 [d/2, d) 之间随机取值，d 为指数退避时间
+func (policy *RetryPolicy) delay(attempt int) time.Duration {
+	d := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay <= 0 || d < policy.MaxDelay); i++ {
+		// 再翻倍会溢出为负数
+		if d > math.MaxInt64/2 {
+			break
+		}
+		d *= 2
+	}
+	if policy.MaxDelay > 0 && d > policy.MaxDelay {
+		d = policy.MaxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// wait 在第 attempt 次尝试失败后判断是否需要重试，若需要则等待后返回 true；
+// 若次数用尽、等待后会超过 ctx 的 deadline 或 ctx 结束则返回 false
+func (policy *RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	if policy == nil || attempt >= policy.MaxAttempts {
+		return false
+	}
+
+	d := policy.delay(attempt)
+	if deadline, ok := ctx.Deadline(); ok && utils.Now().Add(d).After(deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,195 @@
+package mch
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSeqClient 依次返回预设的响应，并记录每次请求
+type testSeqClient struct {
+	resps []func() (*http.Response, error)
+	reqs  []MchXML
+}
+
+func (c *testSeqClient) Do(req *http.Request) (*http.Response, error) {
+	reqXML := MchXML{}
+	if err := xml.NewDecoder(req.Body).Decode(&reqXML); err != nil {
+		return nil, err
+	}
+	c.reqs = append(c.reqs, reqXML)
+	resp := c.resps[0]
+	if len(c.resps) > 1 {
+		c.resps = c.resps[1:]
+	}
+	return resp()
+}
+
+func testRespond(statusCode int, x MchXML) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		body, _ := xml.Marshal(x)
+		return &http.Response{
+			Status:     http.StatusText(statusCode),
+			StatusCode: statusCode,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+		}, nil
+	}
+}
+
+func testSignedRespond(x MchXML) func() (*http.Response, error) {
+	x["return_code"] = "SUCCESS"
+	x["appid"] = config.WechatAppID()
+	x["mch_id"] = config.WechatMchID()
+	x["sign"] = SignMchXML(x, SignTypeMD5, config.WechatMchKey())
+	return testRespond(200, x)
+}
+
+func testBusinessErrorRespond(errCode string) func() (*http.Response, error) {
+	return testSignedRespond(MchXML{"result_code": "FAIL", "err_code": errCode})
+}
+
+func testNetworkErrorRespond() (*http.Response, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestPostMchXMLRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	retryPolicy := &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    2 * time.Millisecond,
+	}
+	DeclareNonIdempotent("/test/nonidempotent")
+
+	for _, testCase := range []struct {
+		Path           string
+		Resps          []func() (*http.Response, error)
+		ExpectSuccess  bool
+		ExpectAttempts int
+	}{
+		{
+			"/pay/unifiedorder",
+			[]func() (*http.Response, error){testSignedRespond(MchXML{"result_code": "SUCCESS"})},
+			true,
+			1,
+		}, // 直接成功
+		{
+			"/pay/unifiedorder",
+			[]func() (*http.Response, error){
+				testBusinessErrorRespond(ErrCodeSYSTEMERROR),
+				testSignedRespond(MchXML{"result_code": "SUCCESS"}),
+			},
+			true,
+			2,
+		}, // 可重试的业务错误
+		{
+			"/pay/unifiedorder",
+			[]func() (*http.Response, error){
+				testRespond(502, MchXML{}),
+				testNetworkErrorRespond,
+				testSignedRespond(MchXML{"result_code": "SUCCESS"}),
+			},
+			true,
+			3,
+		}, // 5xx 及网络错误
+		{
+			"/pay/unifiedorder",
+			[]func() (*http.Response, error){testBusinessErrorRespond(ErrCodeSYSTEMERROR)},
+			false,
+			3,
+		}, // 次数用尽
+		{
+			"/pay/unifiedorder",
+			[]func() (*http.Response, error){testBusinessErrorRespond(ErrCodeORDERPAID)},
+			false,
+			1,
+		}, // 不可重试的业务错误
+		{
+			"/pay/unifiedorder",
+			[]func() (*http.Response, error){testRespond(400, MchXML{})},
+			false,
+			1,
+		}, // 4xx 不重试
+		{
+			"/test/nonidempotent",
+			[]func() (*http.Response, error){testNetworkErrorRespond},
+			false,
+			1,
+		}, // 非幂等接口不重试
+		{
+			"/pay/micropay",
+			[]func() (*http.Response, error){testNetworkErrorRespond},
+			false,
+			1,
+		}, // 内置声明的非幂等接口
+		{
+			"/secapi/pay/reverse",
+			[]func() (*http.Response, error){testBusinessErrorRespond(ErrCodeSYSTEMERROR)},
+			false,
+			1,
+		},
+	} {
+		client := &testSeqClient{resps: testCase.Resps}
+		_, err := PostMchXML(context.Background(), config, testCase.Path, MchXML{"out_trade_no": "1217752501201407033233368018"}, MustOptions(
+			UseClient(client),
+			UseRetryPolicy(retryPolicy),
+		))
+		if testCase.ExpectSuccess {
+			assert.NoError(err)
+		} else {
+			assert.Error(err)
+		}
+		assert.Len(client.reqs, testCase.ExpectAttempts)
+
+		// 每次尝试使用相同的业务参数，但 nonce_str 各不相同
+		nonceStrs := map[string]bool{}
+		for _, reqXML := range client.reqs {
+			assert.Equal("1217752501201407033233368018", reqXML["out_trade_no"])
+			assert.Equal(SignMchXML(reqXML, SignTypeMD5, config.WechatMchKey()), reqXML["sign"])
+			nonceStrs[reqXML["nonce_str"]] = true
+		}
+		assert.Len(nonceStrs, testCase.ExpectAttempts)
+	}
+}
+
+func TestPostMchXMLRetryDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &testSeqClient{resps: []func() (*http.Response, error){testBusinessErrorRespond(ErrCodeSYSTEMERROR)}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// 等待时间超过 deadline，不再重试
+	_, err := PostMchXML(ctx, config, "/pay/orderquery", MchXML{}, MustOptions(
+		UseClient(client),
+		UseRetryPolicy(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}),
+	))
+	assert.True(IsRetryable(err))
+	assert.Len(client.reqs, 1)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &RetryPolicy{MaxAttempts: 100, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, expect := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second, 99: time.Second} {
+		d := policy.delay(attempt)
+		assert.True(d >= expect/2 && d < expect, "%d: %s", attempt, d)
+	}
+
+	// 不限制 MaxDelay 时翻倍不会溢出为负数
+	policy.MaxDelay = 0
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		d := policy.delay(attempt)
+		assert.True(d >= policy.BaseDelay/2, "%d: %s", attempt, d)
+	}
+}
@@ -0,0 +1,59 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+var (
+	// spanAttributeFields 为作为 span 属性的字段，用于关联业务上的订单/退款
+	spanAttributeFields = []string{
+		"mch_id",
+		"out_trade_no",
+		"transaction_id",
+		"out_refund_no",
+		"refund_id",
+	}
+)
+
+// setSpanAttributes 将 x 中存在的 spanAttributeFields 设置为 span 属性
+func setSpanAttributes(span utils.Span, x MchXML) {
+	for _, fieldName := range spanAttributeFields {
+		if v := x[fieldName]; v != "" {
+			span.SetAttribute(fieldName, v)
+		}
+	}
+}
+
+// endSpan 记录错误（若有）并结束 span
+func endSpan(span utils.Span, err error) {
+	if err != nil {
+		span.SetAttribute("error_class", string(ClassifyError(err)))
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// startCallSpan 开始调用接口的 span
+func startCallSpan(ctx context.Context, ep *Endpoint, reqXML MchXML, options *Options) (context.Context, utils.Span) {
+	ctx, span := options.Tracer().Start(ctx, "mch "+ep.Path)
+	span.SetAttribute("path", ep.Path)
+	setSpanAttributes(span, reqXML)
+	return ctx, span
+}
+
+// endCallSpan 结束调用接口的 span
+func endCallSpan(span utils.Span, attempts int, respXML MchXML, err error) {
+	span.SetAttribute("attempts", strconv.Itoa(attempts))
+	if respXML != nil {
+		setSpanAttributes(span, respXML)
+	}
+	var businessErr *MchBusinessError
+	if err != nil && errors.As(err, &businessErr) {
+		span.SetAttribute("err_code", businessErr.ErrCode)
+	}
+	endSpan(span, err)
+}
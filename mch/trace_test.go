@@ -0,0 +1,152 @@
+package mch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSpanKey struct{}
+
+// testSpan 记录 span 的属性/错误以及父 span
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  map[string]string
+	errs   []error
+	ended  bool
+}
+
+func (span *testSpan) SetAttribute(key, value string) {
+	span.attrs[key] = value
+}
+
+func (span *testSpan) RecordError(err error) {
+	span.errs = append(span.errs, err)
+}
+
+func (span *testSpan) End() {
+	span.ended = true
+}
+
+// testTracer 按开始的顺序记录所有 span
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (tracer *testTracer) Start(ctx context.Context, name string) (context.Context, utils.Span) {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	span := &testSpan{name: name, parent: parent, attrs: map[string]string{}}
+	tracer.spans = append(tracer.spans, span)
+	return context.WithValue(ctx, testSpanKey{}, span), span
+}
+
+func TestTraceCall(t *testing.T) {
+	assert := assert.New(t)
+
+	tracer := &testTracer{}
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testBusinessErrorRespond(ErrCodeSYSTEMERROR),
+		testOrderQueryRespond(TradeStateSUCCESS),
+	}}
+	_, err := OrderQuery(context.Background(), config, &OrderQueryRequest{
+		OutTradeNo: "1217752501201407033233368018",
+	}, UseClient(client), UseTracer(tracer), UseRetryPolicy(&RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+	}))
+	assert.NoError(err)
+
+	// 重试在同一个 span 内，且带有请求/响应中的订单号
+	assert.Len(tracer.spans, 1)
+	span := tracer.spans[0]
+	assert.Equal("mch /pay/orderquery", span.name)
+	assert.True(span.ended)
+	assert.Len(span.errs, 0)
+	assert.Equal(map[string]string{
+		"path":           "/pay/orderquery",
+		"attempts":       "2",
+		"mch_id":         config.WechatMchID(),
+		"out_trade_no":   "1217752501201407033233368018",
+		"transaction_id": "1008450740201411110005820873",
+	}, span.attrs)
+
+	// 失败时记录错误
+	tracer = &testTracer{}
+	_, err = OrderQuery(context.Background(), config, &OrderQueryRequest{
+		OutTradeNo: "1217752501201407033233368018",
+	}, UseClient(&testSeqClient{resps: []func() (*http.Response, error){
+		testBusinessErrorRespond(ErrCodeORDERNOTEXIST),
+	}}), UseTracer(tracer))
+	assert.Error(err)
+	span = tracer.spans[0]
+	assert.True(span.ended)
+	assert.Equal([]error{err}, span.errs)
+	assert.Equal("1", span.attrs["attempts"])
+	assert.Equal(ErrCodeORDERNOTEXIST, span.attrs["err_code"])
+	assert.Equal("business", span.attrs["error_class"])
+}
+
+func TestTraceOrderNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	tracer := &testTracer{}
+	client := &testSeqClient{resps: []func() (*http.Response, error){
+		testOrderQueryRespond(TradeStateSUCCESS),
+	}}
+	var handlerSpan *testSpan
+	handler := OrderNotify(func(ctx context.Context, resp *OrderQueryResponse) error {
+		handlerSpan, _ = ctx.Value(testSpanKey{}).(*testSpan)
+		return nil
+	}, config, MustOptions(UseClient(client), UseTracer(tracer)))
+
+	x := MchXML{
+		"return_code":    "SUCCESS",
+		"result_code":    "SUCCESS",
+		"appid":          config.WechatAppID(),
+		"mch_id":         config.WechatMchID(),
+		"out_trade_no":   "1217752501201407033233368018",
+		"transaction_id": "1008450740201411110005820873",
+	}
+	x["sign"] = SignMchXML(x, SignTypeMD5, config.WechatMchKey())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(string(EncodeMchXML(x)))))
+	assert.Contains(w.Body.String(), "SUCCESS")
+
+	// 回调 -> 再次查询 -> 调用接口
+	assert.Len(tracer.spans, 3)
+	notifySpan, querySpan, callSpan := tracer.spans[0], tracer.spans[1], tracer.spans[2]
+	assert.Equal("mch.notify notify", notifySpan.name)
+	assert.Equal("mch.OrderNotify query", querySpan.name)
+	assert.Equal("mch /pay/orderquery", callSpan.name)
+	assert.Nil(notifySpan.parent)
+	assert.Equal(notifySpan, querySpan.parent)
+	assert.Equal(querySpan, callSpan.parent)
+	assert.Equal(notifySpan, handlerSpan)
+	for _, span := range tracer.spans {
+		assert.True(span.ended, span.name)
+		assert.Len(span.errs, 0, span.name)
+		assert.Equal("1217752501201407033233368018", span.attrs["out_trade_no"], span.name)
+		assert.Equal("1008450740201411110005820873", span.attrs["transaction_id"], span.name)
+	}
+
+	// 非法回调
+	tracer.spans = nil
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader("<xml></notxml>")))
+	assert.Len(tracer.spans, 1)
+	assert.True(tracer.spans[0].ended)
+	assert.Len(tracer.spans[0].errs, 1)
+	assert.Equal("decode", tracer.spans[0].attrs["error_class"])
+}
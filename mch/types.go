@@ -12,6 +12,9 @@ type RefundStatus struct{ v string }
 // SignType 代表签名类型
 type SignType struct{ v string }
 
+// Currency 代表币种，为 ISO 4217 三位字母代码
+type Currency struct{ v string }
+
 // ParseTradeType parse 交易类型字符串
 func ParseTradeType(v string) TradeType {
 	switch v {
@@ -52,6 +55,17 @@ func (ts TradeState) IsValid() bool {
 	return ts.v != ""
 }
 
+// IsTerminal 当交易状态为终态（SUCCESS/CLOSED/PAYERROR/REVOKED/REFUND）时返回 true，
+// 即用户不会再对该订单进行支付
+func (ts TradeState) IsTerminal() bool {
+	switch ts {
+	case TradeStateSUCCESS, TradeStateCLOSED, TradeStatePAYERROR, TradeStateREVOKED, TradeStateREFUND:
+		return true
+	default:
+		return false
+	}
+}
+
 // ParseRefundStatus parse 退款状态
 func ParseRefundStatus(v string) RefundStatus {
 	switch v {
@@ -91,3 +105,26 @@ func (st SignType) String() string {
 func (st SignType) IsValid() bool {
 	return st.v != ""
 }
+
+// ParseCurrency parse 币种，须为三位大写字母（ISO 4217）
+func ParseCurrency(v string) Currency {
+	if len(v) != 3 {
+		return Currency{}
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < 'A' || v[i] > 'Z' {
+			return Currency{}
+		}
+	}
+	return Currency{v}
+}
+
+// String 实现 Stringer 接口
+func (c Currency) String() string {
+	return c.v
+}
+
+// IsValid 当该值有效(非空)时返回 true
+func (c Currency) IsValid() bool {
+	return c.v != ""
+}
@@ -9,14 +9,14 @@ import (
 )
 
 var (
-	ErrUnifiedOrderMissingOutTradeNo = errors.New("Missing out_trade_no in UnifiedOrderRequest")
-	ErrUnifiedOrderMissingTotalFee   = errors.New("Missing total_fee in UnifiedOrderRequest")
-	ErrUnifiedOrderMissingBody       = errors.New("Missing body in UnifiedOrderRequest")
-	ErrUnifiedOrderMissingNotifyUrl  = errors.New("Missing notify_url in UnifiedOrderRequest")
-	ErrUnifiedOrderMissingTradeType  = errors.New("Missing trade_type in UnifiedOrderRequest")
+	ErrUnifiedOrderMissingOutTradeNo = missingRequestField("UnifiedOrderRequest", "out_trade_no")
+	ErrUnifiedOrderMissingTotalFee   = missingRequestField("UnifiedOrderRequest", "total_fee")
+	ErrUnifiedOrderMissingBody       = missingRequestField("UnifiedOrderRequest", "body")
+	ErrUnifiedOrderMissingNotifyUrl  = missingRequestField("UnifiedOrderRequest", "notify_url")
+	ErrUnifiedOrderMissingTradeType  = missingRequestField("UnifiedOrderRequest", "trade_type")
 	ErrUnifiedOrderMissingOpenID     = errors.New("Missing openid in UnifiedOrderRequest since trade_type is JSAPI")
 	ErrUnifiedOrderBadTradeType      = errors.New("Bad trade_type is returned from UnifiedOrderResponse")
-	ErrUnifiedOrderNoPrepayID        = errors.New("No prepay_id is returned from UnifiedOrderResponse")
+	ErrUnifiedOrderNoPrepayID        = missingResponseField("UnifiedOrderResponse", "prepay_id")
 	ErrUnifiedOrderNoCodeUrl         = errors.New("No code_url is returned from UnifiedOrderResponse")
 	ErrUnifiedOrderNoMWebUrl         = errors.New("No mweb_url is returned from UnifiedOrderResponse")
 )
@@ -24,26 +24,25 @@ var (
 // UnifiedOrderRequest 为统一下单接口请求
 type UnifiedOrderRequest struct {
 	// ----- 必填字段 -----
-	OutTradeNo string    // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
-	TotalFee   uint64    // total_fee Int 标价金额 单位为分
-	Body       string    // body String(128) 商品描述 <商场名>-<商品名>
-	NotifyUrl  string    // notify_url String(256) 通知地址
-	TradeType  TradeType // trade_type String(16) 交易类型
+	OutTradeNo string    `mch:"out_trade_no,required"`                // out_trade_no String(32) 商户系统内部订单号 同一个商户号下唯一
+	TotalFee   Money     `mch:"total_fee,required,currency=fee_type"` // total_fee Int 标价金额 单位为分 / fee_type String(16) 标价币种
+	Body       string    `mch:"body,required"`                        // body String(128) 商品描述 <商场名>-<商品名>
+	NotifyUrl  string    `mch:"notify_url,required"`                  // notify_url String(256) 通知地址
+	TradeType  TradeType `mch:"trade_type,required"`                  // trade_type String(16) 交易类型
 
 	// ----- 特定条件必填字段 -----
-	OpenID string // openid String(128) 用户标识 trade_type 为 JSAPI 时必填
+	OpenID string `mch:"openid"` // openid String(128) 用户标识 trade_type 为 JSAPI 时必填
 
 	// ----- 选填字段 -----
-	ProductID      string    // product_id String(32) 商户自定义商品 ID trade_type 为 NATIVE 时必传
-	DeviceInfo     string    // device_info String(32) 设备号
-	SpbillCreateIp string    // spbill_create_ip String(16) 终端IP
-	Detail         string    // detail String(6000) 商品详情
-	Attach         string    // attach String(127) 附加数据
-	FeeType        string    // fee_type String(16) 标价币种
-	TimeStart      time.Time // time_start String(14) 交易起始时间 格式如 20091225091010
-	TimeExpire     time.Time // time_expire String(14) 交易结束时间
-	GoodsTag       string    // goods_tag String(32) 订单优惠标记
-	LimitPay       string    // limit_pay String(32)指定支付方式
+	ProductID      string    `mch:"product_id"`       // product_id String(32) 商户自定义商品 ID trade_type 为 NATIVE 时必传
+	DeviceInfo     string    `mch:"device_info"`      // device_info String(32) 设备号
+	SpbillCreateIp string    `mch:"spbill_create_ip"` // spbill_create_ip String(64) 终端IP 支持IPv4和IPv6
+	Detail         string    `mch:"detail"`           // detail String(6000) 商品详情
+	Attach         string    `mch:"attach"`           // attach String(127) 附加数据
+	TimeStart      time.Time `mch:"time_start"`       // time_start String(14) 交易起始时间 格式如 20091225091010
+	TimeExpire     time.Time `mch:"time_expire"`      // time_expire String(14) 交易结束时间
+	GoodsTag       string    `mch:"goods_tag"`        // goods_tag String(32) 订单优惠标记
+	LimitPay       string    `mch:"limit_pay"`        // limit_pay String(32)指定支付方式
 
 	// TOOD: 单品优惠
 }
@@ -54,15 +53,15 @@ type UnifiedOrderResponse struct {
 	MchXML MchXML
 
 	// ----- 必返回字段 -----
-	TradeType TradeType // trade_type String(16) 交易类型
-	PrepayID  string    // prepay_id String(64) 预支付交易会话标识
+	TradeType TradeType `mch:"trade_type"`         // trade_type String(16) 交易类型
+	PrepayID  string    `mch:"prepay_id,required"` // prepay_id String(64) 预支付交易会话标识
 
 	// ----- 特定条件返回字段 -----
-	CodeUrl string // code_url String(64) 二维码链接 trade_type 为 NATIVE 时有返回
-	MWebUrl string // mweb_url String(64) 支付跳转链接 trade_type 为 MWEB 时有返回 可通过访问该url来拉起微信客户端
+	CodeUrl string `mch:"code_url"` // code_url String(64) 二维码链接 trade_type 为 NATIVE 时有返回
+	MWebUrl string `mch:"mweb_url"` // mweb_url String(64) 支付跳转链接 trade_type 为 MWEB 时有返回 可通过访问该url来拉起微信客户端
 
 	// ----- 其它字段
-	DeviceInfo string // device_info String(32) 设备号
+	DeviceInfo string `mch:"device_info"` // device_info String(32) 设备号
 
 }
 
@@ -73,74 +72,15 @@ func UnifiedOrder(ctx context.Context, config conf.MchConfig, req *UnifiedOrderR
 		return nil, err
 	}
 
-	// req -> reqXML
-	reqXML := MchXML{}
-	if req.OutTradeNo == "" {
-		return nil, ErrUnifiedOrderMissingOutTradeNo
-	} else {
-		reqXML.fillString(req.OutTradeNo, "out_trade_no")
-	}
-
-	if req.TotalFee == 0 {
-		return nil, ErrUnifiedOrderMissingTotalFee
-	} else {
-		reqXML.fillUint64(req.TotalFee, "total_fee")
-	}
-
-	if req.Body == "" {
-		return nil, ErrUnifiedOrderMissingBody
-	} else {
-		reqXML.fillString(req.Body, "body")
-	}
-
-	if req.NotifyUrl == "" {
-		return nil, ErrUnifiedOrderMissingNotifyUrl
-	} else {
-		reqXML.fillString(req.NotifyUrl, "notify_url")
-	}
-
-	if !req.TradeType.IsValid() {
-		return nil, ErrUnifiedOrderMissingTradeType
-	} else {
-		reqXML.fillStringer(req.TradeType, "trade_type")
-	}
-
-	if req.TradeType == TradeTypeJSAPI && req.OpenID == "" {
-		return nil, ErrUnifiedOrderMissingOpenID
-	}
-	if req.OpenID != "" {
-		reqXML.fillString(req.OpenID, "openid")
+	// 检查必填字段以及字段长度和格式，一次列出全部问题
+	if err := req.Validate(); err != nil {
+		return nil, err
 	}
 
-	if req.ProductID != "" {
-		reqXML.fillString(req.ProductID, "product_id")
-	}
-	if req.SpbillCreateIp != "" {
-		reqXML.fillString(req.SpbillCreateIp, "spbill_create_ip")
-	}
-	if req.DeviceInfo != "" {
-		reqXML.fillString(req.DeviceInfo, "device_info")
-	}
-	if req.Detail != "" {
-		reqXML.fillString(req.Detail, "detail")
-	}
-	if req.Attach != "" {
-		reqXML.fillString(req.Attach, "attach")
-	}
-	if req.FeeType != "" {
-		reqXML.fillString(req.FeeType, "fee_type")
-	}
-	if !req.TimeStart.IsZero() {
-		reqXML.fillTimeCompact(req.TimeStart, "time_start")
-	}
-	if !req.TimeExpire.IsZero() {
-		reqXML.fillTimeCompact(req.TimeExpire, "time_expire")
-	}
-	if req.GoodsTag != "" {
-		reqXML.fillString(req.GoodsTag, "goods_tag")
-	}
-	if req.LimitPay != "" {
-		reqXML.fillString(req.LimitPay, "limit_pay")
+	// req -> reqXML
+	reqXML, err := MarshalMchXML(req)
+	if err != nil {
+		return nil, err
 	}
 
 	// reqXML -> respXML
@@ -153,12 +93,7 @@ func UnifiedOrder(ctx context.Context, config conf.MchConfig, req *UnifiedOrderR
 	resp := UnifiedOrderResponse{
 		MchXML: respXML,
 	}
-	respXML.extractTradeType(&resp.TradeType, "trade_type", &err)
-	respXML.extractString(&resp.PrepayID, "prepay_id", &err)
-	respXML.extractString(&resp.CodeUrl, "code_url", &err)
-	respXML.extractString(&resp.MWebUrl, "mweb_url", &err)
-	respXML.extractString(&resp.DeviceInfo, "device_info", &err)
-	if err != nil {
+	if err := UnmarshalMchXML(respXML, &resp); err != nil {
 		return nil, err
 	}
 
@@ -166,9 +101,6 @@ func UnifiedOrder(ctx context.Context, config conf.MchConfig, req *UnifiedOrderR
 	if !resp.TradeType.IsValid() || req.TradeType != resp.TradeType {
 		return nil, ErrUnifiedOrderBadTradeType
 	}
-	if resp.PrepayID == "" {
-		return nil, ErrUnifiedOrderNoPrepayID
-	}
 	if resp.TradeType == TradeTypeNATIVE && resp.CodeUrl == "" {
 		return nil, ErrUnifiedOrderNoCodeUrl
 	}
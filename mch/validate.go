@@ -0,0 +1,216 @@
+package mch
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+// FieldViolation 描述单个字段不满足接口文档约束的原因
+type FieldViolation struct {
+	// Field 字段名，如 out_trade_no
+	Field string
+	// Reason 原因
+	Reason string
+
+	// err 为该问题对应的错误变量（如 ErrUnifiedOrderMissingBody），见 ValidationError.Is
+	err error
+}
+
+// ValidationError 为请求参数检查错误，列出所有不满足接口文档约束（长度、字符集、格式等）的字段，
+// 用于在调用接口前发现问题，避免收到含义不明的远程错误
+type ValidationError struct {
+	// Request 请求类型名，如 UnifiedOrderRequest
+	Request string
+	// Violations 不满足约束的字段
+	Violations []FieldViolation
+}
+
+// Error 满足 error 接口
+func (err *ValidationError) Error() string {
+	reasons := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		reasons = append(reasons, fmt.Sprintf("<%s> %s", violation.Field, violation.Reason))
+	}
+	return fmt.Sprintf("Invalid %s: %s", err.Request, strings.Join(reasons, "; "))
+}
+
+// Is 使得 errors.Is(err, ErrUnifiedOrderMissingBody) 等对应单个问题的错误变量在 err 包含该问题时成立
+func (err *ValidationError) Is(target error) bool {
+	for _, violation := range err.Violations {
+		if violation.err != nil && violation.err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// validator 收集字段检查的结果
+type validator struct {
+	request    string
+	violations []FieldViolation
+}
+
+func (v *validator) violate(fieldName string, format string, args ...interface{}) {
+	v.violations = append(v.violations, FieldViolation{
+		Field:  fieldName,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// missing 记录缺少字段，err 为对应的错误变量
+func (v *validator) missing(fieldName string, err error, format string, args ...interface{}) {
+	v.violations = append(v.violations, FieldViolation{
+		Field:  fieldName,
+		Reason: fmt.Sprintf(format, args...),
+		err:    err,
+	})
+}
+
+// required 检查 req 中带有 required 选项（见 MarshalMchXML）的字段不为零值
+func (v *validator) required(req interface{}) {
+	for _, fieldName := range missingRequiredFields(req) {
+		v.missing(fieldName, missingRequestField(v.request, fieldName), "is required")
+	}
+}
+
+// maxLen 检查字段长度，微信支付以字节数计算长度（如一个汉字在 UTF-8 下为 3 字节）
+func (v *validator) maxLen(fieldName, value string, n int) {
+	if len(value) > n {
+		v.violate(fieldName, "exceeds %d bytes (got %d)", n, len(value))
+	}
+}
+
+// outNo 检查商户单号（out_trade_no/out_refund_no 等）：长度以及只能是数字、大小写字母和 extra 中的字符
+func (v *validator) outNo(fieldName, value string, n int, extra string) {
+	v.maxLen(fieldName, value, n)
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || strings.IndexByte(extra, c) >= 0 {
+			continue
+		}
+		v.violate(fieldName, "contains invalid character %+q, only digits, letters and %+q are allowed", value[i:i+1], extra)
+		return
+	}
+}
+
+// ip 检查 IPv4/IPv6 地址格式
+func (v *validator) ip(fieldName, value string) {
+	if value != "" && net.ParseIP(value) == nil {
+		v.violate(fieldName, "is not a valid IP address")
+	}
+}
+
+// notifyUrl 检查回调地址：http(s) 的绝对地址，并且不能携带参数
+func (v *validator) notifyUrl(fieldName, value string) {
+	if value == "" {
+		return
+	}
+	v.maxLen(fieldName, value, 256)
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.violate(fieldName, "is not a valid http(s) url")
+		return
+	}
+	if u.RawQuery != "" {
+		v.violate(fieldName, "can not carry query parameters")
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &ValidationError{
+		Request:    v.request,
+		Violations: v.violations,
+	}
+}
+
+// Validate 检查请求的必填字段以及字段的长度和格式，若有不满足的字段返回列出全部问题的 *ValidationError；UnifiedOrder 调用接口前会自动检查
+func (req *UnifiedOrderRequest) Validate() error {
+	v := &validator{request: "UnifiedOrderRequest"}
+	v.required(req)
+	if req.TradeType == TradeTypeJSAPI && req.OpenID == "" {
+		v.missing("openid", ErrUnifiedOrderMissingOpenID, "is required since trade_type is JSAPI")
+	}
+	v.outNo("out_trade_no", req.OutTradeNo, 32, "_-|*")
+	v.maxLen("body", req.Body, 128)
+	v.notifyUrl("notify_url", req.NotifyUrl)
+	v.maxLen("openid", req.OpenID, 128)
+	v.maxLen("product_id", req.ProductID, 32)
+	v.maxLen("device_info", req.DeviceInfo, 32)
+	v.maxLen("spbill_create_ip", req.SpbillCreateIp, 64)
+	v.ip("spbill_create_ip", req.SpbillCreateIp)
+	v.maxLen("detail", req.Detail, 6000)
+	v.maxLen("attach", req.Attach, 127)
+	v.maxLen("goods_tag", req.GoodsTag, 32)
+	if req.LimitPay != "" && req.LimitPay != "no_credit" {
+		v.violate("limit_pay", "only %+q is supported", "no_credit")
+	}
+
+	// 订单失效时间须至少在交易起始时间（默认为当前时间）的 1 分钟后
+	if !req.TimeExpire.IsZero() {
+		timeStart := req.TimeStart
+		if timeStart.IsZero() {
+			timeStart = utils.Now()
+		}
+		if req.TimeExpire.Before(timeStart.Add(time.Minute)) {
+			v.violate("time_expire", "should be at least 1 minute after time_start")
+		}
+	}
+	return v.err()
+}
+
+// Validate 检查请求的必填字段以及字段的长度和格式，若有不满足的字段返回列出全部问题的 *ValidationError；OrderQuery 调用接口前会自动检查
+func (req *OrderQueryRequest) Validate() error {
+	v := &validator{request: "OrderQueryRequest"}
+	v.required(req)
+	if req.TransactionID == "" && req.OutTradeNo == "" {
+		v.missing("transaction_id/out_trade_no", ErrOrderQueryMissingID, "one of them is required")
+	}
+	v.maxLen("transaction_id", req.TransactionID, 32)
+	v.outNo("out_trade_no", req.OutTradeNo, 32, "_-|*")
+	return v.err()
+}
+
+// Validate 检查请求的必填字段以及字段的长度和格式，若有不满足的字段返回列出全部问题的 *ValidationError；CloseOrder 调用接口前会自动检查
+func (req *CloseOrderRequest) Validate() error {
+	v := &validator{request: "CloseOrderRequest"}
+	v.required(req)
+	v.outNo("out_trade_no", req.OutTradeNo, 32, "_-|*")
+	return v.err()
+}
+
+// Validate 检查请求的必填字段以及字段的长度和格式，若有不满足的字段返回列出全部问题的 *ValidationError；Refund 调用接口前会自动检查
+func (req *RefundRequest) Validate() error {
+	v := &validator{request: "RefundRequest"}
+	v.required(req)
+	if req.TransactionID == "" && req.OutTradeNo == "" {
+		v.missing("transaction_id/out_trade_no", ErrRefundMissingID, "one of them is required")
+	}
+	v.outNo("out_refund_no", req.OutRefundNo, 64, "_-|*@")
+	v.maxLen("transaction_id", req.TransactionID, 32)
+	v.outNo("out_trade_no", req.OutTradeNo, 32, "_-|*")
+	v.maxLen("refund_desc", req.RefundDesc, 80)
+	v.notifyUrl("notify_url", req.NotifyUrl)
+	return v.err()
+}
+
+// Validate 检查请求的必填字段以及字段的长度和格式，若有不满足的字段返回列出全部问题的 *ValidationError；RefundQuery 调用接口前会自动检查
+func (req *RefundQueryRequest) Validate() error {
+	v := &validator{request: "RefundQueryRequest"}
+	v.required(req)
+	if req.RefundID == "" && req.OutRefundNo == "" && req.TransactionID == "" && req.OutTradeNo == "" {
+		v.missing("refund_id/out_refund_no/transaction_id/out_trade_no", ErrRefundQueryMissingID, "one of them is required")
+	}
+	v.maxLen("refund_id", req.RefundID, 32)
+	v.outNo("out_refund_no", req.OutRefundNo, 64, "_-|*@")
+	v.maxLen("transaction_id", req.TransactionID, 32)
+	v.outNo("out_trade_no", req.OutTradeNo, 32, "_-|*")
+	return v.err()
+}
@@ -0,0 +1,110 @@
+package mch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedOrderRequestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := func() *UnifiedOrderRequest {
+		return &UnifiedOrderRequest{
+			OutTradeNo:     "20150806125346|a-b_c*d",
+			TotalFee:       CNY(1),
+			Body:           "腾讯充值中心-QQ会员充值",
+			NotifyUrl:      "https://example.com/wxpay/notify",
+			TradeType:      TradeTypeNATIVE,
+			SpbillCreateIp: "2001:db8::1",
+			TimeStart:      time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC),
+			TimeExpire:     time.Date(2019, 1, 1, 10, 1, 0, 0, time.UTC),
+		}
+	}
+	assert.NoError(valid().Validate())
+
+	req := valid()
+	req.OutTradeNo = "订单123"
+	req.Body = strings.Repeat("商", 43) // 129 字节
+	req.Attach = strings.Repeat("a", 128)
+	req.NotifyUrl = "https://example.com/notify?id=1"
+	req.SpbillCreateIp = "300.1.1.1"
+	req.TimeExpire = req.TimeStart.Add(59 * time.Second)
+	req.LimitPay = "credit"
+
+	err := req.Validate()
+	var e *ValidationError
+	assert.True(errors.As(err, &e))
+	assert.Equal("UnifiedOrderRequest", e.Request)
+	fields := []string{}
+	for _, violation := range e.Violations {
+		fields = append(fields, violation.Field)
+	}
+	assert.Equal([]string{"out_trade_no", "body", "notify_url", "spbill_create_ip", "attach", "limit_pay", "time_expire"}, fields)
+
+	// 接口调用前检查，不会发出请求
+	client := &testSeqClient{}
+	_, err = UnifiedOrder(context.Background(), config, req, UseClient(client))
+	assert.True(errors.As(err, &e))
+	assert.Len(client.reqs, 0)
+}
+
+func TestRefundRequestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &RefundRequest{
+		OutRefundNo: "R@2019|01*02",
+		OutTradeNo:  "1217752501201407033233368018",
+		TotalFee:    CNY(100),
+		RefundFee:   CNY(100),
+	}
+	assert.NoError(req.Validate())
+
+	req.OutRefundNo = "R 1"
+	req.RefundDesc = strings.Repeat("退", 27)
+	err := req.Validate()
+	var e *ValidationError
+	assert.True(errors.As(err, &e))
+	assert.Len(e.Violations, 2)
+	assert.Equal("out_refund_no", e.Violations[0].Field)
+	assert.Equal("refund_desc", e.Violations[1].Field)
+}
+
+func TestValidateRequiredFields(t *testing.T) {
+	assert := assert.New(t)
+
+	// 缺少多个必填字段，且有格式错误：一次列出全部问题
+	req := &UnifiedOrderRequest{
+		OutTradeNo: "订单123",
+		TradeType:  TradeTypeJSAPI,
+		NotifyUrl:  "ftp://example.com/notify",
+	}
+	client := &testSeqClient{}
+	_, err := UnifiedOrder(context.Background(), config, req, UseClient(client))
+	assert.Len(client.reqs, 0)
+	var e *ValidationError
+	assert.True(errors.As(err, &e))
+	fields := []string{}
+	for _, violation := range e.Violations {
+		fields = append(fields, violation.Field)
+	}
+	assert.Equal([]string{"total_fee", "body", "openid", "out_trade_no", "notify_url"}, fields)
+
+	// 仍可使用错误变量判断
+	assert.True(errors.Is(err, ErrUnifiedOrderMissingTotalFee))
+	assert.True(errors.Is(err, ErrUnifiedOrderMissingBody))
+	assert.True(errors.Is(err, ErrUnifiedOrderMissingOpenID))
+	assert.False(errors.Is(err, ErrUnifiedOrderMissingOutTradeNo))
+
+	// 二选一的字段
+	_, err = Refund(context.Background(), config, &RefundRequest{OutRefundNo: "R 1"}, UseClient(client))
+	assert.True(errors.As(err, &e))
+	assert.Len(e.Violations, 4) // total_fee、refund_fee、transaction_id/out_trade_no、out_refund_no
+	assert.True(errors.Is(err, ErrRefundMissingID))
+	assert.True(errors.Is(err, ErrRefundMissingTotalFee))
+	assert.Len(client.reqs, 0)
+}
@@ -75,7 +75,6 @@ func (x MchXML) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 			x[fieldName] = fieldValue
 		}
 	}
-	return nil
 }
 
 var (
@@ -88,7 +87,9 @@ func (x MchXML) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	if err := e.EncodeToken(xmlStart); err != nil {
 		return err
 	}
-	for fieldName, fieldValue := range x {
+	// 按字段名排序，保证结果确定
+	for _, fieldName := range x.sortedFieldNames() {
+		fieldValue := x[fieldName]
 		if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: fieldName}}); err != nil {
 			return err
 		}
@@ -105,6 +106,19 @@ func (x MchXML) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	return nil
 }
 
+// keepFirst 用于多选一的字段：保留 fieldNames 中第一个非空的字段并删除其余字段，若都为空则返回 false
+func (x MchXML) keepFirst(fieldNames ...string) bool {
+	found := false
+	for _, fieldName := range fieldNames {
+		if found {
+			delete(x, fieldName)
+			continue
+		}
+		found = x[fieldName] != ""
+	}
+	return found
+}
+
 func (x MchXML) extractString(target *string, fieldName string, err *error) {
 	if *err != nil {
 		return
@@ -121,10 +135,6 @@ func (x MchXML) extractTime(target *time.Time, fieldName string, layout string,
 	}
 }
 
-func (x MchXML) extractTimeCompact(target *time.Time, fieldName string, err *error) {
-	x.extractTime(target, fieldName, timeCompactLayout, err)
-}
-
 func (x MchXML) extractUint64(target *uint64, fieldName string, err *error) {
 	if *err != nil {
 		return
@@ -191,6 +201,28 @@ func (x MchXML) extractRefundStatus(target *RefundStatus, fieldName string, err
 	}
 }
 
+// extractMoney 提取金额字段 amountFieldName 以及币种字段 currencyFieldName，币种字段为空时为人民币
+func (x MchXML) extractMoney(target *Money, amountFieldName, currencyFieldName string, err *error) {
+	x.extractUint64(&target.Amount, amountFieldName, err)
+	if *err != nil {
+		return
+	}
+	target.Currency = CurrencyCNY
+	x.extractCurrency(&target.Currency, currencyFieldName, err)
+}
+
+func (x MchXML) extractCurrency(target *Currency, fieldName string, err *error) {
+	if *err != nil {
+		return
+	}
+	if fieldValue := x[fieldName]; fieldValue != "" {
+		*target = ParseCurrency(fieldValue)
+		if !target.IsValid() {
+			*err = fmt.Errorf("Unsupported currency %+q", fieldValue)
+		}
+	}
+}
+
 func (x MchXML) fillString(src string, fieldName string) {
 	x[fieldName] = src
 }
@@ -199,10 +231,6 @@ func (x MchXML) fillTime(src time.Time, fieldName string, layout string) {
 	x[fieldName] = src.In(cstTimeZone).Format(layout)
 }
 
-func (x MchXML) fillTimeCompact(src time.Time, fieldName string) {
-	x.fillTime(src, fieldName, timeCompactLayout)
-}
-
 func (x MchXML) fillUint64(src uint64, fieldName string) {
 	x[fieldName] = strconv.FormatUint(src, 10)
 }
@@ -214,3 +242,11 @@ func (x MchXML) fillInt64(src int64, fieldName string) {
 func (x MchXML) fillStringer(stringer fmt.Stringer, fieldName string) {
 	x[fieldName] = stringer.String()
 }
+
+// fillMoney 填充金额字段 amountFieldName，币种非空时同时填充币种字段 currencyFieldName
+func (x MchXML) fillMoney(src Money, amountFieldName, currencyFieldName string) {
+	x.fillUint64(src.Amount, amountFieldName)
+	if src.Currency.IsValid() {
+		x.fillStringer(src.Currency, currencyFieldName)
+	}
+}
@@ -1,12 +1,30 @@
 package mch
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+var (
+	// 以下两种解码方式的结果应当一致
+	testMchXMLDecoders = map[string]func([]byte) (MchXML, error){
+		"encoding/xml": func(data []byte) (MchXML, error) {
+			x := MchXML(make(map[string]string))
+			err := xml.Unmarshal(data, &x)
+			return x, err
+		},
+		"DecodeMchXML": DecodeMchXML,
+	}
+)
+
 func TestMchXMLUnmarshalXML(t *testing.T) {
 	assert := assert.New(t)
 	for _, testCase := range []struct {
@@ -22,18 +40,224 @@ func TestMchXMLUnmarshalXML(t *testing.T) {
 		{"<xml><a>b<c/>d</a></xml>", true, map[string]string{"a": "bd"}},        // 正确（其实也可以错误），深于一层的元素忽略掉
 	} {
 
-		x := MchXML(make(map[string]string))
-		err := xml.Unmarshal([]byte(testCase.Src), &x)
+		for decoderName, decode := range testMchXMLDecoders {
+			x, err := decode([]byte(testCase.Src))
+
+			if testCase.ExpectOK {
+				assert.NoError(err, decoderName)
+			} else {
+				assert.Error(err, decoderName)
+			}
 
-		if testCase.ExpectOK {
-			assert.NoError(err)
-		} else {
-			assert.Error(err)
+			if testCase.ExpectResult != nil {
+				assert.Equal(testCase.ExpectResult, map[string]string(x), decoderName)
+			}
 		}
 
-		if testCase.ExpectResult != nil {
-			assert.Equal(testCase.ExpectResult, map[string]string(x))
+	}
+}
+
+func TestDecodeMchXML(t *testing.T) {
+	assert := assert.New(t)
+	for _, testCase := range []struct {
+		Src  string
+		Fast bool // 是否走快速路径
+	}{
+		{"", false},
+		{"<xml>", false},
+		{"<xml/>", true},
+		{"<xml><a>1</a></XML>", false},
+		{"<xml><a>b</a><b><![CDATA[<&>]]></b></xml>", true},
+		{"<xml>\n\t<a><![CDATA[x]]>y<![CDATA[]]>z</a>\n</xml>", true},
+		{"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<xml><a>中文</a></xml>", true},
+		{"<xml><!-- c --><a>1<!-- d -->2</a></xml>", true},
+		{"<xml><a>&lt;&gt;&amp;&apos;&quot;</a></xml>", true},
+		{"<xml><a>&#65;&#x42;</a></xml>", false},
+		{"<xml><a>&foo;</a></xml>", false},
+		{"<xml><a>]]></a></xml>", false},
+		{"<xml><a>b\r\nc</a></xml>", false},
+		{"<xml><a x=\"1\">b</a></xml>", false},
+		{"<xml><p:a>1</p:a></xml>", false},
+		{"<xml><a>b<c>x</c>d</a></xml>", false},
+		{"<xml> zz <a>b</a>tail</xml>trailing", false},
+		{"<xml><a>b</a></xml>trailing", true},
+		{"<root><a>1</a></root>", true},
+		{"<xml><a>\x00</a></xml>", false},
+		{"<xml><a>\xff</a></xml>", false},
+	} {
+		_, fast := decodeFlatMchXML(testCase.Src)
+		assert.Equal(testCase.Fast, fast, testCase.Src)
+
+		expect, expectErr := testMchXMLDecoders["encoding/xml"]([]byte(testCase.Src))
+		x, err := DecodeMchXML([]byte(testCase.Src))
+		if expectErr != nil {
+			assert.Error(err, testCase.Src)
+			assert.Equal(expectErr.Error(), err.Error(), testCase.Src)
+			continue
 		}
+		assert.NoError(err, testCase.Src)
+		assert.Equal(expect, x, testCase.Src)
+	}
+}
+
+func TestEncodeMchXML(t *testing.T) {
+	assert := assert.New(t)
+
+	x := MchXML{
+		"total_fee": "1",
+		"appid":     "wxd930ea5d5a258f4f",
+		"body":      "<腾讯充值中心>&QQ会员充值",
+		"empty":     "",
+		"attach":    "a]]>b",
+		"cr":        "a\r\nb",
+		"bad":       "a\x00b",
+	}
+	data := EncodeMchXML(x)
+	assert.Equal(
+		"<xml>"+
+			"<appid><![CDATA[wxd930ea5d5a258f4f]]></appid>"+
+			"<attach><![CDATA[a]]]]><![CDATA[>b]]></attach>"+
+			"<bad>a�b</bad>"+
+			"<body><![CDATA[<腾讯充值中心>&QQ会员充值]]></body>"+
+			"<cr>a&#xD;&#xA;b</cr>"+
+			"<empty></empty>"+
+			"<total_fee>1</total_fee>"+
+			"</xml>",
+		string(data),
+	)
+
+	// 结果确定
+	for i := 0; i < 10; i++ {
+		assert.Equal(data, EncodeMchXML(x))
+	}
+
+	// 可以解码回来（不合法的字符除外）
+	delete(x, "bad")
+	for decoderName, decode := range testMchXMLDecoders {
+		x1, err := decode(EncodeMchXML(x))
+		assert.NoError(err, decoderName)
+		assert.Equal(x, x1, decoderName)
+	}
 
+	// MarshalXML 同样按字段名排序
+	data, err := xml.Marshal(MchXML{"b": "2", "a": "1", "c": "3"})
+	assert.NoError(err)
+	assert.Equal("<xml><a>1</a><b>2</b><c>3</c></xml>", string(data))
+}
+
+var (
+	testBenchReqXML = MchXML{
+		"appid":            "wxd930ea5d5a258f4f",
+		"mch_id":           "10000100",
+		"nonce_str":        "ibuaiVcKdpRxkhJA",
+		"sign_type":        "MD5",
+		"body":             "腾讯充值中心-QQ会员充值",
+		"out_trade_no":     "20150806125346",
+		"total_fee":        "888",
+		"spbill_create_ip": "123.12.12.123",
+		"notify_url":       "https://example.com/wxpay/notify",
+		"trade_type":       "JSAPI",
+		"openid":           "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		"sign":             "0CB01533B8C1EF103065174F50BCA001",
+	}
+
+	testBenchRespData = []byte(`<xml>
+   <return_code><![CDATA[SUCCESS]]></return_code>
+   <return_msg><![CDATA[OK]]></return_msg>
+   <appid><![CDATA[wxd930ea5d5a258f4f]]></appid>
+   <mch_id><![CDATA[10000100]]></mch_id>
+   <nonce_str><![CDATA[IITRi8Iabbblz1Jc]]></nonce_str>
+   <openid><![CDATA[oUpF8uMuAJO_M2pxb1Q9zNjWeS6o]]></openid>
+   <sign><![CDATA[7921E432F65EB8ED0CE9755F0E86D72F]]></sign>
+   <result_code><![CDATA[SUCCESS]]></result_code>
+   <prepay_id><![CDATA[wx201411101639507cbf6ffd8b0779950874]]></prepay_id>
+   <trade_type><![CDATA[JSAPI]]></trade_type>
+</xml>`)
+)
+
+func BenchmarkMchXMLEncode(b *testing.B) {
+	b.Run("encoding/xml", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			xml.Marshal(testBenchReqXML)
+		}
+	})
+	b.Run("EncodeMchXML", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			EncodeMchXML(testBenchReqXML)
+		}
+	})
+}
+
+func BenchmarkMchXMLDecode(b *testing.B) {
+	b.Run("encoding/xml", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			x := MchXML{}
+			xml.NewDecoder(bytes.NewReader(testBenchRespData)).Decode(&x)
+		}
+	})
+	b.Run("ReadMchXML", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ReadMchXML(bytes.NewReader(testBenchRespData))
+		}
+	})
+}
+
+// benchClient 总是返回同一个成功的响应
+type benchClient struct {
+	data []byte
+}
+
+func (c *benchClient) Do(req *http.Request) (*http.Response, error) {
+	ioutil.ReadAll(req.Body)
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(c.data)),
+	}, nil
+}
+
+func BenchmarkPostMchXML(b *testing.B) {
+	x := MchXML{
+		"return_code": "SUCCESS",
+		"result_code": "SUCCESS",
+		"appid":       config.WechatAppID(),
+		"mch_id":      config.WechatMchID(),
+		"prepay_id":   "wx201411101639507cbf6ffd8b0779950874",
+		"trade_type":  "JSAPI",
+	}
+	x["sign"] = SignMchXML(x, SignTypeMD5, config.WechatMchKey())
+	options := MustOptions(UseClient(&benchClient{data: EncodeMchXML(x)}))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reqXML := MchXML{
+			"body":         "腾讯充值中心-QQ会员充值",
+			"out_trade_no": "20150806125346",
+			"total_fee":    "888",
+		}
+		if _, err := PostMchXML(context.Background(), config, "/pay/unifiedorder", reqXML, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHandleMchXML(b *testing.B) {
+	handler := HandleMchXML(func(context.Context, MchXML) error {
+		return nil
+	}, nil)
+	data := string(testBenchRespData)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(data)))
+		if w.Code != http.StatusOK {
+			b.Fatal(w.Code)
+		}
 	}
 }
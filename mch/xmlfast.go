@@ -0,0 +1,387 @@
+package mch
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	mchXMLBufferPool = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+)
+
+func getMchXMLBuffer() *bytes.Buffer {
+	buf := mchXMLBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putMchXMLBuffer(buf *bytes.Buffer) {
+	// 过大的 buffer 不放回池中，避免长期占用内存
+	if buf.Cap() > 64*1024 {
+		return
+	}
+	mchXMLBufferPool.Put(buf)
+}
+
+// sortedFieldNames 返回排序后的字段名
+func (x MchXML) sortedFieldNames() []string {
+	fieldNames := make([]string, 0, len(x))
+	for fieldName := range x {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+	return fieldNames
+}
+
+// EncodeMchXML 将 MchXML 编码为 xml，与 xml.Marshal 相比：
+//
+//   - 字段按字段名排序，相同的 MchXML 总是得到相同的结果，便于日志对比和测试
+//   - 与微信支付文档中的示例一致，非纯数字的字段值使用 CDATA
+//   - 使用池化的 buffer 编码，不经过 encoding/xml
+//
+// 例如：
+//
+//   <xml><appid><![CDATA[wxd930ea5d5a258f4f]]></appid><total_fee>1</total_fee></xml>
+//
+// NOTE: 最终用户一般不需要使用该函数
+func EncodeMchXML(x MchXML) []byte {
+	buf := getMchXMLBuffer()
+	defer putMchXMLBuffer(buf)
+	x.encodeTo(buf)
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// WriteMchXML 将 MchXML 按 EncodeMchXML 的格式写入 w
+//
+// NOTE: 最终用户一般不需要使用该函数
+func WriteMchXML(w io.Writer, x MchXML) error {
+	buf := getMchXMLBuffer()
+	defer putMchXMLBuffer(buf)
+	x.encodeTo(buf)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (x MchXML) encodeTo(buf *bytes.Buffer) {
+	buf.WriteString("<xml>")
+	for _, fieldName := range x.sortedFieldNames() {
+		fieldValue := x[fieldName]
+		buf.WriteByte('<')
+		buf.WriteString(fieldName)
+		buf.WriteByte('>')
+		switch {
+		case isDigits(fieldValue):
+			buf.WriteString(fieldValue)
+		case isXMLChars(fieldValue):
+			writeCDATA(buf, fieldValue)
+		default:
+			// 包含 '\r' 或 xml 不允许的字符，CDATA 无法原样表示，使用转义
+			xml.EscapeText(buf, []byte(fieldValue))
+		}
+		buf.WriteString("</")
+		buf.WriteString(fieldName)
+		buf.WriteByte('>')
+	}
+	buf.WriteString("</xml>")
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// writeCDATA 将 s 写为 CDATA，其中的 "]]>" 拆分到两个 CDATA 中
+func writeCDATA(buf *bytes.Buffer, s string) {
+	buf.WriteString("<![CDATA[")
+	for {
+		i := strings.Index(s, "]]>")
+		if i < 0 {
+			break
+		}
+		buf.WriteString(s[:i+2])
+		buf.WriteString("]]><![CDATA[")
+		s = s[i+2:]
+	}
+	buf.WriteString(s)
+	buf.WriteString("]]>")
+}
+
+// isXMLChars 当 s 为合法的 utf8 且所有字符都为 xml 允许的字符时返回 true；
+// '\r' 在解析时会被转换为 '\n'，这里也视为不允许
+func isXMLChars(s string) bool {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c < 0x20 && c != '\t' && c != '\n' {
+				return false
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || !isXMLChar(r) {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// isXMLChar 见 https://www.w3.org/TR/REC-xml/#charsets
+func isXMLChar(r rune) bool {
+	return r == 0x09 ||
+		r == 0x0A ||
+		r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// ReadMchXML 从 r 中读取并解码 MchXML，见 DecodeMchXML
+//
+// NOTE: 最终用户一般不需要使用该函数
+func ReadMchXML(r io.Reader) (MchXML, error) {
+	buf := getMchXMLBuffer()
+	defer putMchXMLBuffer(buf)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return DecodeMchXML(buf.Bytes())
+}
+
+// DecodeMchXML 解码 MchXML，结果与 xml.Unmarshal 到 MchXML 一致（字段名重复时返回错误，深于一层的元素忽略等）。
+//
+// 微信支付的 xml 一般只有一层，只包含文本、CDATA 和注释，对于这种情况使用快速路径解码：整个 data 只复制一次，
+// 字段值尽量直接引用其子串；其它少见的情况（属性、嵌套元素、字符引用、DOCTYPE 等）以及 xml 错误，
+// 则回退到 encoding/xml 解码，以保证结果和错误都与之前一致
+//
+// NOTE: 最终用户一般不需要使用该函数
+func DecodeMchXML(data []byte) (MchXML, error) {
+	if x, ok := decodeFlatMchXML(string(data)); ok {
+		return x, nil
+	}
+	x := MchXML{}
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// decodeFlatMchXML 为快速路径，ok 为 false 时表示需要回退到 encoding/xml
+func decodeFlatMchXML(s string) (x MchXML, ok bool) {
+	if !isXMLChars(s) {
+		return nil, false
+	}
+
+	// 跳过 xml 声明以及前后的空白
+	s = trimXMLSpace(s)
+	if strings.HasPrefix(s, "<?xml") {
+		i := strings.Index(s, "?>")
+		if i < 0 {
+			return nil, false
+		}
+		s = trimXMLSpace(s[i+2:])
+	}
+
+	// 根元素
+	rootName, selfClosing, s, ok := readXMLStartTag(s)
+	if !ok {
+		return nil, false
+	}
+	x = MchXML{}
+	if selfClosing {
+		return x, true
+	}
+
+	for {
+		s = trimXMLSpace(s)
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			if s, ok = skipXMLComment(s); !ok {
+				return nil, false
+			}
+
+		case strings.HasPrefix(s, "</"):
+			// 根元素结束，之后的内容忽略（与 xml.Unmarshal 一致）
+			_, ok = readXMLEndTag(s, rootName)
+			return x, ok
+
+		case strings.HasPrefix(s, "<"):
+			var fieldName, fieldValue string
+			if fieldName, selfClosing, s, ok = readXMLStartTag(s); !ok {
+				return nil, false
+			}
+			// 字段名重复，由 encoding/xml 返回错误
+			if _, dup := x[fieldName]; dup {
+				return nil, false
+			}
+			if !selfClosing {
+				if fieldValue, s, ok = readXMLText(s); !ok {
+					return nil, false
+				}
+				if s, ok = readXMLEndTag(s, fieldName); !ok {
+					return nil, false
+				}
+			}
+			x[fieldName] = fieldValue
+
+		default:
+			// 根元素中的非空白文本，或 xml 不完整
+			return nil, false
+		}
+	}
+}
+
+func trimXMLSpace(s string) string {
+	return strings.TrimLeft(s, " \t\n")
+}
+
+// readXMLStartTag 读取形如 <name> 或 <name/> 的开始标签，不支持属性
+func readXMLStartTag(s string) (name string, selfClosing bool, rest string, ok bool) {
+	if len(s) < 3 || s[0] != '<' {
+		return "", false, s, false
+	}
+	i := 1
+	for i < len(s) && isXMLNameByte(s[i], i == 1) {
+		i++
+	}
+	if i == 1 || i >= len(s) {
+		return "", false, s, false
+	}
+	switch {
+	case s[i] == '>':
+		return s[1:i], false, s[i+1:], true
+	case s[i] == '/' && i+1 < len(s) && s[i+1] == '>':
+		return s[1:i], true, s[i+2:], true
+	default:
+		return "", false, s, false
+	}
+}
+
+// readXMLEndTag 读取 </name>
+func readXMLEndTag(s string, name string) (rest string, ok bool) {
+	n := len(name) + 3
+	if len(s) < n || s[:2] != "</" || s[2:n-1] != name || s[n-1] != '>' {
+		return s, false
+	}
+	return s[n:], true
+}
+
+func skipXMLComment(s string) (rest string, ok bool) {
+	i := strings.Index(s[4:], "--")
+	if i < 0 || !strings.HasPrefix(s[4+i:], "-->") {
+		return s, false
+	}
+	return s[4+i+3:], true
+}
+
+// readXMLText 读取元素中的文本直到下一个结束标签，文本可由普通文本、CDATA、注释组成；
+// 只有一段时直接返回子串，不复制
+func readXMLText(s string) (text string, rest string, ok bool) {
+	var sb *strings.Builder
+	for {
+		var seg string
+		switch {
+		case strings.HasPrefix(s, "</"):
+			if sb == nil {
+				return text, s, true
+			}
+			sb.WriteString(text)
+			return sb.String(), s, true
+
+		case strings.HasPrefix(s, "<![CDATA["):
+			i := strings.Index(s[9:], "]]>")
+			if i < 0 {
+				return "", s, false
+			}
+			seg, s = s[9:9+i], s[9+i+3:]
+
+		case strings.HasPrefix(s, "<!--"):
+			if s, ok = skipXMLComment(s); !ok {
+				return "", s, false
+			}
+			continue
+
+		case strings.HasPrefix(s, "<"):
+			// 嵌套元素等
+			return "", s, false
+
+		default:
+			i := strings.IndexByte(s, '<')
+			if i < 0 {
+				return "", s, false
+			}
+			seg, s = s[:i], s[i:]
+			if strings.Contains(seg, "]]>") {
+				return "", s, false
+			}
+			if strings.IndexByte(seg, '&') >= 0 {
+				if seg, ok = unescapeXMLText(seg); !ok {
+					return "", s, false
+				}
+			}
+		}
+
+		if text == "" && sb == nil {
+			text = seg
+			continue
+		}
+		if sb == nil {
+			sb = &strings.Builder{}
+		}
+		sb.WriteString(text)
+		text = seg
+	}
+}
+
+var xmlEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": "\"",
+}
+
+// unescapeXMLText 处理预定义的实体引用，其它实体（如字符引用）返回 false 以回退到 encoding/xml
+func unescapeXMLText(s string) (string, bool) {
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String(), true
+		}
+		sb.WriteString(s[:i])
+		j := strings.IndexByte(s[i:], ';')
+		if j < 0 {
+			return "", false
+		}
+		entity, ok := xmlEntities[s[i+1:i+j]]
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(entity)
+		s = s[i+j+1:]
+	}
+}
+
+// isXMLNameByte 只接受常见的 ascii 名字字符，不接受名字空间（':'）
+func isXMLNameByte(c byte, first bool) bool {
+	if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' {
+		return true
+	}
+	return !first && ('0' <= c && c <= '9' || c == '-' || c == '.')
+}
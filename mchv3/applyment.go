@@ -0,0 +1,394 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ApplymentState 为特约商户进件的申请单状态
+type ApplymentState string
+
+const (
+	ApplymentStateEDITTING      ApplymentState = "APPLYMENT_STATE_EDITTING"        // 编辑中：提交申请发生错误导致，请使用相同的业务申请编号重新提交
+	ApplymentStateAUDITING      ApplymentState = "APPLYMENT_STATE_AUDITING"        // 审核中
+	ApplymentStateREJECTED      ApplymentState = "APPLYMENT_STATE_REJECTED"        // 已驳回：见 AuditDetail，需修改后使用新的业务申请编号重新提交
+	ApplymentStateTOBECONFIRMED ApplymentState = "APPLYMENT_STATE_TO_BE_CONFIRMED" // 待账户验证：超级管理员需扫描 SignURL 完成账户验证
+	ApplymentStateTOBESIGNED    ApplymentState = "APPLYMENT_STATE_TO_BE_SIGNED"    // 待签约：超级管理员需扫描 SignURL 完成签约
+	ApplymentStateSIGNING       ApplymentState = "APPLYMENT_STATE_SIGNING"         // 开通权限中
+	ApplymentStateFINISHED      ApplymentState = "APPLYMENT_STATE_FINISHED"        // 已完成：SubMchID 为特约商户号
+	ApplymentStateCANCELED      ApplymentState = "APPLYMENT_STATE_CANCELED"        // 已作废
+)
+
+// IsTerminal 返回申请单是否已进入终态（已驳回、已完成、已作废）
+func (state ApplymentState) IsTerminal() bool {
+	switch state {
+	case ApplymentStateREJECTED, ApplymentStateFINISHED, ApplymentStateCANCELED:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsProcessing 返回申请单是否正由微信支付处理中（审核中、开通权限中），即无需服务商或商户操作
+func (state ApplymentState) IsProcessing() bool {
+	return state == ApplymentStateAUDITING || state == ApplymentStateSIGNING
+}
+
+var (
+	ErrApplymentMissingBusinessCode = errors.New("Missing business_code in ApplymentRequest")
+	ErrApplymentMissingID           = errors.New("Missing business_code/applyment_id in applyment query")
+)
+
+var (
+	// DefaultPollApplymentIntervals 为轮询申请单状态的默认查询间隔，用完后重复最后一个
+	DefaultPollApplymentIntervals = []time.Duration{
+		time.Minute,
+		5 * time.Minute,
+		10 * time.Minute,
+	}
+)
+
+// ApplymentRequest 为特约商户进件（提交申请单）请求；
+// 其中的图片为 UploadImage 返回的 media_id，标记为 `wx:"sensitive"` 的字段由 Client.Do 自动加密
+type ApplymentRequest struct {
+	// BusinessCode 业务申请编号，服务商自定义的唯一编号，必填
+	BusinessCode string `json:"business_code"`
+	// ContactInfo 超级管理员信息，必填
+	ContactInfo *ApplymentContactInfo `json:"contact_info"`
+	// SubjectInfo 主体资料，必填
+	SubjectInfo *ApplymentSubjectInfo `json:"subject_info"`
+	// BusinessInfo 经营资料，必填
+	BusinessInfo *ApplymentBusinessInfo `json:"business_info"`
+	// SettlementInfo 结算规则，必填
+	SettlementInfo *ApplymentSettlementInfo `json:"settlement_info"`
+	// BankAccountInfo 结算银行账户，必填
+	BankAccountInfo *ApplymentBankAccountInfo `json:"bank_account_info"`
+	// AdditionInfo 补充材料
+	AdditionInfo *ApplymentAdditionInfo `json:"addition_info,omitempty"`
+}
+
+// ApplymentContactInfo 超级管理员信息
+type ApplymentContactInfo struct {
+	ContactType                 string `json:"contact_type"`                               // 超级管理员类型：LEGAL 经营者/法人，SUPER 经办人
+	ContactName                 string `json:"contact_name" wx:"sensitive"`                // 超级管理员姓名
+	ContactIDDocType            string `json:"contact_id_doc_type,omitempty"`              // 超级管理员证件类型（经办人时必填），如 IDENTIFICATION_TYPE_IDCARD
+	ContactIDNumber             string `json:"contact_id_number,omitempty" wx:"sensitive"` // 超级管理员身份证件号码
+	ContactIDDocCopy            string `json:"contact_id_doc_copy,omitempty"`              // 超级管理员证件正面照片
+	ContactIDDocCopyBack        string `json:"contact_id_doc_copy_back,omitempty"`         // 超级管理员证件反面照片
+	ContactPeriodBegin          string `json:"contact_period_begin,omitempty"`             // 证件有效期开始时间，如 2019-06-06
+	ContactPeriodEnd            string `json:"contact_period_end,omitempty"`               // 证件有效期结束时间，如 2026-06-06 或 长期
+	BusinessAuthorizationLetter string `json:"business_authorization_letter,omitempty"`    // 业务办理授权函（经办人时必填）
+	OpenID                      string `json:"openid,omitempty" wx:"sensitive"`            // 超级管理员微信 openid
+	MobilePhone                 string `json:"mobile_phone" wx:"sensitive"`                // 联系手机
+	ContactEmail                string `json:"contact_email" wx:"sensitive"`               // 联系邮箱
+}
+
+// ApplymentSubjectInfo 主体资料
+type ApplymentSubjectInfo struct {
+	SubjectType         string                        `json:"subject_type"`                    // 主体类型，如 SUBJECT_TYPE_INDIVIDUAL 个体户，SUBJECT_TYPE_ENTERPRISE 企业
+	FinanceInstitution  bool                          `json:"finance_institution,omitempty"`   // 是否是金融机构
+	BusinessLicenseInfo *ApplymentBusinessLicenseInfo `json:"business_license_info,omitempty"` // 营业执照（个体户/企业必填）
+	CertificateInfo     *ApplymentCertificateInfo     `json:"certificate_info,omitempty"`      // 登记证书（党政、机关及事业单位/其他组织必填）
+	IdentityInfo        *ApplymentIdentityInfo        `json:"identity_info"`                   // 经营者/法人身份证件
+	UBOInfoList         []*ApplymentUBOInfo           `json:"ubo_info_list,omitempty"`         // 最终受益人信息列表（企业必填）
+}
+
+// ApplymentBusinessLicenseInfo 营业执照
+type ApplymentBusinessLicenseInfo struct {
+	LicenseCopy    string `json:"license_copy"`              // 营业执照照片
+	LicenseNumber  string `json:"license_number"`            // 注册号/统一社会信用代码
+	MerchantName   string `json:"merchant_name"`             // 商户名称
+	LegalPerson    string `json:"legal_person"`              // 个体户经营者/法人姓名
+	LicenseAddress string `json:"license_address,omitempty"` // 注册地址
+	PeriodBegin    string `json:"period_begin,omitempty"`    // 有效期限开始日期
+	PeriodEnd      string `json:"period_end,omitempty"`      // 有效期限结束日期
+}
+
+// ApplymentCertificateInfo 登记证书
+type ApplymentCertificateInfo struct {
+	CertCopy       string `json:"cert_copy"`       // 登记证书照片
+	CertType       string `json:"cert_type"`       // 登记证书类型
+	CertNumber     string `json:"cert_number"`     // 证书号
+	MerchantName   string `json:"merchant_name"`   // 商户名称
+	CompanyAddress string `json:"company_address"` // 注册地址
+	LegalPerson    string `json:"legal_person"`    // 法定代表人
+	PeriodBegin    string `json:"period_begin"`    // 有效期限开始日期
+	PeriodEnd      string `json:"period_end"`      // 有效期限结束日期
+}
+
+// ApplymentIdentityInfo 经营者/法人身份证件
+type ApplymentIdentityInfo struct {
+	IDHolderType        string               `json:"id_holder_type,omitempty"`        // 证件持有人类型：LEGAL 法人，SUPER 经办人（仅党政、机关及事业单位/其他组织可选）
+	IDDocType           string               `json:"id_doc_type"`                     // 证件类型，如 IDENTIFICATION_TYPE_IDCARD
+	AuthorizeLetterCopy string               `json:"authorize_letter_copy,omitempty"` // 法定代表人说明函（经办人时必填）
+	IDCardInfo          *ApplymentIDCardInfo `json:"id_card_info,omitempty"`          // 身份证信息（证件类型为身份证时必填）
+	IDDocInfo           *ApplymentIDDocInfo  `json:"id_doc_info,omitempty"`           // 其他类型证件信息
+	Owner               bool                 `json:"owner,omitempty"`                 // 经营者/法人是否为受益人（企业必填）
+}
+
+// ApplymentIDCardInfo 身份证信息
+type ApplymentIDCardInfo struct {
+	IDCardCopy      string `json:"id_card_copy"`                             // 身份证人像面照片
+	IDCardNational  string `json:"id_card_national"`                         // 身份证国徽面照片
+	IDCardName      string `json:"id_card_name" wx:"sensitive"`              // 身份证姓名
+	IDCardNumber    string `json:"id_card_number" wx:"sensitive"`            // 身份证号码
+	IDCardAddress   string `json:"id_card_address,omitempty" wx:"sensitive"` // 身份证居住地址（企业必填）
+	CardPeriodBegin string `json:"card_period_begin"`                        // 身份证有效期开始时间
+	CardPeriodEnd   string `json:"card_period_end"`                          // 身份证有效期结束时间
+}
+
+// ApplymentIDDocInfo 其他类型证件信息
+type ApplymentIDDocInfo struct {
+	IDDocCopy      string `json:"id_doc_copy"`                             // 证件正面照片
+	IDDocCopyBack  string `json:"id_doc_copy_back,omitempty"`              // 证件反面照片
+	IDDocName      string `json:"id_doc_name" wx:"sensitive"`              // 证件姓名
+	IDDocNumber    string `json:"id_doc_number" wx:"sensitive"`            // 证件号码
+	IDDocAddress   string `json:"id_doc_address,omitempty" wx:"sensitive"` // 证件居住地址（企业必填）
+	DocPeriodBegin string `json:"doc_period_begin"`                        // 证件有效期开始时间
+	DocPeriodEnd   string `json:"doc_period_end"`                          // 证件有效期结束时间
+}
+
+// ApplymentUBOInfo 最终受益人信息
+type ApplymentUBOInfo struct {
+	UBOIDDocType     string `json:"ubo_id_doc_type"`                   // 证件类型
+	UBOIDDocCopy     string `json:"ubo_id_doc_copy"`                   // 证件正面照片
+	UBOIDDocCopyBack string `json:"ubo_id_doc_copy_back,omitempty"`    // 证件反面照片
+	UBOIDDocName     string `json:"ubo_id_doc_name" wx:"sensitive"`    // 证件姓名
+	UBOIDDocNumber   string `json:"ubo_id_doc_number" wx:"sensitive"`  // 证件号码
+	UBOIDDocAddress  string `json:"ubo_id_doc_address" wx:"sensitive"` // 证件居住地址
+	UBOPeriodBegin   string `json:"ubo_period_begin"`                  // 证件有效期开始时间
+	UBOPeriodEnd     string `json:"ubo_period_end"`                    // 证件有效期结束时间
+}
+
+// ApplymentBusinessInfo 经营资料
+type ApplymentBusinessInfo struct {
+	MerchantShortname string              `json:"merchant_shortname"` // 商户简称，在支付完成页向买家展示
+	ServicePhone      string              `json:"service_phone"`      // 客服电话
+	SalesInfo         *ApplymentSalesInfo `json:"sales_info"`         // 经营场景
+}
+
+// ApplymentSalesInfo 经营场景，按 SalesScenesType 填写对应的场景信息
+type ApplymentSalesInfo struct {
+	SalesScenesType []string                  `json:"sales_scenes_type"`           // 经营场景类型，如 SALES_SCENES_STORE 线下门店，SALES_SCENES_MP 公众号
+	BizStoreInfo    *ApplymentBizStoreInfo    `json:"biz_store_info,omitempty"`    // 线下门店场景
+	MPInfo          *ApplymentMPInfo          `json:"mp_info,omitempty"`           // 公众号场景
+	MiniProgramInfo *ApplymentMiniProgramInfo `json:"mini_program_info,omitempty"` // 小程序场景
+	AppInfo         *ApplymentAppInfo         `json:"app_info,omitempty"`          // APP 场景
+	WebInfo         *ApplymentWebInfo         `json:"web_info,omitempty"`          // 互联网网站场景
+}
+
+// ApplymentBizStoreInfo 线下门店场景
+type ApplymentBizStoreInfo struct {
+	BizStoreName     string   `json:"biz_store_name"`          // 门店名称
+	BizAddressCode   string   `json:"biz_address_code"`        // 门店省市编码
+	BizStoreAddress  string   `json:"biz_store_address"`       // 门店地址
+	StoreEntrancePic []string `json:"store_entrance_pic"`      // 门店门头照片
+	IndoorPic        []string `json:"indoor_pic"`              // 店内环境照片
+	BizSubAppID      string   `json:"biz_sub_appid,omitempty"` // 线下场所对应的商家 appid
+}
+
+// ApplymentMPInfo 公众号场景
+type ApplymentMPInfo struct {
+	MPAppID    string   `json:"mp_appid,omitempty"`     // 服务商公众号 appid
+	MPSubAppID string   `json:"mp_sub_appid,omitempty"` // 商家公众号 appid
+	MPPics     []string `json:"mp_pics"`                // 公众号页面截图
+}
+
+// ApplymentMiniProgramInfo 小程序场景
+type ApplymentMiniProgramInfo struct {
+	MiniProgramAppID    string   `json:"mini_program_appid,omitempty"`     // 服务商小程序 appid
+	MiniProgramSubAppID string   `json:"mini_program_sub_appid,omitempty"` // 商家小程序 appid
+	MiniProgramPics     []string `json:"mini_program_pics,omitempty"`      // 小程序截图
+}
+
+// ApplymentAppInfo APP 场景
+type ApplymentAppInfo struct {
+	AppAppID    string   `json:"app_appid,omitempty"`     // 服务商应用 appid
+	AppSubAppID string   `json:"app_sub_appid,omitempty"` // 商家应用 appid
+	AppPics     []string `json:"app_pics"`                // APP 截图
+}
+
+// ApplymentWebInfo 互联网网站场景
+type ApplymentWebInfo struct {
+	Domain           string `json:"domain"`                      // 互联网网站域名
+	WebAuthorisation string `json:"web_authorisation,omitempty"` // 网站授权函
+	WebAppID         string `json:"web_appid,omitempty"`         // 互联网网站对应的商家 appid
+}
+
+// ApplymentSettlementInfo 结算规则
+type ApplymentSettlementInfo struct {
+	SettlementID        string   `json:"settlement_id"`                  // 入驻结算规则 ID
+	QualificationType   string   `json:"qualification_type"`             // 所属行业
+	Qualifications      []string `json:"qualifications,omitempty"`       // 特殊资质图片
+	ActivitiesID        string   `json:"activities_id,omitempty"`        // 优惠费率活动 ID
+	ActivitiesRate      string   `json:"activities_rate,omitempty"`      // 优惠费率活动值
+	ActivitiesAdditions []string `json:"activities_additions,omitempty"` // 优惠费率活动补充材料
+}
+
+// ApplymentBankAccountInfo 结算银行账户
+type ApplymentBankAccountInfo struct {
+	BankAccountType string `json:"bank_account_type"`             // 账户类型：BANK_ACCOUNT_TYPE_CORPORATE 对公，BANK_ACCOUNT_TYPE_PERSONAL 对私
+	AccountName     string `json:"account_name" wx:"sensitive"`   // 开户名称
+	AccountBank     string `json:"account_bank"`                  // 开户银行
+	BankAddressCode string `json:"bank_address_code"`             // 开户银行省市编码
+	BankBranchID    string `json:"bank_branch_id,omitempty"`      // 开户银行联行号
+	BankName        string `json:"bank_name,omitempty"`           // 开户银行全称（含支行）
+	AccountNumber   string `json:"account_number" wx:"sensitive"` // 银行账号
+}
+
+// ApplymentAdditionInfo 补充材料
+type ApplymentAdditionInfo struct {
+	LegalPersonCommitment string   `json:"legal_person_commitment,omitempty"` // 法人开户承诺函
+	LegalPersonVideo      string   `json:"legal_person_video,omitempty"`      // 法人开户意愿视频，UploadVideo 返回的 media_id
+	BusinessAdditionPics  []string `json:"business_addition_pics,omitempty"`  // 补充材料图片
+	BusinessAdditionMsg   string   `json:"business_addition_msg,omitempty"`   // 补充说明
+}
+
+// ApplymentResponse 为提交申请单的应答
+type ApplymentResponse struct {
+	// ApplymentID 微信支付申请单号
+	ApplymentID int64 `json:"applyment_id"`
+}
+
+// ApplymentQueryResponse 为查询申请单状态的应答
+type ApplymentQueryResponse struct {
+	BusinessCode      string                  `json:"business_code"`          // 业务申请编号
+	ApplymentID       int64                   `json:"applyment_id"`           // 微信支付申请单号
+	SubMchID          string                  `json:"sub_mchid,omitempty"`    // 特约商户号，申请单状态为已完成时返回
+	SignURL           string                  `json:"sign_url,omitempty"`     // 超级管理员签约链接，见 ApplymentQueryResponse.SigningURL
+	ApplymentState    ApplymentState          `json:"applyment_state"`        // 申请单状态
+	ApplymentStateMsg string                  `json:"applyment_state_msg"`    // 申请状态描述
+	AuditDetail       []*ApplymentAuditDetail `json:"audit_detail,omitempty"` // 驳回原因详情
+}
+
+// ApplymentAuditDetail 驳回原因详情
+type ApplymentAuditDetail struct {
+	Field        string `json:"field"`         // 字段名
+	FieldName    string `json:"field_name"`    // 字段名称
+	RejectReason string `json:"reject_reason"` // 驳回原因
+}
+
+// SigningURL 在申请单状态为待账户验证或待签约时返回超级管理员需要扫码打开的链接，否则返回空字符串
+func (resp *ApplymentQueryResponse) SigningURL() string {
+	switch resp.ApplymentState {
+	case ApplymentStateTOBECONFIRMED, ApplymentStateTOBESIGNED:
+		return resp.SignURL
+	default:
+		return ""
+	}
+}
+
+// SubmitApplyment 提交特约商户进件申请单（服务商），返回的 applyment_id 可用于查询申请状态；
+// 若返回错误（如网络错误）可使用相同的业务申请编号重新提交
+func (client *Client) SubmitApplyment(ctx context.Context, req *ApplymentRequest) (*ApplymentResponse, error) {
+	if req.BusinessCode == "" {
+		return nil, ErrApplymentMissingBusinessCode
+	}
+	resp := &ApplymentResponse{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/applyment4sub/applyment/", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QueryApplymentByBusinessCode 通过业务申请编号查询申请状态
+func (client *Client) QueryApplymentByBusinessCode(ctx context.Context, businessCode string) (*ApplymentQueryResponse, error) {
+	if businessCode == "" {
+		return nil, ErrApplymentMissingID
+	}
+	resp := &ApplymentQueryResponse{}
+	if err := client.Do(ctx, http.MethodGet, "/v3/applyment4sub/applyment/business_code/"+url.PathEscape(businessCode), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QueryApplymentByID 通过微信支付申请单号查询申请状态
+func (client *Client) QueryApplymentByID(ctx context.Context, applymentID int64) (*ApplymentQueryResponse, error) {
+	if applymentID == 0 {
+		return nil, ErrApplymentMissingID
+	}
+	resp := &ApplymentQueryResponse{}
+	if err := client.Do(ctx, http.MethodGet, "/v3/applyment4sub/applyment/applyment_id/"+strconv.FormatInt(applymentID, 10), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// PollApplymentRequest 为轮询申请单状态请求
+type PollApplymentRequest struct {
+	// ----- 必填字段 -----
+	// 以下二选一
+	BusinessCode string // 业务申请编号
+	ApplymentID  int64  // 微信支付申请单号
+
+	// ----- 选填字段 -----
+	// Intervals 为每次查询间的等待时间，用完后重复最后一个，为空时使用 DefaultPollApplymentIntervals
+	Intervals []time.Duration
+
+	// OnStateChange 在每次观察到申请单状态变化时（包括首次查询）被调用
+	OnStateChange func(*ApplymentQueryResponse)
+}
+
+// PollApplyment 轮询查询申请单状态直至其不再由微信支付处理中（见 ApplymentState.IsProcessing）或 ctx 结束，即：
+// 进入终态、需要重新提交（编辑中）或需要超级管理员扫码验证/签约（见 ApplymentQueryResponse.SigningURL）；
+// 验证/签约后可再次调用以等待进件完成。
+//
+// 查询时遇到网络错误或可重试的错误（见 APIError.IsRetryable）时会继续轮询，遇到其它错误则立即返回；
+// ctx 结束时返回最后一次成功查询的结果（可能为 nil）以及 ctx.Err()
+func (client *Client) PollApplyment(ctx context.Context, req *PollApplymentRequest) (*ApplymentQueryResponse, error) {
+	if req.BusinessCode == "" && req.ApplymentID == 0 {
+		return nil, ErrApplymentMissingID
+	}
+
+	intervals := req.Intervals
+	if len(intervals) == 0 {
+		intervals = DefaultPollApplymentIntervals
+	}
+
+	var last *ApplymentQueryResponse
+	for i := 0; ; i++ {
+		var resp *ApplymentQueryResponse
+		var err error
+		if req.BusinessCode != "" {
+			resp, err = client.QueryApplymentByBusinessCode(ctx, req.BusinessCode)
+		} else {
+			resp, err = client.QueryApplymentByID(ctx, req.ApplymentID)
+		}
+		switch {
+		case err == nil:
+			if req.OnStateChange != nil && (last == nil || last.ApplymentState != resp.ApplymentState) {
+				req.OnStateChange(resp)
+			}
+			last = resp
+			if !resp.ApplymentState.IsProcessing() {
+				return resp, nil
+			}
+
+		case ctx.Err() != nil:
+			// ctx 结束，下面处理
+
+		default:
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && !apiErr.IsRetryable() {
+				return last, err
+			}
+		}
+
+		interval := intervals[len(intervals)-1]
+		if i < len(intervals) {
+			interval = intervals[i]
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return last, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package mchv3
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmitApplyment(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/applyment4sub/applyment/", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &ApplymentRequest{}
+		assert.NoError(json.Unmarshal(body, req))
+
+		// 嵌套结构体中的敏感字段均已加密
+		for cipherText, expect := range map[string]string{
+			req.ContactInfo.ContactName:                          "张三",
+			req.ContactInfo.MobilePhone:                          "13800000000",
+			req.SubjectInfo.IdentityInfo.IDCardInfo.IDCardNumber: "110101199003070000",
+			req.SubjectInfo.UBOInfoList[0].UBOIDDocName:          "李四",
+			req.BankAccountInfo.AccountNumber:                    "6222000000000000",
+		} {
+			plainText, err := utils.DecryptOAEP(testPlatformKey, cipherText)
+			assert.NoError(err)
+			assert.Equal(expect, plainText)
+		}
+		assert.Equal("BANK_ACCOUNT_TYPE_PERSONAL", req.BankAccountInfo.BankAccountType)
+		assert.Equal(utils.CertificateSerialNo(testPlatformCert), r.Header.Get("Wechatpay-Serial"))
+		testRespond(w, 200, &ApplymentResponse{ApplymentID: 2000002124775691})
+	})
+	ctx := context.Background()
+
+	_, err := client.SubmitApplyment(ctx, &ApplymentRequest{})
+	assert.Equal(ErrApplymentMissingBusinessCode, err)
+
+	resp, err := client.SubmitApplyment(ctx, &ApplymentRequest{
+		BusinessCode: "APPLYMENT_00000000001",
+		ContactInfo: &ApplymentContactInfo{
+			ContactType: "LEGAL",
+			ContactName: "张三",
+			MobilePhone: "13800000000",
+		},
+		SubjectInfo: &ApplymentSubjectInfo{
+			SubjectType: "SUBJECT_TYPE_ENTERPRISE",
+			IdentityInfo: &ApplymentIdentityInfo{
+				IDDocType: "IDENTIFICATION_TYPE_IDCARD",
+				IDCardInfo: &ApplymentIDCardInfo{
+					IDCardName:   "张三",
+					IDCardNumber: "110101199003070000",
+				},
+			},
+			UBOInfoList: []*ApplymentUBOInfo{{UBOIDDocName: "李四"}},
+		},
+		BusinessInfo: &ApplymentBusinessInfo{
+			MerchantShortname: "张三餐厅",
+			SalesInfo:         &ApplymentSalesInfo{SalesScenesType: []string{"SALES_SCENES_STORE"}},
+		},
+		SettlementInfo: &ApplymentSettlementInfo{SettlementID: "719"},
+		BankAccountInfo: &ApplymentBankAccountInfo{
+			BankAccountType: "BANK_ACCOUNT_TYPE_PERSONAL",
+			AccountName:     "张三",
+			AccountNumber:   "6222000000000000",
+		},
+	})
+	assert.NoError(err)
+	assert.Equal(int64(2000002124775691), resp.ApplymentID)
+}
+
+func TestPollApplyment(t *testing.T) {
+	assert := assert.New(t)
+
+	states := []interface{}{
+		&ApplymentQueryResponse{ApplymentState: ApplymentStateAUDITING},
+		map[string]string{"code": "SYSTEM_ERROR", "message": "系统错误"},
+		&ApplymentQueryResponse{ApplymentState: ApplymentStateAUDITING},
+		&ApplymentQueryResponse{ApplymentState: ApplymentStateTOBESIGNED, SignURL: "https://pay.weixin.qq.com/public/apply4ec_sign/s?applymentId=2000002126198476&sign=b207b673049a32c858f3aabd7d27c7ec"},
+	}
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/applyment4sub/applyment/business_code/APPLYMENT_00000000001", r.URL.Path)
+		state := states[0]
+		states = states[1:]
+		if _, ok := state.(map[string]string); ok {
+			testRespond(w, 500, state)
+			return
+		}
+		testRespond(w, 200, state)
+	})
+	ctx := context.Background()
+
+	_, err := client.PollApplyment(ctx, &PollApplymentRequest{})
+	assert.Equal(ErrApplymentMissingID, err)
+
+	changes := []ApplymentState{}
+	resp, err := client.PollApplyment(ctx, &PollApplymentRequest{
+		BusinessCode: "APPLYMENT_00000000001",
+		Intervals:    []time.Duration{time.Millisecond},
+		OnStateChange: func(resp *ApplymentQueryResponse) {
+			changes = append(changes, resp.ApplymentState)
+		},
+	})
+	assert.NoError(err)
+	assert.Equal([]ApplymentState{ApplymentStateAUDITING, ApplymentStateTOBESIGNED}, changes)
+	assert.Equal("https://pay.weixin.qq.com/public/apply4ec_sign/s?applymentId=2000002126198476&sign=b207b673049a32c858f3aabd7d27c7ec", resp.SigningURL())
+	assert.False(resp.ApplymentState.IsTerminal())
+	assert.Len(states, 0)
+
+	// 不可重试的错误立即返回
+	client = testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		testRespond(w, 404, map[string]string{"code": "RESOURCE_NOT_EXISTS", "message": "申请单不存在"})
+	})
+	_, err = client.PollApplyment(ctx, &PollApplymentRequest{ApplymentID: 2000002124775691, Intervals: []time.Duration{time.Millisecond}})
+	assert.Equal("RESOURCE_NOT_EXISTS", ErrCodeOf(err))
+
+	// ctx 结束
+	client = testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/applyment4sub/applyment/applyment_id/2000002124775691", r.URL.Path)
+		testRespond(w, 200, &ApplymentQueryResponse{ApplymentState: ApplymentStateSIGNING})
+	})
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	resp, err = client.PollApplyment(ctx, &PollApplymentRequest{ApplymentID: 2000002124775691, Intervals: []time.Duration{time.Millisecond}})
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(ApplymentStateSIGNING, resp.ApplymentState)
+	assert.Equal("", resp.SigningURL())
+}
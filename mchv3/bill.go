@@ -0,0 +1,470 @@
+package mchv3
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BillType 为交易账单类型
+type BillType string
+
+// AccountType 为资金账单的资金账户类型
+type AccountType string
+
+// TarType 为账单压缩格式
+type TarType string
+
+const (
+	BillTypeALL     BillType = "ALL"     // 当日所有订单信息（不含充值退款订单）
+	BillTypeSUCCESS BillType = "SUCCESS" // 当日成功支付的订单（不含充值退款订单）
+	BillTypeREFUND  BillType = "REFUND"  // 当日退款订单（不含充值退款订单）
+
+	AccountTypeBASIC     AccountType = "BASIC"     // 基本账户
+	AccountTypeOPERATION AccountType = "OPERATION" // 运营账户
+	AccountTypeFEES      AccountType = "FEES"      // 手续费账户
+
+	TarTypeGZIP TarType = "GZIP" // gzip 压缩
+)
+
+var (
+	ErrBillMissingDate       = errors.New("Missing bill_date in bill request")
+	ErrBillMissingURL        = errors.New("Missing download_url in BillResponse")
+	ErrBillUnsupportedHash   = errors.New("Unsupported bill hash_type, expect SHA1")
+	ErrBillHashMismatch      = errors.New("Bill hash mismatch, the downloaded bill is corrupted or tampered")
+	ErrBillMissingHeader     = errors.New("Missing header line in bill")
+	ErrBillUnexpectedContent = errors.New("Unexpected content after bill summary")
+)
+
+// TradeBillRequest 为申请交易账单请求
+type TradeBillRequest struct {
+	// BillDate 账单日期，格式 2006-01-02，必填
+	BillDate string
+	// SubMchID 子商户号（服务商模式），为空时返回服务商及所有子商户的账单
+	SubMchID string
+	// BillType 账单类型，为空时为 ALL
+	BillType BillType
+	// TarType 压缩格式，为空时不压缩
+	TarType TarType
+}
+
+// FundFlowBillRequest 为申请资金账单请求
+type FundFlowBillRequest struct {
+	// BillDate 账单日期，格式 2006-01-02，必填
+	BillDate string
+	// AccountType 资金账户类型，为空时为 BASIC
+	AccountType AccountType
+	// TarType 压缩格式，为空时不压缩
+	TarType TarType
+}
+
+// BillResponse 为申请账单的应答，使用 DownloadBill 下载
+type BillResponse struct {
+	// HashType 哈希类型，目前只有 SHA1
+	HashType string `json:"hash_type"`
+	// HashValue 原始账单（gzip 需要解压缩）的摘要值
+	HashValue string `json:"hash_value"`
+	// DownloadURL 下载地址，5 分钟内有效
+	DownloadURL string `json:"download_url"`
+}
+
+// TradeBill 申请交易账单
+func (client *Client) TradeBill(ctx context.Context, req *TradeBillRequest) (*BillResponse, error) {
+	if req.BillDate == "" {
+		return nil, ErrBillMissingDate
+	}
+	resp := &BillResponse{}
+	if err := client.Do(ctx, http.MethodGet, withQuery(
+		"/v3/bill/tradebill",
+		"bill_date", req.BillDate,
+		"sub_mchid", req.SubMchID,
+		"bill_type", string(req.BillType),
+		"tar_type", string(req.TarType),
+	), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// FundFlowBill 申请资金账单
+func (client *Client) FundFlowBill(ctx context.Context, req *FundFlowBillRequest) (*BillResponse, error) {
+	if req.BillDate == "" {
+		return nil, ErrBillMissingDate
+	}
+	resp := &BillResponse{}
+	if err := client.Do(ctx, http.MethodGet, withQuery(
+		"/v3/bill/fundflowbill",
+		"bill_date", req.BillDate,
+		"account_type", string(req.AccountType),
+		"tar_type", string(req.TarType),
+	), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DownloadBill 以带签名的 GET 请求下载账单，返回（gzip 已解压缩的）原始账单内容；
+// 读取到末尾时校验摘要值，不一致时 Read 返回 ErrBillHashMismatch 而不是 io.EOF，因此应读取到 io.EOF 才能认为账单完整，
+// 例如使用 ScanTradeBill/ScanFundFlowBill 解析时需在其返回 nil error 后才确认已处理的行；调用方需负责 Close
+func (client *Client) DownloadBill(ctx context.Context, bill *BillResponse) (io.ReadCloser, error) {
+	if bill.DownloadURL == "" {
+		return nil, ErrBillMissingURL
+	}
+	if !strings.EqualFold(bill.HashType, "SHA1") {
+		return nil, ErrBillUnsupportedHash
+	}
+
+	req, err := client.newRequest(ctx, http.MethodGet, bill.DownloadURL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 下载的应答不带签名，完整性由摘要值保证
+	resp, err := client.options.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, checkStatus(resp, body)
+	}
+
+	// 根据 gzip 文件头判断是否需要解压缩
+	br := bufio.NewReader(resp.Body)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		r = gr
+	}
+	return &billReader{
+		r:      r,
+		closer: resp.Body,
+		hash:   sha1.New(),
+		expect: strings.ToLower(bill.HashValue),
+	}, nil
+}
+
+// billReader 在读取到末尾时校验摘要值
+type billReader struct {
+	r      io.Reader
+	closer io.Closer
+	hash   hash.Hash
+	expect string
+	err    error
+}
+
+func (r *billReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.expect {
+		err = ErrBillHashMismatch
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *billReader) Close() error {
+	return r.closer.Close()
+}
+
+// TradeBillRow 为交易账单中的一条记录，金额单位为分；不同类型（BillType）的账单所包含的列不同，缺少的列为零值
+type TradeBillRow struct {
+	TradeTime          time.Time `bill:"交易时间"`
+	AppID              string    `bill:"公众账号ID"`
+	MchID              string    `bill:"商户号"`
+	SubMchID           string    `bill:"特约商户号,子商户号"`
+	DeviceInfo         string    `bill:"设备号"`
+	TransactionID      string    `bill:"微信订单号"`
+	OutTradeNo         string    `bill:"商户订单号"`
+	OpenID             string    `bill:"用户标识"`
+	TradeType          string    `bill:"交易类型"`
+	TradeState         string    `bill:"交易状态"`
+	BankType           string    `bill:"付款银行"`
+	Currency           string    `bill:"货币种类"`
+	SettlementTotalFee int64     `bill:"应结订单金额"`
+	CouponFee          int64     `bill:"代金券金额,代金券或立减优惠金额"`
+	RefundID           string    `bill:"微信退款单号"`
+	OutRefundNo        string    `bill:"商户退款单号"`
+	RefundFee          int64     `bill:"退款金额"`
+	CouponRefundFee    int64     `bill:"充值券退款金额,代金券或立减优惠退款金额"`
+	RefundType         string    `bill:"退款类型"`
+	RefundStatus       string    `bill:"退款状态"`
+	Body               string    `bill:"商品名称"`
+	Attach             string    `bill:"商户数据包"`
+	Fee                int64     `bill:"手续费"`
+	Rate               string    `bill:"费率"`
+	TotalFee           int64     `bill:"订单金额"`
+	RefundRequestFee   int64     `bill:"申请退款金额"`
+	RateRemark         string    `bill:"费率备注"`
+}
+
+// TradeBillSummary 为交易账单的汇总，金额单位为分
+type TradeBillSummary struct {
+	TotalCount         int   `bill:"总交易单数"`
+	SettlementTotalFee int64 `bill:"应结订单总金额"`
+	RefundFee          int64 `bill:"退款总金额"`
+	CouponRefundFee    int64 `bill:"充值券退款总金额,代金券或立减优惠退款总金额"`
+	Fee                int64 `bill:"手续费总金额"`
+	TotalFee           int64 `bill:"订单总金额"`
+	RefundRequestFee   int64 `bill:"申请退款总金额"`
+}
+
+// FundFlowBillRow 为资金账单中的一条记录，金额单位为分
+type FundFlowBillRow struct {
+	AccountingTime time.Time `bill:"记账时间"`
+	TransactionID  string    `bill:"微信支付业务单号"`
+	FlowID         string    `bill:"资金流水单号"`
+	BizName        string    `bill:"业务名称"`
+	BizType        string    `bill:"业务类型"`
+	Direction      string    `bill:"收支类型"` // 收入/支出
+	Amount         int64     `bill:"收支金额"`
+	Balance        int64     `bill:"账户结余"`
+	Applicant      string    `bill:"资金变更提交申请人"`
+	Remark         string    `bill:"备注"`
+	VoucherNo      string    `bill:"业务凭证号"`
+}
+
+// FundFlowBillSummary 为资金账单的汇总，金额单位为分
+type FundFlowBillSummary struct {
+	TotalCount   int   `bill:"资金流水总笔数"`
+	IncomeCount  int   `bill:"收入笔数"`
+	Income       int64 `bill:"收入金额"`
+	ExpenseCount int   `bill:"支出笔数"`
+	Expense      int64 `bill:"支出金额"`
+}
+
+// ScanTradeBill 流式解析交易账单，每条记录调用一次 fn（fn 返回错误时停止解析并返回该错误），最后返回账单汇总；
+// r 为 DownloadBill 的结果时，摘要值不一致会在读取到末尾时返回 ErrBillHashMismatch，此前已传给 fn 的记录均不可信
+func ScanTradeBill(r io.Reader, fn func(*TradeBillRow) error) (*TradeBillSummary, error) {
+	summary := &TradeBillSummary{}
+	err := scanBill(r, summary, func(header, record []string) error {
+		row := &TradeBillRow{}
+		if err := decodeBillRecord(header, record, row); err != nil {
+			return err
+		}
+		return fn(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// ScanFundFlowBill 流式解析资金账单，见 ScanTradeBill
+func ScanFundFlowBill(r io.Reader, fn func(*FundFlowBillRow) error) (*FundFlowBillSummary, error) {
+	summary := &FundFlowBillSummary{}
+	err := scanBill(r, summary, func(header, record []string) error {
+		row := &FundFlowBillRow{}
+		if err := decodeBillRecord(header, record, row); err != nil {
+			return err
+		}
+		return fn(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// scanBill 解析账单，格式为：
+//
+//   表头（以逗号分隔的列名）
+//   记录（每个字段以 ` 开头，以逗号分隔）...
+//   汇总表头
+//   汇总记录
+//
+// 总是读取到 r 的末尾（以便 DownloadBill 校验摘要值）
+func scanBill(r io.Reader, summary interface{}, onRecord func(header, record []string) error) error {
+	br := bufio.NewReader(r)
+	lineNo := 0
+	var header, summaryHeader []string
+	summaryDone := false
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		lineNo++
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.TrimSpace(line) != "" {
+			switch {
+			case header == nil:
+				header = splitBillHeader(line)
+
+			case summaryHeader == nil && !strings.HasPrefix(line, "`"):
+				summaryHeader = splitBillHeader(line)
+
+			case summaryHeader == nil:
+				if err := onRecord(header, splitBillRecord(line)); err != nil {
+					return fmt.Errorf("Bill line %d: %s", lineNo, err)
+				}
+
+			case !summaryDone:
+				if err := decodeBillRecord(summaryHeader, splitBillRecord(line), summary); err != nil {
+					return fmt.Errorf("Bill line %d: %s", lineNo, err)
+				}
+				summaryDone = true
+
+			default:
+				return ErrBillUnexpectedContent
+			}
+		}
+
+		if eof {
+			break
+		}
+	}
+
+	if header == nil {
+		return ErrBillMissingHeader
+	}
+	return nil
+}
+
+var billHeaderReplacer = strings.NewReplacer("（元）", "", "(元)", "", " ", "", "\t", "")
+
+func splitBillHeader(line string) []string {
+	header := strings.Split(line, ",")
+	for i, name := range header {
+		header[i] = billHeaderReplacer.Replace(name)
+	}
+	return header
+}
+
+// splitBillRecord 分割记录；字段以 ` 开头，以 ",`" 分割以便字段中可以包含逗号
+func splitBillRecord(line string) []string {
+	return strings.Split(strings.TrimPrefix(line, "`"), ",`")
+}
+
+// billField 为带 bill tag 的结构体字段
+type billField struct {
+	index int
+	names []string
+}
+
+var billFieldsCache sync.Map // reflect.Type -> []billField
+
+func billFields(rt reflect.Type) []billField {
+	if fields, ok := billFieldsCache.Load(rt); ok {
+		return fields.([]billField)
+	}
+	fields := []billField{}
+	for i := 0; i < rt.NumField(); i++ {
+		if tag := rt.Field(i).Tag.Get("bill"); tag != "" {
+			fields = append(fields, billField{index: i, names: strings.Split(tag, ",")})
+		}
+	}
+	billFieldsCache.Store(rt, fields)
+	return fields
+}
+
+var billTimeZone = time.FixedZone("UTC+8", 8*60*60)
+
+// decodeBillRecord 按表头将记录解码至结构体指针 v，字段类型为：string；time.Time；int（笔数）；int64（以元为单位的金额，转换为分）
+func decodeBillRecord(header, record []string, v interface{}) error {
+	if len(record) != len(header) {
+		return fmt.Errorf("Expect %d fields but got %d", len(header), len(record))
+	}
+	columns := make(map[string]string, len(header))
+	for i, name := range header {
+		columns[name] = strings.TrimSpace(record[i])
+	}
+
+	rv := reflect.ValueOf(v).Elem()
+	for _, field := range billFields(rv.Type()) {
+		value, ok := "", false
+		for _, name := range field.names {
+			if value, ok = columns[name]; ok {
+				break
+			}
+		}
+		if !ok || value == "" {
+			continue
+		}
+
+		fv := rv.Field(field.index)
+		switch fv.Interface().(type) {
+		case string:
+			fv.SetString(value)
+
+		case time.Time:
+			t, err := time.ParseInLocation("2006-01-02 15:04:05", value, billTimeZone)
+			if err != nil {
+				return fmt.Errorf("Bad time %q of %s", value, field.names[0])
+			}
+			fv.Set(reflect.ValueOf(t))
+
+		case int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("Bad count %q of %s", value, field.names[0])
+			}
+			fv.SetInt(int64(n))
+
+		case int64:
+			n, err := parseYuan(value)
+			if err != nil {
+				return fmt.Errorf("Bad amount %q of %s", value, field.names[0])
+			}
+			fv.SetInt(n)
+		}
+	}
+	return nil
+}
+
+// parseYuan 将以元为单位的金额（如 "1.23"）转换为分
+func parseYuan(s string) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	parts := strings.SplitN(s, ".", 2)
+	yuan, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	fen := int64(0)
+	if len(parts) == 2 {
+		frac := parts[1]
+		if len(frac) == 0 || len(frac) > 2 {
+			return 0, strconv.ErrSyntax
+		}
+		if len(frac) == 1 {
+			frac += "0"
+		}
+		if fen, err = strconv.ParseInt(frac, 10, 64); err != nil || frac[0] == '-' || frac[0] == '+' {
+			return 0, strconv.ErrSyntax
+		}
+	}
+	n := yuan*100 + fen
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
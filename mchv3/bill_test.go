@@ -0,0 +1,199 @@
+package mchv3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTradeBill = "交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\r\n" +
+	"`2021-01-02 15:04:05,`wx2421b1c4370ec43b,`1900009191,`0,`,`4200000801202101020000000001,`T1,`oUpF8uMuAJO,`JSAPI,`SUCCESS,`CMB_CREDIT,`CNY,`1.00,`0.00,`0,`0,`0.00,`0.00,`,`,`商品,含逗号,`,`0.01,`0.60%,`1.00,`0.00,`\r\n" +
+	"`2021-01-02 16:00:00,`wx2421b1c4370ec43b,`1900009191,`0,`,`4200000801202101020000000002,`T2,`oUpF8uMuAJO,`JSAPI,`REFUND,`CMB_CREDIT,`CNY,`0.00,`0.00,`50000000012021010200000001,`R1,`0.5,`0.00,`ORIGINAL,`SUCCESS,`商品,`,`-0.01,`0.60%,`1.00,`0.50,`\r\n" +
+	"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\r\n" +
+	"`2,`1.00,`0.50,`0.00,`0.00,`2.00,`0.50\r\n"
+
+var testFundFlowBill = "记账时间,微信支付业务单号,资金流水单号,业务名称,业务类型,收支类型,收支金额（元）,账户结余（元）,资金变更提交申请人,备注,业务凭证号\n" +
+	"`2021-01-02 15:04:05,`4200000801202101020000000001,`4200000801202101020000000001,`交易,`交易,`收入,`1.00,`101.00,`system,`缴费,`\n" +
+	"资金流水总笔数,收入笔数,收入金额,支出笔数,支出金额\n" +
+	"`1,`1,`1.00,`0,`0.00\n"
+
+func testSHA1(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func testGzip(s string) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestTradeBill(t *testing.T) {
+	assert := assert.New(t)
+
+	hashValue := testSHA1(testTradeBill)
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/bill/tradebill":
+			assert.Equal("bill_date=2021-01-02&bill_type=ALL&tar_type=GZIP", r.URL.RawQuery)
+			testRespond(w, 200, &BillResponse{
+				HashType:    "SHA1",
+				HashValue:   hashValue,
+				DownloadURL: "https://api.mch.weixin.qq.com/v3/billdownload/file?token=6XIv5TUPto7pByrTQKhd6kwvyKLG2uY2wMMR8cNXqaA_Cv_isgaUtBzp4QtiozLO",
+			})
+		case "/v3/billdownload/file":
+			// 下载请求同样带签名，应答不带签名
+			assert.Equal("token=6XIv5TUPto7pByrTQKhd6kwvyKLG2uY2wMMR8cNXqaA_Cv_isgaUtBzp4QtiozLO", r.URL.RawQuery)
+			w.Write(testGzip(testTradeBill))
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+
+	_, err := client.TradeBill(ctx, &TradeBillRequest{})
+	assert.Equal(ErrBillMissingDate, err)
+
+	bill, err := client.TradeBill(ctx, &TradeBillRequest{
+		BillDate: "2021-01-02",
+		BillType: BillTypeALL,
+		TarType:  TarTypeGZIP,
+	})
+	assert.NoError(err)
+	assert.Equal(hashValue, bill.HashValue)
+
+	r, err := client.DownloadBill(ctx, bill)
+	assert.NoError(err)
+	defer r.Close()
+
+	rows := []*TradeBillRow{}
+	summary, err := ScanTradeBill(r, func(row *TradeBillRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(&TradeBillSummary{
+		TotalCount:         2,
+		SettlementTotalFee: 100,
+		RefundFee:          50,
+		TotalFee:           200,
+		RefundRequestFee:   50,
+	}, summary)
+
+	assert.Len(rows, 2)
+	assert.Equal(time.Date(2021, 1, 2, 15, 4, 5, 0, billTimeZone).Unix(), rows[0].TradeTime.Unix())
+	assert.Equal("wx2421b1c4370ec43b", rows[0].AppID)
+	assert.Equal("0", rows[0].SubMchID)
+	assert.Equal("T1", rows[0].OutTradeNo)
+	assert.Equal("SUCCESS", rows[0].TradeState)
+	assert.Equal(int64(100), rows[0].SettlementTotalFee)
+	assert.Equal("商品,含逗号", rows[0].Body)
+	assert.Equal(int64(1), rows[0].Fee)
+	assert.Equal("0.60%", rows[0].Rate)
+	assert.Equal("", rows[0].RateRemark)
+	assert.Equal("R1", rows[1].OutRefundNo)
+	assert.Equal(int64(50), rows[1].RefundFee)
+	assert.Equal(int64(-1), rows[1].Fee)
+}
+
+func TestDownloadBillHashMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, body := range [][]byte{
+		[]byte(testTradeBill),
+		testGzip(testTradeBill),
+	} {
+		client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		})
+		ctx := context.Background()
+
+		// 未压缩或 gzip 均校验解压后的内容
+		r, err := client.DownloadBill(ctx, &BillResponse{HashType: "SHA1", HashValue: strings.ToUpper(testSHA1(testTradeBill)), DownloadURL: "https://api.mch.weixin.qq.com/v3/billdownload/file?token=x"})
+		assert.NoError(err)
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(err)
+		assert.Equal(testTradeBill, string(data))
+		r.Close()
+
+		r, err = client.DownloadBill(ctx, &BillResponse{HashType: "SHA1", HashValue: testSHA1("x"), DownloadURL: "https://api.mch.weixin.qq.com/v3/billdownload/file?token=x"})
+		assert.NoError(err)
+		_, err = ScanTradeBill(r, func(row *TradeBillRow) error {
+			return nil
+		})
+		assert.Equal(ErrBillHashMismatch, err)
+		r.Close()
+	}
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		testRespond(w, 404, map[string]string{"code": "RESOURCE_NOT_EXISTS", "message": "账单不存在"})
+	})
+	_, err := client.DownloadBill(context.Background(), &BillResponse{HashType: "SHA1", DownloadURL: "https://api.mch.weixin.qq.com/v3/billdownload/file?token=x"})
+	assert.Equal("RESOURCE_NOT_EXISTS", ErrCodeOf(err))
+	_, err = client.DownloadBill(context.Background(), &BillResponse{HashType: "MD5", DownloadURL: "https://api.mch.weixin.qq.com/v3/billdownload/file?token=x"})
+	assert.Equal(ErrBillUnsupportedHash, err)
+}
+
+func TestScanFundFlowBill(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := []*FundFlowBillRow{}
+	summary, err := ScanFundFlowBill(strings.NewReader(testFundFlowBill), func(row *FundFlowBillRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(&FundFlowBillSummary{TotalCount: 1, IncomeCount: 1, Income: 100}, summary)
+	assert.Len(rows, 1)
+	assert.Equal("收入", rows[0].Direction)
+	assert.Equal(int64(100), rows[0].Amount)
+	assert.Equal(int64(10100), rows[0].Balance)
+	assert.Equal("缴费", rows[0].Remark)
+
+	// fn 返回错误时停止
+	fnErr := errors.New("fn error")
+	_, err = ScanFundFlowBill(strings.NewReader(testFundFlowBill), func(row *FundFlowBillRow) error {
+		return fnErr
+	})
+	assert.EqualError(err, "Bill line 2: fn error")
+
+	// 格式错误
+	for _, bill := range []string{
+		"",
+		strings.Replace(testFundFlowBill, "`1.00,`101.00", "`1.001,`101.00", 1),
+		strings.Replace(testFundFlowBill, "`2021-01-02 15:04:05", "`2021/01/02", 1),
+		strings.Replace(testFundFlowBill, ",`缴费", "", 1),
+		testFundFlowBill + "`1\n",
+	} {
+		_, err = ScanFundFlowBill(strings.NewReader(bill), func(row *FundFlowBillRow) error {
+			return nil
+		})
+		assert.Error(err, bill)
+	}
+}
+
+func TestParseYuan(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, expect := range map[string]int64{"0": 0, "1": 100, "1.2": 120, "1.23": 123, "-0.01": -1, "12345.60": 1234560} {
+		n, err := parseYuan(s)
+		assert.NoError(err, s)
+		assert.Equal(expect, n, s)
+	}
+	for _, s := range []string{"", "a", "1.", "1.234", "1.-1", "1.+1"} {
+		_, err := parseYuan(s)
+		assert.Error(err, s)
+	}
+}
@@ -0,0 +1,165 @@
+package mchv3
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+const (
+	// AlgorithmAEADAES256GCM 为平台证书和回调通知的加密算法
+	AlgorithmAEADAES256GCM = "AEAD_AES_256_GCM"
+)
+
+// PlatformCertificates 为微信支付平台证书的集合（按序列号索引），用于验证应答和回调通知的签名以及加密敏感信息；
+// 可以并发使用
+type PlatformCertificates struct {
+	mu    sync.RWMutex
+	certs map[string]*x509.Certificate
+}
+
+// NewPlatformCertificates 创建 PlatformCertificates，certs 为已知的平台证书
+func NewPlatformCertificates(certs ...*x509.Certificate) *PlatformCertificates {
+	pc := &PlatformCertificates{
+		certs: map[string]*x509.Certificate{},
+	}
+	for _, cert := range certs {
+		pc.Add(cert)
+	}
+	return pc
+}
+
+// Add 添加平台证书，序列号相同的会被替换
+func (pc *PlatformCertificates) Add(cert *x509.Certificate) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.certs[utils.CertificateSerialNo(cert)] = cert
+}
+
+// Get 返回序列号对应的平台证书，找不到时返回 nil
+func (pc *PlatformCertificates) Get(serialNo string) *x509.Certificate {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.certs[serialNo]
+}
+
+func (pc *PlatformCertificates) all() []*x509.Certificate {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	certs := make([]*x509.Certificate, 0, len(pc.certs))
+	for _, cert := range pc.certs {
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// Newest 返回当前有效的证书中启用时间最晚的一个，用于加密敏感信息；没有有效证书时返回 nil
+func (pc *PlatformCertificates) Newest() *x509.Certificate {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	now := utils.Now()
+	var newest *x509.Certificate
+	for _, cert := range pc.certs {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			continue
+		}
+		if newest == nil || cert.NotBefore.After(newest.NotBefore) {
+			newest = cert
+		}
+	}
+	return newest
+}
+
+// EncryptedResource 为使用 APIv3 密钥加密的数据，见于平台证书下载接口和回调通知
+type EncryptedResource struct {
+	// Algorithm 加密算法，目前只有 AEAD_AES_256_GCM
+	Algorithm string `json:"algorithm"`
+	// Ciphertext base64 编码的密文
+	Ciphertext string `json:"ciphertext"`
+	// AssociatedData 附加数据
+	AssociatedData string `json:"associated_data"`
+	// Nonce 随机串
+	Nonce string `json:"nonce"`
+	// OriginalType 加密前的对象类型（回调通知）
+	OriginalType string `json:"original_type,omitempty"`
+}
+
+// Decrypt 使用 APIv3 密钥解密
+func (resource *EncryptedResource) Decrypt(apiV3Key string) ([]byte, error) {
+	if resource.Algorithm != AlgorithmAEADAES256GCM {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	cipherBytes, err := base64.StdEncoding.DecodeString(resource.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(apiV3Key))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(resource.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, []byte(resource.Nonce), cipherBytes, []byte(resource.AssociatedData))
+}
+
+// certificatesResponse 为平台证书下载接口的应答
+type certificatesResponse struct {
+	Data []struct {
+		SerialNo           string             `json:"serial_no"`
+		EncryptCertificate *EncryptedResource `json:"encrypt_certificate"`
+	} `json:"data"`
+}
+
+// UpdateCertificates 下载平台证书（/v3/certificates）并加入 Certificates() 中；平台证书会轮换，应定期调用。
+//
+// NOTE: 该应答使用其中的证书验证签名，首次下载时并不能防止中间人攻击，对此有要求的应使用 UsePlatformCertificates
+// 预先提供（例如用微信支付提供的平台证书下载工具取得的）证书
+func (client *Client) UpdateCertificates(ctx context.Context) error {
+	resp, body, err := client.send(ctx, http.MethodGet, "/v3/certificates", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	certsResp := &certificatesResponse{}
+	if err := decodeJSON(body, certsResp); err != nil {
+		return err
+	}
+	certs := []*x509.Certificate{}
+	for _, data := range certsResp.Data {
+		if data.EncryptCertificate == nil {
+			return fmt.Errorf("Missing encrypt_certificate of platform certificate %s", data.SerialNo)
+		}
+		certPEMBlock, err := data.EncryptCertificate.Decrypt(client.config.WechatMchAPIv3Key())
+		if err != nil {
+			return fmt.Errorf("Decrypt platform certificate %s: %s", data.SerialNo, err)
+		}
+		cert, err := utils.ParseCertificate(certPEMBlock)
+		if err != nil {
+			return fmt.Errorf("Parse platform certificate %s: %s", data.SerialNo, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	// 使用已知的以及新下载的证书验证应答签名
+	known := NewPlatformCertificates(client.certs.all()...)
+	for _, cert := range certs {
+		known.Add(cert)
+	}
+	if err := verifySignature(known, resp.Header, body); err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		client.certs.Add(cert)
+	}
+	return nil
+}
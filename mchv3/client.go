@@ -0,0 +1,342 @@
+package mchv3
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/conf"
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+const (
+	// AuthorizationSchema 为请求签名的认证类型
+	AuthorizationSchema = "WECHATPAY2-SHA256-RSA2048"
+)
+
+var (
+	// MaxTimestampSkew 为应答/回调通知中的时间戳（Wechatpay-Timestamp）与当前时间允许的最大偏差，防止重放
+	MaxTimestampSkew = 5 * time.Minute
+)
+
+var (
+	ErrConfigMissingMchID        = errors.New("Missing mch id in MchV3Config")
+	ErrConfigMissingCertSerialNo = errors.New("Missing mch cert serial no in MchV3Config")
+	ErrConfigMissingPrivateKey   = errors.New("Missing mch private key in MchV3Config")
+	ErrConfigBadAPIv3Key         = errors.New("APIv3 key in MchV3Config should be 32 bytes")
+)
+
+// Client 为微信支付 APIv3 客户端，可以并发使用
+type Client struct {
+	config  conf.MchV3Config
+	options *Options
+	certs   *PlatformCertificates
+}
+
+// NewClient 创建 Client；平台证书可由 UsePlatformCertificates 提供，否则需先调用 UpdateCertificates 下载
+func NewClient(config conf.MchV3Config, opts ...Option) (*Client, error) {
+	if config.WechatMchID() == "" {
+		return nil, ErrConfigMissingMchID
+	}
+	if config.WechatMchCertSerialNo() == "" {
+		return nil, ErrConfigMissingCertSerialNo
+	}
+	if config.WechatMchPrivateKey() == nil {
+		return nil, ErrConfigMissingPrivateKey
+	}
+	if key := config.WechatMchAPIv3Key(); key != "" && len(key) != 32 {
+		return nil, ErrConfigBadAPIv3Key
+	}
+
+	options, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	certs := options.PlatformCertificates()
+	if certs == nil {
+		certs = NewPlatformCertificates()
+	}
+	return &Client{
+		config:  config,
+		options: options,
+		certs:   certs,
+	}, nil
+}
+
+// Config 返回配置
+func (client *Client) Config() conf.MchV3Config {
+	return client.config
+}
+
+// Certificates 返回平台证书
+func (client *Client) Certificates() *PlatformCertificates {
+	return client.certs
+}
+
+// Do 调用 APIv3 接口，urlPath 为包含查询参数的路径，如 "/v3/bill/tradebill?bill_date=2021-01-01"：
+// req 不为 nil 时编码为 json 作为请求 body；应答的状态码不为 2xx 时返回 *APIError，否则验证应答签名，
+// 并在 resp 不为 nil 时将应答 json 解码至 resp。
+//
+// req/resp 为结构体指针时，其中标记为敏感（`wx:"sensitive"`，见 utils.EncryptSensitiveFields）的字段会被自动处理：
+// 请求中的使用最新的平台证书加密（不修改 req 本身）并设置请求头 Wechatpay-Serial，应答中的使用商户私钥解密
+func (client *Client) Do(ctx context.Context, method, urlPath string, req, resp interface{}) error {
+	var reqBody []byte
+	var header http.Header
+	if req != nil {
+		var serialNo string
+		var err error
+		reqBody, serialNo, err = client.encodeRequest(req)
+		if err != nil {
+			return err
+		}
+		if serialNo != "" {
+			header = http.Header{"Wechatpay-Serial": []string{serialNo}}
+		}
+	}
+
+	httpResp, respBody, err := client.send(ctx, method, urlPath, reqBody, header)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(client.certs, httpResp.Header, respBody); err != nil {
+		return err
+	}
+	if resp == nil || len(respBody) == 0 {
+		return nil
+	}
+	if err := decodeJSON(respBody, resp); err != nil {
+		return err
+	}
+	return client.decryptSensitiveFields(resp)
+}
+
+// encodeRequest 将请求编码为 json，若其中有敏感字段，则在（保留字段 tag 的）深拷贝上加密后再编码，并返回所用平台证书的序列号
+func (client *Client) encodeRequest(req interface{}) ([]byte, string, error) {
+	found, err := utils.HasSensitiveFields(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		body, err := json.Marshal(req)
+		return body, "", err
+	}
+
+	cert := client.certs.Newest()
+	if cert == nil {
+		return nil, "", ErrNoPlatformCertificate
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("Platform certificate %s is not a RSA certificate", utils.CertificateSerialNo(cert))
+	}
+	encrypted, err := utils.CopyAndEncryptSensitiveFields(req, pub)
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := json.Marshal(encrypted)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, utils.CertificateSerialNo(cert), nil
+}
+
+// decryptSensitiveFields 使用商户私钥解密 v 中的敏感字段；v 不是结构体指针时忽略
+func (client *Client) decryptSensitiveFields(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return utils.DecryptSensitiveFields(v, client.config.WechatMchPrivateKey())
+}
+
+// send 发送签名后的请求（body 不为 nil 时为 json）并读取全部应答，应答的状态码不为 2xx 时返回 *APIError；不验证应答签名
+func (client *Client) send(ctx context.Context, method, urlPath string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := client.newRequest(ctx, method, urlPath, bodyReader, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return client.roundTrip(req)
+}
+
+// roundTrip 发送请求并读取全部应答，应答的状态码不为 2xx 时返回 *APIError；不验证应答签名
+func (client *Client) roundTrip(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := client.options.Client().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkStatus(resp, respBody); err != nil {
+		return nil, nil, err
+	}
+	return resp, respBody, nil
+}
+
+// newRequest 创建签名后的请求，签名内容中的 body 为 signBody（例如上传文件时为 meta 部分）；
+// urlPath 也可以是完整的 url（例如账单的下载地址）
+func (client *Client) newRequest(ctx context.Context, method, urlPath string, body io.Reader, signBody []byte) (*http.Request, error) {
+	rawURL := urlPath
+	if strings.HasPrefix(urlPath, "/") {
+		rawURL = client.options.URLBase() + urlPath
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	authorization, err := client.authorization(method, req.URL.RequestURI(), signBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// authorization 返回请求签名，签名内容为：
+//
+//   HTTP 请求方法\n
+//   URL（包含查询参数）\n
+//   时间戳\n
+//   随机串\n
+//   请求 body\n
+func (client *Client) authorization(method, uri string, body []byte) (string, error) {
+	timestamp := strconv.FormatInt(utils.Now().Unix(), 10)
+	nonce := utils.NonceStr(16)
+	message := method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + string(body) + "\n"
+
+	hashed := sha256.Sum256([]byte(message))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, client.config.WechatMchPrivateKey(), crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		`%s mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		AuthorizationSchema,
+		client.config.WechatMchID(),
+		nonce,
+		base64.StdEncoding.EncodeToString(signature),
+		timestamp,
+		client.config.WechatMchCertSerialNo(),
+	), nil
+}
+
+// verifySignature 使用平台证书验证应答或回调通知的签名，签名内容为：
+//
+//   时间戳\n
+//   随机串\n
+//   body\n
+func verifySignature(certs *PlatformCertificates, header http.Header, body []byte) error {
+	serialNo := header.Get("Wechatpay-Serial")
+	signature := header.Get("Wechatpay-Signature")
+	timestamp := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	if serialNo == "" || signature == "" || timestamp == "" || nonce == "" {
+		return ErrMissingSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	if skew := utils.Now().Sub(time.Unix(ts, 0)); skew > MaxTimestampSkew || skew < -MaxTimestampSkew {
+		return ErrTimestampSkew
+	}
+
+	cert := certs.Get(serialNo)
+	if cert == nil {
+		return ErrUnknownPlatformCertificate
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrSignature
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrSignature
+	}
+	hashed := sha256.Sum256([]byte(timestamp + "\n" + nonce + "\n" + string(body) + "\n"))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], signatureBytes); err != nil {
+		return ErrSignature
+	}
+	return nil
+}
+
+// checkStatus 在状态码不为 2xx 时返回 *APIError
+func checkStatus(resp *http.Response, body []byte) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	apiErr := &APIError{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr = &APIError{Message: resp.Status}
+	}
+	apiErr.StatusCode = resp.StatusCode
+	return apiErr
+}
+
+func decodeJSON(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Decode response json: %s", err)
+	}
+	return nil
+}
+
+// withQuery 返回带查询参数的 url 路径，kvs 为 key1, value1, key2, value2 ...，值为空的参数会被忽略
+func withQuery(urlPath string, kvs ...string) string {
+	query := url.Values{}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if kvs[i+1] != "" {
+			query.Set(kvs[i], kvs[i+1])
+		}
+	}
+	if len(query) == 0 {
+		return urlPath
+	}
+	return urlPath + "?" + query.Encode()
+}
+
+// paginate 从 offset 开始以 limit 为页大小依次调用 fetch 查询各页，直至某页为空、不满一页或已达到总数；
+// fetch 返回该页的数量以及总数（为 0 时表示不知道总数）
+func paginate(offset, limit int, fetch func(offset, limit int) (count, total int, err error)) error {
+	for {
+		count, total, err := fetch(offset, limit)
+		if err != nil {
+			return err
+		}
+		offset += count
+		if count == 0 || count < limit || (total > 0 && offset >= total) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package mchv3
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/conf"
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testAPIv3Key        = "0123456789abcdef0123456789abcdef"
+	testMchKey          *rsa.PrivateKey
+	testPlatformKey     *rsa.PrivateKey
+	testPlatformCert    *x509.Certificate
+	testPlatformCertPEM []byte
+	testConfig          *conf.DefaultConfig
+)
+
+func init() {
+	var err error
+	if testMchKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testPlatformKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	testPlatformCert, testPlatformCertPEM = testCertificate(testPlatformKey, 0x5157F09EFDC096DE, time.Now().Add(-time.Hour))
+	testConfig = &conf.DefaultConfig{
+		MchID:           "1900009191",
+		MchCertSerialNo: "1DDE55AD98ED71D6EDD4A4A16996DE7B47773A8C",
+		MchPrivateKey:   testMchKey,
+		MchAPIv3Key:     testAPIv3Key,
+	}
+}
+
+func testCertificate(key *rsa.PrivateKey, serialNo int64, notBefore time.Time) (*x509.Certificate, []byte) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNo),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(5 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testEncrypt 为 EncryptedResource.Decrypt 的逆操作
+func testEncrypt(plain []byte, associatedData string) *EncryptedResource {
+	nonce := utils.NonceStr(6)
+	block, _ := aes.NewCipher([]byte(testAPIv3Key))
+	aead, _ := cipher.NewGCM(block)
+	return &EncryptedResource{
+		Algorithm:      AlgorithmAEADAES256GCM,
+		Ciphertext:     base64.StdEncoding.EncodeToString(aead.Seal(nil, []byte(nonce), plain, []byte(associatedData))),
+		AssociatedData: associatedData,
+		Nonce:          nonce,
+	}
+}
+
+// testSign 使用平台私钥签名，设置应答/回调通知的签名头
+func testSign(header http.Header, body []byte) {
+	timestamp := strconv.FormatInt(utils.Now().Unix(), 10)
+	nonce := utils.NonceStr(16)
+	hashed := sha256.Sum256([]byte(timestamp + "\n" + nonce + "\n" + string(body) + "\n"))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, testPlatformKey, crypto.SHA256, hashed[:])
+	header.Set("Wechatpay-Serial", utils.CertificateSerialNo(testPlatformCert))
+	header.Set("Wechatpay-Timestamp", timestamp)
+	header.Set("Wechatpay-Nonce", nonce)
+	header.Set("Wechatpay-Signature", base64.StdEncoding.EncodeToString(signature))
+}
+
+// testRespond 返回签名后的 json 应答
+func testRespond(w http.ResponseWriter, statusCode int, v interface{}) {
+	body := []byte{}
+	if v != nil {
+		body, _ = json.Marshal(v)
+	}
+	testSign(w.Header(), body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// testVerifyAuthorization 使用商户公钥验证请求签名，signBody 为签名内容中的 body
+func testVerifyAuthorization(req *http.Request, signBody []byte) error {
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, AuthorizationSchema+" ") {
+		return errors.New("bad authorization schema")
+	}
+	params := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(authorization, AuthorizationSchema+" "), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		params[parts[0]] = strings.Trim(parts[1], `"`)
+	}
+	if params["mchid"] != testConfig.MchID || params["serial_no"] != testConfig.MchCertSerialNo {
+		return errors.New("bad mchid or serial_no")
+	}
+	message := req.Method + "\n" + req.URL.RequestURI() + "\n" + params["timestamp"] + "\n" + params["nonce_str"] + "\n" + string(signBody) + "\n"
+	hashed := sha256.Sum256([]byte(message))
+	signature, _ := base64.StdEncoding.DecodeString(params["signature"])
+	return rsa.VerifyPKCS1v15(&testMchKey.PublicKey, crypto.SHA256, hashed[:], signature)
+}
+
+// testServer 将 http.Handler 作为 HTTPClient，并验证 json 请求的签名
+type testServer struct {
+	t       *testing.T
+	handler http.HandlerFunc
+}
+
+func (s *testServer) Do(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		body := []byte{}
+		if req.Body != nil {
+			var err error
+			if body, err = utils.ReadAndReplaceRequestBody(req); err != nil {
+				return nil, err
+			}
+		}
+		if err := testVerifyAuthorization(req, body); err != nil {
+			s.t.Errorf("Verify authorization of %s %s: %s", req.Method, req.URL, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.handler(w, req)
+	return w.Result(), nil
+}
+
+// testNewClient 返回使用 handler 作为服务端的 Client，已载入平台证书
+func testNewClient(t *testing.T, handler http.HandlerFunc) *Client {
+	client, err := NewClient(testConfig,
+		UseClient(&testServer{t: t, handler: handler}),
+		UsePlatformCertificates(NewPlatformCertificates(testPlatformCert)),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func TestNewClient(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		Config    conf.DefaultConfig
+		ExpectErr error
+	}{
+		{conf.DefaultConfig{MchCertSerialNo: "1", MchPrivateKey: testMchKey}, ErrConfigMissingMchID},
+		{conf.DefaultConfig{MchID: "1", MchPrivateKey: testMchKey}, ErrConfigMissingCertSerialNo},
+		{conf.DefaultConfig{MchID: "1", MchCertSerialNo: "1"}, ErrConfigMissingPrivateKey},
+		{conf.DefaultConfig{MchID: "1", MchCertSerialNo: "1", MchPrivateKey: testMchKey, MchAPIv3Key: "short"}, ErrConfigBadAPIv3Key},
+		{conf.DefaultConfig{MchID: "1", MchCertSerialNo: "1", MchPrivateKey: testMchKey}, nil},
+	} {
+		config := testCase.Config
+		_, err := NewClient(&config)
+		assert.Equal(testCase.ExpectErr, err)
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	assert := assert.New(t)
+
+	type testResponse struct {
+		A string `json:"a"`
+	}
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("https://api.mch.weixin.qq.com/v3/test?a=1&b=%E4%B8%AD", r.URL.String())
+		switch r.Method {
+		case http.MethodPost:
+			assert.Equal("application/json", r.Header.Get("Content-Type"))
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(`{"a":"x"}`, string(body))
+			testRespond(w, 200, &testResponse{A: "y"})
+		case http.MethodDelete:
+			testRespond(w, 204, nil)
+		case http.MethodPut:
+			testRespond(w, 400, map[string]interface{}{
+				"code":    "PARAM_ERROR",
+				"message": "参数错误",
+				"detail":  map[string]string{"field": "a"},
+			})
+		case http.MethodPatch:
+			// 签名不正确
+			body := []byte(`{"a":"y"}`)
+			testSign(w.Header(), []byte(`{"a":"z"}`))
+			w.Write(body)
+		default:
+			w.WriteHeader(502)
+			w.Write([]byte("<html>bad gateway</html>"))
+		}
+	})
+	ctx := context.Background()
+	urlPath := withQuery("/v3/test", "b", "中", "a", "1", "c", "")
+
+	resp := &testResponse{}
+	assert.NoError(client.Do(ctx, http.MethodPost, urlPath, &testResponse{A: "x"}, resp))
+	assert.Equal("y", resp.A)
+
+	assert.NoError(client.Do(ctx, http.MethodDelete, urlPath, nil, nil))
+
+	err := client.Do(ctx, http.MethodPut, urlPath, nil, nil)
+	var apiErr *APIError
+	assert.True(errors.As(err, &apiErr))
+	assert.Equal(400, apiErr.StatusCode)
+	assert.Equal("PARAM_ERROR", ErrCodeOf(fmt.Errorf("wrap: %w", err)))
+	assert.JSONEq(`{"field":"a"}`, string(apiErr.Detail))
+
+	assert.Equal(ErrSignature, client.Do(ctx, http.MethodPatch, urlPath, nil, nil))
+
+	err = client.Do(ctx, http.MethodGet, urlPath, nil, nil)
+	assert.True(errors.As(err, &apiErr))
+	assert.Equal(502, apiErr.StatusCode)
+	assert.Equal("", apiErr.Code)
+}
+
+func TestClientDoSensitive(t *testing.T) {
+	assert := assert.New(t)
+
+	type testIdentity struct {
+		Name   string `json:"name" wx:"sensitive"`
+		Remark string `json:"remark"`
+	}
+	type testWrapper struct {
+		Info interface{} `json:"info"`
+	}
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &testIdentity{}
+		switch r.URL.Path {
+		case "/v3/wrapped":
+			// 深拷贝保留了 interface{} 及 map 中结构体字段的 tag
+			wrapper := &struct {
+				Info  *testIdentity            `json:"info"`
+				Infos map[string]*testIdentity `json:"infos"`
+			}{}
+			assert.NoError(json.Unmarshal(body, wrapper))
+			assert.Equal(utils.CertificateSerialNo(testPlatformCert), r.Header.Get("Wechatpay-Serial"))
+			name, err := utils.DecryptOAEP(testPlatformKey, wrapper.Info.Name)
+			assert.NoError(err)
+			assert.Equal("张三", name)
+			if wrapper.Infos != nil {
+				name, err = utils.DecryptOAEP(testPlatformKey, wrapper.Infos["a"].Name)
+				assert.NoError(err)
+				assert.Equal("李四", name)
+			}
+			testRespond(w, 200, nil)
+			return
+		default:
+			assert.NoError(json.Unmarshal(body, req))
+		}
+		if r.URL.Path == "/v3/plain" {
+			assert.Equal("", r.Header.Get("Wechatpay-Serial"))
+			assert.Equal("b", req.Name)
+			testRespond(w, 200, nil)
+			return
+		}
+
+		// 使用平台证书加密，并设置证书序列号
+		assert.Equal(utils.CertificateSerialNo(testPlatformCert), r.Header.Get("Wechatpay-Serial"))
+		name, err := utils.DecryptOAEP(testPlatformKey, req.Name)
+		assert.NoError(err)
+		assert.Equal("张三", name)
+		assert.Equal("remark", req.Remark)
+
+		// 应答中的敏感字段使用商户公钥加密
+		name, _ = utils.EncryptOAEP(&testMchKey.PublicKey, "李四")
+		testRespond(w, 200, &testIdentity{Name: name, Remark: "remark"})
+	})
+	ctx := context.Background()
+
+	req := &testIdentity{Name: "张三", Remark: "remark"}
+	resp := &testIdentity{}
+	assert.NoError(client.Do(ctx, http.MethodPost, "/v3/sensitive", req, resp))
+	assert.Equal(&testIdentity{Name: "张三", Remark: "remark"}, req) // 不修改请求本身
+	assert.Equal(&testIdentity{Name: "李四", Remark: "remark"}, resp)
+
+	// 没有敏感字段时不加密
+	assert.NoError(client.Do(ctx, http.MethodPost, "/v3/plain", map[string]string{"name": "b"}, nil))
+
+	// interface{} 字段中的结构体值
+	wrapper := &testWrapper{Info: testIdentity{Name: "张三"}}
+	assert.NoError(client.Do(ctx, http.MethodPost, "/v3/wrapped", wrapper, nil))
+	assert.Equal(testIdentity{Name: "张三"}, wrapper.Info)
+	// map 中的结构体
+	infos := map[string]interface{}{"info": &testIdentity{Name: "张三"}, "infos": map[string]testIdentity{"a": {Name: "李四"}}}
+	assert.NoError(client.Do(ctx, http.MethodPost, "/v3/wrapped", infos, nil))
+	assert.Equal(&testIdentity{Name: "张三"}, infos["info"])
+	assert.Equal(map[string]testIdentity{"a": {Name: "李四"}}, infos["infos"])
+
+	// 没有平台证书时无法加密
+	client.certs = NewPlatformCertificates()
+	assert.Equal(ErrNoPlatformCertificate, client.Do(ctx, http.MethodPost, "/v3/sensitive", req, nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	assert := assert.New(t)
+
+	now := utils.Now
+	defer func() {
+		utils.Now = now
+	}()
+
+	certs := NewPlatformCertificates(testPlatformCert)
+	body := []byte(`{"a":"b"}`)
+	header := http.Header{}
+	testSign(header, body)
+	assert.NoError(verifySignature(certs, header, body))
+	assert.Equal(ErrSignature, verifySignature(certs, header, []byte(`{"a":"c"}`)))
+	assert.Equal(ErrUnknownPlatformCertificate, verifySignature(NewPlatformCertificates(), header, body))
+
+	// 时间戳偏差过大
+	utils.Now = func() time.Time {
+		return now().Add(MaxTimestampSkew + time.Minute)
+	}
+	assert.Equal(ErrTimestampSkew, verifySignature(certs, header, body))
+	utils.Now = now
+
+	header.Del("Wechatpay-Signature")
+	assert.Equal(ErrMissingSignature, verifySignature(certs, header, body))
+}
+
+func TestUpdateCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	newCert, newCertPEM := testCertificate(newKey, 0x6157F09EFDC096DE, time.Now())
+
+	respond := func(w http.ResponseWriter, certPEMs ...[]byte) {
+		data := []map[string]interface{}{}
+		for i, certPEM := range certPEMs {
+			data = append(data, map[string]interface{}{
+				"serial_no":           fmt.Sprint(i),
+				"encrypt_certificate": testEncrypt(certPEM, "certificate"),
+			})
+		}
+		testRespond(w, 200, map[string]interface{}{"data": data})
+	}
+
+	client, err := NewClient(testConfig, UseClient(&testServer{t: t, handler: func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/certificates", r.URL.Path)
+		respond(w, testPlatformCertPEM, newCertPEM)
+	}}))
+	assert.NoError(err)
+	assert.Nil(client.Certificates().Newest())
+	assert.NoError(client.UpdateCertificates(context.Background()))
+	assert.Equal(testPlatformCert, client.Certificates().Get(utils.CertificateSerialNo(testPlatformCert)))
+	assert.Equal(newCert, client.Certificates().Get(utils.CertificateSerialNo(newCert)))
+	// 启用时间最晚的用于加密
+	assert.Equal(newCert, client.Certificates().Newest())
+
+	// 应答签名无法用其中的证书验证
+	client, err = NewClient(testConfig, UseClient(&testServer{t: t, handler: func(w http.ResponseWriter, r *http.Request) {
+		respond(w, newCertPEM)
+	}}))
+	assert.NoError(err)
+	assert.Equal(ErrUnknownPlatformCertificate, client.UpdateCertificates(context.Background()))
+	assert.Nil(client.Certificates().Get(utils.CertificateSerialNo(newCert)))
+
+	// 解密失败
+	client, err = NewClient(&conf.DefaultConfig{
+		MchID:           testConfig.MchID,
+		MchCertSerialNo: testConfig.MchCertSerialNo,
+		MchPrivateKey:   testConfig.MchPrivateKey,
+		MchAPIv3Key:     strings.Repeat("x", 32),
+	}, UseClient(&testServer{t: t, handler: func(w http.ResponseWriter, r *http.Request) {
+		respond(w, testPlatformCertPEM)
+	}}))
+	assert.NoError(err)
+	assert.Error(client.UpdateCertificates(context.Background()))
+}
+
+func TestEncryptedResource(t *testing.T) {
+	assert := assert.New(t)
+
+	resource := testEncrypt([]byte("hello"), "ad")
+	plain, err := resource.Decrypt(testAPIv3Key)
+	assert.NoError(err)
+	assert.Equal("hello", string(plain))
+
+	resource.AssociatedData = "bad"
+	_, err = resource.Decrypt(testAPIv3Key)
+	assert.Error(err)
+
+	resource.Algorithm = "AEAD_SM4_GCM"
+	_, err = resource.Decrypt(testAPIv3Key)
+	assert.Equal(ErrUnsupportedAlgorithm, err)
+
+}
@@ -0,0 +1,225 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TradeType 为交易类型
+type TradeType string
+
+// TradeState 为交易状态
+type TradeState string
+
+const (
+	TradeTypeJSAPI  TradeType = "JSAPI"  // 公众号支付、小程序支付
+	TradeTypeAPP    TradeType = "APP"    // APP 支付
+	TradeTypeMWEB   TradeType = "MWEB"   // H5 支付
+	TradeTypeNATIVE TradeType = "NATIVE" // Native 支付
+
+	TradeStateSUCCESS    TradeState = "SUCCESS"    // 支付成功
+	TradeStateREFUND     TradeState = "REFUND"     // 转入退款
+	TradeStateNOTPAY     TradeState = "NOTPAY"     // 未支付
+	TradeStateCLOSED     TradeState = "CLOSED"     // 已关闭
+	TradeStateUSERPAYING TradeState = "USERPAYING" // 用户支付中
+	TradeStatePAYERROR   TradeState = "PAYERROR"   // 支付失败
+)
+
+// IsTerminal 返回交易状态是否为终态（不会再变化，转入退款除外）
+func (state TradeState) IsTerminal() bool {
+	switch state {
+	case TradeStateSUCCESS, TradeStateREFUND, TradeStateCLOSED, TradeStatePAYERROR:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	// EventTypeTransactionSuccess 为支付成功通知的类型
+	EventTypeTransactionSuccess = "TRANSACTION.SUCCESS"
+
+	// CombineMaxSubOrders 为合单支付子单的最大数量
+	CombineMaxSubOrders = 50
+)
+
+var (
+	ErrCombineMissingOutTradeNo    = errors.New("Missing combine_out_trade_no in combine transaction request")
+	ErrCombineBadSubOrders         = errors.New("Expect 1-50 sub_orders in combine transaction request")
+	ErrCombineMissingOpenID        = errors.New("Missing combine_payer_info.openid for JSAPI combine transaction")
+	ErrCombineMissingH5Info        = errors.New("Missing scene_info.h5_info for MWEB combine transaction")
+	ErrCombineUnsupportedTradeType = errors.New("Unsupported trade type for combine transaction")
+)
+
+var combineTransactionPaths = map[TradeType]string{
+	TradeTypeJSAPI:  "/v3/combine-transactions/jsapi",
+	TradeTypeAPP:    "/v3/combine-transactions/app",
+	TradeTypeMWEB:   "/v3/combine-transactions/h5",
+	TradeTypeNATIVE: "/v3/combine-transactions/native",
+}
+
+// CombineTransactionRequest 为合单下单请求
+type CombineTransactionRequest struct {
+	CombineAppID      string             `json:"combine_appid"`                // 合单发起方的 appid，必填
+	CombineMchID      string             `json:"combine_mchid"`                // 合单发起方商户号，为空时使用配置中的商户号
+	CombineOutTradeNo string             `json:"combine_out_trade_no"`         // 合单商户订单号，必填
+	SceneInfo         *CombineSceneInfo  `json:"scene_info,omitempty"`         // 场景信息，H5 支付必填
+	SubOrders         []*CombineSubOrder `json:"sub_orders"`                   // 子单信息，最多 50 单，必填
+	CombinePayerInfo  *CombinePayerInfo  `json:"combine_payer_info,omitempty"` // 支付者信息，JSAPI 支付必填
+	TimeStart         *time.Time         `json:"time_start,omitempty"`         // 交易起始时间
+	TimeExpire        *time.Time         `json:"time_expire,omitempty"`        // 交易结束时间
+	NotifyURL         string             `json:"notify_url"`                   // 通知地址，必填
+}
+
+// CombineSceneInfo 为合单支付场景信息
+type CombineSceneInfo struct {
+	DeviceID      string         `json:"device_id,omitempty"` // 商户端设备号
+	PayerClientIP string         `json:"payer_client_ip"`     // 用户终端 IP
+	H5Info        *CombineH5Info `json:"h5_info,omitempty"`   // H5 场景信息
+}
+
+// CombineH5Info 为 H5 场景信息
+type CombineH5Info struct {
+	Type string `json:"type"` // 场景类型，如 iOS、Android、Wap
+}
+
+// CombinePayerInfo 为支付者信息
+type CombinePayerInfo struct {
+	OpenID string `json:"openid"` // 用户在合单发起方 appid 下的 openid
+}
+
+// CombineAmount 为子单金额
+type CombineAmount struct {
+	TotalAmount   int64  `json:"total_amount"`             // 标价金额（分）
+	Currency      string `json:"currency"`                 // 标价币种，如 CNY
+	PayerAmount   int64  `json:"payer_amount,omitempty"`   // 现金支付金额（分），仅应答/通知中返回
+	PayerCurrency string `json:"payer_currency,omitempty"` // 现金支付币种，仅应答/通知中返回
+}
+
+// CombineSettleInfo 为子单结算信息
+type CombineSettleInfo struct {
+	ProfitSharing bool  `json:"profit_sharing,omitempty"` // 是否指定分账
+	SubsidyAmount int64 `json:"subsidy_amount,omitempty"` // 补差金额（分）
+}
+
+// CombineSubOrder 为合单支付的子单，下单时填写前半部分字段，查询/通知时返回全部字段
+type CombineSubOrder struct {
+	MchID       string             `json:"mchid"`                 // 子单商户号
+	SubMchID    string             `json:"sub_mchid,omitempty"`   // 二级商户号（电商收付通）
+	Attach      string             `json:"attach"`                // 附加数据，在查询和通知中原样返回
+	Amount      *CombineAmount     `json:"amount"`                // 订单金额
+	OutTradeNo  string             `json:"out_trade_no"`          // 子单商户订单号
+	Description string             `json:"description,omitempty"` // 商品描述
+	GoodsTag    string             `json:"goods_tag,omitempty"`   // 订单优惠标记
+	SettleInfo  *CombineSettleInfo `json:"settle_info,omitempty"` // 结算信息
+
+	TradeType     TradeType  `json:"trade_type,omitempty"`     // 交易类型
+	TradeState    TradeState `json:"trade_state,omitempty"`    // 交易状态
+	BankType      string     `json:"bank_type,omitempty"`      // 付款银行
+	SuccessTime   *time.Time `json:"success_time,omitempty"`   // 支付完成时间
+	TransactionID string     `json:"transaction_id,omitempty"` // 微信支付订单号
+}
+
+// CombineTransactionResponse 为合单下单的应答，按交易类型返回其中一项
+type CombineTransactionResponse struct {
+	PrepayID string `json:"prepay_id,omitempty"` // 预支付交易会话标识（JSAPI/APP）
+	H5URL    string `json:"h5_url,omitempty"`    // 支付跳转链接（MWEB）
+	CodeURL  string `json:"code_url,omitempty"`  // 二维码链接（NATIVE）
+}
+
+// CombineTransaction 为合单订单，即合单查询的应答以及支付成功通知（TRANSACTION.SUCCESS）中的数据
+type CombineTransaction struct {
+	CombineAppID      string             `json:"combine_appid"`                // 合单发起方的 appid
+	CombineMchID      string             `json:"combine_mchid"`                // 合单发起方商户号
+	CombineOutTradeNo string             `json:"combine_out_trade_no"`         // 合单商户订单号
+	SceneInfo         *CombineSceneInfo  `json:"scene_info,omitempty"`         // 场景信息
+	SubOrders         []*CombineSubOrder `json:"sub_orders"`                   // 子单信息
+	CombinePayerInfo  *CombinePayerInfo  `json:"combine_payer_info,omitempty"` // 支付者信息
+}
+
+// CreateCombineTransaction 合单下单，tradeType 决定下单接口（jsapi/app/h5/native）以及应答中的字段
+func (client *Client) CreateCombineTransaction(ctx context.Context, tradeType TradeType, req *CombineTransactionRequest) (*CombineTransactionResponse, error) {
+	urlPath, ok := combineTransactionPaths[tradeType]
+	if !ok {
+		return nil, ErrCombineUnsupportedTradeType
+	}
+	if req.CombineOutTradeNo == "" {
+		return nil, ErrCombineMissingOutTradeNo
+	}
+	if len(req.SubOrders) == 0 || len(req.SubOrders) > CombineMaxSubOrders {
+		return nil, ErrCombineBadSubOrders
+	}
+	if tradeType == TradeTypeJSAPI && (req.CombinePayerInfo == nil || req.CombinePayerInfo.OpenID == "") {
+		return nil, ErrCombineMissingOpenID
+	}
+	if tradeType == TradeTypeMWEB && (req.SceneInfo == nil || req.SceneInfo.H5Info == nil) {
+		return nil, ErrCombineMissingH5Info
+	}
+	if req.CombineMchID == "" {
+		// 不修改调用方的请求
+		filled := *req
+		filled.CombineMchID = client.config.WechatMchID()
+		req = &filled
+	}
+
+	resp := &CombineTransactionResponse{}
+	if err := client.Do(ctx, http.MethodPost, urlPath, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QueryCombineTransaction 合单查询
+func (client *Client) QueryCombineTransaction(ctx context.Context, combineOutTradeNo string) (*CombineTransaction, error) {
+	if combineOutTradeNo == "" {
+		return nil, ErrCombineMissingOutTradeNo
+	}
+	resp := &CombineTransaction{}
+	if err := client.Do(ctx, http.MethodGet, "/v3/combine-transactions/out-trade-no/"+url.PathEscape(combineOutTradeNo), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CloseCombineTransactionRequest 为合单关单请求
+type CloseCombineTransactionRequest struct {
+	CombineAppID      string                  `json:"combine_appid"` // 合单发起方的 appid，必填
+	CombineOutTradeNo string                  `json:"-"`             // 合单商户订单号，必填
+	SubOrders         []*CloseCombineSubOrder `json:"sub_orders"`    // 需要关闭的子单，必填
+}
+
+// CloseCombineSubOrder 为合单关单请求中的子单
+type CloseCombineSubOrder struct {
+	MchID      string `json:"mchid"`               // 子单商户号
+	SubMchID   string `json:"sub_mchid,omitempty"` // 二级商户号（电商收付通）
+	OutTradeNo string `json:"out_trade_no"`        // 子单商户订单号
+}
+
+// CloseCombineTransaction 合单关单，子单需全部未支付
+func (client *Client) CloseCombineTransaction(ctx context.Context, req *CloseCombineTransactionRequest) error {
+	if req.CombineOutTradeNo == "" {
+		return ErrCombineMissingOutTradeNo
+	}
+	if len(req.SubOrders) == 0 || len(req.SubOrders) > CombineMaxSubOrders {
+		return ErrCombineBadSubOrders
+	}
+	return client.Do(ctx, http.MethodPost, "/v3/combine-transactions/out-trade-no/"+url.PathEscape(req.CombineOutTradeNo)+"/close", req, nil)
+}
+
+// CombineTransactionNotify 创建一个处理合单支付成功通知（TRANSACTION.SUCCESS）的 http.Handler，
+// handler 的约定同 HandleNotification；其它类型的通知会直接返回成功而不调用 handler
+func (client *Client) CombineTransactionNotify(handler func(context.Context, *Notification, *CombineTransaction) error) http.Handler {
+	return client.HandleNotification(func(ctx context.Context, notification *Notification, resource []byte) error {
+		if notification.EventType != EventTypeTransactionSuccess {
+			return nil
+		}
+		transaction := &CombineTransaction{}
+		if err := client.decodeResource(resource, transaction); err != nil {
+			return err
+		}
+		return handler(ctx, notification, transaction)
+	})
+}
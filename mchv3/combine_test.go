@@ -0,0 +1,122 @@
+package mchv3
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCombineTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := []byte{}
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		switch r.URL.Path {
+		case "/v3/combine-transactions/jsapi":
+			req := &CombineTransactionRequest{}
+			assert.NoError(json.Unmarshal(body, req))
+			assert.Equal(testConfig.MchID, req.CombineMchID)
+			assert.Len(req.SubOrders, 2)
+			assert.Equal("1900000109", req.SubOrders[1].MchID)
+			assert.Equal("深圳分店", req.SubOrders[1].Attach)
+			testRespond(w, 200, &CombineTransactionResponse{PrepayID: "wx201410272009395522657a690389285100"})
+		case "/v3/combine-transactions/native":
+			testRespond(w, 200, &CombineTransactionResponse{CodeURL: "weixin://wxpay/bizpayurl?pr=p4lpSuKzz"})
+		case "/v3/combine-transactions/out-trade-no/P20150806125346":
+			testRespond(w, 200, &CombineTransaction{
+				CombineOutTradeNo: "P20150806125346",
+				SubOrders: []*CombineSubOrder{
+					{MchID: "1900000109", OutTradeNo: "20150806125346", TradeState: TradeStateSUCCESS, Amount: &CombineAmount{TotalAmount: 10, PayerAmount: 10, Currency: "CNY"}},
+				},
+			})
+		case "/v3/combine-transactions/out-trade-no/P20150806125346/close":
+			assert.JSONEq(`{"combine_appid":"wxd678efh567hg6787","sub_orders":[{"mchid":"1900000109","out_trade_no":"20150806125346"}]}`, string(body))
+			testRespond(w, 204, nil)
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+
+	req := &CombineTransactionRequest{
+		CombineAppID:      "wxd678efh567hg6787",
+		CombineOutTradeNo: "P20150806125346",
+		SubOrders: []*CombineSubOrder{
+			{MchID: "1900000109", Attach: "北京分店", Amount: &CombineAmount{TotalAmount: 10, Currency: "CNY"}, OutTradeNo: "20150806125346", Description: "腾讯充值中心-QQ会员充值"},
+			{MchID: "1900000109", Attach: "深圳分店", Amount: &CombineAmount{TotalAmount: 20, Currency: "CNY"}, OutTradeNo: "20150806125347", Description: "腾讯充值中心-QQ会员充值"},
+		},
+		CombinePayerInfo: &CombinePayerInfo{OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+		NotifyURL:        "https://yourapp.com/notify",
+	}
+	resp, err := client.CreateCombineTransaction(ctx, TradeTypeJSAPI, req)
+	assert.NoError(err)
+	assert.Equal("wx201410272009395522657a690389285100", resp.PrepayID)
+	assert.Equal("", req.CombineMchID) // 不修改请求本身
+
+	resp, err = client.CreateCombineTransaction(ctx, TradeTypeNATIVE, req)
+	assert.NoError(err)
+	assert.Equal("weixin://wxpay/bizpayurl?pr=p4lpSuKzz", resp.CodeURL)
+
+	for _, testCase := range []struct {
+		TradeType TradeType
+		Req       CombineTransactionRequest
+		ExpectErr error
+	}{
+		{"MICROPAY", *req, ErrCombineUnsupportedTradeType},
+		{TradeTypeAPP, CombineTransactionRequest{}, ErrCombineMissingOutTradeNo},
+		{TradeTypeAPP, CombineTransactionRequest{CombineOutTradeNo: "P1"}, ErrCombineBadSubOrders},
+		{TradeTypeJSAPI, CombineTransactionRequest{CombineOutTradeNo: "P1", SubOrders: req.SubOrders}, ErrCombineMissingOpenID},
+		{TradeTypeMWEB, CombineTransactionRequest{CombineOutTradeNo: "P1", SubOrders: req.SubOrders}, ErrCombineMissingH5Info},
+	} {
+		_, err := client.CreateCombineTransaction(ctx, testCase.TradeType, &testCase.Req)
+		assert.Equal(testCase.ExpectErr, err)
+	}
+
+	transaction, err := client.QueryCombineTransaction(ctx, "P20150806125346")
+	assert.NoError(err)
+	assert.True(transaction.SubOrders[0].TradeState.IsTerminal())
+	assert.Equal(int64(10), transaction.SubOrders[0].Amount.PayerAmount)
+
+	assert.NoError(client.CloseCombineTransaction(ctx, &CloseCombineTransactionRequest{
+		CombineAppID:      "wxd678efh567hg6787",
+		CombineOutTradeNo: "P20150806125346",
+		SubOrders:         []*CloseCombineSubOrder{{MchID: "1900000109", OutTradeNo: "20150806125346"}},
+	}))
+	assert.Equal(ErrCombineBadSubOrders, client.CloseCombineTransaction(ctx, &CloseCombineTransactionRequest{CombineOutTradeNo: "P20150806125346"}))
+}
+
+func TestCombineTransactionNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, nil)
+	transactions := []*CombineTransaction{}
+	handler := client.CombineTransactionNotify(func(ctx context.Context, notification *Notification, transaction *CombineTransaction) error {
+		transactions = append(transactions, transaction)
+		return nil
+	})
+
+	status, code := testServeNotification(handler, testNotification(EventTypeTransactionSuccess, map[string]interface{}{
+		"combine_out_trade_no": "P20150806125346",
+		"sub_orders": []map[string]interface{}{
+			{"mchid": "1900000109", "out_trade_no": "20150806125346", "trade_state": "SUCCESS", "attach": "北京分店", "success_time": "2015-05-20T13:29:35+08:00", "amount": map[string]interface{}{"total_amount": 10, "payer_amount": 10, "currency": "CNY", "payer_currency": "CNY"}},
+		},
+	}))
+	assert.Equal(200, status)
+	assert.Equal("SUCCESS", code)
+
+	// 其它类型的通知不调用 handler
+	status, _ = testServeNotification(handler, testNotification("TRANSACTION.OTHER", map[string]interface{}{}))
+	assert.Equal(200, status)
+
+	assert.Len(transactions, 1)
+	assert.Equal("北京分店", transactions[0].SubOrders[0].Attach)
+	assert.Equal(TradeStateSUCCESS, transactions[0].SubOrders[0].TradeState)
+	assert.Equal(int64(1432099775), transactions[0].SubOrders[0].SuccessTime.Unix())
+}
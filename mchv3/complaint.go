@@ -0,0 +1,316 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ComplaintState 为投诉单状态
+type ComplaintState string
+
+// ComplaintRefundAction 为退款审批动作
+type ComplaintRefundAction string
+
+const (
+	ComplaintStatePENDING    ComplaintState = "PENDING"    // 待处理
+	ComplaintStatePROCESSING ComplaintState = "PROCESSING" // 处理中
+	ComplaintStatePROCESSED  ComplaintState = "PROCESSED"  // 已处理完成
+
+	ComplaintRefundActionREJECT  ComplaintRefundAction = "REJECT"  // 拒绝退款
+	ComplaintRefundActionAPPROVE ComplaintRefundAction = "APPROVE" // 同意退款
+)
+
+const (
+	// EventTypeComplaintCreate 为用户提交投诉的通知类型
+	EventTypeComplaintCreate = "COMPLAINT.CREATE"
+	// EventTypeComplaintStateChange 为投诉单状态变化（如用户继续投诉、撤诉）的通知类型
+	EventTypeComplaintStateChange = "COMPLAINT.STATE_CHANGE"
+
+	// ComplaintMaxQueryLimit 为查询投诉单列表、协商历史时每页的最大数量
+	ComplaintMaxQueryLimit = 50
+)
+
+var (
+	ErrComplaintMissingDate = errors.New("Missing begin_date/end_date in ComplaintListRequest")
+	ErrComplaintMissingID   = errors.New("Missing complaint_id")
+	ErrComplaintMissingURL  = errors.New("Missing notification url")
+)
+
+// ComplaintListRequest 为查询投诉单列表请求
+type ComplaintListRequest struct {
+	// ----- 必填字段 -----
+	BeginDate string // 开始日期，格式 2006-01-02，与结束日期相差不超过 30 天
+	EndDate   string // 结束日期，格式 2006-01-02
+
+	// ----- 选填字段 -----
+	ComplaintedMchID string // 被诉商户号（服务商查询子商户的投诉）
+	Offset           int    // 起始位置，从 0 开始
+	Limit            int    // 每页的最大数量，最大为 ComplaintMaxQueryLimit，为 0 时为 10
+}
+
+// Complaint 为投诉单详情
+type Complaint struct {
+	ComplaintID           string                `json:"complaint_id"`                         // 投诉单号
+	ComplaintTime         time.Time             `json:"complaint_time"`                       // 投诉时间
+	ComplaintDetail       string                `json:"complaint_detail"`                     // 投诉详情
+	ComplaintedMchID      string                `json:"complainted_mchid,omitempty"`          // 被诉商户号
+	ComplaintState        ComplaintState        `json:"complaint_state"`                      // 投诉单状态
+	PayerPhone            string                `json:"payer_phone,omitempty" wx:"sensitive"` // 投诉人联系方式，已自动解密
+	PayerOpenID           string                `json:"payer_openid,omitempty"`               // 投诉人 openid
+	ComplaintOrderInfo    []*ComplaintOrderInfo `json:"complaint_order_info,omitempty"`       // 投诉单关联订单信息
+	ComplaintFullRefunded bool                  `json:"complaint_full_refunded"`              // 投诉单是否已全额退款
+	IncomingUserResponse  bool                  `json:"incoming_user_response"`               // 是否有待回复的用户留言
+	ProblemDescription    string                `json:"problem_description"`                  // 问题描述
+	UserComplaintTimes    int                   `json:"user_complaint_times"`                 // 用户投诉次数
+	ComplaintMediaList    []*ComplaintMedia     `json:"complaint_media_list,omitempty"`       // 投诉资料列表
+	ProblemType           string                `json:"problem_type,omitempty"`               // 问题类型：REFUND 申请退款，SERVICE_NOT_WORK 服务权益未生效，OTHERS 其他
+	ApplyRefundAmount     int64                 `json:"apply_refund_amount,omitempty"`        // 申请退款金额（分）
+	UserTagList           []string              `json:"user_tag_list,omitempty"`              // 用户标签，如 TRUSTED
+}
+
+// ComplaintOrderInfo 为投诉单关联订单信息
+type ComplaintOrderInfo struct {
+	TransactionID string `json:"transaction_id"` // 微信订单号
+	OutTradeNo    string `json:"out_trade_no"`   // 商户订单号
+	Amount        int64  `json:"amount"`         // 订单金额（分）
+}
+
+// ComplaintMedia 为投诉资料
+type ComplaintMedia struct {
+	MediaType string   `json:"media_type"` // 媒体文件业务类型：USER_COMPLAINT_IMAGE 用户投诉图片，OPERATION_IMAGE 操作流水图片
+	MediaURL  []string `json:"media_url"`  // 媒体文件请求 url，需使用带签名的请求下载，见 DownloadComplaintImage
+}
+
+// ComplaintNegotiation 为投诉协商历史中的一条记录
+type ComplaintNegotiation struct {
+	LogID              string          `json:"log_id"`                         // 操作流水号
+	Operator           string          `json:"operator"`                       // 操作人，如 投诉人、商家、微信支付客服
+	OperateTime        time.Time       `json:"operate_time"`                   // 操作时间
+	OperateType        string          `json:"operate_type"`                   // 操作类型，如 USER_CREATE_COMPLAINT、MERCHANT_RESPONSE
+	OperateDetails     string          `json:"operate_details"`                // 操作内容
+	ImageList          []string        `json:"image_list,omitempty"`           // 图片凭证
+	ComplaintMediaList *ComplaintMedia `json:"complaint_media_list,omitempty"` // 操作资料
+}
+
+// ComplaintResponseRequest 为回复用户请求
+type ComplaintResponseRequest struct {
+	ComplaintedMchID string   `json:"complainted_mchid"`         // 被诉商户号，为空时使用配置中的商户号
+	ResponseContent  string   `json:"response_content"`          // 回复内容，必填
+	ResponseImages   []string `json:"response_images,omitempty"` // 回复图片，为 UploadComplaintImage 返回的 media_id
+	JumpURL          string   `json:"jump_url,omitempty"`        // 跳转链接
+	JumpURLText      string   `json:"jump_url_text,omitempty"`   // 跳转链接文案
+}
+
+// ComplaintRefundProgressRequest 为更新退款审批结果请求
+type ComplaintRefundProgressRequest struct {
+	Action          ComplaintRefundAction `json:"action"`                      // 审批动作，必填
+	LaunchRefundDay int                   `json:"launch_refund_day,omitempty"` // 预计发起退款时间（天），同意退款时填写
+	RejectReason    string                `json:"reject_reason,omitempty"`     // 拒绝退款原因，拒绝退款时必填
+	RejectMediaList []string              `json:"reject_media_list,omitempty"` // 拒绝退款的举证图片，为 UploadComplaintImage 返回的 media_id
+	Remark          string                `json:"remark,omitempty"`            // 备注
+}
+
+// ComplaintNotificationConfig 为投诉通知回调地址
+type ComplaintNotificationConfig struct {
+	MchID string `json:"mchid,omitempty"` // 商户号
+	URL   string `json:"url"`             // 通知地址
+}
+
+// ComplaintEvent 为投诉通知中的数据
+type ComplaintEvent struct {
+	ComplaintID string `json:"complaint_id"` // 投诉单号，需调用 QueryComplaint 查询详情
+	ActionType  string `json:"action_type"`  // 动作类型，如 CREATE_COMPLAINT、CONTINUE_COMPLAINT、USER_RESPONSE、RESPONSE_BY_PLATFORM、SELLER_REFUND、MERCHANT_RESPONSE、MERCHANT_CONFIRM_COMPLETE
+}
+
+// ScanComplaints 从 req.Offset 开始分页查询投诉单列表，对每个投诉单调用 fn，fn 返回错误时停止并返回该错误
+func (client *Client) ScanComplaints(ctx context.Context, req *ComplaintListRequest, fn func(*Complaint) error) error {
+	if req.BeginDate == "" || req.EndDate == "" {
+		return ErrComplaintMissingDate
+	}
+	return paginate(req.Offset, complaintQueryLimit(req.Limit), func(offset, limit int) (int, int, error) {
+		page := &struct {
+			Data       []*Complaint `json:"data"`
+			TotalCount int          `json:"total_count"`
+		}{}
+		if err := client.Do(ctx, http.MethodGet, withQuery(
+			"/v3/merchant-service/complaints-v2",
+			"limit", strconv.Itoa(limit),
+			"offset", strconv.Itoa(offset),
+			"begin_date", req.BeginDate,
+			"end_date", req.EndDate,
+			"complainted_mchid", req.ComplaintedMchID,
+		), nil, page); err != nil {
+			return 0, 0, err
+		}
+		for _, complaint := range page.Data {
+			if err := fn(complaint); err != nil {
+				return 0, 0, err
+			}
+		}
+		return len(page.Data), page.TotalCount, nil
+	})
+}
+
+// QueryComplaint 查询投诉单详情
+func (client *Client) QueryComplaint(ctx context.Context, complaintID string) (*Complaint, error) {
+	if complaintID == "" {
+		return nil, ErrComplaintMissingID
+	}
+	resp := &Complaint{}
+	if err := client.Do(ctx, http.MethodGet, complaintPath(complaintID, ""), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ScanComplaintNegotiations 分页查询投诉协商历史，对每条记录调用 fn，fn 返回错误时停止并返回该错误
+func (client *Client) ScanComplaintNegotiations(ctx context.Context, complaintID string, fn func(*ComplaintNegotiation) error) error {
+	if complaintID == "" {
+		return ErrComplaintMissingID
+	}
+	return paginate(0, ComplaintMaxQueryLimit, func(offset, limit int) (int, int, error) {
+		page := &struct {
+			Data       []*ComplaintNegotiation `json:"data"`
+			TotalCount int                     `json:"total_count"`
+		}{}
+		if err := client.Do(ctx, http.MethodGet, withQuery(
+			complaintPath(complaintID, "/negotiation-historys"),
+			"limit", strconv.Itoa(limit),
+			"offset", strconv.Itoa(offset),
+		), nil, page); err != nil {
+			return 0, 0, err
+		}
+		for _, negotiation := range page.Data {
+			if err := fn(negotiation); err != nil {
+				return 0, 0, err
+			}
+		}
+		return len(page.Data), page.TotalCount, nil
+	})
+}
+
+// UploadComplaintImage 上传回复用户或拒绝退款时的举证图片（jpg/bmp/png，不超过 2M），返回 media_id
+func (client *Client) UploadComplaintImage(ctx context.Context, filename string, r io.Reader) (string, error) {
+	return client.UploadMedia(ctx, ComplaintImageUploadPath, filename, r)
+}
+
+// DownloadComplaintImage 下载投诉资料中的图片（ComplaintMedia.MediaURL），调用方需负责 Close
+func (client *Client) DownloadComplaintImage(ctx context.Context, mediaURL string) (io.ReadCloser, error) {
+	req, err := client.newRequest(ctx, http.MethodGet, mediaURL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.options.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, checkStatus(resp, body)
+	}
+	return resp.Body, nil
+}
+
+// RespondComplaint 回复用户
+func (client *Client) RespondComplaint(ctx context.Context, complaintID string, req *ComplaintResponseRequest) error {
+	if complaintID == "" {
+		return ErrComplaintMissingID
+	}
+	if req.ComplaintedMchID == "" {
+		// 不修改调用方的请求
+		filled := *req
+		filled.ComplaintedMchID = client.config.WechatMchID()
+		req = &filled
+	}
+	return client.Do(ctx, http.MethodPost, complaintPath(complaintID, "/response"), req, nil)
+}
+
+// CompleteComplaint 反馈处理完成，complaintedMchID 为被诉商户号，为空时使用配置中的商户号
+func (client *Client) CompleteComplaint(ctx context.Context, complaintID, complaintedMchID string) error {
+	if complaintID == "" {
+		return ErrComplaintMissingID
+	}
+	if complaintedMchID == "" {
+		complaintedMchID = client.config.WechatMchID()
+	}
+	return client.Do(ctx, http.MethodPost, complaintPath(complaintID, "/complete"), map[string]string{
+		"complainted_mchid": complaintedMchID,
+	}, nil)
+}
+
+// UpdateComplaintRefundProgress 更新退款审批结果（问题类型为申请退款的投诉单）
+func (client *Client) UpdateComplaintRefundProgress(ctx context.Context, complaintID string, req *ComplaintRefundProgressRequest) error {
+	if complaintID == "" {
+		return ErrComplaintMissingID
+	}
+	return client.Do(ctx, http.MethodPost, complaintPath(complaintID, "/update-refund-progress"), req, nil)
+}
+
+// CreateComplaintNotification 创建投诉通知回调地址
+func (client *Client) CreateComplaintNotification(ctx context.Context, notifyURL string) (*ComplaintNotificationConfig, error) {
+	return client.complaintNotification(ctx, http.MethodPost, notifyURL)
+}
+
+// QueryComplaintNotification 查询投诉通知回调地址
+func (client *Client) QueryComplaintNotification(ctx context.Context) (*ComplaintNotificationConfig, error) {
+	return client.complaintNotification(ctx, http.MethodGet, "")
+}
+
+// UpdateComplaintNotification 更新投诉通知回调地址
+func (client *Client) UpdateComplaintNotification(ctx context.Context, notifyURL string) (*ComplaintNotificationConfig, error) {
+	return client.complaintNotification(ctx, http.MethodPut, notifyURL)
+}
+
+// DeleteComplaintNotification 删除投诉通知回调地址
+func (client *Client) DeleteComplaintNotification(ctx context.Context) error {
+	return client.Do(ctx, http.MethodDelete, "/v3/merchant-service/complaint-notifications", nil, nil)
+}
+
+func (client *Client) complaintNotification(ctx context.Context, method, notifyURL string) (*ComplaintNotificationConfig, error) {
+	var req interface{}
+	if method != http.MethodGet {
+		if notifyURL == "" {
+			return nil, ErrComplaintMissingURL
+		}
+		req = &ComplaintNotificationConfig{URL: notifyURL}
+	}
+	resp := &ComplaintNotificationConfig{}
+	if err := client.Do(ctx, method, "/v3/merchant-service/complaint-notifications", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ComplaintNotify 创建一个处理投诉通知（COMPLAINT.*）的 http.Handler，handler 的约定同 HandleNotification；
+// 通知中只有投诉单号和动作类型，需要时调用 QueryComplaint 查询详情
+func (client *Client) ComplaintNotify(handler func(context.Context, *Notification, *ComplaintEvent) error) http.Handler {
+	return client.HandleNotification(func(ctx context.Context, notification *Notification, resource []byte) error {
+		event := &ComplaintEvent{}
+		if err := client.decodeResource(resource, event); err != nil {
+			return err
+		}
+		return handler(ctx, notification, event)
+	})
+}
+
+func complaintPath(complaintID, suffix string) string {
+	return "/v3/merchant-service/complaints-v2/" + url.PathEscape(complaintID) + suffix
+}
+
+func complaintQueryLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return 10
+	case limit > ComplaintMaxQueryLimit:
+		return ComplaintMaxQueryLimit
+	default:
+		return limit
+	}
+}
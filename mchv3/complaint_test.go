@@ -0,0 +1,149 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanComplaints(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []string{}
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/merchant-service/complaints-v2", r.URL.Path)
+		queries = append(queries, r.URL.RawQuery)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		data := []*Complaint{}
+		for i := offset; i < offset+2 && i < 3; i++ {
+			phone, _ := utils.EncryptOAEP(&testMchKey.PublicKey, "1380000000"+strconv.Itoa(i))
+			data = append(data, &Complaint{ComplaintID: strconv.Itoa(i), ComplaintState: ComplaintStatePENDING, PayerPhone: phone})
+		}
+		testRespond(w, 200, map[string]interface{}{"data": data, "limit": 2, "offset": offset, "total_count": 3})
+	})
+	ctx := context.Background()
+
+	assert.Equal(ErrComplaintMissingDate, client.ScanComplaints(ctx, &ComplaintListRequest{}, nil))
+
+	phones := []string{}
+	assert.NoError(client.ScanComplaints(ctx, &ComplaintListRequest{BeginDate: "2021-01-01", EndDate: "2021-01-30", Limit: 2}, func(complaint *Complaint) error {
+		phones = append(phones, complaint.PayerPhone)
+		return nil
+	}))
+	assert.Equal([]string{"13800000000", "13800000001", "13800000002"}, phones)
+	// 已达到总数时不再查询下一页
+	assert.Equal([]string{
+		"begin_date=2021-01-01&end_date=2021-01-30&limit=2&offset=0",
+		"begin_date=2021-01-01&end_date=2021-01-30&limit=2&offset=2",
+	}, queries)
+
+	// fn 返回错误时停止
+	fnErr := errors.New("fn error")
+	queries = queries[:0]
+	assert.Equal(fnErr, client.ScanComplaints(ctx, &ComplaintListRequest{BeginDate: "2021-01-01", EndDate: "2021-01-30", Limit: 2}, func(complaint *Complaint) error {
+		return fnErr
+	}))
+	assert.Len(queries, 1)
+}
+
+func TestComplaintActions(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := []byte{}
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v3/merchant-service/complaints-v2/200201820200101080076610000/negotiation-historys":
+			assert.Equal("limit=50&offset=0", r.URL.RawQuery)
+			testRespond(w, 200, map[string]interface{}{
+				"data":        []*ComplaintNegotiation{{LogID: "300285320210322170000071077", OperateType: "USER_CREATE_COMPLAINT"}},
+				"total_count": 1,
+			})
+		case "POST /v3/merchant-service/complaints-v2/200201820200101080076610000/response":
+			assert.JSONEq(`{"complainted_mchid":"1900009191","response_content":"已与用户沟通解决","response_images":["file23578_21798531.jpg"]}`, string(body))
+			testRespond(w, 204, nil)
+		case "POST /v3/merchant-service/complaints-v2/200201820200101080076610000/complete":
+			assert.JSONEq(`{"complainted_mchid":"1900012181"}`, string(body))
+			testRespond(w, 204, nil)
+		case "POST /v3/merchant-service/complaints-v2/200201820200101080076610000/update-refund-progress":
+			assert.JSONEq(`{"action":"APPROVE","launch_refund_day":3}`, string(body))
+			testRespond(w, 204, nil)
+		case "POST /v3/merchant-service/complaint-notifications", "PUT /v3/merchant-service/complaint-notifications":
+			assert.JSONEq(`{"url":"https://www.xxx.com/notify"}`, string(body))
+			testRespond(w, 200, &ComplaintNotificationConfig{MchID: "1900009191", URL: "https://www.xxx.com/notify"})
+		case "GET /v3/merchant-service/complaint-notifications":
+			testRespond(w, 200, &ComplaintNotificationConfig{MchID: "1900009191", URL: "https://www.xxx.com/notify"})
+		case "DELETE /v3/merchant-service/complaint-notifications":
+			testRespond(w, 204, nil)
+		case "GET /v3/merchant-service/images/ChsyMDAwMDAwMjAyMTA0MjIxMjM0NTY3ODk=":
+			w.Write([]byte("image"))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+	complaintID := "200201820200101080076610000"
+
+	negotiations := []*ComplaintNegotiation{}
+	assert.NoError(client.ScanComplaintNegotiations(ctx, complaintID, func(negotiation *ComplaintNegotiation) error {
+		negotiations = append(negotiations, negotiation)
+		return nil
+	}))
+	assert.Len(negotiations, 1)
+	assert.Equal("USER_CREATE_COMPLAINT", negotiations[0].OperateType)
+
+	assert.NoError(client.RespondComplaint(ctx, complaintID, &ComplaintResponseRequest{
+		ResponseContent: "已与用户沟通解决",
+		ResponseImages:  []string{"file23578_21798531.jpg"},
+	}))
+	assert.NoError(client.CompleteComplaint(ctx, complaintID, "1900012181"))
+	assert.NoError(client.UpdateComplaintRefundProgress(ctx, complaintID, &ComplaintRefundProgressRequest{
+		Action:          ComplaintRefundActionAPPROVE,
+		LaunchRefundDay: 3,
+	}))
+	assert.Equal(ErrComplaintMissingID, client.CompleteComplaint(ctx, "", ""))
+
+	config, err := client.CreateComplaintNotification(ctx, "https://www.xxx.com/notify")
+	assert.NoError(err)
+	assert.Equal("https://www.xxx.com/notify", config.URL)
+	_, err = client.UpdateComplaintNotification(ctx, "")
+	assert.Equal(ErrComplaintMissingURL, err)
+	_, err = client.UpdateComplaintNotification(ctx, "https://www.xxx.com/notify")
+	assert.NoError(err)
+	config, err = client.QueryComplaintNotification(ctx)
+	assert.NoError(err)
+	assert.Equal("1900009191", config.MchID)
+	assert.NoError(client.DeleteComplaintNotification(ctx))
+
+	r, err := client.DownloadComplaintImage(ctx, "https://api.mch.weixin.qq.com/v3/merchant-service/images/ChsyMDAwMDAwMjAyMTA0MjIxMjM0NTY3ODk=")
+	assert.NoError(err)
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal("image", string(data))
+}
+
+func TestComplaintNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, nil)
+	events := []*ComplaintEvent{}
+	handler := client.ComplaintNotify(func(ctx context.Context, notification *Notification, event *ComplaintEvent) error {
+		assert.Equal(EventTypeComplaintCreate, notification.EventType)
+		events = append(events, event)
+		return nil
+	})
+	status, _ := testServeNotification(handler, testNotification(EventTypeComplaintCreate, &ComplaintEvent{
+		ComplaintID: "200201820200101080076610000",
+		ActionType:  "CREATE_COMPLAINT",
+	}))
+	assert.Equal(200, status)
+	assert.Equal([]*ComplaintEvent{{ComplaintID: "200201820200101080076610000", ActionType: "CREATE_COMPLAINT"}}, events)
+}
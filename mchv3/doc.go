@@ -0,0 +1,47 @@
+// Package mchv3 包含微信支付 APIv3（json）接口的 sdk.
+//
+// ##### 接口形式 ########################################################
+//
+// 与 mch 模块不同，APIv3 需要维护平台证书，因此各接口为 Client 的方法，一般形如：
+//
+//   (client *Client) X(ctx context.Context, req *XRequest) (resp *XResponse, err error)
+//
+// 创建 Client 后需先调用 UpdateCertificates 下载平台证书（之后应定期调用以便在证书轮换时更新），
+// 或使用 UsePlatformCertificates 选项提供已知的平台证书：
+//
+//   client, err := mchv3.NewClient(&conf.DefaultConfig{
+//   	MchID:           "1900000001",
+//   	MchCertSerialNo: "...",
+//   	MchPrivateKey:   privateKey,
+//   	MchAPIv3Key:     "...",
+//   })
+//   if err != nil {
+//   	...
+//   }
+//   if err := client.UpdateCertificates(ctx); err != nil {
+//   	...
+//   }
+//
+// 本包尚未封装的接口可直接使用 Client.Do 调用
+//
+// ##### 签名 ########################################################
+//
+// 请求使用商户私钥以 WECHATPAY2-SHA256-RSA2048 签名（Authorization 请求头），应答和回调通知使用平台证书验证签名，
+// 签名不正确时返回 ErrSignature 等错误
+//
+// ##### 回调通知 ########################################################
+//
+// 回调通知由 Client 的方法创建 http.Handler 处理（如 CombineTransactionNotify），验证签名并使用 APIv3 密钥解密通知数据后
+// 调用传入的 handler，handler 返回 nil 时应答成功，否则应答失败（微信之后会重发通知）；同一事件可能重复通知，
+// 可使用 Notification.ID 去重。尚未封装的通知类型可使用 HandleNotification 处理
+//
+// ##### 敏感信息 ########################################################
+//
+// 请求/应答结构体中标记为 `wx:"sensitive"` 的字段（如姓名、证件号、手机号）由 Client.Do 自动处理：
+// 请求中的（包括 interface{} 字段及 map 中的）在请求的深拷贝上使用平台证书加密，并设置请求头 Wechatpay-Serial，
+// 不会修改请求本身；应答中的使用商户私钥解密
+//
+// ##### 错误 ########################################################
+//
+// 应答状态码不为 2xx 时返回 *APIError，其 Code 为微信支付返回的错误码（如 "PARAM_ERROR"）
+package mchv3
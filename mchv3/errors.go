@@ -0,0 +1,54 @@
+package mchv3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoPlatformCertificate      = errors.New("No platform certificate available, call Client.UpdateCertificates first")
+	ErrUnknownPlatformCertificate = errors.New("Unknown platform certificate serial no")
+	ErrMissingSignature           = errors.New("Missing Wechatpay-Signature/Timestamp/Nonce/Serial header")
+	ErrSignature                  = errors.New("Wechatpay signature mismatch")
+	ErrTimestampSkew              = errors.New("Wechatpay timestamp is too far from now")
+	ErrUnsupportedAlgorithm       = errors.New("Unsupported encryption algorithm, expect AEAD_AES_256_GCM")
+)
+
+// APIError 是 APIv3 接口返回的错误，即应答状态码不为 2xx
+type APIError struct {
+	// StatusCode 状态码
+	StatusCode int `json:"-"`
+	// Code 详细错误码，如 "PARAM_ERROR"
+	Code string `json:"code"`
+	// Message 错误描述
+	Message string `json:"message"`
+	// Detail 错误详情（例如出错的字段），可能为空
+	Detail json.RawMessage `json:"detail,omitempty"`
+}
+
+// Error 满足 error 接口
+func (err *APIError) Error() string {
+	return fmt.Sprintf("APIError(status=%d code=%s message=%s)", err.StatusCode, err.Code, err.Message)
+}
+
+// IsRetryable 当错误可使用原参数重试（系统错误、频率限制）时返回 true
+func (err *APIError) IsRetryable() bool {
+	if err.StatusCode >= 500 || err.StatusCode == 429 {
+		return true
+	}
+	switch err.Code {
+	case "SYSTEM_ERROR", "FREQUENCY_LIMITED", "RATELIMIT_EXCEEDED":
+		return true
+	}
+	return false
+}
+
+// ErrCodeOf 返回 err（或其包装的错误）中 APIError 的错误码，若不是 APIError 则返回空
+func ErrCodeOf(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,336 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CouponStatus 为代金券状态
+type CouponStatus string
+
+const (
+	CouponStatusSENDED  CouponStatus = "SENDED"  // 可用
+	CouponStatusUSED    CouponStatus = "USED"    // 已实扣
+	CouponStatusEXPIRED CouponStatus = "EXPIRED" // 已过期
+)
+
+const (
+	// EventTypeCouponUse 为代金券核销的通知类型
+	EventTypeCouponUse = "COUPON.USE"
+
+	// FavorMaxQueryLimit 为查询用户代金券时每页的最大数量
+	FavorMaxQueryLimit = 10
+)
+
+var (
+	ErrFavorMissingStockID      = errors.New("Missing stock_id")
+	ErrFavorMissingOpenID       = errors.New("Missing openid")
+	ErrFavorMissingOutRequestNo = errors.New("Missing out_request_no")
+	ErrFavorMissingAppID        = errors.New("Missing appid")
+	ErrFavorMissingNotifyURL    = errors.New("Missing notify_url")
+)
+
+// CouponStockRequest 为创建代金券批次请求
+type CouponStockRequest struct {
+	StockName          string              `json:"stock_name"`             // 批次名称，必填
+	Comment            string              `json:"comment,omitempty"`      // 批次备注
+	BelongMerchant     string              `json:"belong_merchant"`        // 归属商户号，为空时使用配置中的商户号
+	AvailableBeginTime time.Time           `json:"available_begin_time"`   // 可用时间开始，必填
+	AvailableEndTime   time.Time           `json:"available_end_time"`     // 可用时间结束，必填
+	StockUseRule       *CouponStockUseRule `json:"stock_use_rule"`         // 发放规则，必填
+	PatternInfo        *CouponPatternInfo  `json:"pattern_info,omitempty"` // 样式设置
+	CouponUseRule      *CouponUseRule      `json:"coupon_use_rule"`        // 核销规则，必填
+	NoCash             bool                `json:"no_cash"`                // 是否为营销补差（免充值）
+	StockType          string              `json:"stock_type"`             // 批次类型，目前只有 NORMAL 固定面额满减券，为空时为 NORMAL
+	OutRequestNo       string              `json:"out_request_no"`         // 商户单据号，必填，用于幂等
+}
+
+// CouponStockUseRule 为代金券批次发放规则
+type CouponStockUseRule struct {
+	MaxCoupons         int   `json:"max_coupons"`                 // 发放总上限
+	MaxAmount          int64 `json:"max_amount"`                  // 总预算（分）
+	MaxAmountByDay     int64 `json:"max_amount_by_day,omitempty"` // 单天预算发放上限（分）
+	MaxCouponsPerUser  int   `json:"max_coupons_per_user"`        // 单个用户可领个数
+	NaturalPersonLimit bool  `json:"natural_person_limit"`        // 是否开启自然人限制
+	PreventAPIAbuse    bool  `json:"prevent_api_abuse"`           // 是否开启防刷拦截
+}
+
+// CouponPatternInfo 为代金券样式设置
+type CouponPatternInfo struct {
+	Description     string `json:"description"`                // 使用说明
+	MerchantLogo    string `json:"merchant_logo,omitempty"`    // 商户 logo
+	MerchantName    string `json:"merchant_name,omitempty"`    // 品牌名称
+	BackgroundColor string `json:"background_color,omitempty"` // 背景颜色，如 COLOR010
+	CouponImage     string `json:"coupon_image,omitempty"`     // 券详情图片
+}
+
+// CouponUseRule 为代金券核销规则
+type CouponUseRule struct {
+	FixedNormalCoupon  *FixedNormalCoupon `json:"fixed_normal_coupon,omitempty"` // 固定面额满减券使用规则
+	GoodsTag           []string           `json:"goods_tag,omitempty"`           // 订单优惠标记
+	TradeType          []string           `json:"trade_type,omitempty"`          // 支付方式，如 MICROAPP、APPPAY、PPAY、CARDPAY、FACEPAY
+	CombineUse         bool               `json:"combine_use,omitempty"`         // 是否可以叠加使用
+	AvailableItems     []string           `json:"available_items,omitempty"`     // 可核销商品编码
+	UnavailableItems   []string           `json:"unavailable_items,omitempty"`   // 不可核销商品编码
+	AvailableMerchants []string           `json:"available_merchants"`           // 可用商户号
+}
+
+// FixedNormalCoupon 为固定面额满减券规则
+type FixedNormalCoupon struct {
+	CouponAmount       int64 `json:"coupon_amount"`       // 面额（分）
+	TransactionMinimum int64 `json:"transaction_minimum"` // 使用券门槛（分）
+}
+
+// CouponStockResponse 为创建代金券批次的应答
+type CouponStockResponse struct {
+	StockID    string    `json:"stock_id"`    // 批次号
+	CreateTime time.Time `json:"create_time"` // 创建时间
+}
+
+// CouponStockStatusResponse 为激活/暂停代金券批次的应答
+type CouponStockStatusResponse struct {
+	StockID   string     `json:"stock_id"`             // 批次号
+	StartTime *time.Time `json:"start_time,omitempty"` // 激活时间
+	PauseTime *time.Time `json:"pause_time,omitempty"` // 暂停时间
+}
+
+// SendCouponRequest 为发放代金券请求
+type SendCouponRequest struct {
+	OpenID            string `json:"-"`                        // 用户在 AppID 下的 openid，必填
+	StockID           string `json:"stock_id"`                 // 批次号，必填
+	OutRequestNo      string `json:"out_request_no"`           // 商户单据号，必填，用于幂等
+	AppID             string `json:"appid"`                    // 公众账号 ID，必填
+	StockCreatorMchID string `json:"stock_creator_mchid"`      // 创建批次的商户号，为空时使用配置中的商户号
+	CouponValue       int64  `json:"coupon_value,omitempty"`   // 指定面额发券（分）
+	CouponMinimum     int64  `json:"coupon_minimum,omitempty"` // 指定面额发券时的使用门槛（分）
+}
+
+// SendCouponResponse 为发放代金券的应答
+type SendCouponResponse struct {
+	CouponID string `json:"coupon_id"` // 代金券 id
+}
+
+// UserCouponsRequest 为查询用户代金券请求
+type UserCouponsRequest struct {
+	// ----- 必填字段 -----
+	OpenID string // 用户在 AppID 下的 openid
+	AppID  string // 公众账号 ID
+
+	// ----- 选填字段 -----
+	StockID        string       // 批次号
+	Status         CouponStatus // 代金券状态
+	CreatorMchID   string       // 创建批次的商户号
+	SenderMchID    string       // 批次发放商户号
+	AvailableMchID string       // 可用商户号
+	Offset         int          // 起始位置，从 0 开始
+	Limit          int          // 每页的最大数量，最大为 FavorMaxQueryLimit，为 0 时为 FavorMaxQueryLimit
+}
+
+// Coupon 为用户代金券，即查询用户代金券的应答以及核销通知（COUPON.USE）中的数据
+type Coupon struct {
+	StockCreatorMchID       string                    `json:"stock_creator_mchid"`                 // 创建批次的商户号
+	StockID                 string                    `json:"stock_id"`                            // 批次号
+	CouponID                string                    `json:"coupon_id"`                           // 代金券 id
+	CouponName              string                    `json:"coupon_name"`                         // 代金券名称
+	Status                  CouponStatus              `json:"status"`                              // 代金券状态
+	Description             string                    `json:"description"`                         // 使用说明
+	CreateTime              time.Time                 `json:"create_time"`                         // 领券时间
+	CouponType              string                    `json:"coupon_type"`                         // 券类型：NORMAL 满减券，CUT_TO 减至券
+	NoCash                  bool                      `json:"no_cash"`                             // 是否为营销补差（免充值）
+	AvailableBeginTime      time.Time                 `json:"available_begin_time"`                // 可用开始时间
+	AvailableEndTime        time.Time                 `json:"available_end_time"`                  // 可用结束时间
+	Singleitem              bool                      `json:"singleitem"`                          // 是否为单品优惠
+	NormalCouponInformation *FixedNormalCoupon        `json:"normal_coupon_information,omitempty"` // 普通满减券面额、门槛信息
+	ConsumeInformation      *CouponConsumeInformation `json:"consume_information,omitempty"`       // 已实扣代金券的核销信息
+}
+
+// CouponConsumeInformation 为代金券核销信息
+type CouponConsumeInformation struct {
+	ConsumeTime   time.Time `json:"consume_time"`   // 核销时间
+	ConsumeMchID  string    `json:"consume_mchid"`  // 核销商户号
+	TransactionID string    `json:"transaction_id"` // 核销订单号
+}
+
+// FavorCallbacksResponse 为设置核销事件通知地址的应答
+type FavorCallbacksResponse struct {
+	UpdateTime time.Time `json:"update_time"` // 修改时间
+	NotifyURL  string    `json:"notify_url"`  // 通知地址
+}
+
+// CreateCouponStock 创建代金券批次，创建后需调用 StartCouponStock 激活
+func (client *Client) CreateCouponStock(ctx context.Context, req *CouponStockRequest) (*CouponStockResponse, error) {
+	if req.OutRequestNo == "" {
+		return nil, ErrFavorMissingOutRequestNo
+	}
+	if req.BelongMerchant == "" || req.StockType == "" {
+		// 不修改调用方的请求
+		filled := *req
+		if filled.BelongMerchant == "" {
+			filled.BelongMerchant = client.config.WechatMchID()
+		}
+		if filled.StockType == "" {
+			filled.StockType = "NORMAL"
+		}
+		req = &filled
+	}
+	resp := &CouponStockResponse{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/marketing/favor/coupon-stocks", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// StartCouponStock 激活代金券批次
+func (client *Client) StartCouponStock(ctx context.Context, stockID string) (*CouponStockStatusResponse, error) {
+	return client.couponStockAction(ctx, stockID, "/start")
+}
+
+// PauseCouponStock 暂停代金券批次
+func (client *Client) PauseCouponStock(ctx context.Context, stockID string) (*CouponStockStatusResponse, error) {
+	return client.couponStockAction(ctx, stockID, "/pause")
+}
+
+func (client *Client) couponStockAction(ctx context.Context, stockID, action string) (*CouponStockStatusResponse, error) {
+	if stockID == "" {
+		return nil, ErrFavorMissingStockID
+	}
+	resp := &CouponStockStatusResponse{}
+	if err := client.Do(ctx, http.MethodPost, favorStockPath(stockID, action), map[string]string{
+		"stock_creator_mchid": client.config.WechatMchID(),
+	}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SendCoupon 向用户发放代金券
+func (client *Client) SendCoupon(ctx context.Context, req *SendCouponRequest) (*SendCouponResponse, error) {
+	switch {
+	case req.OpenID == "":
+		return nil, ErrFavorMissingOpenID
+	case req.StockID == "":
+		return nil, ErrFavorMissingStockID
+	case req.OutRequestNo == "":
+		return nil, ErrFavorMissingOutRequestNo
+	case req.AppID == "":
+		return nil, ErrFavorMissingAppID
+	}
+	if req.StockCreatorMchID == "" {
+		// 不修改调用方的请求
+		filled := *req
+		filled.StockCreatorMchID = client.config.WechatMchID()
+		req = &filled
+	}
+	resp := &SendCouponResponse{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/marketing/favor/users/"+url.PathEscape(req.OpenID)+"/coupons", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ScanUserCoupons 从 req.Offset 开始分页查询用户代金券，对每张代金券调用 fn，fn 返回错误时停止并返回该错误
+func (client *Client) ScanUserCoupons(ctx context.Context, req *UserCouponsRequest, fn func(*Coupon) error) error {
+	if req.OpenID == "" {
+		return ErrFavorMissingOpenID
+	}
+	if req.AppID == "" {
+		return ErrFavorMissingAppID
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > FavorMaxQueryLimit {
+		limit = FavorMaxQueryLimit
+	}
+	return paginate(req.Offset, limit, func(offset, limit int) (int, int, error) {
+		page := &struct {
+			Data       []*Coupon `json:"data"`
+			TotalCount int       `json:"total_count"`
+		}{}
+		if err := client.Do(ctx, http.MethodGet, withQuery(
+			"/v3/marketing/favor/users/"+url.PathEscape(req.OpenID)+"/coupons",
+			"appid", req.AppID,
+			"stock_id", req.StockID,
+			"status", string(req.Status),
+			"creator_mchid", req.CreatorMchID,
+			"sender_mchid", req.SenderMchID,
+			"available_mchid", req.AvailableMchID,
+			"offset", strconv.Itoa(offset),
+			"limit", strconv.Itoa(limit),
+		), nil, page); err != nil {
+			return 0, 0, err
+		}
+		for _, coupon := range page.Data {
+			if err := fn(coupon); err != nil {
+				return 0, 0, err
+			}
+		}
+		return len(page.Data), page.TotalCount, nil
+	})
+}
+
+// CouponStockUseFlow 申请下载批次核销明细，返回值可直接使用 DownloadBill 下载（csv 格式）
+func (client *Client) CouponStockUseFlow(ctx context.Context, stockID string) (*BillResponse, error) {
+	return client.couponStockFlow(ctx, stockID, "/use-flow")
+}
+
+// CouponStockRefundFlow 申请下载批次退款明细，返回值可直接使用 DownloadBill 下载（csv 格式）
+func (client *Client) CouponStockRefundFlow(ctx context.Context, stockID string) (*BillResponse, error) {
+	return client.couponStockFlow(ctx, stockID, "/refund-flow")
+}
+
+func (client *Client) couponStockFlow(ctx context.Context, stockID, flow string) (*BillResponse, error) {
+	if stockID == "" {
+		return nil, ErrFavorMissingStockID
+	}
+	resp := &struct {
+		URL       string `json:"url"`
+		HashValue string `json:"hash_value"`
+		HashType  string `json:"hash_type"`
+	}{}
+	if err := client.Do(ctx, http.MethodGet, favorStockPath(stockID, flow), nil, resp); err != nil {
+		return nil, err
+	}
+	return &BillResponse{
+		HashType:    resp.HashType,
+		HashValue:   resp.HashValue,
+		DownloadURL: resp.URL,
+	}, nil
+}
+
+// SetFavorCallbacks 设置代金券核销事件通知地址，enable 为 false 时关闭通知
+func (client *Client) SetFavorCallbacks(ctx context.Context, notifyURL string, enable bool) (*FavorCallbacksResponse, error) {
+	if notifyURL == "" {
+		return nil, ErrFavorMissingNotifyURL
+	}
+	resp := &FavorCallbacksResponse{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/marketing/favor/callbacks", map[string]interface{}{
+		"mchid":      client.config.WechatMchID(),
+		"notify_url": notifyURL,
+		"switch":     enable,
+	}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CouponUseNotify 创建一个处理代金券核销通知（COUPON.USE）的 http.Handler，handler 的约定同 HandleNotification；
+// 其它类型的通知会直接返回成功而不调用 handler
+func (client *Client) CouponUseNotify(handler func(context.Context, *Notification, *Coupon) error) http.Handler {
+	return client.HandleNotification(func(ctx context.Context, notification *Notification, resource []byte) error {
+		if notification.EventType != EventTypeCouponUse {
+			return nil
+		}
+		coupon := &Coupon{}
+		if err := client.decodeResource(resource, coupon); err != nil {
+			return err
+		}
+		return handler(ctx, notification, coupon)
+	})
+}
+
+func favorStockPath(stockID, suffix string) string {
+	return "/v3/marketing/favor/stocks/" + url.PathEscape(stockID) + suffix
+}
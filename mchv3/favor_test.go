@@ -0,0 +1,183 @@
+package mchv3
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCouponStock(t *testing.T) {
+	assert := assert.New(t)
+
+	useFlow := "批次id,代金券id,优惠类型,优惠金额（元）,订单总金额（元）,交易类型,支付单号,消耗时间,消耗商户号,设备号,银行流水号,单品信息\n" +
+		"`9856000,`10000000001,`全场代金券,`1.00,`10.00,`JSAPI,`4200000801202101020000000001,`2021-01-02 15:04:05,`1900009191,`,`,`\n"
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := []byte{}
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		switch r.URL.Path {
+		case "/v3/marketing/favor/coupon-stocks":
+			req := &CouponStockRequest{}
+			assert.NoError(json.Unmarshal(body, req))
+			assert.Equal(testConfig.MchID, req.BelongMerchant)
+			assert.Equal("NORMAL", req.StockType)
+			assert.Equal(int64(100), req.CouponUseRule.FixedNormalCoupon.CouponAmount)
+			testRespond(w, 200, map[string]string{"stock_id": "9856000", "create_time": "2015-05-20T13:29:35.120+08:00"})
+		case "/v3/marketing/favor/stocks/9856000/start":
+			assert.JSONEq(`{"stock_creator_mchid":"`+testConfig.MchID+`"}`, string(body))
+			testRespond(w, 200, map[string]string{"stock_id": "9856000", "start_time": "2015-05-20T13:29:35.120+08:00"})
+		case "/v3/marketing/favor/stocks/9856000/pause":
+			testRespond(w, 200, map[string]string{"stock_id": "9856000", "pause_time": "2015-05-20T13:29:35.120+08:00"})
+		case "/v3/marketing/favor/stocks/9856000/use-flow":
+			testRespond(w, 200, map[string]string{
+				"url":        "https://api.mch.weixin.qq.com/v3/billdownload/file?token=xxx",
+				"hash_value": testSHA1(useFlow),
+				"hash_type":  "SHA1",
+			})
+		case "/v3/billdownload/file":
+			w.Write([]byte(useFlow))
+		case "/v3/marketing/favor/callbacks":
+			assert.JSONEq(`{"mchid":"`+testConfig.MchID+`","notify_url":"https://yourapp.com/notify","switch":true}`, string(body))
+			testRespond(w, 200, map[string]string{"update_time": "2015-05-20T13:29:35.120+08:00", "notify_url": "https://yourapp.com/notify"})
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+
+	_, err := client.CreateCouponStock(ctx, &CouponStockRequest{})
+	assert.Equal(ErrFavorMissingOutRequestNo, err)
+
+	req := &CouponStockRequest{
+		StockName:    "微信支付代金券",
+		StockUseRule: &CouponStockUseRule{MaxCoupons: 100, MaxAmount: 10000, MaxCouponsPerUser: 1},
+		CouponUseRule: &CouponUseRule{
+			FixedNormalCoupon:  &FixedNormalCoupon{CouponAmount: 100, TransactionMinimum: 1000},
+			AvailableMerchants: []string{testConfig.MchID},
+		},
+		OutRequestNo: "89560002019101000121",
+	}
+	stock, err := client.CreateCouponStock(ctx, req)
+	assert.NoError(err)
+	assert.Equal("9856000", stock.StockID)
+	// 不修改调用方的请求
+	assert.Equal("", req.BelongMerchant)
+
+	_, err = client.StartCouponStock(ctx, "")
+	assert.Equal(ErrFavorMissingStockID, err)
+	status, err := client.StartCouponStock(ctx, "9856000")
+	assert.NoError(err)
+	assert.NotNil(status.StartTime)
+	status, err = client.PauseCouponStock(ctx, "9856000")
+	assert.NoError(err)
+	assert.NotNil(status.PauseTime)
+
+	flow, err := client.CouponStockUseFlow(ctx, "9856000")
+	assert.NoError(err)
+	r, err := client.DownloadBill(ctx, flow)
+	assert.NoError(err)
+	content, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(useFlow, string(content))
+
+	_, err = client.SetFavorCallbacks(ctx, "", true)
+	assert.Equal(ErrFavorMissingNotifyURL, err)
+	callbacks, err := client.SetFavorCallbacks(ctx, "https://yourapp.com/notify", true)
+	assert.NoError(err)
+	assert.Equal("https://yourapp.com/notify", callbacks.NotifyURL)
+}
+
+func TestUserCoupons(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []string{}
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/marketing/favor/users/oUpF8uMuAJO_M2pxb1Q9zNjWeS6o/coupons":
+			if r.Method == http.MethodPost {
+				body, _ := ioutil.ReadAll(r.Body)
+				req := &SendCouponRequest{}
+				assert.NoError(json.Unmarshal(body, req))
+				assert.Equal(testConfig.MchID, req.StockCreatorMchID)
+				assert.Equal("9856000", req.StockID)
+				testRespond(w, 200, &SendCouponResponse{CouponID: "10000000001"})
+				return
+			}
+			queries = append(queries, r.URL.RawQuery)
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			data := []*Coupon{}
+			for i := offset; i < offset+FavorMaxQueryLimit && i < 12; i++ {
+				data = append(data, &Coupon{CouponID: strconv.Itoa(i), StockID: "9856000", Status: CouponStatusSENDED})
+			}
+			testRespond(w, 200, map[string]interface{}{"data": data, "limit": FavorMaxQueryLimit, "offset": offset, "total_count": 12})
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+
+	_, err := client.SendCoupon(ctx, &SendCouponRequest{OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o", StockID: "9856000", OutRequestNo: "89560002019101000121"})
+	assert.Equal(ErrFavorMissingAppID, err)
+	sent, err := client.SendCoupon(ctx, &SendCouponRequest{
+		OpenID:       "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		StockID:      "9856000",
+		OutRequestNo: "89560002019101000121",
+		AppID:        "wx233544546545989",
+	})
+	assert.NoError(err)
+	assert.Equal("10000000001", sent.CouponID)
+
+	assert.Equal(ErrFavorMissingAppID, client.ScanUserCoupons(ctx, &UserCouponsRequest{OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}, nil))
+
+	ids := []string{}
+	assert.NoError(client.ScanUserCoupons(ctx, &UserCouponsRequest{
+		OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		AppID:  "wx233544546545989",
+		Status: CouponStatusSENDED,
+		Limit:  100,
+	}, func(coupon *Coupon) error {
+		ids = append(ids, coupon.CouponID)
+		return nil
+	}))
+	assert.Len(ids, 12)
+	// limit 超过上限时使用 FavorMaxQueryLimit
+	assert.Equal([]string{
+		"appid=wx233544546545989&limit=10&offset=0&status=SENDED",
+		"appid=wx233544546545989&limit=10&offset=10&status=SENDED",
+	}, queries)
+}
+
+func TestCouponUseNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, nil)
+	coupons := []*Coupon{}
+	handler := client.CouponUseNotify(func(ctx context.Context, notification *Notification, coupon *Coupon) error {
+		coupons = append(coupons, coupon)
+		return nil
+	})
+	status, _ := testServeNotification(handler, testNotification(EventTypeCouponUse, map[string]interface{}{
+		"stock_id":  "9856000",
+		"coupon_id": "10000000001",
+		"status":    "USED",
+		"consume_information": map[string]string{
+			"consume_time":   "2015-05-20T13:29:35.120+08:00",
+			"consume_mchid":  "1900009191",
+			"transaction_id": "4200000801202101020000000001",
+		},
+	}))
+	assert.Equal(200, status)
+	assert.Len(coupons, 1)
+	assert.Equal(CouponStatusUSED, coupons[0].Status)
+	assert.Equal("4200000801202101020000000001", coupons[0].ConsumeInformation.TransactionID)
+
+	status, _ = testServeNotification(handler, testNotification("COUPON.OTHER", map[string]interface{}{}))
+	assert.Equal(200, status)
+	assert.Len(coupons, 1)
+}
@@ -0,0 +1,164 @@
+package mchv3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+)
+
+const (
+	// MediaImageUploadPath 图片上传（特约商户进件等）
+	MediaImageUploadPath = "/v3/merchant/media/upload"
+	// MediaVideoUploadPath 视频上传（特约商户进件等）
+	MediaVideoUploadPath = "/v3/merchant/media/video_upload"
+	// ComplaintImageUploadPath 商户上传反馈图片（消费者投诉）
+	ComplaintImageUploadPath = "/v3/merchant-service/images/upload"
+)
+
+var (
+	// ErrMediaMissingFilename 上传文件时没有提供文件名
+	ErrMediaMissingFilename = errors.New("Missing media filename")
+)
+
+// MediaMeta 为上传文件请求中的 meta 部分，同时也是请求签名内容中的 body
+type MediaMeta struct {
+	// Filename 文件名，须包含扩展名，如 "a.jpg"
+	Filename string `json:"filename"`
+	// SHA256 文件内容的 sha256（hex）
+	SHA256 string `json:"sha256"`
+}
+
+// MediaUploadResponse 为上传文件的应答
+type MediaUploadResponse struct {
+	// MediaID 媒体文件标识 Id，用于进件、投诉回复等接口
+	MediaID string `json:"media_id"`
+}
+
+// UploadImage 上传图片（jpg/bmp/png，不超过 2M），返回 media_id
+func (client *Client) UploadImage(ctx context.Context, filename string, r io.Reader) (string, error) {
+	return client.UploadMedia(ctx, MediaImageUploadPath, filename, r)
+}
+
+// UploadVideo 上传视频（avi/wmv/mpeg/mp4/mov/mkv/flv/f4v/m4v/rmvb，不超过 5M），返回 media_id
+func (client *Client) UploadVideo(ctx context.Context, filename string, r io.Reader) (string, error) {
+	return client.UploadMedia(ctx, MediaVideoUploadPath, filename, r)
+}
+
+// UploadMedia 将 r 中的文件内容以 multipart 形式上传至 urlPath（如 MediaImageUploadPath），返回 media_id。
+//
+// 由于签名需要文件的 sha256，文件内容会被读取两遍：r 实现了 io.Seeker 时（如 *os.File）先计算 sha256 后 seek 回原位置
+// 再流式上传，否则会将全部内容读入内存
+func (client *Client) UploadMedia(ctx context.Context, urlPath, filename string, r io.Reader) (string, error) {
+	if filename == "" {
+		return "", ErrMediaMissingFilename
+	}
+
+	file, size, sum, err := readMedia(r)
+	if err != nil {
+		return "", err
+	}
+	meta, err := json.Marshal(&MediaMeta{
+		Filename: filename,
+		SHA256:   sum,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// 除文件内容外的部分都很小，事先写好以便设置 Content-Length
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	metaHeader := textproto.MIMEHeader{}
+	metaHeader.Set("Content-Disposition", `form-data; name="meta"`)
+	metaHeader.Set("Content-Type", "application/json")
+	part, err := w.CreatePart(metaHeader)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(meta); err != nil {
+		return "", err
+	}
+	fileHeader := textproto.MIMEHeader{}
+	fileHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, escapeQuotes(filename)))
+	fileHeader.Set("Content-Type", mediaContentType(filename))
+	if _, err := w.CreatePart(fileHeader); err != nil {
+		return "", err
+	}
+	head := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	tail := buf.Bytes()
+
+	req, err := client.newRequest(ctx, http.MethodPost, urlPath, io.MultiReader(bytes.NewReader(head), file, bytes.NewReader(tail)), meta)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = int64(len(head)) + size + int64(len(tail))
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	httpResp, respBody, err := client.roundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(client.certs, httpResp.Header, respBody); err != nil {
+		return "", err
+	}
+	resp := &MediaUploadResponse{}
+	if err := decodeJSON(respBody, resp); err != nil {
+		return "", err
+	}
+	return resp.MediaID, nil
+}
+
+// readMedia 计算文件内容的长度和 sha256，并返回可以再次读取文件内容的 io.Reader
+func readMedia(r io.Reader) (io.Reader, int64, string, error) {
+	h := sha256.New()
+	if seeker, ok := r.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		size, err := io.Copy(h, r)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, 0, "", err
+		}
+		return io.LimitReader(r, size), size, hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	buf := &bytes.Buffer{}
+	size, err := io.Copy(io.MultiWriter(h, buf), r)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return buf, size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mediaContentType 根据扩展名返回文件的 Content-Type
+func mediaContentType(filename string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(path.Ext(filename))); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,59 @@
+package mchv3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadMedia(t *testing.T) {
+	assert := assert.New(t)
+
+	content := strings.Repeat("\x89PNG\r\n", 1000)
+	sum := sha256.Sum256([]byte(content))
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(MediaImageUploadPath, r.URL.Path)
+		assert.True(r.ContentLength > int64(len(content))) // 设置了 Content-Length
+		assert.NoError(r.ParseMultipartForm(1 << 20))
+
+		// 签名内容为 meta
+		meta := r.MultipartForm.Value["meta"][0]
+		assert.NoError(testVerifyAuthorization(r, []byte(meta)))
+		m := &MediaMeta{}
+		assert.NoError(json.Unmarshal([]byte(meta), m))
+		assert.Equal("a.png", m.Filename)
+		assert.Equal(hex.EncodeToString(sum[:]), m.SHA256)
+
+		fh := r.MultipartForm.File["file"][0]
+		assert.Equal("a.png", fh.Filename)
+		assert.Equal("image/png", fh.Header.Get("Content-Type"))
+		f, _ := fh.Open()
+		data, _ := ioutil.ReadAll(f)
+		assert.Equal(content, string(data))
+		testRespond(w, 200, &MediaUploadResponse{MediaID: "6uqyGjGrCf2GtyXP8bxrbuH9-aAoTjH-rKeSl3Lf4_So6kdkQu4w8BYVP3bzLtvR38lxt4PjtCDXsQpzqge_hQEovHzOhsLleGFQVRF-U_0"})
+	})
+	ctx := context.Background()
+
+	_, err := client.UploadImage(ctx, "", strings.NewReader(content))
+	assert.Equal(ErrMediaMissingFilename, err)
+
+	// 可 seek 的文件从当前位置开始上传
+	r := strings.NewReader("ignored" + content)
+	r.Seek(int64(len("ignored")), 0)
+	mediaID, err := client.UploadImage(ctx, "a.png", r)
+	assert.NoError(err)
+	assert.Equal("6uqyGjGrCf2GtyXP8bxrbuH9-aAoTjH-rKeSl3Lf4_So6kdkQu4w8BYVP3bzLtvR38lxt4PjtCDXsQpzqge_hQEovHzOhsLleGFQVRF-U_0", mediaID)
+
+	// 不可 seek 的文件读入内存
+	mediaID, err = client.UploadImage(ctx, "a.png", ioutil.NopCloser(bytes.NewBufferString(content)))
+	assert.NoError(err)
+	assert.NotEmpty(mediaID)
+}
@@ -0,0 +1,100 @@
+package mchv3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// MaxNotificationSize 为回调通知 body 的最大长度
+	MaxNotificationSize = 1 << 20
+)
+
+var (
+	ErrNotificationMissingResource = errors.New("Missing resource in notification")
+	ErrNotificationTooLarge        = errors.New("Notification body is too large")
+)
+
+// Notification 为 APIv3 回调通知
+type Notification struct {
+	// ID 通知 ID，同一事件的重复通知 ID 相同，可用于去重
+	ID string `json:"id"`
+	// CreateTime 通知创建时间
+	CreateTime time.Time `json:"create_time"`
+	// EventType 通知类型，如 "TRANSACTION.SUCCESS"
+	EventType string `json:"event_type"`
+	// ResourceType 通知数据类型，一般为 "encrypt-resource"
+	ResourceType string `json:"resource_type"`
+	// Summary 回调摘要
+	Summary string `json:"summary"`
+	// Resource 加密的通知数据
+	Resource *EncryptedResource `json:"resource"`
+}
+
+// HandleNotification 创建一个处理 APIv3 回调通知的 http.Handler：使用平台证书验证签名，使用 APIv3 密钥解密通知数据，
+// 然后将通知及解密后的数据（json）传入 handler；handler 处理过后若成功应该返回 nil，若失败则应该返回一个非 nil error 对象，
+// 该 error 的 Error() 将会返回给微信，微信之后会重发通知。
+//
+// 一般使用对应类型的封装，如 CombineTransactionNotify
+func (client *Client) HandleNotification(handler func(context.Context, *Notification, []byte) error) http.Handler {
+	return client.options.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse := func(err error) {
+			w.Header().Set("Content-Type", "application/json")
+			if err == nil {
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(map[string]string{"code": "SUCCESS"})
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"code": "FAIL", "message": err.Error()})
+		}
+
+		notification, resource, err := client.readNotification(r)
+		if err != nil {
+			writeResponse(err)
+			return
+		}
+		writeResponse(handler(r.Context(), notification, resource))
+	}))
+}
+
+// readNotification 读取并验证回调通知，返回通知及解密后的数据
+func (client *Client) readNotification(r *http.Request) (*Notification, []byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxNotificationSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > MaxNotificationSize {
+		return nil, nil, ErrNotificationTooLarge
+	}
+	if err := verifySignature(client.certs, r.Header, body); err != nil {
+		return nil, nil, err
+	}
+
+	notification := &Notification{}
+	if err := json.Unmarshal(body, notification); err != nil {
+		return nil, nil, fmt.Errorf("Decode notification json: %s", err)
+	}
+	if notification.Resource == nil {
+		return nil, nil, ErrNotificationMissingResource
+	}
+	resource, err := notification.Resource.Decrypt(client.config.WechatMchAPIv3Key())
+	if err != nil {
+		return nil, nil, err
+	}
+	return notification, resource, nil
+}
+
+// decodeResource 将解密后的通知数据解码至 v，并解密其中的敏感字段
+func (client *Client) decodeResource(resource []byte, v interface{}) error {
+	if err := decodeJSON(resource, v); err != nil {
+		return err
+	}
+	return client.decryptSensitiveFields(v)
+}
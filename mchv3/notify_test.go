@@ -0,0 +1,85 @@
+package mchv3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNotification 返回签名后的回调通知请求，resource 为通知数据（加密前）
+func testNotification(eventType string, resource interface{}) *http.Request {
+	plain, _ := json.Marshal(resource)
+	body, _ := json.Marshal(&Notification{
+		ID:           "EV-2018022511223320873",
+		EventType:    eventType,
+		ResourceType: "encrypt-resource",
+		Resource:     testEncrypt(plain, "transaction"),
+	})
+	req := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewReader(body))
+	testSign(req.Header, body)
+	return req
+}
+
+// testServeNotification 处理回调通知，返回状态码和应答中的 code
+func testServeNotification(handler http.Handler, req *http.Request) (int, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	resp := map[string]string{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp["code"]
+}
+
+func TestHandleNotification(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, nil)
+	var called int
+	handler := client.HandleNotification(func(ctx context.Context, notification *Notification, resource []byte) error {
+		called++
+		assert.Equal("EV-2018022511223320873", notification.ID)
+		if notification.EventType == "FAIL" {
+			return errors.New("handler error")
+		}
+		assert.JSONEq(`{"a":"b"}`, string(resource))
+		return nil
+	})
+
+	status, code := testServeNotification(handler, testNotification("X", map[string]string{"a": "b"}))
+	assert.Equal(200, status)
+	assert.Equal("SUCCESS", code)
+
+	status, code = testServeNotification(handler, testNotification("FAIL", map[string]string{"a": "b"}))
+	assert.Equal(500, status)
+	assert.Equal("FAIL", code)
+	assert.Equal(2, called)
+
+	// 签名不正确时不调用 handler
+	req := testNotification("X", map[string]string{"a": "b"})
+	req.Header.Set("Wechatpay-Signature", "bad")
+	status, code = testServeNotification(handler, req)
+	assert.Equal(500, status)
+	assert.Equal("FAIL", code)
+	assert.Equal(2, called)
+
+	// 使用中间件
+	client, _ = NewClient(testConfig,
+		UsePlatformCertificates(NewPlatformCertificates(testPlatformCert)),
+		UseMiddleware(func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Middleware", "1")
+				h.ServeHTTP(w, r)
+			})
+		}),
+	)
+	w := httptest.NewRecorder()
+	client.HandleNotification(func(context.Context, *Notification, []byte) error {
+		return nil
+	}).ServeHTTP(w, testNotification("X", nil))
+	assert.Equal("1", w.Header().Get("X-Middleware"))
+}
@@ -0,0 +1,163 @@
+package mchv3
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+const (
+	// URLBaseDefault 为默认接入点
+	URLBaseDefault = "https://api.mch.weixin.qq.com"
+
+	// URLBaseBackup 为备用接入点
+	URLBaseBackup = "https://api2.mch.weixin.qq.com"
+)
+
+var (
+	// DefaultOptions 为 mchv3 模块的默认设置，可修改它影响该模块下的默认行为
+	DefaultOptions = &Options{
+		urlBase: URLBaseDefault,
+	}
+)
+
+// Options 为 Client 的选项
+type Options struct {
+	// http 客户端
+	client utils.HTTPClient
+
+	// http 服务端中间件，用于回调接口
+	middleware func(http.Handler) http.Handler
+
+	// 地址前缀
+	urlBase string
+
+	// 平台证书
+	platformCertificates *PlatformCertificates
+}
+
+// Option 代表 Client 的单个选项
+type Option func(*Options) error
+
+// NewOptions 创建一个 Options
+//
+// NOTE: 若 len(opts) == 0，返回 (*Options)(nil) 也是有效的
+func NewOptions(opts ...Option) (*Options, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+
+	options := &Options{}
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// MustOptions 是 must 版 NewOptions
+func MustOptions(opts ...Option) *Options {
+	options, err := NewOptions(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return options
+}
+
+// Client 返回 HTTPClient，依次：options.client > DefaultOptions.client > utils.DefaultHTTPClient > http.DefaultClient
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) Client() utils.HTTPClient {
+	if options != nil && options.client != nil {
+		return options.client
+	}
+	if DefaultOptions != nil && DefaultOptions.client != nil {
+		return DefaultOptions.client
+	}
+	if utils.DefaultHTTPClient != nil {
+		return utils.DefaultHTTPClient
+	}
+	return http.DefaultClient
+}
+
+func noopMiddleware(h http.Handler) http.Handler {
+	return h
+}
+
+// Middleware 返回用于回调接口的服务端中间件，依次：options.middleware > DefaultOptions.middleware > noopMiddleware
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) Middleware() func(http.Handler) http.Handler {
+	if options != nil && options.middleware != nil {
+		return options.middleware
+	}
+	if DefaultOptions != nil && DefaultOptions.middleware != nil {
+		return DefaultOptions.middleware
+	}
+	return noopMiddleware
+}
+
+// URLBase 返回 API 地址前缀，依次：options.urlBase > DefaultOptions.urlBase > URLBaseDefault
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回
+func (options *Options) URLBase() string {
+	if options != nil && options.urlBase != "" {
+		return options.urlBase
+	}
+	if DefaultOptions != nil && DefaultOptions.urlBase != "" {
+		return DefaultOptions.urlBase
+	}
+	return URLBaseDefault
+}
+
+// PlatformCertificates 返回预设的平台证书，依次：options.platformCertificates > nil（新建一个空集合）
+//
+// NOTE: 即使 options 为 nil 指针该方法仍能有效返回；平台证书属于单个商户，因此不使用 DefaultOptions 中的设置
+func (options *Options) PlatformCertificates() *PlatformCertificates {
+	if options != nil && options.platformCertificates != nil {
+		return options.platformCertificates
+	}
+	return nil
+}
+
+// UseClient 设置 HTTPClient
+func UseClient(client utils.HTTPClient) Option {
+	return func(options *Options) error {
+		options.client = client
+		return nil
+	}
+}
+
+// UseMiddleware 设置回调接口的服务端中间件
+func UseMiddleware(middleware func(http.Handler) http.Handler) Option {
+	return func(options *Options) error {
+		options.middleware = middleware
+		return nil
+	}
+}
+
+// UseURLBase 设置 API 地址前缀，默认为 URLBaseDefault
+func UseURLBase(urlBase string) Option {
+	return func(options *Options) error {
+		u, err := url.Parse(urlBase)
+		if err != nil {
+			return err
+		}
+		// 只取 scheme 和 host 部分
+		options.urlBase = (&url.URL{
+			Scheme: u.Scheme,
+			Host:   u.Host,
+		}).String()
+		return nil
+	}
+}
+
+// UsePlatformCertificates 设置平台证书，例如多个 Client 共享或者从本地缓存载入的平台证书
+func UsePlatformCertificates(platformCertificates *PlatformCertificates) Option {
+	return func(options *Options) error {
+		options.platformCertificates = platformCertificates
+		return nil
+	}
+}
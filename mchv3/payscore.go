@@ -0,0 +1,314 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/huangjunwen/wx-driver/conf"
+	"github.com/huangjunwen/wx-driver/mch"
+	"github.com/huangjunwen/wx-driver/utils"
+)
+
+// ServiceOrderState 为支付分服务订单状态
+type ServiceOrderState string
+
+const (
+	ServiceOrderStateCREATED ServiceOrderState = "CREATED" // 商户已创建服务订单
+	ServiceOrderStateDOING   ServiceOrderState = "DOING"   // 服务订单进行中
+	ServiceOrderStateDONE    ServiceOrderState = "DONE"    // 服务订单完成
+	ServiceOrderStateREVOKED ServiceOrderState = "REVOKED" // 商户取消服务订单
+	ServiceOrderStateEXPIRED ServiceOrderState = "EXPIRED" // 服务订单已失效（用户未确认）
+)
+
+// IsTerminal 返回服务订单是否已进入终态（完成、取消、失效）
+func (state ServiceOrderState) IsTerminal() bool {
+	switch state {
+	case ServiceOrderStateDONE, ServiceOrderStateREVOKED, ServiceOrderStateEXPIRED:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	// EventTypePayScoreUserConfirm 为用户确认订单的通知类型
+	EventTypePayScoreUserConfirm = "PAYSCORE.USER_CONFIRM"
+	// EventTypePayScoreUserPaid 为用户支付成功的通知类型
+	EventTypePayScoreUserPaid = "PAYSCORE.USER_PAID"
+)
+
+var (
+	ErrServiceOrderMissingOutOrderNo = errors.New("Missing out_order_no/service_id in service order request")
+	ErrServiceOrderMissingQueryID    = errors.New("Missing out_order_no/query_id in service order query")
+	ErrServiceOrderTotalMismatch     = errors.New("total_amount mismatch with post_payments/post_discounts")
+)
+
+// ServiceOrderPayment 为后付费项目
+type ServiceOrderPayment struct {
+	Name        string `json:"name"`                  // 付费项目名称
+	Amount      int64  `json:"amount,omitempty"`      // 金额（分），完结时必填
+	Description string `json:"description,omitempty"` // 计费说明
+	Count       int    `json:"count,omitempty"`       // 付费数量
+}
+
+// ServiceOrderDiscount 为后付费商户优惠
+type ServiceOrderDiscount struct {
+	Name        string `json:"name"`             // 优惠名称
+	Description string `json:"description"`      // 优惠说明
+	Amount      int64  `json:"amount,omitempty"` // 优惠金额（分），完结时必填
+	Count       int    `json:"count,omitempty"`  // 优惠数量
+}
+
+// ServiceOrderTimeRange 为服务时间段，时间格式为 20060102150405 或 20060102
+type ServiceOrderTimeRange struct {
+	StartTime       string `json:"start_time"`                  // 服务开始时间，创建时可为 OnAccept（用户确认成功时间）
+	StartTimeRemark string `json:"start_time_remark,omitempty"` // 服务开始时间备注
+	EndTime         string `json:"end_time,omitempty"`          // 预计服务结束时间
+	EndTimeRemark   string `json:"end_time_remark,omitempty"`   // 预计服务结束时间备注
+}
+
+// ServiceOrderLocation 为服务位置
+type ServiceOrderLocation struct {
+	StartLocation string `json:"start_location,omitempty"` // 服务开始地点
+	EndLocation   string `json:"end_location,omitempty"`   // 预计服务结束地点
+}
+
+// ServiceOrderRiskFund 为订单风险金
+type ServiceOrderRiskFund struct {
+	Name        string `json:"name"`                  // 风险金名称：DEPOSIT 押金，ADVANCE 预付款，CASH_DEPOSIT 保证金，ESTIMATE_ORDER_COST 预估订单费用
+	Amount      int64  `json:"amount"`                // 风险金额（分）
+	Description string `json:"description,omitempty"` // 风险说明
+}
+
+// ServiceOrderRequest 为创建支付分服务订单请求
+type ServiceOrderRequest struct {
+	OutOrderNo          string                  `json:"out_order_no"`             // 商户服务订单号，必填
+	AppID               string                  `json:"appid"`                    // 应用 ID，必填
+	ServiceID           string                  `json:"service_id"`               // 服务 ID，必填
+	ServiceIntroduction string                  `json:"service_introduction"`     // 服务信息，必填
+	PostPayments        []*ServiceOrderPayment  `json:"post_payments,omitempty"`  // 后付费项目
+	PostDiscounts       []*ServiceOrderDiscount `json:"post_discounts,omitempty"` // 后付费商户优惠
+	TimeRange           *ServiceOrderTimeRange  `json:"time_range"`               // 服务时间段，必填
+	Location            *ServiceOrderLocation   `json:"location,omitempty"`       // 服务位置
+	RiskFund            *ServiceOrderRiskFund   `json:"risk_fund"`                // 订单风险金，必填
+	Attach              string                  `json:"attach,omitempty"`         // 商户数据包，在查询和通知中原样返回
+	NotifyURL           string                  `json:"notify_url"`               // 商户回调地址，必填
+	OpenID              string                  `json:"openid,omitempty"`         // 用户标识，免确认模式必填
+	NeedUserConfirm     bool                    `json:"need_user_confirm"`        // 是否需要用户确认，为 true 时需使用 PayScoreExtraData 跳转确认
+}
+
+// ServiceOrder 为支付分服务订单，即各接口的应答以及支付分通知中的数据
+type ServiceOrder struct {
+	AppID               string                  `json:"appid"`                          // 应用 ID
+	MchID               string                  `json:"mchid"`                          // 商户号
+	OutOrderNo          string                  `json:"out_order_no"`                   // 商户服务订单号
+	ServiceID           string                  `json:"service_id"`                     // 服务 ID
+	ServiceIntroduction string                  `json:"service_introduction,omitempty"` // 服务信息
+	State               ServiceOrderState       `json:"state,omitempty"`                // 服务订单状态
+	StateDescription    string                  `json:"state_description,omitempty"`    // 订单状态说明，如 USER_CONFIRM 用户确认，MCH_COMPLETE 商户完结
+	TotalAmount         int64                   `json:"total_amount,omitempty"`         // 总金额（分）
+	PostPayments        []*ServiceOrderPayment  `json:"post_payments,omitempty"`        // 后付费项目
+	PostDiscounts       []*ServiceOrderDiscount `json:"post_discounts,omitempty"`       // 后付费商户优惠
+	RiskFund            *ServiceOrderRiskFund   `json:"risk_fund,omitempty"`            // 订单风险金
+	TimeRange           *ServiceOrderTimeRange  `json:"time_range,omitempty"`           // 服务时间段
+	Location            *ServiceOrderLocation   `json:"location,omitempty"`             // 服务位置
+	Attach              string                  `json:"attach,omitempty"`               // 商户数据包
+	NotifyURL           string                  `json:"notify_url,omitempty"`           // 商户回调地址
+	OrderID             string                  `json:"order_id,omitempty"`             // 微信支付服务订单号
+	Package             string                  `json:"package,omitempty"`              // 跳转微信侧小程序订单数据，见 PayScoreExtraData
+	NeedCollection      bool                    `json:"need_collection,omitempty"`      // 是否需要收款
+	Collection          *ServiceOrderCollection `json:"collection,omitempty"`           // 收款信息
+	OpenID              string                  `json:"openid,omitempty"`               // 用户标识
+}
+
+// ServiceOrderCollection 为服务订单收款信息
+type ServiceOrderCollection struct {
+	State        string                          `json:"state"`                   // 收款状态：USER_PAYING 待支付，USER_PAID 已支付
+	TotalAmount  int64                           `json:"total_amount,omitempty"`  // 总收款金额（分）
+	PayingAmount int64                           `json:"paying_amount,omitempty"` // 待收金额（分）
+	PaidAmount   int64                           `json:"paid_amount,omitempty"`   // 已收金额（分）
+	Details      []*ServiceOrderCollectionDetail `json:"details,omitempty"`       // 收款明细列表
+}
+
+// ServiceOrderCollectionDetail 为服务订单收款明细
+type ServiceOrderCollectionDetail struct {
+	Seq           int    `json:"seq"`                      // 收款序号
+	Amount        int64  `json:"amount"`                   // 单笔收款金额（分）
+	PaidType      string `json:"paid_type"`                // 收款成功渠道：NEWTON 微信支付分，MCH 商户渠道
+	PaidTime      string `json:"paid_time"`                // 收款成功时间
+	TransactionID string `json:"transaction_id,omitempty"` // 微信支付交易单号
+}
+
+// ServiceOrderQueryRequest 为查询支付分服务订单请求
+type ServiceOrderQueryRequest struct {
+	// ----- 必填字段 -----
+	AppID     string // 应用 ID
+	ServiceID string // 服务 ID
+	// 以下二选一
+	OutOrderNo string // 商户服务订单号
+	QueryID    string // 回跳查询 ID（用户确认订单后跳转回商户小程序时带回）
+}
+
+// ServiceOrderCancelRequest 为取消支付分服务订单请求
+type ServiceOrderCancelRequest struct {
+	OutOrderNo string `json:"-"`          // 商户服务订单号，必填
+	AppID      string `json:"appid"`      // 应用 ID，必填
+	ServiceID  string `json:"service_id"` // 服务 ID，必填
+	Reason     string `json:"reason"`     // 取消原因，必填
+}
+
+// ServiceOrderModifyRequest 为修改支付分订单金额请求
+type ServiceOrderModifyRequest struct {
+	OutOrderNo    string                  `json:"-"`                        // 商户服务订单号，必填
+	AppID         string                  `json:"appid"`                    // 应用 ID，必填
+	ServiceID     string                  `json:"service_id"`               // 服务 ID，必填
+	PostPayments  []*ServiceOrderPayment  `json:"post_payments"`            // 后付费项目，必填
+	PostDiscounts []*ServiceOrderDiscount `json:"post_discounts,omitempty"` // 后付费商户优惠
+	TotalAmount   int64                   `json:"total_amount"`             // 总金额（分），为 0 时按付费项目和优惠自动计算
+	Reason        string                  `json:"reason"`                   // 修改原因，必填
+}
+
+// ServiceOrderCompleteRequest 为完结支付分订单请求
+type ServiceOrderCompleteRequest struct {
+	OutOrderNo    string                  `json:"-"`                        // 商户服务订单号，必填
+	AppID         string                  `json:"appid"`                    // 应用 ID，必填
+	ServiceID     string                  `json:"service_id"`               // 服务 ID，必填
+	PostPayments  []*ServiceOrderPayment  `json:"post_payments"`            // 后付费项目，必填
+	PostDiscounts []*ServiceOrderDiscount `json:"post_discounts,omitempty"` // 后付费商户优惠
+	TotalAmount   int64                   `json:"total_amount"`             // 总金额（分），为 0 时按付费项目和优惠自动计算
+	TimeRange     *ServiceOrderTimeRange  `json:"time_range,omitempty"`     // 实际服务时间段
+	Location      *ServiceOrderLocation   `json:"location,omitempty"`       // 实际服务位置
+	ProfitSharing bool                    `json:"profit_sharing,omitempty"` // 是否需要分账
+	GoodsTag      string                  `json:"goods_tag,omitempty"`      // 订单优惠标记
+}
+
+// ServiceOrderSyncRequest 为同步服务订单信息请求
+type ServiceOrderSyncRequest struct {
+	OutOrderNo string                  `json:"-"`          // 商户服务订单号，必填
+	AppID      string                  `json:"appid"`      // 应用 ID，必填
+	ServiceID  string                  `json:"service_id"` // 服务 ID，必填
+	Type       string                  `json:"type"`       // 场景类型，目前只有 Order_Paid（用户通过其它渠道支付成功）
+	Detail     *ServiceOrderSyncDetail `json:"detail"`     // 内容信息详情
+}
+
+// ServiceOrderSyncDetail 为同步服务订单信息的内容
+type ServiceOrderSyncDetail struct {
+	PaidTime string `json:"paid_time"` // 收款成功时间，格式 20060102150405
+}
+
+// CreateServiceOrder 创建支付分服务订单；需要用户确认时应答中的 Package 用于 PayScoreExtraData
+func (client *Client) CreateServiceOrder(ctx context.Context, req *ServiceOrderRequest) (*ServiceOrder, error) {
+	if req.OutOrderNo == "" || req.ServiceID == "" {
+		return nil, ErrServiceOrderMissingOutOrderNo
+	}
+	resp := &ServiceOrder{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/payscore/serviceorder", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QueryServiceOrder 查询支付分服务订单
+func (client *Client) QueryServiceOrder(ctx context.Context, req *ServiceOrderQueryRequest) (*ServiceOrder, error) {
+	if req.OutOrderNo == "" && req.QueryID == "" {
+		return nil, ErrServiceOrderMissingQueryID
+	}
+	resp := &ServiceOrder{}
+	if err := client.Do(ctx, http.MethodGet, withQuery(
+		"/v3/payscore/serviceorder",
+		"service_id", req.ServiceID,
+		"appid", req.AppID,
+		"out_order_no", req.OutOrderNo,
+		"query_id", req.QueryID,
+	), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CancelServiceOrder 取消支付分服务订单
+func (client *Client) CancelServiceOrder(ctx context.Context, req *ServiceOrderCancelRequest) (*ServiceOrder, error) {
+	return client.serviceOrderAction(ctx, req.OutOrderNo, req.ServiceID, "/cancel", req)
+}
+
+// ModifyServiceOrder 修改支付分订单金额（订单完结后、用户支付前）；TotalAmount 为 0 时按付费项目和优惠计算，否则需与之一致
+func (client *Client) ModifyServiceOrder(ctx context.Context, req *ServiceOrderModifyRequest) (*ServiceOrder, error) {
+	totalAmount, err := serviceOrderTotalAmount(req.TotalAmount, req.PostPayments, req.PostDiscounts)
+	if err != nil {
+		return nil, err
+	}
+	filled := *req
+	filled.TotalAmount = totalAmount
+	return client.serviceOrderAction(ctx, req.OutOrderNo, req.ServiceID, "/modify", &filled)
+}
+
+// CompleteServiceOrder 完结支付分订单；TotalAmount 为 0 时按付费项目和优惠计算，否则需与之一致
+func (client *Client) CompleteServiceOrder(ctx context.Context, req *ServiceOrderCompleteRequest) (*ServiceOrder, error) {
+	totalAmount, err := serviceOrderTotalAmount(req.TotalAmount, req.PostPayments, req.PostDiscounts)
+	if err != nil {
+		return nil, err
+	}
+	filled := *req
+	filled.TotalAmount = totalAmount
+	return client.serviceOrderAction(ctx, req.OutOrderNo, req.ServiceID, "/complete", &filled)
+}
+
+// SyncServiceOrder 同步服务订单信息，如用户通过其它渠道支付成功
+func (client *Client) SyncServiceOrder(ctx context.Context, req *ServiceOrderSyncRequest) (*ServiceOrder, error) {
+	return client.serviceOrderAction(ctx, req.OutOrderNo, req.ServiceID, "/sync", req)
+}
+
+func (client *Client) serviceOrderAction(ctx context.Context, outOrderNo, serviceID, action string, req interface{}) (*ServiceOrder, error) {
+	if outOrderNo == "" || serviceID == "" {
+		return nil, ErrServiceOrderMissingOutOrderNo
+	}
+	resp := &ServiceOrder{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/payscore/serviceorder/"+url.PathEscape(outOrderNo)+action, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// serviceOrderTotalAmount 计算付费项目减去优惠的金额，totalAmount 不为 0 时需与之一致
+func serviceOrderTotalAmount(totalAmount int64, payments []*ServiceOrderPayment, discounts []*ServiceOrderDiscount) (int64, error) {
+	amount := int64(0)
+	for _, payment := range payments {
+		amount += payment.Amount
+	}
+	for _, discount := range discounts {
+		amount -= discount.Amount
+	}
+	if totalAmount != 0 && totalAmount != amount {
+		return 0, ErrServiceOrderTotalMismatch
+	}
+	return amount, nil
+}
+
+// PayScoreNotify 创建一个处理支付分通知（用户确认订单 PAYSCORE.USER_CONFIRM、用户支付成功 PAYSCORE.USER_PAID）的 http.Handler，
+// handler 的约定同 HandleNotification，可通过 Notification.EventType 区分通知类型
+func (client *Client) PayScoreNotify(handler func(context.Context, *Notification, *ServiceOrder) error) http.Handler {
+	return client.HandleNotification(func(ctx context.Context, notification *Notification, resource []byte) error {
+		order := &ServiceOrder{}
+		if err := client.decodeResource(resource, order); err != nil {
+			return err
+		}
+		return handler(ctx, notification, order)
+	})
+}
+
+// PayScoreExtraData 返回小程序跳转支付分确认订单（wx.navigateToMiniProgram，appId 为 wxd8f3793ea3b935b8）所需的 extraData，
+// pkg 为创建订单应答中的 Package；使用 APIv2 密钥以 HMAC-SHA256 签名
+func PayScoreExtraData(config conf.MchConfig, pkg string) map[string]string {
+	m := map[string]string{
+		"mch_id":    config.WechatMchID(),
+		"package":   pkg,
+		"timestamp": strconv.FormatInt(utils.Now().Unix(), 10),
+		"nonce_str": utils.NonceStr(16),
+		"sign_type": mch.SignTypeHMACSHA256.String(),
+	}
+	m["sign"] = mch.SignMchXML(mch.MchXML(m), mch.SignTypeHMACSHA256, config.WechatMchKey())
+	return m
+}
@@ -0,0 +1,147 @@
+package mchv3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/huangjunwen/wx-driver/conf"
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := []byte{}
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v3/payscore/serviceorder":
+			req := &ServiceOrderRequest{}
+			assert.NoError(json.Unmarshal(body, req))
+			assert.True(req.NeedUserConfirm)
+			testRespond(w, 200, &ServiceOrder{OutOrderNo: req.OutOrderNo, State: ServiceOrderStateCREATED, Package: "XXXXXXXX", OrderID: "15646546545165651651"})
+		case "GET /v3/payscore/serviceorder":
+			assert.Equal("appid=wxd678efh567hg6787&query_id=15646546545165651651&service_id=500001", r.URL.RawQuery)
+			testRespond(w, 200, &ServiceOrder{OutOrderNo: "1234323JKHDFE1243252", State: ServiceOrderStateDOING, StateDescription: "USER_CONFIRM"})
+		case "POST /v3/payscore/serviceorder/1234323JKHDFE1243252/cancel":
+			assert.JSONEq(`{"appid":"wxd678efh567hg6787","service_id":"500001","reason":"用户投诉"}`, string(body))
+			testRespond(w, 200, &ServiceOrder{OutOrderNo: "1234323JKHDFE1243252"})
+		case "POST /v3/payscore/serviceorder/1234323JKHDFE1243252/complete":
+			req := &ServiceOrderCompleteRequest{}
+			assert.NoError(json.Unmarshal(body, req))
+			assert.Equal(int64(3900), req.TotalAmount)
+			testRespond(w, 200, &ServiceOrder{OutOrderNo: "1234323JKHDFE1243252", State: ServiceOrderStateDOING, StateDescription: "MCH_COMPLETE", TotalAmount: req.TotalAmount})
+		case "POST /v3/payscore/serviceorder/1234323JKHDFE1243252/sync":
+			assert.JSONEq(`{"appid":"wxd678efh567hg6787","service_id":"500001","type":"Order_Paid","detail":{"paid_time":"20091225091210"}}`, string(body))
+			testRespond(w, 200, &ServiceOrder{OutOrderNo: "1234323JKHDFE1243252", State: ServiceOrderStateDONE})
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+
+	order, err := client.CreateServiceOrder(ctx, &ServiceOrderRequest{
+		OutOrderNo:          "1234323JKHDFE1243252",
+		AppID:               "wxd678efh567hg6787",
+		ServiceID:           "500001",
+		ServiceIntroduction: "某某酒店",
+		TimeRange:           &ServiceOrderTimeRange{StartTime: "OnAccept"},
+		RiskFund:            &ServiceOrderRiskFund{Name: "DEPOSIT", Amount: 10000},
+		NotifyURL:           "https://api.test.com",
+		NeedUserConfirm:     true,
+	})
+	assert.NoError(err)
+	assert.Equal("XXXXXXXX", order.Package)
+	_, err = client.CreateServiceOrder(ctx, &ServiceOrderRequest{})
+	assert.Equal(ErrServiceOrderMissingOutOrderNo, err)
+
+	order, err = client.QueryServiceOrder(ctx, &ServiceOrderQueryRequest{AppID: "wxd678efh567hg6787", ServiceID: "500001", QueryID: "15646546545165651651"})
+	assert.NoError(err)
+	assert.Equal(ServiceOrderStateDOING, order.State)
+	assert.False(order.State.IsTerminal())
+	_, err = client.QueryServiceOrder(ctx, &ServiceOrderQueryRequest{})
+	assert.Equal(ErrServiceOrderMissingQueryID, err)
+
+	_, err = client.CancelServiceOrder(ctx, &ServiceOrderCancelRequest{OutOrderNo: "1234323JKHDFE1243252", AppID: "wxd678efh567hg6787", ServiceID: "500001", Reason: "用户投诉"})
+	assert.NoError(err)
+
+	completeReq := &ServiceOrderCompleteRequest{
+		OutOrderNo:    "1234323JKHDFE1243252",
+		AppID:         "wxd678efh567hg6787",
+		ServiceID:     "500001",
+		PostPayments:  []*ServiceOrderPayment{{Name: "就餐费用", Amount: 4000}},
+		PostDiscounts: []*ServiceOrderDiscount{{Name: "满20减1元", Description: "不与其他优惠叠加", Amount: 100}},
+	}
+	order, err = client.CompleteServiceOrder(ctx, completeReq)
+	assert.NoError(err)
+	assert.Equal(int64(3900), order.TotalAmount)
+	assert.Equal(int64(0), completeReq.TotalAmount) // 不修改请求本身
+	completeReq.TotalAmount = 4000
+	_, err = client.CompleteServiceOrder(ctx, completeReq)
+	assert.Equal(ErrServiceOrderTotalMismatch, err)
+
+	order, err = client.SyncServiceOrder(ctx, &ServiceOrderSyncRequest{
+		OutOrderNo: "1234323JKHDFE1243252",
+		AppID:      "wxd678efh567hg6787",
+		ServiceID:  "500001",
+		Type:       "Order_Paid",
+		Detail:     &ServiceOrderSyncDetail{PaidTime: "20091225091210"},
+	})
+	assert.NoError(err)
+	assert.True(order.State.IsTerminal())
+}
+
+func TestPayScoreNotify(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, nil)
+	orders := []*ServiceOrder{}
+	handler := client.PayScoreNotify(func(ctx context.Context, notification *Notification, order *ServiceOrder) error {
+		assert.Equal(EventTypePayScoreUserPaid, notification.EventType)
+		orders = append(orders, order)
+		return nil
+	})
+	status, _ := testServeNotification(handler, testNotification(EventTypePayScoreUserPaid, &ServiceOrder{
+		OutOrderNo: "1234323JKHDFE1243252",
+		State:      ServiceOrderStateDONE,
+		Collection: &ServiceOrderCollection{State: "USER_PAID", PaidAmount: 3900},
+	}))
+	assert.Equal(200, status)
+	assert.Len(orders, 1)
+	assert.Equal(int64(3900), orders[0].Collection.PaidAmount)
+}
+
+func TestPayScoreExtraData(t *testing.T) {
+	assert := assert.New(t)
+
+	now := utils.Now
+	defer func() {
+		utils.Now = now
+	}()
+	utils.Now = func() time.Time {
+		return time.Unix(1530097563, 0)
+	}
+
+	config := &conf.DefaultConfig{MchID: "1230000109", MchKey: "192006250b4c09247ec02edce69f6a2d"}
+	extraData := PayScoreExtraData(config, "XXXXXXXX")
+	assert.Equal("1230000109", extraData["mch_id"])
+	assert.Equal("XXXXXXXX", extraData["package"])
+	assert.Equal("1530097563", extraData["timestamp"])
+	assert.Equal("HMAC-SHA256", extraData["sign_type"])
+
+	// 按字段名排序后以 APIv2 密钥签名
+	message := fmt.Sprintf("mch_id=1230000109&nonce_str=%s&package=XXXXXXXX&sign_type=HMAC-SHA256&timestamp=1530097563&key=192006250b4c09247ec02edce69f6a2d", extraData["nonce_str"])
+	h := hmac.New(sha256.New, []byte(config.MchKey))
+	h.Write([]byte(message))
+	assert.Equal(fmt.Sprintf("%X", h.Sum(nil)), extraData["sign"])
+}
@@ -0,0 +1,384 @@
+package mchv3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// TransferBatchStatus 为转账批次单状态
+type TransferBatchStatus string
+
+// TransferDetailStatus 为转账明细单状态
+type TransferDetailStatus string
+
+const (
+	TransferBatchStatusWAITPAY    TransferBatchStatus = "WAIT_PAY"   // 待付款确认
+	TransferBatchStatusACCEPTED   TransferBatchStatus = "ACCEPTED"   // 已受理
+	TransferBatchStatusPROCESSING TransferBatchStatus = "PROCESSING" // 转账中
+	TransferBatchStatusFINISHED   TransferBatchStatus = "FINISHED"   // 已完成，批次内的明细可能有成功也有失败
+	TransferBatchStatusCLOSED     TransferBatchStatus = "CLOSED"     // 已关闭，见 CloseReason
+
+	TransferDetailStatusINIT       TransferDetailStatus = "INIT"       // 初始态
+	TransferDetailStatusWAITPAY    TransferDetailStatus = "WAIT_PAY"   // 待确认
+	TransferDetailStatusPROCESSING TransferDetailStatus = "PROCESSING" // 转账中
+	TransferDetailStatusSUCCESS    TransferDetailStatus = "SUCCESS"    // 转账成功
+	TransferDetailStatusFAIL       TransferDetailStatus = "FAIL"       // 转账失败，见 FailReason
+)
+
+// IsTerminal 返回批次是否已进入终态（已完成、已关闭）
+func (status TransferBatchStatus) IsTerminal() bool {
+	return status == TransferBatchStatusFINISHED || status == TransferBatchStatusCLOSED
+}
+
+// IsTerminal 返回明细是否已进入终态（成功、失败）
+func (status TransferDetailStatus) IsTerminal() bool {
+	return status == TransferDetailStatusSUCCESS || status == TransferDetailStatusFAIL
+}
+
+const (
+	// TransferUserNameRequiredAmount 单笔转账金额（分）不小于该值时必须填写收款用户姓名
+	TransferUserNameRequiredAmount = 200000
+	// TransferUserNameForbiddenAmount 单笔转账金额（分）小于该值时不允许填写收款用户姓名
+	TransferUserNameForbiddenAmount = 30
+	// TransferMaxQueryLimit 查询批次时每页明细的最大数量
+	TransferMaxQueryLimit = 100
+)
+
+var (
+	ErrTransferMissingOutBatchNo   = errors.New("Missing out_batch_no in TransferBatchRequest")
+	ErrTransferBadOutBatchNo       = errors.New("Bad out_batch_no, expect 5-32 letters or digits")
+	ErrTransferNoDetails           = errors.New("Empty transfer_detail_list in TransferBatchRequest")
+	ErrTransferTotalMismatch       = errors.New("total_amount/total_num mismatch with transfer_detail_list")
+	ErrTransferMissingID           = errors.New("Missing batch_id/out_batch_no in transfer query")
+	ErrTransferMissingDetailID     = errors.New("Missing detail_id/out_detail_no in transfer detail query")
+	ErrTransferBadAmount           = errors.New("Bad transfer_amount")
+	ErrTransferUserNameRequired    = errors.New("user_name is required when transfer_amount >= 2000 yuan")
+	ErrTransferUserNameNotAllowed  = errors.New("user_name is not allowed when transfer_amount < 0.3 yuan")
+	ErrTransferDetailAmountTooMuch = errors.New("transfer_amount exceeds the limit")
+)
+
+var (
+	// DefaultTransferLimits 为 SplitTransferBatch 的默认限额：每个批次最多 1000 笔明细，不限制金额
+	DefaultTransferLimits = &TransferLimits{
+		MaxDetails: 1000,
+	}
+)
+
+var outBatchNoRegexp = regexp.MustCompile(`^[0-9a-zA-Z]{5,32}$`)
+
+// TransferBatchRequest 为发起商家转账（批量转账到零钱）请求
+type TransferBatchRequest struct {
+	AppID              string                 `json:"appid"`                       // 商户 appid，必填
+	OutBatchNo         string                 `json:"out_batch_no"`                // 商家批次单号，数字或字母，必填
+	BatchName          string                 `json:"batch_name"`                  // 批次名称，必填
+	BatchRemark        string                 `json:"batch_remark"`                // 批次备注，必填
+	TotalAmount        int64                  `json:"total_amount"`                // 转账总金额（分），为 0 时按明细自动计算
+	TotalNum           int                    `json:"total_num"`                   // 转账总笔数，为 0 时按明细自动计算
+	TransferDetailList []*TransferDetailInput `json:"transfer_detail_list"`        // 转账明细列表，必填
+	TransferSceneID    string                 `json:"transfer_scene_id,omitempty"` // 转账场景 ID
+}
+
+// TransferDetailInput 为发起转账时的转账明细
+type TransferDetailInput struct {
+	OutDetailNo    string `json:"out_detail_no"`                      // 商家明细单号，数字或字母，必填
+	TransferAmount int64  `json:"transfer_amount"`                    // 转账金额（分），必填
+	TransferRemark string `json:"transfer_remark"`                    // 转账备注，必填
+	OpenID         string `json:"openid"`                             // 收款用户 openid（商户 appid 下），必填
+	UserName       string `json:"user_name,omitempty" wx:"sensitive"` // 收款用户姓名，见 TransferUserNameRequiredAmount/TransferUserNameForbiddenAmount
+}
+
+// TransferBatchResponse 为发起商家转账的应答
+type TransferBatchResponse struct {
+	OutBatchNo string    `json:"out_batch_no"` // 商家批次单号
+	BatchID    string    `json:"batch_id"`     // 微信批次单号
+	CreateTime time.Time `json:"create_time"`  // 批次创建时间
+}
+
+// TransferBatchQueryRequest 为查询转账批次单请求
+type TransferBatchQueryRequest struct {
+	// ----- 必填字段 -----
+	// 以下二选一
+	BatchID    string // 微信批次单号
+	OutBatchNo string // 商家批次单号
+
+	// ----- 选填字段 -----
+	NeedQueryDetail bool                 // 是否查询转账明细单
+	Offset          int                  // 明细的起始位置，从 0 开始
+	Limit           int                  // 明细的最大数量，最大为 TransferMaxQueryLimit，为 0 时为 20
+	DetailStatus    TransferDetailStatus // 明细状态，为空时查询全部（ALL）
+}
+
+// TransferBatchQueryResponse 为查询转账批次单的应答
+type TransferBatchQueryResponse struct {
+	TransferBatch      *TransferBatch         `json:"transfer_batch"`                 // 转账批次单
+	TransferDetailList []*TransferDetailBrief `json:"transfer_detail_list,omitempty"` // 转账明细单列表
+	Offset             int                    `json:"offset,omitempty"`               // 请求的起始位置
+	Limit              int                    `json:"limit,omitempty"`                // 请求的最大数量
+}
+
+// TransferBatch 为转账批次单
+type TransferBatch struct {
+	MchID         string              `json:"mchid"`                    // 商户号
+	OutBatchNo    string              `json:"out_batch_no"`             // 商家批次单号
+	BatchID       string              `json:"batch_id"`                 // 微信批次单号
+	AppID         string              `json:"appid"`                    // 商户 appid
+	BatchStatus   TransferBatchStatus `json:"batch_status"`             // 批次状态
+	BatchType     string              `json:"batch_type"`               // 批次类型：API 或 WEB
+	BatchName     string              `json:"batch_name"`               // 批次名称
+	BatchRemark   string              `json:"batch_remark"`             // 批次备注
+	CloseReason   string              `json:"close_reason,omitempty"`   // 批次关闭原因，如 OVERDUE_CLOSE、TRANSFER_SCENE_INVALID
+	TotalAmount   int64               `json:"total_amount"`             // 转账总金额（分）
+	TotalNum      int                 `json:"total_num"`                // 转账总笔数
+	CreateTime    *time.Time          `json:"create_time,omitempty"`    // 批次创建时间
+	UpdateTime    *time.Time          `json:"update_time,omitempty"`    // 批次更新时间
+	SuccessAmount int64               `json:"success_amount,omitempty"` // 转账成功金额（分），批次完成时返回
+	SuccessNum    int                 `json:"success_num,omitempty"`    // 转账成功笔数
+	FailAmount    int64               `json:"fail_amount,omitempty"`    // 转账失败金额（分）
+	FailNum       int                 `json:"fail_num,omitempty"`       // 转账失败笔数
+}
+
+// TransferDetailBrief 为批次单中的转账明细单
+type TransferDetailBrief struct {
+	DetailID     string               `json:"detail_id"`     // 微信明细单号
+	OutDetailNo  string               `json:"out_detail_no"` // 商家明细单号
+	DetailStatus TransferDetailStatus `json:"detail_status"` // 明细状态
+}
+
+// TransferDetail 为转账明细单
+type TransferDetail struct {
+	MchID          string               `json:"mchid"`                              // 商户号
+	OutBatchNo     string               `json:"out_batch_no"`                       // 商家批次单号
+	BatchID        string               `json:"batch_id"`                           // 微信批次单号
+	AppID          string               `json:"appid"`                              // 商户 appid
+	OutDetailNo    string               `json:"out_detail_no"`                      // 商家明细单号
+	DetailID       string               `json:"detail_id"`                          // 微信明细单号
+	DetailStatus   TransferDetailStatus `json:"detail_status"`                      // 明细状态
+	TransferAmount int64                `json:"transfer_amount"`                    // 转账金额（分）
+	TransferRemark string               `json:"transfer_remark"`                    // 转账备注
+	FailReason     string               `json:"fail_reason,omitempty"`              // 明细失败原因，如 ACCOUNT_FROZEN、REAL_NAME_CHECK_FAIL
+	OpenID         string               `json:"openid"`                             // 收款用户 openid
+	UserName       string               `json:"user_name,omitempty" wx:"sensitive"` // 收款用户姓名，已自动解密
+	InitiateTime   time.Time            `json:"initiate_time"`                      // 转账发起时间
+	UpdateTime     time.Time            `json:"update_time"`                        // 明细更新时间
+}
+
+// CreateTransferBatch 发起商家转账（批量转账到零钱），明细中的收款用户姓名由 Client.Do 自动加密；
+// TotalAmount/TotalNum 为 0 时按明细计算，否则需与明细一致。明细较多时可先使用 SplitTransferBatch 拆分为多个批次
+func (client *Client) CreateTransferBatch(ctx context.Context, req *TransferBatchRequest) (*TransferBatchResponse, error) {
+	if req.OutBatchNo == "" {
+		return nil, ErrTransferMissingOutBatchNo
+	}
+	if !outBatchNoRegexp.MatchString(req.OutBatchNo) {
+		return nil, ErrTransferBadOutBatchNo
+	}
+	if len(req.TransferDetailList) == 0 {
+		return nil, ErrTransferNoDetails
+	}
+
+	totalAmount := int64(0)
+	for _, detail := range req.TransferDetailList {
+		if err := validateTransferDetail(detail); err != nil {
+			return nil, err
+		}
+		totalAmount += detail.TransferAmount
+	}
+	if (req.TotalAmount != 0 && req.TotalAmount != totalAmount) || (req.TotalNum != 0 && req.TotalNum != len(req.TransferDetailList)) {
+		return nil, ErrTransferTotalMismatch
+	}
+	if req.TotalAmount == 0 || req.TotalNum == 0 {
+		// 不修改调用方的请求
+		filled := *req
+		filled.TotalAmount = totalAmount
+		filled.TotalNum = len(req.TransferDetailList)
+		req = &filled
+	}
+
+	resp := &TransferBatchResponse{}
+	if err := client.Do(ctx, http.MethodPost, "/v3/transfer/batches", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QueryTransferBatch 通过微信批次单号或商家批次单号查询批次单，NeedQueryDetail 为 true 时同时返回一页明细单，
+// 需要全部明细时可使用 ScanTransferDetails
+func (client *Client) QueryTransferBatch(ctx context.Context, req *TransferBatchQueryRequest) (*TransferBatchQueryResponse, error) {
+	var urlPath string
+	switch {
+	case req.BatchID != "":
+		urlPath = "/v3/transfer/batches/batch-id/" + url.PathEscape(req.BatchID)
+	case req.OutBatchNo != "":
+		urlPath = "/v3/transfer/batches/out-batch-no/" + url.PathEscape(req.OutBatchNo)
+	default:
+		return nil, ErrTransferMissingID
+	}
+
+	kvs := []string{"need_query_detail", strconv.FormatBool(req.NeedQueryDetail)}
+	if req.NeedQueryDetail {
+		detailStatus := string(req.DetailStatus)
+		if detailStatus == "" {
+			detailStatus = "ALL"
+		}
+		kvs = append(kvs,
+			"offset", strconv.Itoa(req.Offset),
+			"limit", strconv.Itoa(transferQueryLimit(req.Limit)),
+			"detail_status", detailStatus,
+		)
+	}
+	resp := &TransferBatchQueryResponse{}
+	if err := client.Do(ctx, http.MethodGet, withQuery(urlPath, kvs...), nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ScanTransferDetails 从 req.Offset 开始分页查询批次单中的全部明细单（NeedQueryDetail 视为 true），对每个明细单调用 fn，
+// fn 返回错误时停止并返回该错误；返回最后一次查询到的批次单
+func (client *Client) ScanTransferDetails(ctx context.Context, req *TransferBatchQueryRequest, fn func(*TransferDetailBrief) error) (*TransferBatch, error) {
+	pageReq := *req
+	pageReq.NeedQueryDetail = true
+
+	var batch *TransferBatch
+	err := paginate(req.Offset, transferQueryLimit(req.Limit), func(offset, limit int) (int, int, error) {
+		pageReq.Offset, pageReq.Limit = offset, limit
+		resp, err := client.QueryTransferBatch(ctx, &pageReq)
+		if err != nil {
+			return 0, 0, err
+		}
+		batch = resp.TransferBatch
+		for _, detail := range resp.TransferDetailList {
+			if err := fn(detail); err != nil {
+				return 0, 0, err
+			}
+		}
+		return len(resp.TransferDetailList), 0, nil
+	})
+	return batch, err
+}
+
+// QueryTransferDetailByID 通过微信批次单号和微信明细单号查询明细单
+func (client *Client) QueryTransferDetailByID(ctx context.Context, batchID, detailID string) (*TransferDetail, error) {
+	if batchID == "" || detailID == "" {
+		return nil, ErrTransferMissingDetailID
+	}
+	return client.queryTransferDetail(ctx, "/v3/transfer/batches/batch-id/"+url.PathEscape(batchID)+"/details/detail-id/"+url.PathEscape(detailID))
+}
+
+// QueryTransferDetailByOutNo 通过商家批次单号和商家明细单号查询明细单
+func (client *Client) QueryTransferDetailByOutNo(ctx context.Context, outBatchNo, outDetailNo string) (*TransferDetail, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, ErrTransferMissingDetailID
+	}
+	return client.queryTransferDetail(ctx, "/v3/transfer/batches/out-batch-no/"+url.PathEscape(outBatchNo)+"/details/out-detail-no/"+url.PathEscape(outDetailNo))
+}
+
+func (client *Client) queryTransferDetail(ctx context.Context, urlPath string) (*TransferDetail, error) {
+	resp := &TransferDetail{}
+	if err := client.Do(ctx, http.MethodGet, urlPath, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// TransferLimits 为拆分转账批次的限额，金额单位为分，0 表示不限制
+type TransferLimits struct {
+	// MaxDetails 每个批次最多的明细笔数
+	MaxDetails int
+	// MaxBatchAmount 每个批次最高的转账总金额
+	MaxBatchAmount int64
+	// MaxDetailAmount 单笔明细最高的转账金额，超过时返回 ErrTransferDetailAmountTooMuch 而不是拆分该明细
+	MaxDetailAmount int64
+}
+
+// SplitTransferBatch 按 limits（为 nil 时使用 DefaultTransferLimits）将 req 按明细顺序拆分为多个批次，每个批次的 TotalAmount/TotalNum 均已计算；
+// 只需一个批次时其商家批次单号不变，否则依次在原单号后加上相同宽度的序号，如 "plan123" 拆分为 "plan12301" ~ "plan12312"，
+// 调用方需保证原单号加上序号后仍不超过 32 个字符且不与其它批次单号重复。各明细也会按收款用户姓名的规则校验
+func SplitTransferBatch(req *TransferBatchRequest, limits *TransferLimits) ([]*TransferBatchRequest, error) {
+	if limits == nil {
+		limits = DefaultTransferLimits
+	}
+	if req.OutBatchNo == "" {
+		return nil, ErrTransferMissingOutBatchNo
+	}
+	if len(req.TransferDetailList) == 0 {
+		return nil, ErrTransferNoDetails
+	}
+
+	batches := []*TransferBatchRequest{}
+	var batch *TransferBatchRequest
+	for _, detail := range req.TransferDetailList {
+		if err := validateTransferDetail(detail); err != nil {
+			return nil, err
+		}
+		if (limits.MaxDetailAmount > 0 && detail.TransferAmount > limits.MaxDetailAmount) ||
+			(limits.MaxBatchAmount > 0 && detail.TransferAmount > limits.MaxBatchAmount) {
+			return nil, fmt.Errorf("Transfer detail %s: %w", detail.OutDetailNo, ErrTransferDetailAmountTooMuch)
+		}
+
+		if batch == nil ||
+			(limits.MaxDetails > 0 && len(batch.TransferDetailList) >= limits.MaxDetails) ||
+			(limits.MaxBatchAmount > 0 && batch.TotalAmount+detail.TransferAmount > limits.MaxBatchAmount) {
+			batch = &TransferBatchRequest{
+				AppID:           req.AppID,
+				BatchName:       req.BatchName,
+				BatchRemark:     req.BatchRemark,
+				TransferSceneID: req.TransferSceneID,
+			}
+			batches = append(batches, batch)
+		}
+		batch.TransferDetailList = append(batch.TransferDetailList, detail)
+		batch.TotalAmount += detail.TransferAmount
+		batch.TotalNum++
+	}
+
+	if len(batches) == 1 {
+		batches[0].OutBatchNo = req.OutBatchNo
+	} else {
+		width := len(strconv.Itoa(len(batches)))
+		if width < 2 {
+			width = 2
+		}
+		for i, batch := range batches {
+			batch.OutBatchNo = fmt.Sprintf("%s%0*d", req.OutBatchNo, width, i+1)
+		}
+	}
+	for _, batch := range batches {
+		if !outBatchNoRegexp.MatchString(batch.OutBatchNo) {
+			return nil, ErrTransferBadOutBatchNo
+		}
+	}
+	return batches, nil
+}
+
+// validateTransferDetail 校验明细的金额以及收款用户姓名规则
+func validateTransferDetail(detail *TransferDetailInput) error {
+	var err error
+	switch {
+	case detail.TransferAmount <= 0:
+		err = ErrTransferBadAmount
+	case detail.TransferAmount >= TransferUserNameRequiredAmount && detail.UserName == "":
+		err = ErrTransferUserNameRequired
+	case detail.TransferAmount < TransferUserNameForbiddenAmount && detail.UserName != "":
+		err = ErrTransferUserNameNotAllowed
+	default:
+		return nil
+	}
+	return fmt.Errorf("Transfer detail %s: %w", detail.OutDetailNo, err)
+}
+
+func transferQueryLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return 20
+	case limit > TransferMaxQueryLimit:
+		return TransferMaxQueryLimit
+	default:
+		return limit
+	}
+}
@@ -0,0 +1,206 @@
+package mchv3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/huangjunwen/wx-driver/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTransferDetails(amounts ...int64) []*TransferDetailInput {
+	details := []*TransferDetailInput{}
+	for i, amount := range amounts {
+		detail := &TransferDetailInput{
+			OutDetailNo:    "x23zy545Bd5436" + strconv.Itoa(i),
+			TransferAmount: amount,
+			TransferRemark: "2020年4月报销",
+			OpenID:         "o-MYE42l80oelYMDE34nYD456Xoy",
+		}
+		if amount >= TransferUserNameRequiredAmount {
+			detail.UserName = "张三"
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+func TestCreateTransferBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/transfer/batches", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &TransferBatchRequest{}
+		assert.NoError(json.Unmarshal(body, req))
+		assert.Equal(int64(200100), req.TotalAmount)
+		assert.Equal(2, req.TotalNum)
+		assert.Equal("", req.TransferDetailList[0].UserName)
+		userName, err := utils.DecryptOAEP(testPlatformKey, req.TransferDetailList[1].UserName)
+		assert.NoError(err)
+		assert.Equal("张三", userName)
+		testRespond(w, 200, map[string]string{
+			"out_batch_no": req.OutBatchNo,
+			"batch_id":     "1030000071100999991182020050700019480001",
+			"create_time":  "2015-05-20T13:29:35.120+08:00",
+		})
+	})
+	ctx := context.Background()
+
+	req := &TransferBatchRequest{
+		AppID:              "wxf636efh567hg4356",
+		OutBatchNo:         "plfk2020042013",
+		BatchName:          "2019年1月深圳分部报销单",
+		BatchRemark:        "2019年1月深圳分部报销单",
+		TransferDetailList: testTransferDetails(100, 200000),
+	}
+	resp, err := client.CreateTransferBatch(ctx, req)
+	assert.NoError(err)
+	assert.Equal("1030000071100999991182020050700019480001", resp.BatchID)
+	assert.Equal(int64(0), req.TotalAmount) // 不修改请求本身
+	assert.Equal("张三", req.TransferDetailList[1].UserName)
+
+	for _, testCase := range []struct {
+		Req       TransferBatchRequest
+		ExpectErr error
+	}{
+		{TransferBatchRequest{}, ErrTransferMissingOutBatchNo},
+		{TransferBatchRequest{OutBatchNo: "plfk_2020"}, ErrTransferBadOutBatchNo},
+		{TransferBatchRequest{OutBatchNo: "plfk2020042013"}, ErrTransferNoDetails},
+		{TransferBatchRequest{OutBatchNo: "plfk2020042013", TotalNum: 2, TransferDetailList: testTransferDetails(100)}, ErrTransferTotalMismatch},
+		{TransferBatchRequest{OutBatchNo: "plfk2020042013", TransferDetailList: []*TransferDetailInput{{TransferAmount: 200000}}}, ErrTransferUserNameRequired},
+		{TransferBatchRequest{OutBatchNo: "plfk2020042013", TransferDetailList: []*TransferDetailInput{{TransferAmount: 29, UserName: "张三"}}}, ErrTransferUserNameNotAllowed},
+		{TransferBatchRequest{OutBatchNo: "plfk2020042013", TransferDetailList: []*TransferDetailInput{{TransferAmount: 0}}}, ErrTransferBadAmount},
+	} {
+		_, err := client.CreateTransferBatch(ctx, &testCase.Req)
+		assert.True(errors.Is(err, testCase.ExpectErr), "%+v %s", testCase.Req, err)
+	}
+}
+
+func TestScanTransferDetails(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []string{}
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/transfer/batches/out-batch-no/plfk2020042013", r.URL.Path)
+		queries = append(queries, r.URL.RawQuery)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		details := []*TransferDetailBrief{}
+		for i := offset; i < offset+2 && i < 5; i++ {
+			details = append(details, &TransferDetailBrief{DetailID: strconv.Itoa(i), DetailStatus: TransferDetailStatusSUCCESS})
+		}
+		testRespond(w, 200, &TransferBatchQueryResponse{
+			TransferBatch:      &TransferBatch{OutBatchNo: "plfk2020042013", BatchStatus: TransferBatchStatusFINISHED, TotalNum: 5},
+			TransferDetailList: details,
+		})
+	})
+	ctx := context.Background()
+
+	_, err := client.QueryTransferBatch(ctx, &TransferBatchQueryRequest{})
+	assert.Equal(ErrTransferMissingID, err)
+
+	ids := []string{}
+	batch, err := client.ScanTransferDetails(ctx, &TransferBatchQueryRequest{OutBatchNo: "plfk2020042013", Limit: 2}, func(detail *TransferDetailBrief) error {
+		ids = append(ids, detail.DetailID)
+		return nil
+	})
+	assert.NoError(err)
+	assert.True(batch.BatchStatus.IsTerminal())
+	assert.Equal([]string{"0", "1", "2", "3", "4"}, ids)
+	assert.Equal([]string{
+		"detail_status=ALL&limit=2&need_query_detail=true&offset=0",
+		"detail_status=ALL&limit=2&need_query_detail=true&offset=2",
+		"detail_status=ALL&limit=2&need_query_detail=true&offset=4",
+	}, queries)
+
+	queries = queries[:0]
+	_, err = client.QueryTransferBatch(ctx, &TransferBatchQueryRequest{OutBatchNo: "plfk2020042013"})
+	assert.NoError(err)
+	assert.Equal([]string{"need_query_detail=false"}, queries)
+}
+
+func TestQueryTransferDetail(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testNewClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v3/transfer/batches/out-batch-no/plfk2020042013/details/out-detail-no/x23zy545Bd5436", r.URL.Path)
+		userName, _ := utils.EncryptOAEP(&testMchKey.PublicKey, "张三")
+		testRespond(w, 200, map[string]interface{}{
+			"out_detail_no":   "x23zy545Bd5436",
+			"detail_status":   "FAIL",
+			"fail_reason":     "ACCOUNT_FROZEN",
+			"transfer_amount": 200000,
+			"user_name":       userName,
+			"initiate_time":   "2015-05-20T13:29:35.120+08:00",
+			"update_time":     "2015-05-20T13:29:35.120+08:00",
+		})
+	})
+	ctx := context.Background()
+
+	_, err := client.QueryTransferDetailByOutNo(ctx, "plfk2020042013", "")
+	assert.Equal(ErrTransferMissingDetailID, err)
+
+	detail, err := client.QueryTransferDetailByOutNo(ctx, "plfk2020042013", "x23zy545Bd5436")
+	assert.NoError(err)
+	assert.True(detail.DetailStatus.IsTerminal())
+	assert.Equal("ACCOUNT_FROZEN", detail.FailReason)
+	assert.Equal("张三", detail.UserName)
+}
+
+func TestSplitTransferBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &TransferBatchRequest{
+		AppID:              "wxf636efh567hg4356",
+		OutBatchNo:         "plfk2020042013",
+		BatchName:          "报销",
+		TransferDetailList: testTransferDetails(100, 200, 300, 400, 500),
+	}
+
+	// 一个批次时单号不变
+	batches, err := SplitTransferBatch(req, nil)
+	assert.NoError(err)
+	assert.Len(batches, 1)
+	assert.Equal("plfk2020042013", batches[0].OutBatchNo)
+	assert.Equal(int64(1500), batches[0].TotalAmount)
+	assert.Equal(5, batches[0].TotalNum)
+
+	// 按笔数和金额拆分
+	batches, err = SplitTransferBatch(req, &TransferLimits{MaxDetails: 2, MaxBatchAmount: 600})
+	assert.NoError(err)
+	nums := []int{}
+	amounts := []int64{}
+	for _, batch := range batches {
+		assert.Equal("wxf636efh567hg4356", batch.AppID)
+		assert.Equal("报销", batch.BatchName)
+		nums = append(nums, batch.TotalNum)
+		amounts = append(amounts, batch.TotalAmount)
+	}
+	assert.Equal([]int{2, 1, 1, 1}, nums)
+	assert.Equal([]int64{300, 300, 400, 500}, amounts)
+	assert.Equal("plfk202004201301", batches[0].OutBatchNo)
+	assert.Equal("plfk202004201304", batches[3].OutBatchNo)
+
+	// 单笔超过限额
+	_, err = SplitTransferBatch(req, &TransferLimits{MaxDetailAmount: 400})
+	assert.True(errors.Is(err, ErrTransferDetailAmountTooMuch))
+	_, err = SplitTransferBatch(req, &TransferLimits{MaxBatchAmount: 400})
+	assert.True(errors.Is(err, ErrTransferDetailAmountTooMuch))
+
+	// 加上序号后单号过长
+	long := *req
+	long.OutBatchNo = "plfk2020042013plfk2020042013plfk"
+	_, err = SplitTransferBatch(&long, &TransferLimits{MaxDetails: 1})
+	assert.Equal(ErrTransferBadOutBatchNo, err)
+
+	// 姓名规则
+	bad := *req
+	bad.TransferDetailList = []*TransferDetailInput{{OutDetailNo: "a", TransferAmount: 200000}}
+	_, err = SplitTransferBatch(&bad, nil)
+	assert.True(errors.Is(err, ErrTransferUserNameRequired))
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoPEMBlock 表示找不到 PEM 块
+	ErrNoPEMBlock = errors.New("No PEM block found")
+)
+
+// ParseCertificate 从 PEM 块中解析证书，例如微信支付平台证书
+func ParseCertificate(certPEMBlock []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEMBlock)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CertificateSerialNo 返回证书序列号的大写十六进制形式，即请求头 Wechatpay-Serial 所需的值
+func CertificateSerialNo(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+// ParsePrivateKey 从 PEM 块中解析 RSA 私钥，支持 PKCS#8（商户 apiclient_key.pem 的格式）和 PKCS#1
+func ParsePrivateKey(keyPEMBlock []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEMBlock)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Private key is not a RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,434 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxBodySize 为 Redactor 默认的 body 最大输出长度（字节）
+	DefaultMaxBodySize = 4096
+
+	// DefaultMaxCaptureSize 为 HTTPLogger 默认为记录日志最多读取的 body 长度（字节）
+	DefaultMaxCaptureSize = 64 << 10
+
+	// RedactedValue 为敏感字段遮盖后的值
+	RedactedValue = "***"
+)
+
+var (
+	// DefaultSensitiveFields 为默认需要遮盖的字段名（不区分大小写）
+	DefaultSensitiveFields = []string{
+		"sign",            // 签名
+		"paySign",         // 前端支付签名
+		"openid",          // 用户标识
+		"sub_openid",      // 子商户下的用户标识
+		"enc_bank_no",     // 企业付款到银行卡：加密的收款方银行卡号
+		"enc_true_name",   // 企业付款到银行卡：加密的收款方用户名
+		"re_user_name",    // 企业付款到零钱：收款用户姓名
+		"req_info",        // 退款结果通知：加密信息
+		"sandbox_signkey", // 沙箱密钥
+	}
+
+	// DefaultRedactor 为默认的 Redactor
+	DefaultRedactor = &Redactor{}
+)
+
+// Redactor 用于在记录日志前处理请求/响应的 body：解析 xml（如微信支付的 mch xml）或 json，
+// 遮盖其中的敏感字段，并截断过长的内容；无法解析的 body 不会原样输出
+type Redactor struct {
+	// Fields 需要遮盖的字段名（不区分大小写），为空时使用 DefaultSensitiveFields
+	Fields []string
+
+	// MaxBodySize 输出的最大长度（字节），为 0 时使用 DefaultMaxBodySize，为负数时不截断
+	MaxBodySize int
+}
+
+// Redact 返回处理后的 body；无法解析为 xml 或 json 的 body 中无法确定敏感字段的位置，
+// 因此只输出其长度，形如 "<unparsable body, 17 bytes>"
+func (redactor *Redactor) Redact(body []byte) string {
+	if redactor == nil {
+		redactor = DefaultRedactor
+	}
+
+	fieldNames := redactor.Fields
+	if len(fieldNames) == 0 {
+		fieldNames = DefaultSensitiveFields
+	}
+	fields := make(map[string]bool, len(fieldNames))
+	for _, fieldName := range fieldNames {
+		fields[strings.ToLower(fieldName)] = true
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return ""
+	}
+	result, ok := "", false
+	switch trimmed[0] {
+	case '<':
+		result, ok = redactXML(trimmed, fields)
+	case '{', '[':
+		result, ok = redactJSON(trimmed, fields)
+	}
+	if !ok {
+		return fmt.Sprintf("<unparsable body, %d bytes>", len(body))
+	}
+
+	maxBodySize := redactor.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	return truncateBody(result, maxBodySize)
+}
+
+// redactXML 处理只有一层子元素的 xml（如 mch xml），更深的元素的文本合并到其所属的子元素中
+func redactXML(body []byte, fields map[string]bool) (string, bool) {
+	type field struct {
+		name  string
+		value strings.Builder
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	root := ""
+	depth := 0
+	result := []*field{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", false
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				root = t.Name.Local
+			case 2:
+				result = append(result, &field{name: t.Name.Local})
+			}
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth >= 2 {
+				result[len(result)-1].value.Write(t)
+			}
+		}
+	}
+	if root == "" {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<" + root + ">")
+	for _, f := range result {
+		buf.WriteString("<" + f.name + ">")
+		if fields[strings.ToLower(f.name)] {
+			buf.WriteString(RedactedValue)
+		} else {
+			xml.EscapeText(&buf, []byte(f.value.String()))
+		}
+		buf.WriteString("</" + f.name + ">")
+	}
+	buf.WriteString("</" + root + ">")
+	return buf.String(), true
+}
+
+// redactJSON 处理 json，遮盖任意层级中的敏感字段
+func redactJSON(body []byte, fields map[string]bool) (string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return "", false
+	}
+
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for key, val := range t {
+				if fields[strings.ToLower(key)] && val != nil {
+					t[key] = RedactedValue
+					continue
+				}
+				t[key] = walk(val)
+			}
+		case []interface{}:
+			for i, val := range t {
+				t[i] = walk(val)
+			}
+		}
+		return v
+	}
+
+	result, err := json.Marshal(walk(v))
+	if err != nil {
+		return "", false
+	}
+	return string(result), true
+}
+
+// truncateBody 截断 s 至最多 maxBodySize 字节（不截断 utf8 字符）
+func truncateBody(s string, maxBodySize int) string {
+	if maxBodySize < 0 || len(s) <= maxBodySize {
+		return s
+	}
+	n := maxBodySize
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s...(truncated, %d bytes)", s[:n], len(s))
+}
+
+// HTTPLogEntry 为一次 http 请求的日志记录，其中的 body 均已经过 Redactor 处理
+type HTTPLogEntry struct {
+	// Server 为 true 表示作为服务端收到的请求（如回调），否则为作为客户端发出的请求
+	Server bool
+	// Method/URL 请求方法和地址
+	Method string
+	URL    string
+	// StatusCode http 状态码；没有收到响应时为 0
+	StatusCode int
+	// Latency 耗时
+	Latency time.Duration
+	// RequestBody/ResponseBody 请求/响应的 body
+	RequestBody  string
+	ResponseBody string
+	// Err 错误
+	Err error
+}
+
+// String 返回 key=value 形式的日志
+func (entry *HTTPLogEntry) String() string {
+	kind := "client"
+	if entry.Server {
+		kind = "server"
+	}
+	s := fmt.Sprintf("http_%s method=%s url=%q status=%d latency=%s req=%q resp=%q",
+		kind,
+		entry.Method,
+		entry.URL,
+		entry.StatusCode,
+		entry.Latency,
+		entry.RequestBody,
+		entry.ResponseBody,
+	)
+	if entry.Err != nil {
+		s += fmt.Sprintf(" err=%q", entry.Err.Error())
+	}
+	return s
+}
+
+// HTTPLogger 记录 http 请求/响应的日志，包括作为客户端（Client）和作为服务端（Middleware），例如：
+//
+//   logger := &utils.HTTPLogger{}
+//   mch.DefaultOptions = mch.MustOptions(
+//   	mch.UseClient(logger.Client(client)),
+//   	mch.UseMiddleware(logger.Middleware),
+//   )
+type HTTPLogger struct {
+	// Redactor 处理 body，为 nil 时使用 DefaultRedactor
+	Redactor *Redactor
+
+	// Log 输出日志，为 nil 时使用标准库 log 输出 entry.String()
+	Log func(entry *HTTPLogEntry)
+
+	// MaxCaptureSize 为记录日志最多读取的 body 长度（字节），为 0 时使用 DefaultMaxCaptureSize；
+	// 超过的 body 只记录长度，但仍完整地传递给调用方（不会整个读入内存）
+	MaxCaptureSize int
+}
+
+func (logger *HTTPLogger) maxCaptureSize() int {
+	if logger.MaxCaptureSize > 0 {
+		return logger.MaxCaptureSize
+	}
+	return DefaultMaxCaptureSize
+}
+
+// captureBody 读取 body 的前 MaxCaptureSize 字节并返回处理后用于日志的内容，以及与原 body 内容相同的新 body
+// （已读取的部分 + 未读取的部分）；非文本的 body（如 gzip、octet-stream、multipart）不读取
+func (logger *HTTPLogger) captureBody(header http.Header, body io.ReadCloser) (string, io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return "", body, nil
+	}
+	if !isTextBody(header) {
+		return fmt.Sprintf("<%s body>", header.Get("Content-Type")), body, nil
+	}
+
+	limit := logger.maxCaptureSize()
+	captured, err := ioutil.ReadAll(io.LimitReader(body, int64(limit)+1))
+	newBody := &multiReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(captured), body),
+		Closer: body,
+	}
+	if err != nil {
+		return "", newBody, err
+	}
+	if len(captured) > limit {
+		return fmt.Sprintf("<body exceeds %d bytes>", limit), newBody, nil
+	}
+	return logger.Redactor.Redact(captured), newBody, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isTextBody 返回 header 对应的 body 是否为可记录的文本（xml、json、表单等）；没有 Content-Type 时视为文本
+func isTextBody(header http.Header) bool {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "/json"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "/xml"),
+		strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/x-www-form-urlencoded":
+		return true
+	default:
+		return false
+	}
+}
+
+func (logger *HTTPLogger) log(entry *HTTPLogEntry) {
+	if logger.Log != nil {
+		logger.Log(entry)
+		return
+	}
+	log.Print(entry.String())
+}
+
+// Client 返回记录日志的 HTTPClient
+func (logger *HTTPLogger) Client(client HTTPClient) HTTPClient {
+	return &logHTTPClient{logger: logger, client: client}
+}
+
+type logHTTPClient struct {
+	logger *HTTPLogger
+	client HTTPClient
+}
+
+func (c *logHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	entry := &HTTPLogEntry{
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	start := time.Now()
+
+	if req.Body != nil {
+		reqBody, body, err := c.logger.captureBody(req.Header, req.Body)
+		req.Body = body
+		if err != nil {
+			return nil, err
+		}
+		entry.RequestBody = reqBody
+	}
+
+	resp, err := c.client.Do(req)
+	if err == nil {
+		entry.StatusCode = resp.StatusCode
+		var body io.ReadCloser
+		entry.ResponseBody, body, err = c.logger.captureBody(resp.Header, resp.Body)
+		resp.Body = body
+		if err != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+	}
+
+	entry.Latency = time.Since(start)
+	entry.Err = err
+	c.logger.log(entry)
+	return resp, err
+}
+
+// Middleware 为记录日志的 http 服务端中间件
+func (logger *HTTPLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &HTTPLogEntry{
+			Server: true,
+			Method: r.Method,
+			URL:    r.URL.String(),
+		}
+		start := time.Now()
+
+		reqBody, body, err := logger.captureBody(r.Header, r.Body)
+		r.Body = body
+		entry.RequestBody = reqBody
+		entry.Err = err
+
+		rw := &logResponseWriter{ResponseWriter: w, limit: logger.maxCaptureSize()}
+		next.ServeHTTP(rw, r)
+
+		entry.StatusCode = rw.statusCode
+		if entry.StatusCode == 0 {
+			entry.StatusCode = http.StatusOK
+		}
+		switch {
+		case !isTextBody(rw.Header()):
+			entry.ResponseBody = fmt.Sprintf("<%s body>", rw.Header().Get("Content-Type"))
+		case rw.size > rw.limit:
+			entry.ResponseBody = fmt.Sprintf("<body exceeds %d bytes>", rw.limit)
+		default:
+			entry.ResponseBody = logger.Redactor.Redact(rw.body.Bytes())
+		}
+		entry.Latency = time.Since(start)
+		logger.log(entry)
+	})
+}
+
+// logResponseWriter 记录写入的状态码及 body（最多 limit 字节）
+type logResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	size       int
+	limit      int
+}
+
+func (w *logResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *logResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	// 多保留一个字节以判断是否超过 limit
+	if remain := w.limit + 1 - w.body.Len(); remain > 0 {
+		if remain > len(p) {
+			remain = len(p)
+		}
+		w.body.Write(p[:remain])
+	}
+	w.size += len(p)
+	return w.ResponseWriter.Write(p)
+}
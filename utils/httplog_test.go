@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		Redactor *Redactor
+		Body     string
+		Expect   string
+	}{
+		// mch xml
+		{
+			nil,
+			"<xml>\n<appid><![CDATA[wx1]]></appid><openid><![CDATA[oUpF8uMuAJO]]></openid><sign>ABC</sign><body><![CDATA[<a&b>]]></body></xml>",
+			"<xml><appid>wx1</appid><openid>***</openid><sign>***</sign><body>&lt;a&amp;b&gt;</body></xml>",
+		},
+		// 沙箱密钥
+		{
+			nil,
+			"<xml><return_code>SUCCESS</return_code><sandbox_signkey>013467007045764</sandbox_signkey></xml>",
+			"<xml><return_code>SUCCESS</return_code><sandbox_signkey>***</sandbox_signkey></xml>",
+		},
+		// json，任意层级，字段名不区分大小写
+		{
+			nil,
+			`{"appId":"wx1","paySign":"ABC","payer":{"OpenID":"o1","amount":100},"list":[{"sign":"x"}],"empty":null}`,
+			`{"appId":"wx1","empty":null,"list":[{"sign":"***"}],"paySign":"***","payer":{"OpenID":"***","amount":100}}`,
+		},
+		// 自定义字段
+		{
+			&Redactor{Fields: []string{"body"}},
+			"<xml><sign>ABC</sign><body>x</body></xml>",
+			"<xml><sign>ABC</sign><body>***</body></xml>",
+		},
+		// 无法解析时不输出内容
+		{
+			nil,
+			"<html>bad gateway",
+			"<unparsable body, 17 bytes>",
+		},
+		{
+			nil,
+			"<xml><return_code>SUCCESS</return_code><openid>oUpF8uMuAJO</openid>",
+			"<unparsable body, 67 bytes>",
+		},
+		{
+			nil,
+			`{"openid":"oUpF8uMuAJO"`,
+			"<unparsable body, 23 bytes>",
+		},
+		{
+			nil,
+			"a=1&openid=oUpF8uMuAJO",
+			"<unparsable body, 22 bytes>",
+		},
+		{
+			nil,
+			"",
+			"",
+		},
+		// 截断，不截断 utf8 字符
+		{
+			&Redactor{MaxBodySize: 14},
+			`{"a":"中文内容"}`,
+			`{"a":"中文...(truncated, 20 bytes)`,
+		},
+		{
+			&Redactor{MaxBodySize: -1},
+			`{"a":"` + strings.Repeat("a", DefaultMaxBodySize) + `"}`,
+			`{"a":"` + strings.Repeat("a", DefaultMaxBodySize) + `"}`,
+		},
+	} {
+		assert.Equal(testCase.Expect, testCase.Redactor.Redact([]byte(testCase.Body)), testCase.Body)
+	}
+
+	// 默认截断
+	assert.True(strings.HasPrefix(
+		DefaultRedactor.Redact([]byte(`{"a":"`+strings.Repeat("a", DefaultMaxBodySize)+`"}`)),
+		`{"a":"`+strings.Repeat("a", DefaultMaxBodySize-6)+"...(truncated",
+	))
+}
+
+type testLogClient struct {
+	body   string
+	header http.Header
+	err    error
+}
+
+func (c *testLogClient) Do(req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	ioutil.ReadAll(req.Body)
+	header := c.header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(c.body)),
+	}, nil
+}
+
+func TestHTTPLoggerClient(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []*HTTPLogEntry{}
+	logger := &HTTPLogger{Log: func(entry *HTTPLogEntry) {
+		entries = append(entries, entry)
+	}}
+
+	// 请求/响应的 body 可以继续被读取
+	respBody := "<xml><return_code>SUCCESS</return_code><openid>o1</openid></xml>"
+	client := logger.Client(&testLogClient{body: respBody})
+	req, _ := http.NewRequest("POST", "https://api.mch.weixin.qq.com/pay/orderquery", bytes.NewBufferString("<xml><sign>ABC</sign></xml>"))
+	resp, err := client.Do(req)
+	assert.NoError(err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(respBody, string(body))
+
+	assert.Len(entries, 1)
+	entry := entries[0]
+	assert.False(entry.Server)
+	assert.Equal("POST", entry.Method)
+	assert.Equal("https://api.mch.weixin.qq.com/pay/orderquery", entry.URL)
+	assert.Equal(200, entry.StatusCode)
+	assert.Equal("<xml><sign>***</sign></xml>", entry.RequestBody)
+	assert.Equal("<xml><return_code>SUCCESS</return_code><openid>***</openid></xml>", entry.ResponseBody)
+	assert.NoError(entry.Err)
+	assert.NotContains(entry.String(), "ABC")
+
+	// 网络错误
+	networkErr := errors.New("connection reset by peer")
+	client = logger.Client(&testLogClient{err: networkErr})
+	req, _ = http.NewRequest("GET", "https://api.mch.weixin.qq.com/", nil)
+	_, err = client.Do(req)
+	assert.Equal(networkErr, err)
+	assert.Len(entries, 2)
+	assert.Equal(0, entries[1].StatusCode)
+	assert.Equal(networkErr, entries[1].Err)
+	assert.Contains(entries[1].String(), `err="connection reset by peer"`)
+}
+
+func TestHTTPLoggerMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []*HTTPLogEntry{}
+	logger := &HTTPLogger{Log: func(entry *HTTPLogEntry) {
+		entries = append(entries, entry)
+	}}
+
+	reqBody := "<xml><return_code>SUCCESS</return_code><openid>o1</openid><sign>ABC</sign></xml>"
+	handler := logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// handler 仍能读取完整的 body
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(reqBody, string(body))
+		w.Write([]byte("<xml><return_code>SUCCESS</return_code></xml>"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(reqBody)))
+	assert.Equal("<xml><return_code>SUCCESS</return_code></xml>", w.Body.String())
+
+	assert.Len(entries, 1)
+	entry := entries[0]
+	assert.True(entry.Server)
+	assert.Equal("/notify", entry.URL)
+	assert.Equal(200, entry.StatusCode)
+	assert.Equal("<xml><return_code>SUCCESS</return_code><openid>***</openid><sign>***</sign></xml>", entry.RequestBody)
+	assert.Equal("<xml><return_code>SUCCESS</return_code></xml>", entry.ResponseBody)
+}
+
+// testCountReader 记录已被读取的字节数
+type testCountReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *testCountReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestHTTPLoggerBodyLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []*HTTPLogEntry{}
+	logger := &HTTPLogger{MaxCaptureSize: 16, Log: func(entry *HTTPLogEntry) {
+		entries = append(entries, entry)
+	}}
+
+	// 超过 MaxCaptureSize 的 body 只读取 MaxCaptureSize+1 字节，之后仍可完整读取
+	bigBody := "<xml><openid>" + strings.Repeat("o", 1000) + "</openid></xml>"
+	counter := &testCountReader{r: strings.NewReader(bigBody)}
+	client := logger.Client(&testLogClient{body: bigBody})
+	req, _ := http.NewRequest("POST", "https://api.mch.weixin.qq.com/pay/orderquery", counter)
+	resp, err := client.Do(req)
+	assert.NoError(err)
+	assert.Equal(len(bigBody), counter.n) // testLogClient 读取了完整的请求 body
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(bigBody, string(body))
+	assert.Equal("<body exceeds 16 bytes>", entries[0].RequestBody)
+	assert.Equal("<body exceeds 16 bytes>", entries[0].ResponseBody)
+	assert.NotContains(entries[0].String(), "ooo")
+
+	// 非文本的 body 不读取
+	gzipped := "\x1f\x8bgzipped"
+	client = logger.Client(&testLogClient{body: gzipped, header: http.Header{"Content-Type": {"application/octet-stream"}}})
+	counter = &testCountReader{r: strings.NewReader("--boundary")}
+	req, _ = http.NewRequest("POST", "https://api.mch.weixin.qq.com/v3/merchant/media/upload", counter)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	resp, err = client.Do(req)
+	assert.NoError(err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(gzipped, string(body))
+	assert.Equal("<multipart/form-data; boundary=boundary body>", entries[1].RequestBody)
+	assert.Equal("<application/octet-stream body>", entries[1].ResponseBody)
+
+	// 服务端
+	handler := logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(bigBody, string(body))
+		w.Write([]byte(bigBody[:10]))
+		w.Write([]byte(bigBody[10:]))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(bigBody)))
+	assert.Equal(bigBody, w.Body.String())
+	assert.Equal("<body exceeds 16 bytes>", entries[2].RequestBody)
+	assert.Equal("<body exceeds 16 bytes>", entries[2].ResponseBody)
+}
+
+func TestHTTPLoggerCaptureStreaming(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := &HTTPLogger{MaxCaptureSize: 16, Log: func(entry *HTTPLogEntry) {}}
+	counter := &testCountReader{r: strings.NewReader(strings.Repeat("x", 1000))}
+	_, body, err := logger.captureBody(http.Header{}, ioutil.NopCloser(counter))
+	assert.NoError(err)
+	// 只读取了 MaxCaptureSize+1 字节
+	assert.Equal(17, counter.n)
+	rest, _ := ioutil.ReadAll(body)
+	assert.Len(rest, 1000)
+}
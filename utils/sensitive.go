@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// EncryptOAEP 使用公钥以 RSA-OAEP(SHA1) 加密明文，返回 base64 编码的密文；
+// 微信支付的敏感信息（如 v3 接口中的姓名、证件号码、手机号码）均使用这种方式加密
+func EncryptOAEP(pub *rsa.PublicKey, plainText string) (string, error) {
+	cipherBytes, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, []byte(plainText), nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(cipherBytes), nil
+}
+
+// DecryptOAEP 使用私钥解密 EncryptOAEP 加密的密文
+func DecryptOAEP(priv *rsa.PrivateKey, cipherText string) (string, error) {
+	cipherBytes, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
+		return "", err
+	}
+	plainBytes, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, priv, cipherBytes, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plainBytes), nil
+}
+
+// EncryptSensitiveFields 使用公钥加密 v 中所有标记为敏感的字段（原地替换为密文）；v 须为结构体指针。
+// 敏感字段为带有 `wx:"sensitive"` tag 的 string 字段，空字符串不加密；嵌套的结构体、指针、切片、map、interface{} 会被递归处理：
+//
+//   type IdentityInfo struct {
+//   	IDCardName      string `json:"id_card_name" wx:"sensitive"`
+//   	IDCardNumber    string `json:"id_card_number" wx:"sensitive"`
+//   	IDCardValidTime string `json:"id_card_valid_time"`
+//   }
+func EncryptSensitiveFields(v interface{}, pub *rsa.PublicKey) error {
+	return walkSensitiveFields(v, func(plainText string) (string, error) {
+		return EncryptOAEP(pub, plainText)
+	})
+}
+
+// DecryptSensitiveFields 使用私钥解密 v 中所有标记为敏感的字段（原地替换为明文），字段标记方式同 EncryptSensitiveFields
+func DecryptSensitiveFields(v interface{}, priv *rsa.PrivateKey) error {
+	return walkSensitiveFields(v, func(cipherText string) (string, error) {
+		return DecryptOAEP(priv, cipherText)
+	})
+}
+
+// HasSensitiveFields 返回 v 中是否有非空的敏感字段；v 可以是任意值，interface{} 字段以及 map 中的值同样会被检查；不会修改 v
+func HasSensitiveFields(v interface{}) (bool, error) {
+	found := false
+	_, err := walkSensitiveValue(reflect.ValueOf(v), func(s string) (string, error) {
+		found = true
+		return s, nil
+	})
+	return found, err
+}
+
+// CopyAndEncryptSensitiveFields 返回 v 的深拷贝（类型不变，因此保留字段的 tag），并使用公钥加密其中所有标记为敏感的字段；不会修改 v。
+// 与 EncryptSensitiveFields 不同，v 可以是任意值，interface{} 字段以及 map 中的敏感字段同样会被加密；v 中不能有循环引用
+func CopyAndEncryptSensitiveFields(v interface{}, pub *rsa.PublicKey) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	copied := reflect.New(reflect.TypeOf(v)).Elem()
+	copied.Set(deepCopy(reflect.ValueOf(v)))
+	if _, err := walkSensitiveValue(copied, func(plainText string) (string, error) {
+		return EncryptOAEP(pub, plainText)
+	}); err != nil {
+		return nil, err
+	}
+	return copied.Interface(), nil
+}
+
+func walkSensitiveFields(v interface{}, fn func(string) (string, error)) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Expect a non-nil struct pointer but got %T", v)
+	}
+	_, err := walkSensitiveValue(rv.Elem(), fn)
+	return err
+}
+
+// walkSensitiveValue 对 rv 中的敏感字段调用 fn 并以其返回值替换，返回是否有字段被替换
+func walkSensitiveValue(rv reflect.Value, fn func(string) (string, error)) (bool, error) {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return false, nil
+		}
+		return walkSensitiveValue(rv.Elem(), fn)
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return false, nil
+		}
+		elem := rv.Elem()
+		if elem.Kind() == reflect.Ptr {
+			return walkSensitiveValue(elem, fn)
+		}
+		// interface 中的值不可寻址，在副本上替换后再放回
+		tmp := reflect.New(elem.Type()).Elem()
+		tmp.Set(elem)
+		changed, err := walkSensitiveValue(tmp, fn)
+		if err != nil || !changed {
+			return false, err
+		}
+		// 不能跳过，否则敏感字段会以明文发送
+		if !rv.CanSet() {
+			return false, fmt.Errorf("Sensitive field in %s stored in interface is not settable, store a pointer instead", elem.Type())
+		}
+		rv.Set(tmp)
+		return true, nil
+
+	case reflect.Map:
+		changed := false
+		for _, key := range rv.MapKeys() {
+			// map 中的值同样不可寻址
+			tmp := reflect.New(rv.Type().Elem()).Elem()
+			tmp.Set(rv.MapIndex(key))
+			c, err := walkSensitiveValue(tmp, fn)
+			if err != nil {
+				return false, err
+			}
+			if c {
+				rv.SetMapIndex(key, tmp)
+				changed = true
+			}
+		}
+		return changed, nil
+
+	case reflect.Slice, reflect.Array:
+		changed := false
+		for i := 0; i < rv.Len(); i++ {
+			c, err := walkSensitiveValue(rv.Index(i), fn)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+		return changed, nil
+
+	case reflect.Struct:
+		changed := false
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			sf := rt.Field(i)
+			// 未导出字段
+			if sf.PkgPath != "" {
+				continue
+			}
+			fv := rv.Field(i)
+			if !isSensitiveField(sf) {
+				c, err := walkSensitiveValue(fv, fn)
+				if err != nil {
+					return false, err
+				}
+				changed = changed || c
+				continue
+			}
+			if fv.Kind() != reflect.String {
+				return false, fmt.Errorf("Sensitive field %s.%s should be a string", rt.Name(), sf.Name)
+			}
+			if fv.String() == "" {
+				continue
+			}
+			s, err := fn(fv.String())
+			if err != nil {
+				return false, fmt.Errorf("Sensitive field %s.%s: %s", rt.Name(), sf.Name, err)
+			}
+			if s == fv.String() {
+				continue
+			}
+			// 例如不可寻址的数组中的结构体；不能跳过，否则敏感字段会以明文发送
+			if !fv.CanSet() {
+				return false, fmt.Errorf("Sensitive field %s.%s is not settable", rt.Name(), sf.Name)
+			}
+			fv.SetString(s)
+			changed = true
+		}
+		return changed, nil
+
+	default:
+		return false, nil
+	}
+}
+
+// deepCopy 返回 rv 的深拷贝，类型与 rv 相同；未导出字段为浅拷贝
+func deepCopy(rv reflect.Value) reflect.Value {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return rv
+		}
+		copied := reflect.New(rv.Type().Elem())
+		copied.Elem().Set(deepCopy(rv.Elem()))
+		return copied
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return rv
+		}
+		copied := reflect.New(rv.Type()).Elem()
+		copied.Set(deepCopy(rv.Elem()))
+		return copied
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return rv
+		}
+		copied := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		for _, key := range rv.MapKeys() {
+			copied.SetMapIndex(key, deepCopy(rv.MapIndex(key)))
+		}
+		return copied
+
+	case reflect.Slice:
+		if rv.IsNil() {
+			return rv
+		}
+		copied := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			copied.Index(i).Set(deepCopy(rv.Index(i)))
+		}
+		return copied
+
+	case reflect.Array:
+		copied := reflect.New(rv.Type()).Elem()
+		for i := 0; i < rv.Len(); i++ {
+			copied.Index(i).Set(deepCopy(rv.Index(i)))
+		}
+		return copied
+
+	case reflect.Struct:
+		copied := reflect.New(rv.Type()).Elem()
+		copied.Set(rv)
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			if rt.Field(i).PkgPath != "" {
+				continue
+			}
+			copied.Field(i).Set(deepCopy(rv.Field(i)))
+		}
+		return copied
+
+	default:
+		return rv
+	}
+}
+
+func isSensitiveField(sf reflect.StructField) bool {
+	for _, opt := range strings.Split(sf.Tag.Get("wx"), ",") {
+		if opt == "sensitive" {
+			return true
+		}
+	}
+	return false
+}
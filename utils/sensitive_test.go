@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testIdentity struct {
+	Name     string `json:"name" wx:"sensitive"`
+	IDNumber string `json:"id_number" wx:"sensitive"`
+	Phone    string `json:"phone" wx:"sensitive"`
+	Remark   string `json:"remark"`
+}
+
+type testApplyment struct {
+	Identity  testIdentity
+	Contact   *testIdentity
+	Others    []testIdentity
+	BizCode   string
+	secretKey string
+}
+
+func TestSensitiveFields(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+
+	orig := testApplyment{
+		Identity: testIdentity{Name: "张三", IDNumber: "110101199003070000", Remark: "remark"},
+		Contact:  &testIdentity{Phone: "13800000000"},
+		Others:   []testIdentity{{Name: "李四"}},
+		BizCode:  "APPLY_1",
+	}
+	contact := *orig.Contact
+	v := orig
+	v.Contact = &contact
+	v.Others = []testIdentity{orig.Others[0]}
+
+	found, err := HasSensitiveFields(&v)
+	assert.NoError(err)
+	assert.True(found)
+	found, err = HasSensitiveFields(&testApplyment{Identity: testIdentity{Remark: "remark"}})
+	assert.NoError(err)
+	assert.False(found)
+
+	assert.NoError(EncryptSensitiveFields(&v, &priv.PublicKey))
+	assert.NotEqual(orig.Identity.Name, v.Identity.Name)
+	assert.NotEqual(orig.Identity.IDNumber, v.Identity.IDNumber)
+	assert.NotEqual(orig.Contact.Phone, v.Contact.Phone)
+	assert.NotEqual(orig.Others[0].Name, v.Others[0].Name)
+	assert.Equal("", v.Identity.Phone)        // 空字符串不加密
+	assert.Equal("remark", v.Identity.Remark) // 非敏感字段不变
+	assert.Equal("APPLY_1", v.BizCode)
+
+	assert.NoError(DecryptSensitiveFields(&v, priv))
+	assert.Equal(orig.Identity, v.Identity)
+	assert.Equal(*orig.Contact, *v.Contact)
+	assert.Equal(orig.Others, v.Others)
+
+	// 非结构体指针
+	assert.Error(EncryptSensitiveFields(v, &priv.PublicKey))
+	// 敏感字段类型错误
+	assert.Error(EncryptSensitiveFields(&struct {
+		Age int `wx:"sensitive"`
+	}{1}, &priv.PublicKey))
+
+	// interface 中的结构体值在副本上替换后放回
+	wrapper := &struct{ X interface{} }{X: testIdentity{Name: "a"}}
+	assert.NoError(EncryptSensitiveFields(wrapper, &priv.PublicKey))
+	assert.NotEqual("a", wrapper.X.(testIdentity).Name)
+	// 没有需要替换的字段时不影响
+	assert.NoError(EncryptSensitiveFields(&struct{ X interface{} }{X: testIdentity{Remark: "a"}}, &priv.PublicKey))
+	// interface 中的指针可以替换
+	inner := &testIdentity{Name: "a"}
+	assert.NoError(EncryptSensitiveFields(&struct{ X interface{} }{X: inner}, &priv.PublicKey))
+	assert.NotEqual("a", inner.Name)
+}
+
+func TestCopyAndEncryptSensitiveFields(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+
+	type wrapper struct {
+		Info  interface{}
+		Infos map[string]testIdentity
+		List  []interface{}
+	}
+	orig := &wrapper{
+		Info:  testIdentity{Name: "张三"},
+		Infos: map[string]testIdentity{"a": {Phone: "13800000000"}},
+		List:  []interface{}{&testIdentity{IDNumber: "110101199003070000"}},
+	}
+
+	// 值在 interface{} 或 map 中时同样能找到敏感字段
+	found, err := HasSensitiveFields(map[string]interface{}{"x": orig})
+	assert.NoError(err)
+	assert.True(found)
+
+	copied, err := CopyAndEncryptSensitiveFields(orig, &priv.PublicKey)
+	assert.NoError(err)
+	// 不修改原值
+	assert.Equal(testIdentity{Name: "张三"}, orig.Info)
+	assert.Equal(testIdentity{Phone: "13800000000"}, orig.Infos["a"])
+	assert.Equal("110101199003070000", orig.List[0].(*testIdentity).IDNumber)
+
+	w := copied.(*wrapper)
+	name, err := DecryptOAEP(priv, w.Info.(testIdentity).Name)
+	assert.NoError(err)
+	assert.Equal("张三", name)
+	phone, err := DecryptOAEP(priv, w.Infos["a"].Phone)
+	assert.NoError(err)
+	assert.Equal("13800000000", phone)
+	idNumber, err := DecryptOAEP(priv, w.List[0].(*testIdentity).IDNumber)
+	assert.NoError(err)
+	assert.Equal("110101199003070000", idNumber)
+
+	// 非指针同样可以
+	copied, err = CopyAndEncryptSensitiveFields(testIdentity{Name: "张三"}, &priv.PublicKey)
+	assert.NoError(err)
+	assert.NotEqual("张三", copied.(testIdentity).Name)
+}
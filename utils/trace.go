@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+)
+
+var (
+	// NoopTracer 不做任何事的 Tracer
+	NoopTracer Tracer = noopTracer{}
+)
+
+// Tracer 是对链路追踪的一个最小抽象，wxdriver 在调用接口、处理回调等边界处开始 span，
+// 应用可适配到自己使用的追踪系统；例如适配 OpenTelemetry：
+//
+//   type otelTracer struct{ tracer trace.Tracer }
+//
+//   func (t otelTracer) Start(ctx context.Context, name string) (context.Context, utils.Span) {
+//   	ctx, span := t.tracer.Start(ctx, name)
+//   	return ctx, otelSpan{span}
+//   }
+//
+//   type otelSpan struct{ span trace.Span }
+//
+//   func (s otelSpan) SetAttribute(key, value string) { s.span.SetAttributes(attribute.String(key, value)) }
+//   func (s otelSpan) RecordError(err error) {
+//   	s.span.RecordError(err)
+//   	s.span.SetStatus(codes.Error, err.Error())
+//   }
+//   func (s otelSpan) End() { s.span.End() }
+type Tracer interface {
+	// Start 开始一个 span，返回的 ctx 中应带有该 span，以便其后开始的 span 成为其子 span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 代表一段被追踪的操作
+type Span interface {
+	// SetAttribute 设置属性，如 out_trade_no
+	SetAttribute(key, value string)
+	// RecordError 记录错误，并将 span 标记为失败
+	RecordError(err error)
+	// End 结束 span，之后不应再调用其它方法
+	End()
+}
+
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(key, value string) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) End() {}
@@ -162,8 +162,8 @@ func init() {
 	mchCmd.AddCommand(refundCmd)
 	refundCmd.Flags().StringVar(&refundRequest.OutTradeNo, "out_trade_no", "", "Wechat payment out_trade_no")
 	refundCmd.Flags().StringVar(&refundRequest.OutRefundNo, "out_refund_no", "", "Wechat payment out_refund_no")
-	refundCmd.Flags().Uint64Var(&refundRequest.TotalFee, "total_fee", 0, "Wechat payment total_fee")
-	refundCmd.Flags().Uint64Var(&refundRequest.RefundFee, "refund_fee", 0, "Wechat payment refund_fee")
+	refundCmd.Flags().Uint64Var(&refundRequest.TotalFee.Amount, "total_fee", 0, "Wechat payment total_fee")
+	refundCmd.Flags().Uint64Var(&refundRequest.RefundFee.Amount, "refund_fee", 0, "Wechat payment refund_fee")
 
 	// ----- refundQueryCmd -----
 	mchCmd.AddCommand(refundQueryCmd)
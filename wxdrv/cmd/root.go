@@ -177,7 +177,7 @@ func initHTTPClient() error {
 	}
 
 	if verbose {
-		client = VerboseLogHTTPClient{client}
+		client = (&utils.HTTPLogger{}).Client(client)
 	}
 
 	utils.DefaultHTTPClient = client
@@ -185,31 +185,6 @@ func initHTTPClient() error {
 
 }
 
-type VerboseLogHTTPClient struct {
-	client utils.HTTPClient
-}
-
-func (client VerboseLogHTTPClient) Do(req *http.Request) (*http.Response, error) {
-	reqBody, err := utils.ReadAndReplaceRequestBody(req)
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("HTTP Request: %s %+q %+q", req.Method, req.URL.String(), reqBody)
-
-	resp, err := client.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	respBody, err := utils.ReadAndReplaceResponseBody(resp)
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("HTTP Response: %s %s %+q", resp.Status, resp.Proto, respBody)
-
-	return resp, nil
-}
-
 func dumpJSON(v interface{}) {
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")